@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const keysUsage = `usage: v8box keys rotate [-batch-size n] [-pause duration]
+
+rotate encrypts the content of every note with the first master key, with
+the text of its tasks and the targets of its links, and hashes the words of
+its content in the search index with it again. It works in batches that
+each commit on their own, so it can run while the server does and be
+stopped and run again. To rotate, put the new key first in
+V8BOX_MASTER_KEYS or V8BOX_MASTER_KEY_FILE, keep the old ones after it,
+restart the server, then run this with the same keys. Once it's done the old
+keys can be removed. Running it after turning encryption at rest on
+encrypts the notes that were stored in plaintext. What they overwrite is
+zeroed, but pages SQLite freed before it ran can still hold old plaintext
+until the database is vacuumed.`
+
+// runKeys runs v8box keys with the arguments after it.
+func runKeys(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "rotate" {
+		return errors.New(keysUsage)
+	}
+
+	flags := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), keysUsage) }
+	batchSize := flags.Int("batch-size", 100, "notes encrypted again in each transaction")
+	pause := flags.Duration("pause", 50*time.Millisecond, "time between batches, in which the server has the database to itself")
+	err := flags.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return errors.New("-batch-size has to be at least 1")
+	}
+
+	keys, err := atrest.Load(cfg.MasterKeys, cfg.MasterKeyFile)
+	if err != nil {
+		return err
+	}
+	if !keys.Enabled() {
+		return errors.New("no master keys are configured, set V8BOX_MASTER_KEYS or V8BOX_MASTER_KEY_FILE")
+	}
+
+	// what's rotated away is overwritten rather than left in free pages
+	db, err := repository.OpenDB(cfg.SQLitePath, "secure_delete(on)")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rotationRepo := repository.NewRotationRepository(db, keys)
+
+	stale, err := rotationRepo.CountStale()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d notes to encrypt with master key %q\n", stale, keys.Current())
+
+	done := 0
+	for {
+		count, err := rotationRepo.RotateBatch(*batchSize)
+		if err != nil {
+			return fmt.Errorf("after %d notes: %w", done, err)
+		}
+		if count == 0 {
+			break
+		}
+		done += count
+		fmt.Printf("%d/%d\n", done, stale)
+		time.Sleep(*pause)
+	}
+
+	err = rotationRepo.PurgeIndex()
+	if err != nil {
+		return fmt.Errorf("purging the search index: %w", err)
+	}
+
+	fmt.Printf("every note is encrypted with master key %q\n", keys.Current())
+	return nil
+}
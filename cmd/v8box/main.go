@@ -1,13 +1,21 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
 	"github.com/vaporii/v8box/internal/middleware"
+	"github.com/vaporii/v8box/internal/repository"
 
 	"github.com/vaporii/v8box/internal/handler"
 )
@@ -15,47 +23,169 @@ import (
 func main() {
 	cfg := config.LoadConfig()
 
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "keys":
+			err = runKeys(cfg, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, the server runs without one", os.Args[1])
+		}
+		if err != nil {
+			log.Fatalf("err: %v\n", err)
+		}
+		return
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.ErrorHandler)
 
-	routes, err := setupRouter(cfg)
+	db, err := repository.OpenDB(cfg.SQLitePath)
 	if err != nil {
 		log.Fatalf("err: %v\n", err)
 		return
 	}
 
-	r.Mount("/api/v1", routes)
+	handlers := handler.NewHandlers(db, *cfg)
 
-	http.ListenAndServe(cfg.ServerAddress, r)
-}
+	r.Mount("/api/v1", setupRouter(handlers))
 
-func setupRouter(cfg *config.Config) (*chi.Mux, error) {
-	r := chi.NewRouter()
-
-	db, err := sql.Open("sqlite", cfg.SQLitePath)
-	if err != nil {
-		return nil, err
+	server := &http.Server{
+		Addr:    cfg.ServerAddress,
+		Handler: r,
+	}
+	// event streams never finish on their own, so end them before
+	// Shutdown starts waiting on open connections
+	server.RegisterOnShutdown(handlers.EventBus.Close)
+	// hijacked websockets aren't tracked by Shutdown at all
+	server.RegisterOnShutdown(handlers.CollabHub.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go handlers.AttachmentService.RunGarbageCollector(ctx, cfg.BlobGCInterval)
+	go handlers.ExportService.Run(ctx)
+	go handlers.ReminderService.Run(ctx)
+
+	go func() {
+		<-ctx.Done()
+		logging.Info("shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logging.Error("err during shutdown: %v", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("err: %v\n", err)
 	}
+}
 
-	handlers := handler.NewHandlers(db, *config.LoadConfig())
+func setupRouter(handlers *handler.Handlers) *chi.Mux {
+	r := chi.NewRouter()
 
 	r.Mount("/auth", setupAuthRoutes(handlers.AuthHandler))
 	r.Mount("/me", setupMeRoutes(handlers))
+	r.Mount("/public", setupPublicRoutes(handlers))
+	r.Get("/avatars/{userId}", handlers.UserHandler.GetAvatar)
 
-	return r, nil
+	return r
 }
 
 func setupMeRoutes(handlers *handler.Handlers) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Auth)
+	r.Use(middleware.RejectRevoked(handlers.SessionService))
 
 	r.Get("/", handlers.UserHandler.GetCurrentUser)
+	r.Patch("/", handlers.UserHandler.UpdateProfile)
+	r.Delete("/", handlers.AccountHandler.DeleteAccount)
+	r.Get("/export", handlers.AccountHandler.RequestExport)
+	r.Get("/export/{exportId}/download", handlers.AccountHandler.DownloadExport)
+	r.Get("/export/markdown", handlers.VaultHandler.Export)
+	r.Post("/import", handlers.VaultHandler.Import)
+	r.Put("/avatar", handlers.UserHandler.SetAvatar)
+	r.Delete("/avatar", handlers.UserHandler.DeleteAvatar)
+	r.Get("/events", handlers.EventHandler.Stream)
 	r.Get("/note", handlers.NoteHandler.GetNotes)
 	r.Get("/note/{id}", handlers.NoteHandler.GetNoteByID)
+	r.Get("/note/{id}/collab", handlers.CollabHandler.Connect)
+	r.Get("/note/{id}/backlinks", handlers.GraphHandler.GetBacklinks)
+	r.Get("/note/{id}/reminders", handlers.ReminderHandler.GetNoteReminders)
+	r.Post("/note/{id}/reminders", handlers.ReminderHandler.CreateReminder)
 	r.Post("/note", handlers.NoteHandler.Create)
+	r.Post("/note/state", handlers.NoteHandler.SetNoteStates)
+	r.Post("/note/batch", handlers.NoteHandler.BatchNotes)
 	r.Put("/note/{id}", handlers.NoteHandler.EditNoteByID)
+	r.Delete("/note/{id}", handlers.NoteHandler.DeleteNoteByID)
+	r.Get("/note/{id}/shares", handlers.NoteHandler.GetNoteShares)
+	r.Put("/note/{id}/shares", handlers.NoteHandler.ShareNote)
+	r.Delete("/note/{id}/shares/{userId}", handlers.NoteHandler.UnshareNote)
+	r.Post("/note/{id}/transfer", handlers.NoteHandler.TransferNote)
+	r.Get("/note/{id}/links", handlers.ShareLinkHandler.GetNoteLinks)
+	r.Post("/note/{id}/links", handlers.ShareLinkHandler.CreateLink)
+	r.Delete("/note/{id}/links/{linkId}", handlers.ShareLinkHandler.RevokeLink)
+	r.Get("/note/{id}/links/{linkId}/accesses", handlers.ShareLinkHandler.GetLinkAccesses)
+	r.Get("/note/{id}/attachments", handlers.AttachmentHandler.GetNoteAttachments)
+	r.Post("/note/{id}/attachments", handlers.AttachmentHandler.Upload)
+	r.Get("/note/{id}/attachments/{attachmentId}", handlers.AttachmentHandler.Download)
+	r.Get("/note/{id}/attachments/{attachmentId}/thumbnail", handlers.AttachmentHandler.Thumbnail)
+	r.Delete("/note/{id}/attachments/{attachmentId}", handlers.AttachmentHandler.Delete)
+	r.Get("/shared", handlers.NoteHandler.GetSharedNotes)
+	r.Get("/graph", handlers.GraphHandler.GetGraph)
+	r.Get("/tasks", handlers.TaskHandler.GetTasks)
+	r.Post("/tasks/{id}/toggle", handlers.TaskHandler.ToggleTask)
+	r.Get("/reminders", handlers.ReminderHandler.GetReminders)
+	r.Delete("/reminders/{reminderId}", handlers.ReminderHandler.DeleteReminder)
+	r.Get("/notifications", handlers.ReminderHandler.GetChannels)
+	r.Put("/notifications", handlers.ReminderHandler.SetChannels)
+	r.Post("/notifications/test", handlers.ReminderHandler.TestChannels)
+	r.Get("/daily", handlers.DailyHandler.GetCalendar)
+	r.Get("/daily/{date}", handlers.DailyHandler.GetDailyNote)
+	r.Get("/templates", handlers.TemplateHandler.GetTemplates)
+	r.Post("/templates", handlers.TemplateHandler.CreateTemplate)
+	r.Get("/templates/{templateId}", handlers.TemplateHandler.GetTemplate)
+	r.Put("/templates/{templateId}", handlers.TemplateHandler.UpdateTemplate)
+	r.Delete("/templates/{templateId}", handlers.TemplateHandler.DeleteTemplate)
+	r.Get("/properties", handlers.PropertyHandler.GetSchema)
+	r.Put("/properties", handlers.PropertyHandler.SetSchema)
+	r.Get("/keys", handlers.KeyHandler.GetKey)
+	r.Put("/keys", handlers.KeyHandler.SetKey)
+	r.Get("/keys/{username}", handlers.KeyHandler.GetPublicKey)
+	r.Get("/search", handlers.SearchHandler.Search)
+	r.Get("/searches", handlers.SearchHandler.GetSavedSearches)
+	r.Post("/searches", handlers.SearchHandler.CreateSavedSearch)
+	r.Get("/searches/{searchId}", handlers.SearchHandler.GetSavedSearch)
+	r.Put("/searches/{searchId}", handlers.SearchHandler.UpdateSavedSearch)
+	r.Delete("/searches/{searchId}", handlers.SearchHandler.DeleteSavedSearch)
+	r.Get("/searches/{searchId}/notes", handlers.SearchHandler.RunSavedSearch)
+	r.Get("/calendar", handlers.CalendarHandler.GetFeed)
+	r.Delete("/calendar", handlers.CalendarHandler.DeleteFeed)
+	r.Post("/calendar/token", handlers.CalendarHandler.RegenerateToken)
+	r.Get("/workspaces", handlers.WorkspaceHandler.GetWorkspaces)
+	r.Post("/workspaces", handlers.WorkspaceHandler.CreateWorkspace)
+	r.Get("/workspaces/{workspaceId}", handlers.WorkspaceHandler.GetWorkspace)
+	r.Put("/workspaces/{workspaceId}", handlers.WorkspaceHandler.UpdateWorkspace)
+	r.Delete("/workspaces/{workspaceId}", handlers.WorkspaceHandler.DeleteWorkspace)
+	r.Get("/workspaces/{workspaceId}/note", handlers.WorkspaceHandler.GetWorkspaceNotes)
+	r.Post("/workspaces/{workspaceId}/members", handlers.WorkspaceHandler.AddMember)
+	r.Put("/workspaces/{workspaceId}/members/{userId}", handlers.WorkspaceHandler.UpdateMember)
+	r.Delete("/workspaces/{workspaceId}/members/{userId}", handlers.WorkspaceHandler.RemoveMember)
+
+	return r
+}
+
+// setupPublicRoutes serves share links to people without an account.
+func setupPublicRoutes(handlers *handler.Handlers) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Get("/calendar/{token}", handlers.CalendarHandler.Feed)
+	r.Get("/{token}", handlers.ShareLinkHandler.Open)
+	r.Post("/{token}", handlers.ShareLinkHandler.Open)
 
 	return r
 }
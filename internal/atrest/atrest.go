@@ -0,0 +1,264 @@
+// Package atrest encrypts note content before it's written to the database.
+// Each value is encrypted with AES-256-GCM under a data key of its own, which
+// is stored next to it wrapped by a master key. Master keys are configured
+// as a list of id:key pairs, base64 keys of 32 bytes, one per line or
+// separated by commas, made with something like openssl rand -base64 32:
+//
+//	2026-10:3q2+7w4vKhtE0mbpO8Y5dXa0yJv2tR1cUeFz6HnGkLs=
+//	2026-01:m9Xq4ZtU2oV8bLr1sN6yKcJ0aWfE3hPdT7gQiR5vMxA=
+//
+// The first key encrypts everything written from then on, the others are
+// only kept to read what they encrypted until it's rotated to the first.
+//
+// With keys configured, note content, task text and the targets of wiki
+// links are encrypted. Where rows have to be found by one of those, a keyed
+// hash stands in for it (see Blind): link targets, task ids, which are made
+// from the task's text, and the words in the full text index, which keeps
+// the hash of each word of a note's content rather than the word. Searching
+// content then matches whole words, ignoring case, but not prefixes or other
+// forms of a word, and the index still shows which notes share words and how
+// often. Titles, tags, folders, properties, due dates and priorities stay in
+// plaintext, notes are sorted and filtered by them in SQL, and titles are
+// searched as before.
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const keySize = 32
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring holds the master keys. A nil or empty Keyring leaves values in
+// plaintext.
+type Keyring struct {
+	current string
+	// ids in the order they were listed
+	ids  []string
+	keys map[string]cipher.AEAD
+	// keys of the hashes Blind makes, one made from each master key
+	blinds map[string][]byte
+}
+
+// Sealed is a value as it's stored. KeyID names the master key DataKey is
+// wrapped with, it's empty for values kept in plaintext.
+type Sealed struct {
+	KeyID   string
+	DataKey string
+	Value   string
+}
+
+// Load reads the master keys from file if it's set, and from keys otherwise.
+// Neither being set turns encryption at rest off.
+func Load(keys string, file string) (*Keyring, error) {
+	if file != "" {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading master key file: %w", err)
+		}
+		keys = string(contents)
+	}
+	return Parse(keys)
+}
+
+// Parse reads a list of master keys, ignoring blank lines and lines
+// starting with #.
+func Parse(list string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]cipher.AEAD), blinds: make(map[string][]byte)}
+	for _, line := range strings.Split(list, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			err := keyring.add(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keyring, nil
+}
+
+func (k *Keyring) add(entry string) error {
+	id, encoded, ok := strings.Cut(entry, ":")
+	if !ok || !keyIDPattern.MatchString(id) {
+		return errors.New("master keys are written id:key, with ids of up to 64 letters, digits, dots, dashes and underscores")
+	}
+	if _, exists := k.keys[id]; exists {
+		return fmt.Errorf("master key %q is listed twice", id)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return fmt.Errorf("master key %q isn't %d bytes of base64", id, keySize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	// the hashes don't use the master key itself, so they have nothing to
+	// do with what it encrypts
+	blind := hmac.New(sha256.New, key)
+	blind.Write([]byte("v8box blind index"))
+
+	k.ids = append(k.ids, id)
+	k.keys[id] = aead
+	k.blinds[id] = blind.Sum(nil)
+	if k.current == "" {
+		k.current = id
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Enabled reports whether new values are encrypted.
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.current != ""
+}
+
+// Current is the id of the master key new values are encrypted with, empty
+// when they're kept in plaintext.
+func (k *Keyring) Current() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// Has reports whether values wrapped with the master key id can be read.
+func (k *Keyring) Has(id string) bool {
+	if k == nil {
+		return false
+	}
+	_, ok := k.keys[id]
+	return ok
+}
+
+// Seal encrypts value with a new data key. The value is bound to context,
+// the id of the row it belongs to, so it can't be copied to another row.
+func (k *Keyring) Seal(context string, value string) (Sealed, error) {
+	if !k.Enabled() {
+		return Sealed{Value: value}, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	wrapped, err := seal(k.keys[k.current], dataKey, k.current)
+	if err != nil {
+		return Sealed{}, err
+	}
+	sealed, err := seal(aead, []byte(value), context)
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{KeyID: k.current, DataKey: wrapped, Value: sealed}, nil
+}
+
+// Open decrypts a value Seal encrypted for the same context.
+func (k *Keyring) Open(context string, sealed Sealed) (string, error) {
+	if sealed.KeyID == "" {
+		return sealed.Value, nil
+	}
+	if !k.Has(sealed.KeyID) {
+		return "", fmt.Errorf("value is encrypted with master key %q, which isn't configured", sealed.KeyID)
+	}
+
+	dataKey, err := open(k.keys[sealed.KeyID], sealed.DataKey, sealed.KeyID)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := open(aead, sealed.Value, context)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
+	}
+	return string(value), nil
+}
+
+// Blind returns a keyed hash of value to store in its place where rows are
+// looked up by it, made with the current master key. Without one value is
+// returned as it is.
+func (k *Keyring) Blind(value string) string {
+	if !k.Enabled() {
+		return value
+	}
+	return blind(k.blinds[k.current], value)
+}
+
+// Blinds returns value, then what Blind would make of it with each master
+// key, in the order they're listed. Lookups match any of them, to find rows
+// written before encryption at rest was turned on or before a rotation
+// finished.
+func (k *Keyring) Blinds(value string) []string {
+	values := []string{value}
+	if k == nil {
+		return values
+	}
+	for _, id := range k.ids {
+		values = append(values, blind(k.blinds[id], value))
+	}
+	return values
+}
+
+func blind(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// seal returns the base64 of a new nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(additionalData))
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func open(aead cipher.AEAD, encoded string, additionalData string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+}
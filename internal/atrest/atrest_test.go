@@ -0,0 +1,159 @@
+package atrest
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	oldKey = "2026-01:FLqaHEUYqnSKvtcxrpFcrRToKuxbkz1UUiDlr8P0qEc="
+	newKey = "2026-10:+7pIKSzBQHM/C5CZkNUl2tXgUGpkb6sSSrs/PLlTEss="
+)
+
+func mustParse(t *testing.T, list string) *Keyring {
+	t.Helper()
+	keys, err := Parse(list)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", list, err)
+	}
+	return keys
+}
+
+func TestSealOpen(t *testing.T) {
+	keys := mustParse(t, newKey+"\n"+oldKey)
+	sealed, err := keys.Seal("note-1", "Meet at 9, bring the keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.KeyID != "2026-10" {
+		t.Errorf("sealed with %q, not the first key", sealed.KeyID)
+	}
+	if strings.Contains(sealed.Value, "Meet") || sealed.DataKey == "" {
+		t.Errorf("not encrypted: %+v", sealed)
+	}
+
+	opened, err := keys.Open("note-1", sealed)
+	if err != nil || opened != "Meet at 9, bring the keys" {
+		t.Errorf("opened to %q, %v", opened, err)
+	}
+
+	// every value has a data key of its own
+	again, err := keys.Seal("note-1", "Meet at 9, bring the keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Value == sealed.Value || again.DataKey == sealed.DataKey {
+		t.Error("the same value sealed twice came out the same")
+	}
+
+	// an older key can still open what it sealed
+	old := mustParse(t, oldKey)
+	sealed, err = old.Seal("note-1", "from before the rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err = keys.Open("note-1", sealed)
+	if err != nil || opened != "from before the rotation" {
+		t.Errorf("opened to %q, %v", opened, err)
+	}
+}
+
+func TestOpenWrongContext(t *testing.T) {
+	keys := mustParse(t, newKey)
+	sealed, err := keys.Seal("note-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// copied to another row
+	if opened, err := keys.Open("note-2", sealed); err == nil {
+		t.Errorf("opened in another context to %q", opened)
+	}
+}
+
+func TestOpenUnknownKey(t *testing.T) {
+	sealed, err := mustParse(t, oldKey).Seal("note-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mustParse(t, newKey).Open("note-1", sealed)
+	if err == nil || !strings.Contains(err.Error(), `"2026-01"`) {
+		t.Errorf("got %v", err)
+	}
+	_, err = (*Keyring)(nil).Open("note-1", sealed)
+	if err == nil {
+		t.Error("opened without any keys")
+	}
+
+	// the wrapped data key belongs to its master key
+	sealed.KeyID = "2026-10"
+	_, err = mustParse(t, newKey+","+oldKey).Open("note-1", sealed)
+	if err == nil {
+		t.Error("opened with the wrong master key")
+	}
+}
+
+func TestPlaintext(t *testing.T) {
+	var keys *Keyring
+	sealed, err := keys.Seal("note-1", "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed != (Sealed{Value: "plain"}) {
+		t.Errorf("got %+v", sealed)
+	}
+	// values stored before keys were configured are read as they are
+	opened, err := mustParse(t, newKey).Open("note-1", sealed)
+	if err != nil || opened != "plain" {
+		t.Errorf("opened to %q, %v", opened, err)
+	}
+	if keys.Blind("word") != "word" {
+		t.Error("blinded without keys")
+	}
+}
+
+func TestBlinds(t *testing.T) {
+	rotating := mustParse(t, newKey+"\n"+oldKey)
+	blinds := rotating.Blinds("groceries")
+	if len(blinds) != 3 {
+		t.Fatalf("got %d blinds", len(blinds))
+	}
+
+	// rows written in plaintext, under the old key and under the new one
+	// are all found
+	for _, written := range []string{
+		(*Keyring)(nil).Blind("groceries"),
+		mustParse(t, oldKey).Blind("groceries"),
+		mustParse(t, newKey).Blind("groceries"),
+	} {
+		if !slices.Contains(blinds, written) {
+			t.Errorf("%q isn't among %v", written, blinds)
+		}
+	}
+	if rotating.Blind("groceries") != mustParse(t, newKey).Blind("groceries") {
+		t.Error("not blinded with the first key")
+	}
+
+	if slices.Contains(blinds, rotating.Blind("grocery")) {
+		t.Error("another word has the same blind")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, list := range []string{
+		"nokey",
+		"bad id:" + strings.SplitN(newKey, ":", 2)[1],
+		"short:c2hvcnQ=",
+		newKey + "," + newKey,
+	} {
+		if _, err := Parse(list); err == nil {
+			t.Errorf("%q parsed", list)
+		}
+	}
+
+	keys := mustParse(t, "# rotated in October\n"+newKey+"\n\n"+oldKey+"\n")
+	if keys.Current() != "2026-10" || !keys.Has("2026-01") {
+		t.Errorf("current %q", keys.Current())
+	}
+}
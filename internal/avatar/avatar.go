@@ -0,0 +1,204 @@
+// Package avatar keeps resized copies of user avatars on disk and draws
+// identicons for users without one.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/vaporii/v8box/internal/thumbnail"
+)
+
+// MaxSize is the largest avatar image accepted, before resizing.
+const MaxSize = 5 << 20
+
+type Store struct {
+	dir   string
+	sizes []int
+}
+
+func NewStore(dir string, sizes []int) (*Store, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("at least one avatar size is required")
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid avatar size %d", size)
+		}
+	}
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		dir:   dir,
+		sizes: slices.Sorted(slices.Values(sizes)),
+	}, nil
+}
+
+// Size picks the smallest standard size at least as big as requested, or
+// the biggest one there is. 0 asks for the default.
+func (s *Store) Size(requested int) int {
+	if requested <= 0 {
+		requested = 128
+	}
+	for _, size := range s.sizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return s.sizes[len(s.sizes)-1]
+}
+
+// Save crops the image to a square and writes it at every standard size,
+// replacing the user's previous avatar. Returns thumbnail.ErrUnsupported
+// for anything that isn't a JPEG, PNG, GIF or WebP image.
+func (s *Store) Save(userId string, r io.ReadSeeker) error {
+	if !validID(userId) {
+		return fmt.Errorf("invalid user id %q", userId)
+	}
+
+	largest, err := thumbnail.Load(r, s.sizes[len(s.sizes)-1], true)
+	if err != nil {
+		return err
+	}
+
+	for _, size := range s.sizes {
+		img := largest
+		if size < largest.Bounds().Dx() {
+			img = thumbnail.Scale(largest, size)
+		}
+
+		var buf bytes.Buffer
+		err = png.Encode(&buf, img)
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(s.path(userId, size), buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open returns the stored avatar at one of the standard sizes, or
+// os.ErrNotExist if the user doesn't have one.
+func (s *Store) Open(userId string, size int) (*os.File, error) {
+	if !validID(userId) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(s.path(userId, size))
+}
+
+func (s *Store) Exists(userId string) bool {
+	file, err := s.Open(userId, s.sizes[0])
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+func (s *Store) Delete(userId string) error {
+	if !validID(userId) {
+		return fmt.Errorf("invalid user id %q", userId)
+	}
+
+	for _, size := range s.sizes {
+		err := os.Remove(s.path(userId, size))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// validID keeps ids from reaching outside the avatar directory.
+func validID(userId string) bool {
+	return userId != "" && userId[0] != '.' && filepath.Base(userId) == userId
+}
+
+func (s *Store) path(userId string, size int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%d.png", userId, size))
+}
+
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Identicon draws a symmetric 5 by 5 pattern derived from seed, so the same
+// user always gets the same picture.
+func Identicon(seed string, size int) ([]byte, error) {
+	hash := sha256.Sum256([]byte(seed))
+
+	foreground := color.NRGBA{
+		R: 40 + hash[29]%160,
+		G: 40 + hash[30]%160,
+		B: 40 + hash[31]%160,
+		A: 0xff,
+	}
+	background := color.NRGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+
+	// the pattern sits in a 6 by 6 grid with half a cell of margin
+	cell := max(1, size/6)
+	margin := (size - cell*5) / 2
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			img.SetNRGBA(x, y, background)
+		}
+	}
+
+	for row := range 5 {
+		for col := range 3 {
+			if hash[row*3+col]&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, 4 - col} {
+				x0, y0 := margin+c*cell, margin+row*cell
+				for y := y0; y < y0+cell; y++ {
+					for x := x0; x < x0+cell; x++ {
+						img.SetNRGBA(x, y, foreground)
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
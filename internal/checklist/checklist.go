@@ -0,0 +1,142 @@
+// Package checklist finds Markdown task list items, "- [ ] do this", in note
+// content. Items can carry a due date, @due(2026-11-01), and a priority,
+// @priority(high).
+package checklist
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DateLayout = "2006-01-02"
+
+type Priority string
+
+const (
+	PriorityNone   Priority = ""
+	PriorityHigh   Priority = "high"
+	PriorityMedium Priority = "medium"
+	PriorityLow    Priority = "low"
+)
+
+// Valid says whether p is one of the priorities above.
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityNone, PriorityHigh, PriorityMedium, PriorityLow:
+		return true
+	}
+	return false
+}
+
+var ErrNotFound = errors.New("task not found")
+
+var (
+	itemPattern     = regexp.MustCompile(`^(\s*(?:[-*+]|\d+[.)])\s+\[)([ xX])\]\s+(.*)$`)
+	duePattern      = regexp.MustCompile(`\s*@due\((\d{4}-\d{2}-\d{2})\)`)
+	priorityPattern = regexp.MustCompile(`(?i)\s*@priority\((high|medium|low)\)`)
+	fencePattern    = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+)
+
+// Item is a task list item in a note.
+type Item struct {
+	// stays the same when the item is checked or moved around the note
+	ID string
+	// zero based index of the item's line
+	Line int
+	// without the due date and priority
+	Text     string
+	Done     bool
+	Due      string
+	Priority Priority
+}
+
+// Parse lists the task list items in content, leaving out any inside code
+// blocks. noteId goes into the item ids, so they're unique across notes.
+func Parse(noteId string, content string) []Item {
+	items := make([]Item, 0)
+	seen := make(map[string]int)
+	fence := ""
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if match := fencePattern.FindStringSubmatch(line); match != nil {
+			switch fence {
+			case "":
+				fence = match[1]
+			case match[1]:
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+
+		match := itemPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		item := parseText(match[3])
+		item.Line = i
+		item.Done = match[2] != " "
+		if item.Text == "" {
+			continue
+		}
+
+		// the same text twice in a note is told apart by its occurrence
+		occurrence := seen[item.Text]
+		seen[item.Text]++
+		item.ID = itemID(noteId, item.Text, occurrence)
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func parseText(text string) Item {
+	var item Item
+	if match := duePattern.FindStringSubmatch(text); match != nil {
+		if _, err := time.Parse(DateLayout, match[1]); err == nil {
+			item.Due = match[1]
+			text = duePattern.ReplaceAllString(text, "")
+		}
+	}
+	if match := priorityPattern.FindStringSubmatch(text); match != nil {
+		item.Priority = Priority(strings.ToLower(match[1]))
+		text = priorityPattern.ReplaceAllString(text, "")
+	}
+	item.Text = strings.TrimSpace(text)
+	return item
+}
+
+func itemID(noteId string, text string, occurrence int) string {
+	sum := sha1.Sum([]byte(noteId + "\n" + text + "\n" + strconv.Itoa(occurrence)))
+	return hex.EncodeToString(sum[:12])
+}
+
+// Toggle checks or unchecks the item with id, changing only the checkbox on
+// its line. It returns the new content and the item as it is now.
+func Toggle(noteId string, content string, id string) (string, *Item, error) {
+	for _, item := range Parse(noteId, content) {
+		if item.ID != id {
+			continue
+		}
+
+		lines := strings.Split(content, "\n")
+		match := itemPattern.FindStringSubmatch(strings.TrimSuffix(lines[item.Line], "\r"))
+		mark := "x"
+		if item.Done {
+			mark = " "
+		}
+		lines[item.Line] = match[1] + mark + lines[item.Line][len(match[1])+1:]
+
+		item.Done = !item.Done
+		return strings.Join(lines, "\n"), &item, nil
+	}
+	return "", nil, ErrNotFound
+}
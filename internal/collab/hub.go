@@ -0,0 +1,462 @@
+package collab
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/crdt"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/keyed"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+// Conn is the part of a websocket connection the hub needs.
+type Conn interface {
+	ReadJSON(v any) error
+	WriteJSON(v any) error
+	Close() error
+}
+
+var ErrHubClosed = errors.New("collaboration hub is shutting down")
+
+// how many outgoing messages a client can fall behind before it gets dropped
+const clientBuffer = 256
+
+// how often peers' access to the note is checked again, so those who lost
+// it or were made viewers stop editing soon after
+const accessInterval = 5 * time.Second
+
+// the site of changes made outside the session, which clients never get
+// since theirs are numbers
+const serverSite = "server"
+
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	// held while a note's session starts, stops or saves, and while a
+	// write from outside reaches it, so they happen one at a time without
+	// the whole hub waiting on the database
+	notes           keyed.Mutex
+	noteService     service.NoteService
+	compactInterval time.Duration
+	closed          bool
+}
+
+type session struct {
+	id     string
+	noteID string
+
+	mu       sync.Mutex
+	doc      *crdt.Document
+	clients  map[*client]struct{}
+	nextSite int
+	dirty    bool
+
+	stop chan struct{}
+}
+
+type client struct {
+	peer Peer
+	conn Conn
+	send chan ServerMessage
+	done chan struct{}
+	once sync.Once
+}
+
+func NewHub(noteService service.NoteService, compactInterval time.Duration) *Hub {
+	return &Hub{
+		sessions:        make(map[string]*session),
+		noteService:     noteService,
+		compactInterval: compactInterval,
+	}
+}
+
+// Serve joins conn to the editing session for noteID and blocks until the
+// connection ends.
+func (h *Hub) Serve(noteID string, user dto.UserJwtPackage, conn Conn) error {
+	s, c, err := h.join(noteID, user, conn)
+	if err != nil {
+		return err
+	}
+	defer h.leave(s, c)
+
+	go c.writeLoop()
+
+	for {
+		var msg ClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return nil
+		}
+		s.handle(c, msg)
+	}
+}
+
+// Close persists every open session and disconnects its clients.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	sessions := make([]*session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mu.Unlock()
+
+	for _, s := range sessions {
+		unlock := h.notes.Lock(s.noteID)
+		s.save(h.noteService)
+		unlock()
+
+		s.mu.Lock()
+		for c := range s.clients {
+			c.drop()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (h *Hub) join(noteID string, user dto.UserJwtPackage, conn Conn) (*session, *client, error) {
+	unlock := h.notes.Lock(noteID)
+	defer unlock()
+
+	// checked on every join, not just when the session starts
+	note, err := h.noteService.GetNoteByID(user.UserID, noteID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, nil, ErrHubClosed
+	}
+	s, exists := h.sessions[noteID]
+	if !exists {
+		s = &session{
+			id:      uuid.NewString(),
+			noteID:  noteID,
+			doc:     crdt.NewDocument(note.Content),
+			clients: make(map[*client]struct{}),
+			stop:    make(chan struct{}),
+		}
+		h.sessions[noteID] = s
+		go h.compactLoop(s)
+		go s.accessLoop(h.noteService)
+		logging.Verbose("started collaboration session %s for note %s", s.id, noteID)
+	}
+	h.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSite++
+	c := &client{
+		peer: Peer{
+			Site:     strconv.Itoa(s.nextSite),
+			UserID:   user.UserID,
+			Username: user.Username,
+			Role:     note.Role,
+		},
+		conn: conn,
+		send: make(chan ServerMessage, clientBuffer),
+		done: make(chan struct{}),
+	}
+
+	peers := make([]Peer, 0, len(s.clients))
+	for other := range s.clients {
+		peers = append(peers, other.peer)
+	}
+
+	c.queue(ServerMessage{
+		Type:     MessageWelcome,
+		Session:  s.id,
+		Site:     c.peer.Site,
+		Clock:    s.doc.Clock(),
+		Elements: s.doc.Elements(),
+		Peers:    peers,
+	})
+	peer := c.peer
+	s.broadcastLocked(c, ServerMessage{Type: MessagePresence, Peer: &peer})
+	s.clients[c] = struct{}{}
+
+	return s, c, nil
+}
+
+func (h *Hub) leave(s *session, c *client) {
+	c.drop()
+
+	unlock := h.notes.Lock(s.noteID)
+	defer unlock()
+
+	s.mu.Lock()
+	delete(s.clients, c)
+	peer := c.peer
+	s.broadcastLocked(c, ServerMessage{Type: MessagePresence, Peer: &peer, Left: true})
+	empty := len(s.clients) == 0
+	s.mu.Unlock()
+
+	if !empty {
+		return
+	}
+
+	close(s.stop)
+	h.mu.Lock()
+	if h.sessions[s.noteID] == s {
+		delete(h.sessions, s.noteID)
+	}
+	h.mu.Unlock()
+	// saved before the note is unlocked so a session started right after
+	// this one loads the final content
+	s.save(h.noteService)
+	logging.Verbose("closed collaboration session %s for note %s", s.id, s.noteID)
+}
+
+// Edit runs a write made outside the sessions of noteIDs, by the API or
+// the server, so their next saves don't undo it. write gets the text of
+// those that have a session open, saves the notes and returns the content
+// each has now, which the sessions take on and send peers as ops. None of
+// the sessions starts, stops, saves or takes ops while write runs, and
+// they're left as they were if it fails.
+func (h *Hub) Edit(noteIDs []string, write func(live map[string]string) (map[string]string, error)) error {
+	unlock := h.notes.LockAll(noteIDs)
+	defer unlock()
+
+	h.mu.Lock()
+	sessions := make([]*session, 0, len(noteIDs))
+	for _, noteID := range noteIDs {
+		if s, exists := h.sessions[noteID]; exists && !slices.Contains(sessions, s) {
+			sessions = append(sessions, s)
+		}
+	}
+	h.mu.Unlock()
+
+	live := make(map[string]string, len(sessions))
+	for _, s := range sessions {
+		// only ever locked together here, while their notes are, so the
+		// order doesn't matter
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		live[s.noteID] = s.doc.String()
+	}
+
+	contents, err := write(live)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		content, written := contents[s.noteID]
+		if !written {
+			continue
+		}
+		ops := s.doc.Replace(serverSite, content)
+		if len(ops) > 0 {
+			s.broadcastLocked(nil, ServerMessage{Type: MessageOps, Site: serverSite, Ops: ops})
+		}
+		// what's left is what was just saved
+		s.dirty = false
+	}
+	return nil
+}
+
+func (s *session) handle(c *client, msg ClientMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Session != s.id {
+		c.fail("stale session, reconnect to get a fresh snapshot")
+		return
+	}
+
+	switch msg.Type {
+	case MessageOps:
+		if !c.peer.Role.Allows(models.NoteRoleEditor) {
+			c.fail("you only have view access to this note")
+			return
+		}
+
+		var applied []crdt.Op
+		var failure string
+		for _, op := range msg.Ops {
+			if op.Kind == crdt.OpInsert && op.ID.Site != c.peer.Site {
+				failure = "inserts must use the site assigned in the welcome message"
+				break
+			}
+			ops, err := s.doc.Apply(op)
+			if err != nil {
+				failure = err.Error()
+				break
+			}
+			applied = append(applied, ops...)
+		}
+		// ops before a bad one have already been applied, so peers still
+		// need to hear about them
+		if len(applied) > 0 {
+			s.dirty = true
+			s.broadcastLocked(c, ServerMessage{Type: MessageOps, Site: c.peer.Site, Ops: applied})
+		}
+		if failure != "" {
+			c.fail(failure)
+		}
+	case MessageCursor:
+		if msg.Cursor == nil {
+			c.peer.Cursor = nil
+		} else {
+			if !s.validAnchor(msg.Cursor.Anchor) || !s.validAnchor(msg.Cursor.Head) {
+				c.fail("cursor references an unknown element")
+				return
+			}
+			cursor := *msg.Cursor
+			c.peer.Cursor = &cursor
+		}
+		peer := c.peer
+		s.broadcastLocked(c, ServerMessage{Type: MessagePresence, Peer: &peer})
+	default:
+		c.fail("unknown message type")
+	}
+}
+
+func (s *session) validAnchor(id crdt.ID) bool {
+	return id.IsZero() || s.doc.Contains(id)
+}
+
+func (s *session) broadcastLocked(from *client, msg ServerMessage) {
+	for c := range s.clients {
+		if c != from {
+			c.queue(msg)
+		}
+	}
+}
+
+func (h *Hub) compactLoop(s *session) {
+	ticker := time.NewTicker(h.compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			unlock := h.notes.Lock(s.noteID)
+			s.save(h.noteService)
+			unlock()
+		}
+	}
+}
+
+func (s *session) accessLoop(noteService service.NoteService) {
+	ticker := time.NewTicker(accessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkAccess(noteService)
+		}
+	}
+}
+
+// checkAccess drops peers who can't see the note anymore and updates the
+// roles of the others, since shares and workspace memberships can change
+// while they're connected.
+func (s *session) checkAccess(noteService service.NoteService) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		role, err := noteService.GetNoteRole(c.peer.UserID, s.noteID)
+		var notFound *httperror.NotFoundError
+		var forbidden *httperror.ForbiddenError
+		if errors.As(err, &notFound) || errors.As(err, &forbidden) {
+			logging.Verbose("%s lost access to note %s during collaboration session", c.peer.UserID, s.noteID)
+			c.drop()
+			continue
+		}
+		if err != nil {
+			logging.Warning("couldn't check access to note %s: %v", s.noteID, err)
+			continue
+		}
+
+		s.mu.Lock()
+		if _, connected := s.clients[c]; connected && c.peer.Role != role {
+			c.peer.Role = role
+			peer := c.peer
+			c.queue(ServerMessage{Type: MessagePresence, Peer: &peer})
+			s.broadcastLocked(c, ServerMessage{Type: MessagePresence, Peer: &peer})
+		}
+		s.mu.Unlock()
+	}
+}
+
+// save flattens the document back into the note's content if anything changed
+// since the last save. The document is only dropped once the note is gone.
+// The note has to be locked, see Hub.notes.
+func (s *session) save(noteService service.NoteService) {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return
+	}
+	content := s.doc.String()
+	s.dirty = false
+	s.mu.Unlock()
+
+	_, err := noteService.SaveLiveContent(s.noteID, content)
+	var notFound *httperror.NotFoundError
+	if errors.As(err, &notFound) {
+		logging.Info("note %s was deleted during collaboration session", s.noteID)
+		return
+	}
+	if err != nil {
+		logging.Warning("couldn't save collaboration session for note %s: %v", s.noteID, err)
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+func (c *client) queue(msg ServerMessage) {
+	select {
+	case c.send <- msg:
+	default:
+		// too far behind, it can reconnect and start from a fresh snapshot
+		c.drop()
+	}
+}
+
+func (c *client) fail(message string) {
+	c.queue(ServerMessage{Type: MessageError, Message: message})
+}
+
+func (c *client) drop() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.drop()
+				return
+			}
+		}
+	}
+}
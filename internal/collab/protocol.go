@@ -0,0 +1,51 @@
+package collab
+
+import (
+	"github.com/vaporii/v8box/internal/crdt"
+	"github.com/vaporii/v8box/internal/models"
+)
+
+type MessageType string
+
+const (
+	MessageWelcome  MessageType = "welcome"
+	MessageOps      MessageType = "ops"
+	MessageCursor   MessageType = "cursor"
+	MessagePresence MessageType = "presence"
+	MessageError    MessageType = "error"
+)
+
+// Cursor positions are anchored to element IDs rather than offsets so they
+// stay put while other peers edit. A zero ID is the start of the document.
+type Cursor struct {
+	Anchor crdt.ID `json:"anchor"`
+	Head   crdt.ID `json:"head"`
+}
+
+type Peer struct {
+	Site     string          `json:"site"`
+	UserID   string          `json:"user_id"`
+	Username string          `json:"username"`
+	Role     models.NoteRole `json:"role"`
+	Cursor   *Cursor         `json:"cursor,omitempty"`
+}
+
+type ClientMessage struct {
+	Type    MessageType `json:"type"`
+	Session string      `json:"session"`
+	Ops     []crdt.Op   `json:"ops,omitempty"`
+	Cursor  *Cursor     `json:"cursor,omitempty"`
+}
+
+type ServerMessage struct {
+	Type     MessageType    `json:"type"`
+	Session  string         `json:"session,omitempty"`
+	Site     string         `json:"site,omitempty"`
+	Clock    uint64         `json:"clock,omitempty"`
+	Elements []crdt.Element `json:"elements,omitempty"`
+	Peers    []Peer         `json:"peers,omitempty"`
+	Ops      []crdt.Op      `json:"ops,omitempty"`
+	Peer     *Peer          `json:"peer,omitempty"`
+	Left     bool           `json:"left,omitempty"`
+	Message  string         `json:"message,omitempty"`
+}
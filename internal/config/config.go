@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vaporii/v8box/internal/logging"
@@ -14,12 +15,62 @@ type Config struct {
 	Issuer         string
 	URL            string
 	AvatarPath     string
-	DisableXSRF    bool
-	TokenSecret    string
-	ServerAddress  string
-	SQLitePath     string
-	Environment    string
-	JwtSecret      string
+	// edge lengths in pixels avatars are stored at
+	AvatarSizes   []int
+	DisableXSRF   bool
+	TokenSecret   string
+	ServerAddress string
+	SQLitePath    string
+	// master keys note content, task text and link targets are encrypted
+	// with at rest, see package atrest for what stays in plaintext. The
+	// file is read instead of the list when it's set, with neither
+	// everything is stored in plaintext.
+	MasterKeys    string
+	MasterKeyFile string
+	Environment   string
+	JwtSecret     string
+	// number of events kept per user for Last-Event-ID resume
+	EventReplaySize int
+	// how long they're kept once the user has no event streams open
+	EventReplayAge time.Duration
+	EventHeartbeat time.Duration
+	// how often live editing sessions are written back to the note
+	CollabCompactInterval time.Duration
+	// local or s3
+	BlobStore     string
+	BlobPath      string
+	S3Endpoint    string
+	S3Bucket      string
+	S3Region      string
+	S3AccessKey   string
+	S3SecretKey   string
+	S3PathStyle   bool
+	MaxUploadSize int64
+	// largest archive accepted by POST /me/import
+	MaxImportSize int64
+	// how often unreferenced blobs are removed from storage
+	BlobGCInterval time.Duration
+	ThumbnailPath  string
+	// where finished personal data exports wait to be downloaded
+	ExportPath string
+	ExportTTL  time.Duration
+	// longest edge in pixels of each thumbnail size offered
+	ThumbnailSizes []int
+	// bytes of rendered note HTML kept in memory
+	RenderCacheSize int
+	// how often the reminder scheduler looks for due reminders
+	ReminderInterval time.Duration
+	// lets webhook and ntfy channels reach loopback, private and link-local
+	// addresses, for local test receivers. Off, as anyone can set them.
+	NotifyAllowPrivate bool
+	// outgoing mail for email notifications, which are off without a host
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// starttls, tls or none
+	SMTPSecurity string
 	// none, error, warning, info, verbose
 	Logging logging.LogLevel
 }
@@ -28,18 +79,49 @@ func LoadConfig() *Config {
 	logLevel := logging.LogLevel(getEnvAsInt("V8BOX_LOGGING", int(logging.LogLevelWarning)))
 	logging.SetLogLevel(logLevel)
 	return &Config{
-		TokenDuration:  5 * time.Minute,
-		CookieDuration: 24 * time.Hour,
-		Issuer:         getEnv("V8BOX_ISSUER", "v8box"),
-		URL:            getEnv("V8BOX_URL", ""),
-		AvatarPath:     getEnv("V8BOX_AVATAR_PATH", "/tmp"),
-		DisableXSRF:    getEnvAsBool("V8BOX_DISABLE_XSRF", true),
-		TokenSecret:    getEnv("V8BOX_TOKEN_SECRET", "secret"),
-		ServerAddress:  getEnv("V8BOX_ADDRESS", ":3000"),
-		SQLitePath:     getEnv("V8BOX_SQLITE_PATH", "./dev.db"),
-		Environment:    getEnv("V8BOX_ENVIRONMENT", "dev"),
-		JwtSecret:      getEnv("V8BOX_JWT_SECRET", ""),
-		Logging:        logLevel,
+		TokenDuration:         5 * time.Minute,
+		CookieDuration:        24 * time.Hour,
+		Issuer:                getEnv("V8BOX_ISSUER", "v8box"),
+		URL:                   getEnv("V8BOX_URL", ""),
+		AvatarPath:            getEnv("V8BOX_AVATAR_PATH", "/tmp"),
+		AvatarSizes:           getEnvAsIntList("V8BOX_AVATAR_SIZES", []int{32, 64, 128, 256}),
+		DisableXSRF:           getEnvAsBool("V8BOX_DISABLE_XSRF", true),
+		TokenSecret:           getEnv("V8BOX_TOKEN_SECRET", "secret"),
+		ServerAddress:         getEnv("V8BOX_ADDRESS", ":3000"),
+		SQLitePath:            getEnv("V8BOX_SQLITE_PATH", "./dev.db"),
+		MasterKeys:            getEnv("V8BOX_MASTER_KEYS", ""),
+		MasterKeyFile:         getEnv("V8BOX_MASTER_KEY_FILE", ""),
+		Environment:           getEnv("V8BOX_ENVIRONMENT", "dev"),
+		JwtSecret:             getEnv("V8BOX_JWT_SECRET", ""),
+		EventReplaySize:       getEnvAsInt("V8BOX_EVENT_REPLAY_SIZE", 100),
+		EventReplayAge:        time.Duration(getEnvAsPositiveInt("V8BOX_EVENT_REPLAY_SECONDS", 3600)) * time.Second,
+		EventHeartbeat:        time.Duration(getEnvAsPositiveInt("V8BOX_EVENT_HEARTBEAT_SECONDS", 15)) * time.Second,
+		CollabCompactInterval: time.Duration(getEnvAsPositiveInt("V8BOX_COLLAB_COMPACT_SECONDS", 10)) * time.Second,
+		BlobStore:             getEnv("V8BOX_BLOB_STORE", "local"),
+		BlobPath:              getEnv("V8BOX_BLOB_PATH", "./blobs"),
+		S3Endpoint:            getEnv("V8BOX_S3_ENDPOINT", ""),
+		S3Bucket:              getEnv("V8BOX_S3_BUCKET", ""),
+		S3Region:              getEnv("V8BOX_S3_REGION", "us-east-1"),
+		S3AccessKey:           getEnv("V8BOX_S3_ACCESS_KEY", ""),
+		S3SecretKey:           getEnv("V8BOX_S3_SECRET_KEY", ""),
+		S3PathStyle:           getEnvAsBool("V8BOX_S3_PATH_STYLE", true),
+		MaxUploadSize:         int64(getEnvAsInt("V8BOX_MAX_UPLOAD_MB", 25)) << 20,
+		MaxImportSize:         int64(getEnvAsInt("V8BOX_MAX_IMPORT_MB", 1024)) << 20,
+		BlobGCInterval:        time.Duration(getEnvAsPositiveInt("V8BOX_BLOB_GC_MINUTES", 60)) * time.Minute,
+		ThumbnailPath:         getEnv("V8BOX_THUMBNAIL_PATH", "./thumbnails"),
+		ExportPath:            getEnv("V8BOX_EXPORT_PATH", "./exports"),
+		ExportTTL:             time.Duration(getEnvAsPositiveInt("V8BOX_EXPORT_TTL_HOURS", 24)) * time.Hour,
+		ThumbnailSizes:        getEnvAsIntList("V8BOX_THUMBNAIL_SIZES", []int{128, 512}),
+		RenderCacheSize:       getEnvAsInt("V8BOX_RENDER_CACHE_MB", 32) << 20,
+		ReminderInterval:      time.Duration(getEnvAsPositiveInt("V8BOX_REMINDER_INTERVAL_SECONDS", 15)) * time.Second,
+		NotifyAllowPrivate:    getEnvAsBool("V8BOX_NOTIFY_ALLOW_PRIVATE", false),
+		SMTPHost:              getEnv("V8BOX_SMTP_HOST", ""),
+		SMTPPort:              getEnvAsInt("V8BOX_SMTP_PORT", 587),
+		SMTPUsername:          getEnv("V8BOX_SMTP_USERNAME", ""),
+		SMTPPassword:          getEnv("V8BOX_SMTP_PASSWORD", ""),
+		SMTPFrom:              getEnv("V8BOX_SMTP_FROM", ""),
+		SMTPSecurity:          getEnv("V8BOX_SMTP_SECURITY", "starttls"),
+		Logging:               logLevel,
 	}
 }
 
@@ -67,3 +149,29 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsPositiveInt is for intervals, which tickers can't run with if
+// they're zero or less.
+func getEnvAsPositiveInt(key string, defaultValue int) int {
+	value := getEnvAsInt(key, defaultValue)
+	if value <= 0 {
+		logging.Warning("%s has to be more than 0, using %d", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvAsIntList(key string, defaultValue []int) []int {
+	if value, exists := os.LookupEnv(key); exists {
+		var list []int
+		for _, item := range strings.Split(value, ",") {
+			conv, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				return defaultValue
+			}
+			list = append(list, conv)
+		}
+		return list
+	}
+	return defaultValue
+}
@@ -0,0 +1,312 @@
+package crdt
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+type ID struct {
+	Counter uint64 `json:"c"`
+	Site    string `json:"s"`
+}
+
+func (a ID) IsZero() bool {
+	return a.Counter == 0 && a.Site == ""
+}
+
+// Less orders IDs by Lamport counter, then site. Concurrent inserts after the
+// same origin are placed with the greatest ID first.
+func (a ID) Less(b ID) bool {
+	if a.Counter != b.Counter {
+		return a.Counter < b.Counter
+	}
+	return a.Site < b.Site
+}
+
+type OpKind string
+
+const (
+	OpInsert OpKind = "insert"
+	OpDelete OpKind = "delete"
+)
+
+type Op struct {
+	Kind OpKind `json:"kind"`
+	ID   ID     `json:"id"`
+	// left neighbour for inserts, zero means start of document
+	Origin ID `json:"origin,omitzero"`
+	// a single character for inserts
+	Value string `json:"value,omitempty"`
+}
+
+type Element struct {
+	ID      ID     `json:"id"`
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+var (
+	ErrInvalidOp      = errors.New("invalid crdt operation")
+	ErrTooManyPending = errors.New("too many operations waiting on missing dependencies")
+)
+
+// maximum ops held back because their origin or target hasn't arrived yet
+const maxPending = 1024
+
+type node struct {
+	id      ID
+	value   rune
+	deleted bool
+	next    *node
+}
+
+// Document is a replicated growable array (RGA) of runes. Every character has
+// a unique ID and deletions leave tombstones, so replicas that apply the same
+// set of operations in any causally consistent order end up with the same text.
+type Document struct {
+	head    node
+	nodes   map[ID]*node
+	clock   uint64
+	pending []Op
+}
+
+// NewDocument seeds a document with text. The seed characters belong to the
+// empty site so every replica created from the same text agrees on their IDs.
+func NewDocument(text string) *Document {
+	d := &Document{
+		nodes: make(map[ID]*node),
+	}
+
+	last := &d.head
+	for _, r := range text {
+		d.clock++
+		n := &node{
+			id:    ID{Counter: d.clock},
+			value: r,
+		}
+		last.next = n
+		d.nodes[n.id] = n
+		last = n
+	}
+
+	return d
+}
+
+// FromElements rebuilds a document from a snapshot taken with Elements.
+func FromElements(elements []Element) (*Document, error) {
+	d := &Document{
+		nodes: make(map[ID]*node),
+	}
+
+	last := &d.head
+	for _, e := range elements {
+		r, size := utf8.DecodeRuneInString(e.Value)
+		if e.ID.Counter == 0 || size == 0 || size != len(e.Value) || !utf8.ValidString(e.Value) {
+			return nil, ErrInvalidOp
+		}
+		if _, exists := d.nodes[e.ID]; exists {
+			return nil, ErrInvalidOp
+		}
+		n := &node{
+			id:      e.ID,
+			value:   r,
+			deleted: e.Deleted,
+		}
+		last.next = n
+		d.nodes[n.id] = n
+		last = n
+		d.clock = max(d.clock, e.ID.Counter)
+	}
+
+	return d, nil
+}
+
+// Clock is the highest Lamport counter the document has seen. New local
+// operations must use a greater counter.
+func (d *Document) Clock() uint64 {
+	return d.clock
+}
+
+// Apply integrates op and any held back operations it unblocks, returning the
+// operations that took effect in the order they were applied. Duplicates are
+// ignored and operations with missing dependencies are held until they arrive.
+func (d *Document) Apply(op Op) ([]Op, error) {
+	if err := validate(op); err != nil {
+		return nil, err
+	}
+
+	applied, ok := d.apply(op)
+	if !ok {
+		if len(d.pending) >= maxPending {
+			return nil, ErrTooManyPending
+		}
+		d.pending = append(d.pending, op)
+		return nil, nil
+	}
+
+	var result []Op
+	if applied {
+		result = append(result, op)
+	}
+
+	for progress := applied; progress; {
+		progress = false
+		remaining := d.pending[:0]
+		for _, p := range d.pending {
+			applied, ok := d.apply(p)
+			if !ok {
+				remaining = append(remaining, p)
+				continue
+			}
+			if applied {
+				result = append(result, p)
+				progress = true
+			}
+		}
+		d.pending = remaining
+	}
+
+	return result, nil
+}
+
+func validate(op Op) error {
+	switch op.Kind {
+	case OpInsert:
+		_, size := utf8.DecodeRuneInString(op.Value)
+		if op.ID.Counter == 0 || size == 0 || size != len(op.Value) || !utf8.ValidString(op.Value) {
+			return ErrInvalidOp
+		}
+	case OpDelete:
+		if op.ID.IsZero() {
+			return ErrInvalidOp
+		}
+	default:
+		return ErrInvalidOp
+	}
+	return nil
+}
+
+// apply reports whether op changed the document and whether its dependencies
+// were present.
+func (d *Document) apply(op Op) (applied bool, ok bool) {
+	switch op.Kind {
+	case OpInsert:
+		if _, exists := d.nodes[op.ID]; exists {
+			return false, true
+		}
+
+		origin := &d.head
+		if !op.Origin.IsZero() {
+			n, exists := d.nodes[op.Origin]
+			if !exists {
+				return false, false
+			}
+			origin = n
+		}
+
+		// skip concurrent inserts at the same position that sort ahead of
+		// this one, along with everything inserted after them
+		left := origin
+		for left.next != nil && op.ID.Less(left.next.id) {
+			left = left.next
+		}
+
+		r, _ := utf8.DecodeRuneInString(op.Value)
+		n := &node{
+			id:    op.ID,
+			value: r,
+			next:  left.next,
+		}
+		left.next = n
+		d.nodes[n.id] = n
+		d.clock = max(d.clock, op.ID.Counter)
+
+		return true, true
+	case OpDelete:
+		n, exists := d.nodes[op.ID]
+		if !exists {
+			return false, false
+		}
+		if n.deleted {
+			return false, true
+		}
+		n.deleted = true
+
+		return true, true
+	}
+
+	return false, false
+}
+
+// Replace makes and applies the ops that turn the document's text into
+// text, keeping what the two start and end with. New characters belong to
+// site. It's for writes that arrive as whole texts rather than as ops.
+func (d *Document) Replace(site string, text string) []Op {
+	var visible []*node
+	for n := d.head.next; n != nil; n = n.next {
+		if !n.deleted {
+			visible = append(visible, n)
+		}
+	}
+	runes := []rune(text)
+
+	prefix := 0
+	for prefix < len(visible) && prefix < len(runes) && visible[prefix].value == runes[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(visible)-prefix && suffix < len(runes)-prefix &&
+		visible[len(visible)-1-suffix].value == runes[len(runes)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	for _, n := range visible[prefix : len(visible)-suffix] {
+		op := Op{Kind: OpDelete, ID: n.id}
+		d.apply(op)
+		ops = append(ops, op)
+	}
+	var origin ID
+	if prefix > 0 {
+		origin = visible[prefix-1].id
+	}
+	for _, r := range runes[prefix : len(runes)-suffix] {
+		// newer than everything, so it goes right after its origin
+		op := Op{Kind: OpInsert, ID: ID{Counter: d.clock + 1, Site: site}, Origin: origin, Value: string(r)}
+		d.apply(op)
+		ops = append(ops, op)
+		origin = op.ID
+	}
+	return ops
+}
+
+func (d *Document) String() string {
+	var b strings.Builder
+	for n := d.head.next; n != nil; n = n.next {
+		if !n.deleted {
+			b.WriteRune(n.value)
+		}
+	}
+	return b.String()
+}
+
+// Elements returns every element in document order, tombstones included, so a
+// new replica can still resolve operations that reference deleted characters.
+func (d *Document) Elements() []Element {
+	elements := make([]Element, 0, len(d.nodes))
+	for n := d.head.next; n != nil; n = n.next {
+		elements = append(elements, Element{
+			ID:      n.id,
+			Value:   string(n.value),
+			Deleted: n.deleted,
+		})
+	}
+	return elements
+}
+
+// Contains reports whether the element with id exists, deleted or not.
+func (d *Document) Contains(id ID) bool {
+	_, exists := d.nodes[id]
+	return exists
+}
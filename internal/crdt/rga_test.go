@@ -0,0 +1,277 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// visible lists the ids of the characters that aren't deleted, in order.
+func visible(d *Document) []ID {
+	var ids []ID
+	for n := d.head.next; n != nil; n = n.next {
+		if !n.deleted {
+			ids = append(ids, n.id)
+		}
+	}
+	return ids
+}
+
+// localInsert makes the op a client at site sends to insert value at pos.
+func localInsert(d *Document, site string, pos int, value string) Op {
+	op := Op{Kind: OpInsert, ID: ID{Counter: d.Clock() + 1, Site: site}, Value: value}
+	if pos > 0 {
+		op.Origin = visible(d)[pos-1]
+	}
+	return op
+}
+
+func mustApply(t *testing.T, d *Document, op Op) {
+	t.Helper()
+	if _, err := d.Apply(op); err != nil {
+		t.Fatalf("applying %+v: %v", op, err)
+	}
+}
+
+// dependency is what op can't be applied without.
+func dependency(op Op) ID {
+	if op.Kind == OpDelete {
+		return op.ID
+	}
+	return op.Origin
+}
+
+// causalOrder shuffles ops so each comes after the insert it depends on,
+// the characters of seed being there from the start.
+func causalOrder(rng *rand.Rand, seed string, ops []Op) []Op {
+	delivered := make(map[ID]bool)
+	for id := range NewDocument(seed).nodes {
+		delivered[id] = true
+	}
+	remaining := append([]Op(nil), ops...)
+	ordered := make([]Op, 0, len(ops))
+	for len(remaining) > 0 {
+		var ready []int
+		for i, op := range remaining {
+			dep := dependency(op)
+			if dep.IsZero() || delivered[dep] {
+				ready = append(ready, i)
+			}
+		}
+		i := ready[rng.IntN(len(ready))]
+		op := remaining[i]
+		ordered = append(ordered, op)
+		if op.Kind == OpInsert {
+			delivered[op.ID] = true
+		}
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
+
+// withDuplicates delivers about a third of ops a second time, later on.
+func withDuplicates(rng *rand.Rand, ops []Op) []Op {
+	result := append([]Op(nil), ops...)
+	for i, op := range ops {
+		if rng.IntN(3) == 0 {
+			at := i + 1 + rng.IntN(len(result)-i)
+			result = append(result[:at], append([]Op{op}, result[at:]...)...)
+		}
+	}
+	return result
+}
+
+// edit has sites edit their own replicas, only sometimes hearing from each
+// other, so many of the ops are concurrent. It returns every op made, once
+// it's checked the sites agree after hearing everything.
+func edit(t *testing.T, rng *rand.Rand, seed string, sites int, rounds int) []Op {
+	t.Helper()
+	replicas := make([]*Document, sites)
+	heard := make([]int, sites)
+	for i := range replicas {
+		replicas[i] = NewDocument(seed)
+	}
+
+	var log []Op
+	for range rounds {
+		site := rng.IntN(sites)
+		d := replicas[site]
+
+		// catch up on part of what the others did
+		if rng.IntN(4) == 0 {
+			upTo := heard[site] + rng.IntN(len(log)-heard[site]+1)
+			for _, op := range log[heard[site]:upTo] {
+				mustApply(t, d, op)
+			}
+			heard[site] = upTo
+		}
+
+		var op Op
+		ids := visible(d)
+		if len(ids) > 0 && rng.IntN(3) == 0 {
+			op = Op{Kind: OpDelete, ID: ids[rng.IntN(len(ids))]}
+		} else {
+			op = localInsert(d, fmt.Sprint(site+1), rng.IntN(len(ids)+1), string(rune('a'+rng.IntN(26))))
+		}
+		mustApply(t, d, op)
+		log = append(log, op)
+	}
+
+	for i, d := range replicas {
+		for _, op := range log {
+			mustApply(t, d, op)
+		}
+		if d.String() != replicas[0].String() {
+			t.Fatalf("site %d has %q, site 1 has %q", i+1, d.String(), replicas[0].String())
+		}
+	}
+	return log
+}
+
+func TestConvergesInAnyCausalOrder(t *testing.T) {
+	for seed := range uint64(50) {
+		rng := rand.New(rand.NewPCG(seed, 1))
+		ops := edit(t, rng, "hello world", 4, 200)
+
+		var want string
+		for replica := range 5 {
+			d := NewDocument("hello world")
+			for _, op := range withDuplicates(rng, causalOrder(rng, "hello world", ops)) {
+				mustApply(t, d, op)
+			}
+			if replica == 0 {
+				want = d.String()
+			} else if d.String() != want {
+				t.Fatalf("seed %d: replica %d has %q, replica 0 has %q", seed, replica, d.String(), want)
+			}
+		}
+	}
+}
+
+func TestConvergesWhenOpsArriveBeforeTheirDependencies(t *testing.T) {
+	for seed := range uint64(50) {
+		rng := rand.New(rand.NewPCG(seed, 2))
+		ops := edit(t, rng, "", 3, 150)
+
+		in := NewDocument("")
+		for _, op := range ops {
+			mustApply(t, in, op)
+		}
+
+		shuffled := withDuplicates(rng, ops)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		d := NewDocument("")
+		for _, op := range shuffled {
+			mustApply(t, d, op)
+		}
+		if len(d.pending) != 0 {
+			t.Fatalf("seed %d: %d ops still pending", seed, len(d.pending))
+		}
+		if d.String() != in.String() {
+			t.Fatalf("seed %d: shuffled replica has %q, in order %q", seed, d.String(), in.String())
+		}
+	}
+}
+
+func TestConcurrentInsertAndDeleteAtSamePosition(t *testing.T) {
+	a := NewDocument("abc")
+	b := NewDocument("abc")
+
+	// a types x after the b while b deletes that b and types y in its place
+	insertX := localInsert(a, "1", 2, "x")
+	deleteB := Op{Kind: OpDelete, ID: visible(b)[1]}
+	mustApply(t, b, deleteB)
+	insertY := localInsert(b, "2", 1, "y")
+	mustApply(t, b, insertY)
+	insertZ := localInsert(b, "2", 1, "z")
+	mustApply(t, b, insertZ)
+	mustApply(t, a, insertX)
+
+	orders := [][]Op{
+		{insertX, deleteB, insertY, insertZ},
+		{deleteB, insertY, insertZ, insertX},
+		{insertZ, insertX, deleteB, insertY},
+		{deleteB, insertX, deleteB, insertZ, insertY, insertX},
+	}
+	var want string
+	for i, order := range orders {
+		d := NewDocument("abc")
+		for _, op := range order {
+			mustApply(t, d, op)
+		}
+		if i == 0 {
+			want = d.String()
+		} else if d.String() != want {
+			t.Fatalf("order %d gives %q, order 0 gives %q", i, d.String(), want)
+		}
+	}
+
+	for _, op := range []Op{deleteB, insertY, insertZ} {
+		mustApply(t, a, op)
+	}
+	mustApply(t, b, insertX)
+	if a.String() != want || b.String() != want {
+		t.Fatalf("sites have %q and %q, replicas %q", a.String(), b.String(), want)
+	}
+	// y and z are newer than the deleted b so they go before it, x stays
+	// after it
+	if want != "azyxc" {
+		t.Fatalf("got %q, want %q", want, "azyxc")
+	}
+}
+
+func TestDuplicateOpsAreIgnored(t *testing.T) {
+	d := NewDocument("ab")
+	insert := localInsert(d, "1", 1, "x")
+	remove := Op{Kind: OpDelete, ID: visible(d)[0]}
+
+	for range 3 {
+		mustApply(t, d, insert)
+		mustApply(t, d, remove)
+	}
+	if d.String() != "xb" {
+		t.Fatalf("got %q, want %q", d.String(), "xb")
+	}
+	if applied, _ := d.Apply(insert); len(applied) != 0 {
+		t.Fatalf("duplicate insert reported as applied: %+v", applied)
+	}
+}
+
+func TestReplaceReachesOtherReplicas(t *testing.T) {
+	for _, tc := range []struct{ from, to string }{
+		{"hello world", "hello brave world"},
+		{"hello world", "help"},
+		{"", "new"},
+		{"gone", ""},
+		{"aaa", "aaaa"},
+		{"- [ ] task", "- [x] task"},
+		{"héllo", "hëllo"},
+	} {
+		d := NewDocument(tc.from)
+		other := NewDocument(tc.from)
+		ops := d.Replace("server", tc.to)
+		for _, op := range ops {
+			mustApply(t, other, op)
+		}
+		if d.String() != tc.to || other.String() != tc.to {
+			t.Fatalf("%q to %q: got %q and %q", tc.from, tc.to, d.String(), other.String())
+		}
+	}
+}
+
+func TestReplaceMergesWithConcurrentEdits(t *testing.T) {
+	d := NewDocument("one two")
+	peer := NewDocument("one two")
+
+	// a peer appends while the server rewrites the start
+	edit := localInsert(peer, "1", 7, "!")
+	mustApply(t, peer, edit)
+	for _, op := range d.Replace("server", "ONE two") {
+		mustApply(t, peer, op)
+	}
+	mustApply(t, d, edit)
+
+	if d.String() != "ONE two!" || peer.String() != d.String() {
+		t.Fatalf("got %q and %q, want %q", d.String(), peer.String(), "ONE two!")
+	}
+}
@@ -0,0 +1,8 @@
+package dto
+
+// DeleteAccountRequest confirms deleting the account. Confirm must be the
+// username; accounts with a password need it again.
+type DeleteAccountRequest struct {
+	Confirm  string `json:"confirm" validate:"required"`
+	Password string `json:"password"`
+}
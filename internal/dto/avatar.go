@@ -0,0 +1,13 @@
+package dto
+
+import (
+	"io"
+	"time"
+)
+
+type Avatar struct {
+	Content io.ReadSeekCloser
+	// zero for generated identicons
+	ModTime time.Time
+	ETag    string
+}
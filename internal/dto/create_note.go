@@ -1,7 +1,40 @@
 package dto
 
+import "time"
+
 type CreateNoteRequest struct {
-	Title   string `json:"title" validate:"required,min=1,max=255"`
-	UserID  string `json:"-"`
-	Content string `json:"content"`
+	Title  string `json:"title" validate:"required,min=1,max=255"`
+	UserID string `json:"-"`
+	// only used on create, empty for a personal note
+	WorkspaceID string `json:"workspace_id"`
+	Content     string `json:"content"`
+	// edits leave tags and folder alone when they're missing from the
+	// request, an empty list or string clears them
+	Tags   []string `json:"tags"`
+	Folder *string  `json:"folder"`
+	// like tags, edits replace all properties when this is set
+	Properties map[string]PropertyValue `json:"properties"`
+	// makes the note end-to-end encrypted, Content is then ciphertext.
+	// Only set on create, and on every edit of an encrypted note with a
+	// fresh nonce.
+	Encryption *NoteEncryptionRequest `json:"encryption"`
+	// create only: start from a template, filling in its variables.
+	// Title, tags and folder in the request override the template's.
+	TemplateID string            `json:"template_id"`
+	Variables  map[string]string `json:"variables"`
+	// only set by importers, notes are otherwise stamped with the current
+	// time. UpdatedAt also applies to edits.
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+	// only set by edits made from a copy of the note read earlier, which
+	// fail if it's no longer at this version
+	Version int `json:"-"`
+}
+
+type NoteEncryptionRequest struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Nonce     string `json:"nonce"`
+	// create only: the note key wrapped with the creator's public key
+	WrappedKey string `json:"wrapped_key"`
 }
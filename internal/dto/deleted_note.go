@@ -0,0 +1,5 @@
+package dto
+
+type DeletedNote struct {
+	ID string `json:"id"`
+}
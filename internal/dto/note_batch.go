@@ -0,0 +1,59 @@
+package dto
+
+type NoteBatchMode string
+
+const (
+	// every operation is applied or none of them
+	NoteBatchAtomic NoteBatchMode = "atomic"
+	// operations that fail are left out and the rest applied
+	NoteBatchBestEffort NoteBatchMode = "best_effort"
+)
+
+type NoteBatchRequest struct {
+	// atomic when missing
+	Mode       NoteBatchMode   `json:"mode"`
+	Operations []NoteOperation `json:"operations"`
+}
+
+// NoteOperation is one change in a batch. Op is create, update, delete or
+// move. Everything but create names its note with ID, updates leave missing
+// fields alone and moves only take a folder. Updates and moves with a
+// Version fail if the note isn't at that version anymore.
+type NoteOperation struct {
+	Op          string   `json:"op"`
+	ID          string   `json:"id"`
+	WorkspaceID string   `json:"workspace_id"`
+	Title       *string  `json:"title"`
+	Content     *string  `json:"content"`
+	Tags        []string `json:"tags"`
+	Folder      *string  `json:"folder"`
+	Version     int      `json:"version"`
+}
+
+type NoteBatchStatus string
+
+const (
+	NoteBatchStatusDone   NoteBatchStatus = "done"
+	NoteBatchStatusFailed NoteBatchStatus = "failed"
+	// the operation was fine, but it was part of an atomic batch in which
+	// another one failed
+	NoteBatchStatusAborted NoteBatchStatus = "aborted"
+)
+
+// NoteBatchResult says what happened to one operation, in the order they
+// were given.
+type NoteBatchResult struct {
+	Op      string          `json:"op"`
+	Status  NoteBatchStatus `json:"status"`
+	NoteID  string          `json:"note_id,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+type NoteBatchReport struct {
+	Mode NoteBatchMode `json:"mode"`
+	// false when an atomic batch was rolled back
+	Committed bool              `json:"committed"`
+	Done      int               `json:"done"`
+	Failed    int               `json:"failed"`
+	Results   []NoteBatchResult `json:"results"`
+}
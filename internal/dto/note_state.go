@@ -0,0 +1,10 @@
+package dto
+
+// NoteStateRequest changes the state of many notes at once. Missing fields
+// are left alone.
+type NoteStateRequest struct {
+	NoteIDs   []string `json:"note_ids"`
+	Pinned    *bool    `json:"pinned"`
+	Archived  *bool    `json:"archived"`
+	Favourite *bool    `json:"favourite"`
+}
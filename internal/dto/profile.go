@@ -0,0 +1,31 @@
+package dto
+
+// UpdateProfileRequest only changes the fields that are present.
+type UpdateProfileRequest struct {
+	Username    *string                `json:"username" validate:"omitempty,min=3,max=30"`
+	DisplayName *string                `json:"display_name" validate:"omitempty,max=100"`
+	Settings    *UpdateSettingsRequest `json:"settings"`
+}
+
+type UpdateSettingsRequest struct {
+	Theme       *string `json:"theme"`
+	DefaultSort *string `json:"default_sort"`
+	Timezone    *string `json:"timezone"`
+	EditorMode  *string `json:"editor_mode"`
+	// an empty template id goes back to blank daily notes
+	DailyFolder     *string `json:"daily_folder"`
+	DailyTemplateID *string `json:"daily_template_id"`
+}
+
+// NoteListQuery controls how note listings are returned. An empty Sort
+// means the user's default.
+type NoteListQuery struct {
+	Sort string
+	// "true" or "false" to only list notes in or out of that state, empty
+	// or "all" for both
+	Pinned    string
+	Archived  string
+	Favourite string
+	// every filter has to match
+	Properties []PropertyFilter
+}
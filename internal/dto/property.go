@@ -0,0 +1,52 @@
+package dto
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// PropertyValue is a property given on a note, either as a bare JSON value
+// or as {"type": ..., "value": ...}. The type can be left out when the
+// notebook's schema has it or the JSON value makes it clear.
+type PropertyValue struct {
+	Type  string
+	Value json.RawMessage
+}
+
+func (v *PropertyValue) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		v.Type = ""
+		v.Value = append(json.RawMessage(nil), data...)
+		return nil
+	}
+
+	var typed struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	err := json.Unmarshal(data, &typed)
+	if err != nil {
+		return err
+	}
+	v.Type, v.Value = typed.Type, typed.Value
+	return nil
+}
+
+type PropertySchemaRequest struct {
+	Properties []PropertyDefinitionRequest `json:"properties"`
+}
+
+type PropertyDefinitionRequest struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Options []string `json:"options"`
+}
+
+// PropertyFilter is a prop.<name><op><value> listing parameter. Op is one of
+// =, !=, <, <=, > and >=, or empty to list notes that have the property at
+// all.
+type PropertyFilter struct {
+	Name  string
+	Op    string
+	Value string
+}
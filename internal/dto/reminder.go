@@ -0,0 +1,26 @@
+package dto
+
+import "time"
+
+type ReminderRequest struct {
+	At time.Time `json:"at"`
+	// optional iCalendar RRULE, e.g. FREQ=DAILY;COUNT=5
+	RRule string `json:"rrule"`
+	// defaults to the user's timezone setting
+	Timezone string `json:"timezone"`
+	Message  string `json:"message"`
+}
+
+type NotificationChannelRequest struct {
+	Type    string `json:"type"`
+	Enabled *bool  `json:"enabled"`
+	Target  string `json:"target"`
+	// left out to keep the current secret, empty to remove it
+	Secret *string `json:"secret"`
+}
+
+type NotificationChannelResult struct {
+	Type  string `json:"type"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
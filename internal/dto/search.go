@@ -0,0 +1,14 @@
+package dto
+
+type SavedSearchRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+type SearchRequest struct {
+	Query string
+	// a note sort, or relevance. Searches for text default to relevance,
+	// others to the user's default sort.
+	Sort  string
+	Limit int
+}
@@ -0,0 +1,15 @@
+package dto
+
+import "time"
+
+type CreateShareLinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	Password  string     `json:"password"`
+	MaxViews  int        `json:"max_views" validate:"min=0"`
+}
+
+type PublicNote struct {
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,13 @@
+package dto
+
+type ShareNoteRequest struct {
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=viewer editor"`
+	// encrypted notes only: the note key wrapped with the recipient's
+	// public key
+	WrappedKey string `json:"wrapped_key"`
+}
+
+type TransferNoteRequest struct {
+	Username string `json:"username" validate:"required"`
+}
@@ -0,0 +1,9 @@
+package dto
+
+type TaskQuery struct {
+	// open, done or all, open by default
+	Status    string
+	DueBefore string
+	Priority  string
+	NoteID    string
+}
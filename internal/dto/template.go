@@ -0,0 +1,19 @@
+package dto
+
+type TemplateRequest struct {
+	Name string `json:"name"`
+	// only used on create, empty for a personal template
+	WorkspaceID string                    `json:"workspace_id"`
+	Title       string                    `json:"title"`
+	Content     string                    `json:"content"`
+	Tags        []string                  `json:"tags"`
+	Folder      string                    `json:"folder"`
+	Variables   []TemplateVariableRequest `json:"variables"`
+}
+
+type TemplateVariableRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default"`
+}
@@ -0,0 +1,10 @@
+package dto
+
+import "encoding/json"
+
+type UserKeyRequest struct {
+	Algorithm         string          `json:"algorithm"`
+	PublicKey         string          `json:"public_key"`
+	WrappedPrivateKey string          `json:"wrapped_private_key"`
+	KDF               json.RawMessage `json:"kdf"`
+}
@@ -0,0 +1,37 @@
+package dto
+
+type ImportStatus string
+
+const (
+	ImportStatusCreated ImportStatus = "created"
+	ImportStatusSkipped ImportStatus = "skipped"
+	ImportStatusFailed  ImportStatus = "failed"
+)
+
+// ImportResult says what happened to one file of an import.
+type ImportResult struct {
+	Path     string       `json:"path"`
+	Status   ImportStatus `json:"status"`
+	NoteID   string       `json:"note_id,omitempty"`
+	Message  string       `json:"message,omitempty"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+type ImportReport struct {
+	Created int            `json:"created"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+func (r *ImportReport) Add(result ImportResult) {
+	switch result.Status {
+	case ImportStatusCreated:
+		r.Created++
+	case ImportStatusSkipped:
+		r.Skipped++
+	case ImportStatusFailed:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
@@ -0,0 +1,14 @@
+package dto
+
+type WorkspaceRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=255"`
+}
+
+type AddWorkspaceMemberRequest struct {
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=viewer editor admin owner"`
+}
+
+type UpdateWorkspaceMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor admin owner"`
+}
@@ -0,0 +1,232 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	NoteCreated EventType = "note.created"
+	NoteUpdated EventType = "note.updated"
+	NoteDeleted EventType = "note.deleted"
+	// sent to a user when they gain or lose access to someone else's note
+	NoteShared   EventType = "note.shared"
+	NoteUnshared EventType = "note.unshared"
+	// sent when one of the user's reminders goes off
+	ReminderDue EventType = "reminder.due"
+	// sent to a user when they pin, archive or favourite notes
+	NoteStateChanged EventType = "note.state"
+	// sent instead of the replay when events since Last-Event-ID are no
+	// longer buffered, clients should fetch what they show again
+	Resync EventType = "resync"
+)
+
+type Event struct {
+	ID     uint64
+	Type   EventType
+	UserID string
+	Data   any
+}
+
+// how many events a subscriber can fall behind before it gets dropped
+const subscriberBuffer = 64
+
+type Bus struct {
+	mu         sync.Mutex
+	nextID     uint64
+	firstID    uint64
+	replaySize int
+	replayAge  time.Duration
+	replay     map[string]*replay
+	// the newest event of the replays pruned so far
+	pruned uint64
+	// when replays were last looked at for pruning
+	swept       time.Time
+	now         func() time.Time
+	subscribers map[string]map[*Subscription]struct{}
+	closed      bool
+}
+
+// replay is a user's buffer of recent events.
+type replay struct {
+	events []Event
+	// the newest event that fell out of the buffer
+	evicted uint64
+	// when the newest event was published
+	updated time.Time
+}
+
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	bus    *Bus
+	userID string
+	closed bool
+}
+
+// NewBus keeps up to replaySize events of each user for resuming. Once a
+// user has no subscribers their events are dropped when the newest is
+// replayAge old, which is noticed within another replayAge.
+func NewBus(replaySize int, replayAge time.Duration) *Bus {
+	// seeded from the clock so ids keep increasing across restarts and
+	// stale Last-Event-ID values from a previous process don't skip events
+	now := time.Now()
+	firstID := uint64(now.UnixNano())
+	return &Bus{
+		nextID:      firstID,
+		firstID:     firstID,
+		replaySize:  replaySize,
+		replayAge:   replayAge,
+		replay:      make(map[string]*replay),
+		swept:       now,
+		now:         time.Now,
+		subscribers: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func (b *Bus) Publish(userID string, eventType EventType, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.nextID++
+	event := Event{
+		ID:     b.nextID,
+		Type:   eventType,
+		UserID: userID,
+		Data:   data,
+	}
+
+	now := b.now()
+	if b.replaySize > 0 {
+		buf := b.replay[userID]
+		if buf == nil {
+			buf = &replay{}
+			b.replay[userID] = buf
+		}
+		buf.events = append(buf.events, event)
+		if len(buf.events) > b.replaySize {
+			buf.evicted = buf.events[len(buf.events)-b.replaySize-1].ID
+			buf.events = buf.events[len(buf.events)-b.replaySize:]
+		}
+		buf.updated = now
+	}
+	if now.Sub(b.swept) >= b.replayAge {
+		b.pruneLocked(now)
+	}
+
+	for sub := range b.subscribers[userID] {
+		select {
+		case sub.c <- event:
+		default:
+			// slow consumer, disconnect it so the client reconnects and
+			// resumes from the replay buffer with Last-Event-ID
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for userID and returns any buffered events
+// newer than lastEventID. Pass 0 to skip the replay. When some of those events
+// aren't buffered anymore, because they were pushed out or were sent before
+// a restart, a single Resync event is returned instead.
+func (b *Bus) Subscribe(userID string, lastEventID uint64) (*Subscription, []Event) {
+	c := make(chan Event, subscriberBuffer)
+	sub := &Subscription{
+		C:      c,
+		c:      c,
+		bus:    b,
+		userID: userID,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.closed = true
+		close(c)
+		return sub, nil
+	}
+
+	buf := b.replay[userID]
+	if buf == nil {
+		buf = &replay{}
+	}
+	stale := lastEventID < b.firstID || lastEventID < buf.evicted || b.replaySize <= 0
+	// the user's events after lastEventID may have been pruned, possibly
+	// before the ones buffered now were published
+	if lastEventID < b.pruned && (len(buf.events) == 0 || buf.events[0].ID > lastEventID) {
+		stale = true
+	}
+
+	var backlog []Event
+	if lastEventID != 0 && stale {
+		backlog = []Event{{ID: b.nextID, Type: Resync, UserID: userID, Data: struct{}{}}}
+	} else if lastEventID != 0 {
+		for _, event := range buf.events {
+			if event.ID > lastEventID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[userID][sub] = struct{}{}
+
+	return sub, backlog
+}
+
+// Close disconnects every subscriber and stops accepting events.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subscribers {
+		for sub := range subs {
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// pruneLocked drops the replays of users without subscribers whose newest
+// event is replayAge old, so users who stopped coming back don't hold on to
+// memory.
+func (b *Bus) pruneLocked(now time.Time) {
+	b.swept = now
+	for userID, buf := range b.replay {
+		if len(b.subscribers[userID]) > 0 || now.Sub(buf.updated) < b.replayAge {
+			continue
+		}
+		b.pruned = max(b.pruned, buf.events[len(buf.events)-1].ID)
+		delete(b.replay, userID)
+	}
+}
+
+func (b *Bus) removeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.c)
+
+	subs := b.subscribers[sub.userID]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.userID)
+	}
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.removeLocked(s)
+}
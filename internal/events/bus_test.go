@@ -0,0 +1,94 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a time tests move on by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestBus(replaySize int) (*Bus, *clock) {
+	bus := NewBus(replaySize, time.Hour)
+	c := &clock{now: time.Now()}
+	bus.now = c.Now
+	return bus, c
+}
+
+func checkResync(t *testing.T, backlog []Event) {
+	t.Helper()
+	if len(backlog) != 1 || backlog[0].Type != Resync {
+		t.Errorf("got %+v, want a resync", backlog)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	bus, _ := newTestBus(2)
+	first, _ := bus.Subscribe("ann", 0)
+	bus.Publish("ann", NoteCreated, 1)
+	bus.Publish("bob", NoteCreated, 2)
+	bus.Publish("ann", NoteUpdated, 3)
+	seen := <-first.C
+	first.Close()
+
+	_, backlog := bus.Subscribe("ann", seen.ID)
+	if len(backlog) != 1 || backlog[0].Data != 3 {
+		t.Errorf("resumed with %+v", backlog)
+	}
+
+	// the first event falls out of the buffer
+	bus.Publish("ann", NoteDeleted, 4)
+	bus.Publish("ann", NoteDeleted, 5)
+	_, backlog = bus.Subscribe("ann", seen.ID)
+	checkResync(t, backlog)
+}
+
+func TestPrune(t *testing.T) {
+	bus, clock := newTestBus(10)
+	listening, _ := bus.Subscribe("cat", 0)
+	defer listening.Close()
+
+	bus.Publish("ann", NoteCreated, nil)
+	bus.Publish("bob", NoteCreated, nil)
+	bus.Publish("cat", NoteCreated, nil)
+	annLast := bus.replay["ann"].events[0].ID
+	clock.now = clock.now.Add(30 * time.Minute)
+	bus.Publish("bob", NoteUpdated, nil)
+
+	// only ann's newest event is an hour old by the next publish
+	clock.now = clock.now.Add(40 * time.Minute)
+	bus.Publish("dan", NoteCreated, nil)
+	if bus.replay["ann"] != nil {
+		t.Error("ann's events were kept")
+	}
+	for _, user := range []string{"bob", "cat", "dan"} {
+		if bus.replay[user] == nil {
+			t.Errorf("%s's events were dropped", user)
+		}
+	}
+
+	// resuming from before what was dropped needs a resync, even once new
+	// events are buffered
+	_, backlog := bus.Subscribe("ann", annLast-1)
+	checkResync(t, backlog)
+	bus.Publish("ann", NoteUpdated, nil)
+	_, backlog = bus.Subscribe("ann", annLast-1)
+	checkResync(t, backlog)
+
+	// but resuming from the newest one doesn't
+	newest := bus.replay["ann"].events[0].ID
+	_, backlog = bus.Subscribe("ann", newest)
+	if len(backlog) != 0 {
+		t.Errorf("resumed with %+v", backlog)
+	}
+	_, backlog = bus.Subscribe("bob", bus.replay["bob"].events[0].ID)
+	if len(backlog) != 1 || backlog[0].Type != NoteUpdated {
+		t.Errorf("resumed with %+v", backlog)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type AccountHandler interface {
+	RequestExport(w http.ResponseWriter, r *http.Request)
+	DownloadExport(w http.ResponseWriter, r *http.Request)
+	DeleteAccount(w http.ResponseWriter, r *http.Request)
+}
+
+type accountHandler struct {
+	accountService service.AccountService
+	exportService  service.ExportService
+}
+
+func NewAccountHandler(accountService service.AccountService, exportService service.ExportService) AccountHandler {
+	return &accountHandler{
+		accountService: accountService,
+		exportService:  exportService,
+	}
+}
+
+// RequestExport returns the current export, starting one if needed. It
+// answers 202 until the export can be downloaded, so clients poll it.
+// ?refresh=true builds a new export even if a finished one exists.
+func (h *accountHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	export, err := h.exportService.RequestExport(models.ExtractUser(r).UserID, r.URL.Query().Get("refresh") == "true")
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if export.Status == models.ExportStatusPending || export.Status == models.ExportStatusRunning {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	err = json.NewEncoder(w).Encode(export)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *accountHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	export, file, err := h.exportService.OpenExport(models.ExtractUser(r).UserID, chi.URLParam(r, "exportId"))
+	if checkErr(err, r) {
+		return
+	}
+	defer file.Close()
+
+	filename := "v8box-export-" + export.CreatedAt.Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	http.ServeContent(w, r, "", *export.CompletedAt, file)
+}
+
+func (h *accountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var deleteRequest dto.DeleteAccountRequest
+	err := json.NewDecoder(r.Body).Decode(&deleteRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request: " + err.Error()}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	err = h.accountService.DeleteAccount(models.ExtractUser(r), deleteRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Set-Cookie", "JWT=; Path=/; Max-Age=0")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type AttachmentHandler interface {
+	Upload(w http.ResponseWriter, r *http.Request)
+	GetNoteAttachments(w http.ResponseWriter, r *http.Request)
+	Download(w http.ResponseWriter, r *http.Request)
+	Thumbnail(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+type attachmentHandler struct {
+	attachmentService service.AttachmentService
+	maxUploadSize     int64
+}
+
+func NewAttachmentHandler(attachmentService service.AttachmentService, maxUploadSize int64) AttachmentHandler {
+	return &attachmentHandler{
+		attachmentService: attachmentService,
+		maxUploadSize:     maxUploadSize,
+	}
+}
+
+// types browsers can show without running anything from the file
+var inlineMimeTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// Upload stores every file part of a multipart/form-data body. Parts are
+// streamed, nothing is buffered in memory.
+func (h *attachmentHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Expected a multipart/form-data upload"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	userId := models.ExtractUser(r).UserID
+	noteId := chi.URLParam(r, "id")
+
+	attachments := make([]models.Attachment, 0)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			err = uploadError(err)
+			if !errors.As(err, new(*httperror.BadClientRequestError)) {
+				err = &httperror.BadClientRequestError{Message: "Malformed multipart upload"}
+			}
+			checkErr(err, r)
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := h.attachmentService.Upload(r.Context(), userId, noteId, part.FileName(), part)
+		part.Close()
+		if checkErr(uploadError(err), r) {
+			return
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	if len(attachments) == 0 {
+		checkErr(&httperror.BadClientRequestError{Message: "No files in upload"}, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(attachments)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *attachmentHandler) GetNoteAttachments(w http.ResponseWriter, r *http.Request) {
+	attachments, err := h.attachmentService.GetNoteAttachments(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(attachments)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// Download serves the attachment with range and conditional request support.
+// Files are downloaded unless ?inline is set and the type is safe to show.
+func (h *attachmentHandler) Download(w http.ResponseWriter, r *http.Request) {
+	attachment, blob, err := h.attachmentService.Open(r.Context(), models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "attachmentId"))
+	if checkErr(err, r) {
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	mediaType, _, _ := mime.ParseMediaType(attachment.MimeType)
+	if r.URL.Query().Has("inline") && inlineMimeTypes[mediaType] {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+	w.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+
+	http.ServeContent(w, r, "", attachment.CreatedAt, blob)
+}
+
+func (h *attachmentHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	var size int
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			checkErr(&httperror.BadClientRequestError{Message: "size must be a positive number"}, r)
+			return
+		}
+	}
+
+	attachment, file, mimeType, err := h.attachmentService.Thumbnail(r.Context(), models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "attachmentId"), size)
+	if checkErr(err, r) {
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// thumbnails of a blob never change, only access to them does
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	// cached files are named after the blob and the size actually served
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(filepath.Base(file.Name()), filepath.Ext(file.Name()))+`"`)
+
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
+
+func (h *attachmentHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := h.attachmentService.Delete(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "attachmentId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func uploadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &httperror.BadClientRequestError{Message: "Upload is too large"}
+	}
+	if errors.Is(err, io.EOF) {
+		return &httperror.BadClientRequestError{Message: "No files in upload"}
+	}
+	return err
+}
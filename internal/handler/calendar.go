@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type CalendarHandler interface {
+	GetFeed(w http.ResponseWriter, r *http.Request)
+	RegenerateToken(w http.ResponseWriter, r *http.Request)
+	DeleteFeed(w http.ResponseWriter, r *http.Request)
+	Feed(w http.ResponseWriter, r *http.Request)
+}
+
+type calendarHandler struct {
+	calendarService service.CalendarService
+}
+
+func NewCalendarHandler(calendarService service.CalendarService) CalendarHandler {
+	return &calendarHandler{
+		calendarService: calendarService,
+	}
+}
+
+func (h *calendarHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	feed, err := h.calendarService.GetFeed(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(feed)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// RegenerateToken creates the feed or moves it to a new URL, which is only
+// shown in this response.
+func (h *calendarHandler) RegenerateToken(w http.ResponseWriter, r *http.Request) {
+	feed, err := h.calendarService.RegenerateToken(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(feed)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *calendarHandler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	err := h.calendarService.DeleteFeed(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Feed serves the calendar to subscribed apps, which authenticate with the
+// token in the URL alone.
+func (h *calendarHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutSuffix(chi.URLParam(r, "token"), ".ics")
+	if !ok {
+		checkErr(&httperror.NotFoundError{Entity: "Calendar feed"}, r)
+		return
+	}
+
+	calendar, err := h.calendarService.RenderFeed(token)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="v8box.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(calendar)
+}
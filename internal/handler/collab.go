@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/vaporii/v8box/internal/collab"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+const (
+	wsMaxMessageSize = 1 << 20
+	wsPongWait       = 60 * time.Second
+	wsPingInterval   = wsPongWait * 9 / 10
+	wsWriteWait      = 10 * time.Second
+)
+
+type CollabHandler interface {
+	Connect(w http.ResponseWriter, r *http.Request)
+}
+
+type collabHandler struct {
+	hub         *collab.Hub
+	noteService service.NoteService
+	upgrader    websocket.Upgrader
+}
+
+func NewCollabHandler(hub *collab.Hub, noteService service.NoteService) CollabHandler {
+	return &collabHandler{
+		hub:         hub,
+		noteService: noteService,
+		// the default origin check only allows same-host browsers, which is
+		// what we want with cookie auth
+		upgrader: websocket.Upgrader{},
+	}
+}
+
+func (h *collabHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "id")
+
+	// checked before upgrading so missing notes get a normal error response
+	note, err := h.noteService.GetNoteByID(models.ExtractUser(r).UserID, noteID)
+	if checkErr(err, r) {
+		return
+	}
+	if note.Encrypted() {
+		checkErr(&httperror.BadClientRequestError{Message: "Encrypted notes can't be edited together, the server can't merge ciphertext"}, r)
+		return
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already written a response
+		logging.Warning("websocket upgrade failed: %v", err)
+		return
+	}
+
+	conn := newWSConn(ws)
+	defer conn.Close()
+
+	err = h.hub.Serve(noteID, models.ExtractUser(r), conn)
+	if err != nil {
+		logging.Warning("collaboration session for note %s ended: %v", noteID, err)
+		conn.WriteJSON(collab.ServerMessage{Type: collab.MessageError, Message: err.Error()})
+	}
+}
+
+// wsConn keeps the connection alive with pings and applies deadlines.
+type wsConn struct {
+	ws   *websocket.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := &wsConn{
+		ws:   ws,
+		done: make(chan struct{}),
+	}
+
+	ws.SetReadLimit(wsMaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go c.pingLoop()
+
+	return c
+}
+
+func (c *wsConn) pingLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) ReadJSON(v any) error {
+	return c.ws.ReadJSON(v)
+}
+
+func (c *wsConn) WriteJSON(v any) error {
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.ws.WriteJSON(v)
+}
+
+func (c *wsConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.ws.Close()
+	})
+	return err
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type DailyHandler interface {
+	GetDailyNote(w http.ResponseWriter, r *http.Request)
+	GetCalendar(w http.ResponseWriter, r *http.Request)
+}
+
+type dailyHandler struct {
+	dailyService service.DailyService
+}
+
+func NewDailyHandler(dailyService service.DailyService) DailyHandler {
+	return &dailyHandler{
+		dailyService: dailyService,
+	}
+}
+
+// GetDailyNote serves /daily/2026-10-19 or /daily/today, creating the
+// day's note on first access.
+func (h *dailyHandler) GetDailyNote(w http.ResponseWriter, r *http.Request) {
+	daily, err := h.dailyService.GetDailyNote(models.ExtractUser(r).UserID, chi.URLParam(r, "date"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(daily)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// GetCalendar lists the days with a note in ?month=2026-10, this month by
+// default.
+func (h *dailyHandler) GetCalendar(w http.ResponseWriter, r *http.Request) {
+	calendar, err := h.dailyService.GetCalendar(models.ExtractUser(r).UserID, r.URL.Query().Get("month"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(calendar)
+	if checkErr(err, r) {
+		return
+	}
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vaporii/v8box/internal/events"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+)
+
+type EventHandler interface {
+	Stream(w http.ResponseWriter, r *http.Request)
+}
+
+type eventHandler struct {
+	bus       *events.Bus
+	heartbeat time.Duration
+}
+
+func NewEventHandler(bus *events.Bus, heartbeat time.Duration) EventHandler {
+	return &eventHandler{
+		bus:       bus,
+		heartbeat: heartbeat,
+	}
+}
+
+func (h *eventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		checkErr(errors.New("response writer doesn't support flushing"), r)
+		return
+	}
+
+	var lastEventID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			err = &httperror.BadClientRequestError{Message: "Bad Last-Event-ID header"}
+		}
+		if checkErr(err, r) {
+			return
+		}
+		lastEventID = id
+	}
+
+	sub, backlog := h.bus.Subscribe(models.ExtractUser(r).UserID, lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if writeEvent(w, event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type GraphHandler interface {
+	GetBacklinks(w http.ResponseWriter, r *http.Request)
+	GetGraph(w http.ResponseWriter, r *http.Request)
+}
+
+type graphHandler struct {
+	graphService service.GraphService
+}
+
+func NewGraphHandler(graphService service.GraphService) GraphHandler {
+	return &graphHandler{
+		graphService: graphService,
+	}
+}
+
+func (h *graphHandler) GetBacklinks(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.graphService.GetBacklinks(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(notes)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// GetGraph graphs a workspace's notes with ?workspace_id=, otherwise the
+// user's own and shared notes.
+func (h *graphHandler) GetGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := h.graphService.GetGraph(models.ExtractUser(r).UserID, r.URL.Query().Get("workspace_id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(graph)
+	if checkErr(err, r) {
+		return
+	}
+}
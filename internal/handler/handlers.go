@@ -2,21 +2,57 @@ package handler
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/avatar"
+	"github.com/vaporii/v8box/internal/collab"
 	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/events"
+	"github.com/vaporii/v8box/internal/render"
 	"github.com/vaporii/v8box/internal/repository"
 	"github.com/vaporii/v8box/internal/service"
+	"github.com/vaporii/v8box/internal/storage"
+	"github.com/vaporii/v8box/internal/thumbnail"
 )
 
 type Handlers struct {
-	UserHandler UserHandler
-	NoteHandler NoteHandler
-	AuthHandler AuthHandler
+	UserHandler       UserHandler
+	NoteHandler       NoteHandler
+	AuthHandler       AuthHandler
+	EventHandler      EventHandler
+	CollabHandler     CollabHandler
+	ShareLinkHandler  ShareLinkHandler
+	WorkspaceHandler  WorkspaceHandler
+	AttachmentHandler AttachmentHandler
+	AccountHandler    AccountHandler
+	VaultHandler      VaultHandler
+	GraphHandler      GraphHandler
+	TaskHandler       TaskHandler
+	ReminderHandler   ReminderHandler
+	CalendarHandler   CalendarHandler
+	TemplateHandler   TemplateHandler
+	DailyHandler      DailyHandler
+	PropertyHandler   PropertyHandler
+	SearchHandler     SearchHandler
+	KeyHandler        KeyHandler
+	AttachmentService service.AttachmentService
+	ExportService     service.ExportService
+	ReminderService   service.ReminderService
+	SessionService    service.SessionService
+	EventBus          *events.Bus
+	CollabHub         *collab.Hub
 }
 
 func NewHandlers(db *sql.DB, cfg config.Config) *Handlers {
-	noteRepo, err := repository.NewNoteRepository(db)
+	keys, err := atrest.Load(cfg.MasterKeys, cfg.MasterKeyFile)
+	if err != nil {
+		log.Fatalf("err loading master keys: %v\n", err)
+		return nil
+	}
+
+	noteRepo, err := repository.NewNoteRepository(db, keys)
 	if err != nil {
 		log.Fatalf("err setting up note repository: %v\n", err)
 		return nil
@@ -28,10 +64,181 @@ func NewHandlers(db *sql.DB, cfg config.Config) *Handlers {
 		return nil
 	}
 
-	userService := service.NewUserService(userRepo, cfg)
+	shareRepo, err := repository.NewNoteShareRepository(db, keys)
+	if err != nil {
+		log.Fatalf("err setting up note share repository: %v\n", err)
+		return nil
+	}
+
+	linkRepo, err := repository.NewShareLinkRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up share link repository: %v\n", err)
+		return nil
+	}
+
+	workspaceRepo, err := repository.NewWorkspaceRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up workspace repository: %v\n", err)
+		return nil
+	}
+
+	attachmentRepo, err := repository.NewAttachmentRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up attachment repository: %v\n", err)
+		return nil
+	}
+
+	noteLinkRepo, err := repository.NewNoteLinkRepository(db, keys)
+	if err != nil {
+		log.Fatalf("err setting up note link repository: %v\n", err)
+		return nil
+	}
+
+	taskRepo, err := repository.NewTaskRepository(db, keys)
+	if err != nil {
+		log.Fatalf("err setting up task repository: %v\n", err)
+		return nil
+	}
+
+	noteStateRepo, err := repository.NewNoteStateRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up note state repository: %v\n", err)
+		return nil
+	}
+
+	propertyRepo, err := repository.NewPropertyRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up property repository: %v\n", err)
+		return nil
+	}
+
+	keyRepo, err := repository.NewKeyRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up key repository: %v\n", err)
+		return nil
+	}
+
+	searchRepo, err := repository.NewSearchRepository(db, keys)
+	if err != nil {
+		log.Fatalf("err setting up search repository: %v\n", err)
+		return nil
+	}
+
+	reminderRepo, err := repository.NewReminderRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up reminder repository: %v\n", err)
+		return nil
+	}
+
+	notificationRepo, err := repository.NewNotificationRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up notification repository: %v\n", err)
+		return nil
+	}
+
+	templateRepo, err := repository.NewTemplateRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up template repository: %v\n", err)
+		return nil
+	}
+
+	calendarRepo, err := repository.NewCalendarRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up calendar repository: %v\n", err)
+		return nil
+	}
+
+	exportRepo, err := repository.NewExportRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up export repository: %v\n", err)
+		return nil
+	}
+
+	sessionRepo, err := repository.NewSessionRepository(db)
+	if err != nil {
+		log.Fatalf("err setting up session repository: %v\n", err)
+		return nil
+	}
+
+	blobStore, err := newBlobStore(cfg)
+	if err != nil {
+		log.Fatalf("err setting up blob storage: %v\n", err)
+		return nil
+	}
+
+	thumbnails, err := thumbnail.NewCache(cfg.ThumbnailPath, cfg.ThumbnailSizes)
+	if err != nil {
+		log.Fatalf("err setting up thumbnail cache: %v\n", err)
+		return nil
+	}
+
+	avatars, err := avatar.NewStore(cfg.AvatarPath, cfg.AvatarSizes)
+	if err != nil {
+		log.Fatalf("err setting up avatar storage: %v\n", err)
+		return nil
+	}
+
+	bus := events.NewBus(cfg.EventReplaySize, cfg.EventReplayAge)
+
+	userService := service.NewUserService(userRepo, avatars, cfg)
+	noteService := service.NewNoteService(noteRepo, shareRepo, workspaceRepo, attachmentRepo, noteLinkRepo, taskRepo, noteStateRepo, propertyRepo, keyRepo, userService, bus, cfg)
+	hub := collab.NewHub(noteService, cfg.CollabCompactInterval)
+	noteService.SetLiveSessions(hub)
+	attachmentService := service.NewAttachmentService(attachmentRepo, noteService, blobStore, thumbnails)
+	workspaceService := service.NewWorkspaceService(workspaceRepo, noteRepo, attachmentRepo, noteStateRepo, propertyRepo, userService, cfg)
+	exportService := service.NewExportService(exportRepo, noteRepo, attachmentRepo, keyRepo, userService, blobStore, cfg)
+	templateService := service.NewTemplateService(templateRepo, workspaceRepo, userService)
+	reminderService := service.NewReminderService(reminderRepo, notificationRepo, noteService, userService, bus, cfg)
+
+	sessionService, err := service.NewSessionService(sessionRepo)
+	if err != nil {
+		log.Fatalf("err loading revoked sessions: %v\n", err)
+		return nil
+	}
+
 	return &Handlers{
-		UserHandler: NewUserHandler(userService),
-		NoteHandler: NewNoteHandler(service.NewNoteService(noteRepo, userService)),
-		AuthHandler: NewAuthHandler(service.NewAuthService(userRepo, cfg)),
+		UserHandler:       NewUserHandler(userService),
+		NoteHandler:       NewNoteHandler(noteService, service.NewRenderService(noteService, render.NewCache(cfg.RenderCacheSize)), templateService),
+		AuthHandler:       NewAuthHandler(service.NewAuthService(userRepo, avatars, cfg)),
+		EventHandler:      NewEventHandler(bus, cfg.EventHeartbeat),
+		CollabHandler:     NewCollabHandler(hub, noteService),
+		ShareLinkHandler:  NewShareLinkHandler(service.NewShareLinkService(linkRepo, noteRepo, noteService, cfg)),
+		WorkspaceHandler:  NewWorkspaceHandler(workspaceService),
+		AttachmentHandler: NewAttachmentHandler(attachmentService, cfg.MaxUploadSize),
+		AccountHandler:    NewAccountHandler(service.NewAccountService(userRepo, sessionService, exportService, avatars), exportService),
+		VaultHandler:      NewVaultHandler(service.NewVaultService(noteService, workspaceService), service.NewImportService(noteService, attachmentService, cfg), cfg.MaxImportSize),
+		GraphHandler:      NewGraphHandler(service.NewGraphService(noteLinkRepo, noteService, workspaceService)),
+		TaskHandler:       NewTaskHandler(service.NewTaskService(taskRepo, noteService)),
+		ReminderHandler:   NewReminderHandler(reminderService),
+		TemplateHandler:   NewTemplateHandler(templateService),
+		DailyHandler:      NewDailyHandler(service.NewDailyService(noteRepo, noteService, templateService, userService)),
+		PropertyHandler:   NewPropertyHandler(service.NewPropertyService(propertyRepo, workspaceRepo)),
+		SearchHandler:     NewSearchHandler(service.NewSearchService(searchRepo, noteStateRepo, propertyRepo, attachmentRepo, keyRepo, userService, cfg)),
+		KeyHandler:        NewKeyHandler(service.NewKeyService(keyRepo, userService)),
+		CalendarHandler:   NewCalendarHandler(service.NewCalendarService(calendarRepo, reminderRepo, taskRepo, noteService, cfg)),
+		AttachmentService: attachmentService,
+		ExportService:     exportService,
+		ReminderService:   reminderService,
+		SessionService:    sessionService,
+		EventBus:          bus,
+		CollabHub:         hub,
+	}
+}
+
+func newBlobStore(cfg config.Config) (storage.BlobStore, error) {
+	switch cfg.BlobStore {
+	case "local":
+		return storage.NewLocalStore(cfg.BlobPath)
+	case "s3":
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store %q, expected local or s3", cfg.BlobStore)
 	}
 }
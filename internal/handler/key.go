@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type KeyHandler interface {
+	GetKey(w http.ResponseWriter, r *http.Request)
+	SetKey(w http.ResponseWriter, r *http.Request)
+	GetPublicKey(w http.ResponseWriter, r *http.Request)
+}
+
+type keyHandler struct {
+	keyService service.KeyService
+}
+
+func NewKeyHandler(keyService service.KeyService) KeyHandler {
+	return &keyHandler{
+		keyService: keyService,
+	}
+}
+
+func (h *keyHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.keyService.GetKey(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(key)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *keyHandler) SetKey(w http.ResponseWriter, r *http.Request) {
+	var keyRequest dto.UserKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&keyRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	key, err := h.keyService.SetKey(models.ExtractUser(r).UserID, keyRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(key)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// GetPublicKey returns another user's public key, to share encrypted notes
+// with them.
+func (h *keyHandler) GetPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.keyService.GetPublicKey(chi.URLParam(r, "username"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(key)
+	if checkErr(err, r) {
+		return
+	}
+}
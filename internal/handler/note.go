@@ -3,6 +3,9 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/vaporii/v8box/internal/dto"
@@ -16,15 +19,27 @@ type NoteHandler interface {
 	GetNotes(w http.ResponseWriter, r *http.Request)
 	GetNoteByID(w http.ResponseWriter, r *http.Request)
 	EditNoteByID(w http.ResponseWriter, r *http.Request)
+	DeleteNoteByID(w http.ResponseWriter, r *http.Request)
+	GetSharedNotes(w http.ResponseWriter, r *http.Request)
+	GetNoteShares(w http.ResponseWriter, r *http.Request)
+	ShareNote(w http.ResponseWriter, r *http.Request)
+	UnshareNote(w http.ResponseWriter, r *http.Request)
+	TransferNote(w http.ResponseWriter, r *http.Request)
+	SetNoteStates(w http.ResponseWriter, r *http.Request)
+	BatchNotes(w http.ResponseWriter, r *http.Request)
 }
 
 type noteHandler struct {
-	noteService service.NoteService
+	noteService     service.NoteService
+	renderService   service.RenderService
+	templateService service.TemplateService
 }
 
-func NewNoteHandler(noteService service.NoteService) NoteHandler {
+func NewNoteHandler(noteService service.NoteService, renderService service.RenderService, templateService service.TemplateService) NoteHandler {
 	return &noteHandler{
-		noteService: noteService,
+		noteService:     noteService,
+		renderService:   renderService,
+		templateService: templateService,
 	}
 }
 
@@ -39,6 +54,12 @@ func (h *noteHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	noteRequest.UserID = models.ExtractUser(r).UserID
+	if noteRequest.TemplateID != "" {
+		err = h.templateService.ApplyTemplate(noteRequest.UserID, &noteRequest, time.Now())
+		if checkErr(err, r) {
+			return
+		}
+	}
 
 	note, err := h.noteService.Create(noteRequest)
 	if checkErr(err, r) {
@@ -50,7 +71,7 @@ func (h *noteHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *noteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
-	notes, err := h.noteService.GetUserNotes(models.ExtractUser(r).UserID)
+	notes, err := h.noteService.GetUserNotes(models.ExtractUser(r).UserID, noteListQuery(r))
 	if checkErr(err, r) {
 		return
 	}
@@ -62,8 +83,16 @@ func (h *noteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetNoteByID adds the content rendered to HTML and a table of contents
+// with ?format=html.
 func (h *noteHandler) GetNoteByID(w http.ResponseWriter, r *http.Request) {
-	note, err := h.noteService.GetNoteByID(chi.URLParam(r, "id"))
+	var note any
+	var err error
+	if r.URL.Query().Get("format") == "html" {
+		note, err = h.renderService.RenderNoteByID(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	} else {
+		note, err = h.noteService.GetNoteByID(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	}
 	if checkErr(err, r) {
 		return
 	}
@@ -85,7 +114,7 @@ func (h *noteHandler) EditNoteByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := h.noteService.EditNoteByID(chi.URLParam(r, "id"), noteRequest)
+	note, err := h.noteService.EditNoteByID(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), noteRequest)
 	if checkErr(err, r) {
 		return
 	}
@@ -96,3 +125,106 @@ func (h *noteHandler) EditNoteByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (h *noteHandler) DeleteNoteByID(w http.ResponseWriter, r *http.Request) {
+	err := h.noteService.DeleteNoteByID(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func noteListQuery(r *http.Request) dto.NoteListQuery {
+	query := dto.NoteListQuery{
+		Sort:      r.URL.Query().Get("sort"),
+		Pinned:    r.URL.Query().Get("pinned"),
+		Archived:  r.URL.Query().Get("archived"),
+		Favourite: r.URL.Query().Get("favourite"),
+	}
+	// archived notes stay out of the way unless they're asked for
+	if !r.URL.Query().Has("archived") {
+		query.Archived = "false"
+	}
+	query.Properties = propertyFilters(r.URL.RawQuery)
+	return query
+}
+
+// filter operators, longest first so >= isn't read as >
+var filterOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// propertyFilters reads prop.<name><op><value> parameters. They're taken
+// from the raw query since operators like >= don't survive url.ParseQuery,
+// which would split them at the =.
+func propertyFilters(rawQuery string) []dto.PropertyFilter {
+	var filters []dto.PropertyFilter
+	for _, part := range strings.Split(rawQuery, "&") {
+		part, err := url.QueryUnescape(part)
+		if err != nil || !strings.HasPrefix(part, "prop.") {
+			continue
+		}
+		part = strings.TrimPrefix(part, "prop.")
+
+		i := strings.IndexAny(part, "=!<>")
+		if i < 0 {
+			filters = append(filters, dto.PropertyFilter{Name: part})
+			continue
+		}
+		filter := dto.PropertyFilter{Name: part[:i], Op: part[i : i+1]}
+		for _, op := range filterOps {
+			if strings.HasPrefix(part[i:], op) {
+				filter.Op = op
+				break
+			}
+		}
+		filter.Value = part[i+len(filter.Op):]
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+func (h *noteHandler) SetNoteStates(w http.ResponseWriter, r *http.Request) {
+	var stateRequest dto.NoteStateRequest
+	err := json.NewDecoder(r.Body).Decode(&stateRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	states, err := h.noteService.SetNoteStates(models.ExtractUser(r).UserID, stateRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(states)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// BatchNotes answers 200 with a report even when operations failed, the
+// report's committed field says whether anything was saved.
+func (h *noteHandler) BatchNotes(w http.ResponseWriter, r *http.Request) {
+	var batchRequest dto.NoteBatchRequest
+	err := json.NewDecoder(r.Body).Decode(&batchRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	report, err := h.noteService.BatchNotes(models.ExtractUser(r).UserID, batchRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(report)
+	if checkErr(err, r) {
+		return
+	}
+}
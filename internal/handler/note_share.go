@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+)
+
+func (h *noteHandler) GetSharedNotes(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.noteService.GetSharedNotes(models.ExtractUser(r).UserID, noteListQuery(r))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(notes)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *noteHandler) GetNoteShares(w http.ResponseWriter, r *http.Request) {
+	shares, err := h.noteService.GetNoteShares(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(shares)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *noteHandler) ShareNote(w http.ResponseWriter, r *http.Request) {
+	var shareRequest dto.ShareNoteRequest
+	err := json.NewDecoder(r.Body).Decode(&shareRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	share, err := h.noteService.ShareNote(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), shareRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(share)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *noteHandler) UnshareNote(w http.ResponseWriter, r *http.Request) {
+	err := h.noteService.UnshareNote(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "userId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *noteHandler) TransferNote(w http.ResponseWriter, r *http.Request) {
+	var transferRequest dto.TransferNoteRequest
+	err := json.NewDecoder(r.Body).Decode(&transferRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	note, err := h.noteService.TransferNote(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), transferRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(note)
+	if checkErr(err, r) {
+		return
+	}
+}
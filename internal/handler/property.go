@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+// PropertyHandler serves property schemas, those of a workspace with
+// ?workspace_id= and the user's personal one otherwise.
+type PropertyHandler interface {
+	GetSchema(w http.ResponseWriter, r *http.Request)
+	SetSchema(w http.ResponseWriter, r *http.Request)
+}
+
+type propertyHandler struct {
+	propertyService service.PropertyService
+}
+
+func NewPropertyHandler(propertyService service.PropertyService) PropertyHandler {
+	return &propertyHandler{
+		propertyService: propertyService,
+	}
+}
+
+func (h *propertyHandler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := h.propertyService.GetSchema(models.ExtractUser(r).UserID, r.URL.Query().Get("workspace_id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(schema)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *propertyHandler) SetSchema(w http.ResponseWriter, r *http.Request) {
+	var schemaRequest dto.PropertySchemaRequest
+	err := json.NewDecoder(r.Body).Decode(&schemaRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	schema, err := h.propertyService.SetSchema(models.ExtractUser(r).UserID, r.URL.Query().Get("workspace_id"), schemaRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(schema)
+	if checkErr(err, r) {
+		return
+	}
+}
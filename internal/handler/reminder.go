@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type ReminderHandler interface {
+	CreateReminder(w http.ResponseWriter, r *http.Request)
+	GetNoteReminders(w http.ResponseWriter, r *http.Request)
+	GetReminders(w http.ResponseWriter, r *http.Request)
+	DeleteReminder(w http.ResponseWriter, r *http.Request)
+	GetChannels(w http.ResponseWriter, r *http.Request)
+	SetChannels(w http.ResponseWriter, r *http.Request)
+	TestChannels(w http.ResponseWriter, r *http.Request)
+}
+
+type reminderHandler struct {
+	reminderService service.ReminderService
+}
+
+func NewReminderHandler(reminderService service.ReminderService) ReminderHandler {
+	return &reminderHandler{
+		reminderService: reminderService,
+	}
+}
+
+func (h *reminderHandler) CreateReminder(w http.ResponseWriter, r *http.Request) {
+	var reminderRequest dto.ReminderRequest
+	err := json.NewDecoder(r.Body).Decode(&reminderRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	reminder, err := h.reminderService.CreateReminder(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), reminderRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(reminder)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *reminderHandler) GetNoteReminders(w http.ResponseWriter, r *http.Request) {
+	h.writeReminders(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *reminderHandler) GetReminders(w http.ResponseWriter, r *http.Request) {
+	h.writeReminders(w, r, "")
+}
+
+func (h *reminderHandler) writeReminders(w http.ResponseWriter, r *http.Request, noteId string) {
+	reminders, err := h.reminderService.GetReminders(models.ExtractUser(r).UserID, noteId)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(reminders)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *reminderHandler) DeleteReminder(w http.ResponseWriter, r *http.Request) {
+	err := h.reminderService.DeleteReminder(models.ExtractUser(r).UserID, chi.URLParam(r, "reminderId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *reminderHandler) GetChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.reminderService.GetChannels(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(channels)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *reminderHandler) SetChannels(w http.ResponseWriter, r *http.Request) {
+	var channelRequests []dto.NotificationChannelRequest
+	err := json.NewDecoder(r.Body).Decode(&channelRequests)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	channels, err := h.reminderService.SetChannels(models.ExtractUser(r).UserID, channelRequests)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(channels)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *reminderHandler) TestChannels(w http.ResponseWriter, r *http.Request) {
+	results, err := h.reminderService.TestChannels(r.Context(), models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(results)
+	if checkErr(err, r) {
+		return
+	}
+}
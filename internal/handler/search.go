@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type SearchHandler interface {
+	Search(w http.ResponseWriter, r *http.Request)
+	CreateSavedSearch(w http.ResponseWriter, r *http.Request)
+	GetSavedSearches(w http.ResponseWriter, r *http.Request)
+	GetSavedSearch(w http.ResponseWriter, r *http.Request)
+	UpdateSavedSearch(w http.ResponseWriter, r *http.Request)
+	DeleteSavedSearch(w http.ResponseWriter, r *http.Request)
+	RunSavedSearch(w http.ResponseWriter, r *http.Request)
+}
+
+type searchHandler struct {
+	searchService service.SearchService
+}
+
+func NewSearchHandler(searchService service.SearchService) SearchHandler {
+	return &searchHandler{
+		searchService: searchService,
+	}
+}
+
+// searchRequest reads ?q=, ?sort= and ?limit=.
+func searchRequest(r *http.Request) (dto.SearchRequest, error) {
+	request := dto.SearchRequest{
+		Query: r.URL.Query().Get("q"),
+		Sort:  r.URL.Query().Get("sort"),
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return request, &httperror.BadClientRequestError{Message: "limit must be a positive number"}
+		}
+		request.Limit = limit
+	}
+	return request, nil
+}
+
+func (h *searchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	request, err := searchRequest(r)
+	if checkErr(err, r) {
+		return
+	}
+
+	notes, err := h.searchService.Search(models.ExtractUser(r).UserID, request)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(notes)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *searchHandler) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var savedSearchRequest dto.SavedSearchRequest
+	err := json.NewDecoder(r.Body).Decode(&savedSearchRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	savedSearch, err := h.searchService.CreateSavedSearch(models.ExtractUser(r).UserID, savedSearchRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(savedSearch)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *searchHandler) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	savedSearches, err := h.searchService.GetSavedSearches(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(savedSearches)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *searchHandler) GetSavedSearch(w http.ResponseWriter, r *http.Request) {
+	savedSearch, err := h.searchService.GetSavedSearch(models.ExtractUser(r).UserID, chi.URLParam(r, "searchId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(savedSearch)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *searchHandler) UpdateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var savedSearchRequest dto.SavedSearchRequest
+	err := json.NewDecoder(r.Body).Decode(&savedSearchRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	savedSearch, err := h.searchService.UpdateSavedSearch(models.ExtractUser(r).UserID, chi.URLParam(r, "searchId"), savedSearchRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(savedSearch)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *searchHandler) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	err := h.searchService.DeleteSavedSearch(models.ExtractUser(r).UserID, chi.URLParam(r, "searchId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedSearch takes ?sort= and ?limit= like a search.
+func (h *searchHandler) RunSavedSearch(w http.ResponseWriter, r *http.Request) {
+	request, err := searchRequest(r)
+	if checkErr(err, r) {
+		return
+	}
+
+	notes, err := h.searchService.RunSavedSearch(models.ExtractUser(r).UserID, chi.URLParam(r, "searchId"), request)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(notes)
+	if checkErr(err, r) {
+		return
+	}
+}
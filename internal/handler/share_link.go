@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type ShareLinkHandler interface {
+	CreateLink(w http.ResponseWriter, r *http.Request)
+	GetNoteLinks(w http.ResponseWriter, r *http.Request)
+	RevokeLink(w http.ResponseWriter, r *http.Request)
+	GetLinkAccesses(w http.ResponseWriter, r *http.Request)
+	Open(w http.ResponseWriter, r *http.Request)
+}
+
+type shareLinkHandler struct {
+	shareLinkService service.ShareLinkService
+}
+
+func NewShareLinkHandler(shareLinkService service.ShareLinkService) ShareLinkHandler {
+	return &shareLinkHandler{
+		shareLinkService: shareLinkService,
+	}
+}
+
+var publicNoteTemplate = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<pre style="white-space: pre-wrap">{{.Content}}</pre>
+</body>
+</html>
+`))
+
+var publicPasswordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>{{.}}</p>
+<input type="password" name="password" autofocus>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+func (h *shareLinkHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	var linkRequest dto.CreateShareLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&linkRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	link, err := h.shareLinkService.CreateLink(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), linkRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(link)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *shareLinkHandler) GetNoteLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := h.shareLinkService.GetNoteLinks(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(links)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *shareLinkHandler) RevokeLink(w http.ResponseWriter, r *http.Request) {
+	err := h.shareLinkService.RevokeLink(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "linkId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *shareLinkHandler) GetLinkAccesses(w http.ResponseWriter, r *http.Request) {
+	accesses, err := h.shareLinkService.GetLinkAccesses(models.ExtractUser(r).UserID, chi.URLParam(r, "id"), chi.URLParam(r, "linkId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(accesses)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// Open serves a public link as JSON, or as a page when the client asks for
+// HTML. The password comes from the X-Share-Password header or, for the HTML
+// form, a posted password field.
+func (h *shareLinkHandler) Open(w http.ResponseWriter, r *http.Request) {
+	html := r.URL.Query().Get("format") == "html" || strings.Contains(r.Header.Get("Accept"), "text/html")
+
+	password := r.Header.Get("X-Share-Password")
+	if password == "" && r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+	}
+
+	note, err := h.shareLinkService.OpenLink(chi.URLParam(r, "token"), password, models.ShareLinkAccess{
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+
+	var unauthorized *httperror.UnauthorizedError
+	if html && errors.As(err, &unauthorized) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		publicPasswordTemplate.Execute(w, unauthorized.Message)
+		return
+	}
+	var tooMany *httperror.TooManyRequestsError
+	if html && errors.As(err, &tooMany) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		publicPasswordTemplate.Execute(w, tooMany.Message)
+		return
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	if html {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		err = publicNoteTemplate.Execute(w, note)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = json.NewEncoder(w).Encode(note)
+	}
+	if checkErr(err, r) {
+		return
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type TaskHandler interface {
+	GetTasks(w http.ResponseWriter, r *http.Request)
+	ToggleTask(w http.ResponseWriter, r *http.Request)
+}
+
+type taskHandler struct {
+	taskService service.TaskService
+}
+
+func NewTaskHandler(taskService service.TaskService) TaskHandler {
+	return &taskHandler{
+		taskService: taskService,
+	}
+}
+
+// GetTasks lists open tasks, filtered with ?status=open|done|all,
+// ?due_before=2026-11-01, ?priority=high|medium|low and ?note_id=.
+func (h *taskHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tasks, err := h.taskService.GetTasks(models.ExtractUser(r).UserID, dto.TaskQuery{
+		Status:    query.Get("status"),
+		DueBefore: query.Get("due_before"),
+		Priority:  query.Get("priority"),
+		NoteID:    query.Get("note_id"),
+	})
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(tasks)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *taskHandler) ToggleTask(w http.ResponseWriter, r *http.Request) {
+	task, err := h.taskService.ToggleTask(models.ExtractUser(r).UserID, chi.URLParam(r, "id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(task)
+	if checkErr(err, r) {
+		return
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type TemplateHandler interface {
+	CreateTemplate(w http.ResponseWriter, r *http.Request)
+	GetTemplates(w http.ResponseWriter, r *http.Request)
+	GetTemplate(w http.ResponseWriter, r *http.Request)
+	UpdateTemplate(w http.ResponseWriter, r *http.Request)
+	DeleteTemplate(w http.ResponseWriter, r *http.Request)
+}
+
+type templateHandler struct {
+	templateService service.TemplateService
+}
+
+func NewTemplateHandler(templateService service.TemplateService) TemplateHandler {
+	return &templateHandler{
+		templateService: templateService,
+	}
+}
+
+func (h *templateHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var templateRequest dto.TemplateRequest
+	err := json.NewDecoder(r.Body).Decode(&templateRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	template, err := h.templateService.CreateTemplate(models.ExtractUser(r).UserID, templateRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(template)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+// GetTemplates lists personal and workspace templates, only those of one
+// workspace with ?workspace_id=.
+func (h *templateHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.templateService.GetTemplates(models.ExtractUser(r).UserID, r.URL.Query().Get("workspace_id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(templates)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *templateHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := h.templateService.GetTemplate(models.ExtractUser(r).UserID, chi.URLParam(r, "templateId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(template)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *templateHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	var templateRequest dto.TemplateRequest
+	err := json.NewDecoder(r.Body).Decode(&templateRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	template, err := h.templateService.UpdateTemplate(models.ExtractUser(r).UserID, chi.URLParam(r, "templateId"), templateRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(template)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *templateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	err := h.templateService.DeleteTemplate(models.ExtractUser(r).UserID, chi.URLParam(r, "templateId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,15 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/avatar"
+	"github.com/vaporii/v8box/internal/dto"
 
 	"github.com/vaporii/v8box/internal/httperror"
 	"github.com/vaporii/v8box/internal/logging"
@@ -12,6 +19,10 @@ import (
 
 type UserHandler interface {
 	GetCurrentUser(w http.ResponseWriter, r *http.Request)
+	UpdateProfile(w http.ResponseWriter, r *http.Request)
+	GetAvatar(w http.ResponseWriter, r *http.Request)
+	SetAvatar(w http.ResponseWriter, r *http.Request)
+	DeleteAvatar(w http.ResponseWriter, r *http.Request)
 }
 
 type userHandler struct {
@@ -39,6 +50,101 @@ func (h *userHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *userHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var profileRequest dto.UpdateProfileRequest
+	decoder := json.NewDecoder(r.Body)
+	// a misspelled setting would otherwise be silently ignored
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&profileRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request: " + err.Error()}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(models.ExtractUser(r).UserID, profileRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(user)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *userHandler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	var size int
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			checkErr(&httperror.BadClientRequestError{Message: "size must be a positive number"}, r)
+			return
+		}
+	}
+
+	avatar, err := h.userService.GetAvatar(chi.URLParam(r, "userId"), size)
+	if checkErr(err, r) {
+		return
+	}
+	defer avatar.Content.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// short enough that a new avatar shows up soon after it's changed
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("ETag", avatar.ETag)
+
+	http.ServeContent(w, r, "", avatar.ModTime, avatar.Content)
+}
+
+// SetAvatar takes the image either as the raw request body or as the first
+// file of a multipart/form-data upload.
+func (h *userHandler) SetAvatar(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, avatar.MaxSize)
+
+	var body io.Reader = r.Body
+	if reader, err := r.MultipartReader(); err == nil {
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				checkErr(uploadError(err), r)
+				return
+			}
+			if part.FileName() != "" {
+				defer part.Close()
+				body = part
+				break
+			}
+			part.Close()
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if checkErr(uploadError(err), r) {
+		return
+	}
+
+	err = h.userService.SetAvatar(models.ExtractUser(r).UserID, bytes.NewReader(data))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *userHandler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	err := h.userService.DeleteAvatar(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func checkErr(err error, r *http.Request) bool {
 	if err != nil {
 		errorVal := r.Context().Value(httperror.ErrorKey).(*error)
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type VaultHandler interface {
+	Import(w http.ResponseWriter, r *http.Request)
+	Export(w http.ResponseWriter, r *http.Request)
+}
+
+type vaultHandler struct {
+	vaultService  service.VaultService
+	importService service.ImportService
+	maxImportSize int64
+}
+
+func NewVaultHandler(vaultService service.VaultService, importService service.ImportService, maxImportSize int64) VaultHandler {
+	return &vaultHandler{
+		vaultService:  vaultService,
+		importService: importService,
+		maxImportSize: maxImportSize,
+	}
+}
+
+// Import takes an archive either as the raw request body or as the first
+// file of a multipart/form-data upload:
+//
+//   - markdown: a ZIP of Markdown files with front matter
+//   - enex: an Evernote export, put in ?folder= or a folder named after
+//     the uploaded file
+//   - jex: a Joplin export
+//
+// ?format= picks one, otherwise it's told from the content. ?workspace_id=
+// imports into a workspace instead of the personal notes.
+func (h *vaultHandler) Import(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxImportSize)
+
+	var body io.Reader = r.Body
+	var filename string
+	if reader, err := r.MultipartReader(); err == nil {
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				checkErr(uploadError(err), r)
+				return
+			}
+			if part.FileName() != "" {
+				defer part.Close()
+				body = part
+				filename = part.FileName()
+				break
+			}
+			part.Close()
+		}
+	}
+
+	buffered := bufio.NewReader(body)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormat(buffered, filename)
+	}
+
+	userId := models.ExtractUser(r).UserID
+	workspaceId := r.URL.Query().Get("workspace_id")
+
+	var report *dto.ImportReport
+	var err error
+	switch format {
+	case "markdown":
+		report, err = h.importMarkdown(userId, workspaceId, buffered)
+	case "enex":
+		folder := r.URL.Query().Get("folder")
+		if folder == "" && filename != "" {
+			folder = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+		}
+		report, err = h.importService.ImportENEX(r.Context(), userId, workspaceId, folder, buffered)
+	case "jex":
+		report, err = h.importService.ImportJEX(r.Context(), userId, workspaceId, buffered)
+	default:
+		err = &httperror.BadClientRequestError{Message: "Unknown import format, set format to markdown, enex or jex"}
+	}
+	if checkErr(uploadError(err), r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(report)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *vaultHandler) importMarkdown(userId string, workspaceId string, body io.Reader) (*dto.ImportReport, error) {
+	// the ZIP directory is at the end, so the archive has to be spooled
+	// before anything can be read from it
+	tmp, err := os.CreateTemp("", "v8box-import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return nil, &httperror.BadClientRequestError{Message: "Upload isn't a valid ZIP archive"}
+	}
+
+	return h.vaultService.Import(userId, workspaceId, archive)
+}
+
+// importFormat tells the formats apart by their first bytes, falling back
+// to the file name.
+func importFormat(r *bufio.Reader, filename string) string {
+	head, _ := r.Peek(1024)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "markdown"
+	case len(head) > 262 && string(head[257:262]) == "ustar":
+		return "jex"
+	case bytes.Contains(head, []byte("<en-export")):
+		return "enex"
+	}
+
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".zip":
+		return "markdown"
+	case ".jex":
+		return "jex"
+	case ".enex":
+		return "enex"
+	}
+	return ""
+}
+
+// Export streams the personal notes, or a workspace's with ?workspace_id=,
+// in the layout a markdown import reads.
+func (h *vaultHandler) Export(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.vaultService.GetNotes(models.ExtractUser(r).UserID, r.URL.Query().Get("workspace_id"))
+	if checkErr(err, r) {
+		return
+	}
+
+	filename := "v8box-notes-" + time.Now().Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	// too late for an error response once the archive has started
+	err = h.vaultService.WriteArchive(w, notes)
+	if err != nil {
+		logging.Error("err writing markdown export: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/service"
+)
+
+type WorkspaceHandler interface {
+	CreateWorkspace(w http.ResponseWriter, r *http.Request)
+	GetWorkspaces(w http.ResponseWriter, r *http.Request)
+	GetWorkspace(w http.ResponseWriter, r *http.Request)
+	UpdateWorkspace(w http.ResponseWriter, r *http.Request)
+	DeleteWorkspace(w http.ResponseWriter, r *http.Request)
+	AddMember(w http.ResponseWriter, r *http.Request)
+	UpdateMember(w http.ResponseWriter, r *http.Request)
+	RemoveMember(w http.ResponseWriter, r *http.Request)
+	GetWorkspaceNotes(w http.ResponseWriter, r *http.Request)
+}
+
+type workspaceHandler struct {
+	workspaceService service.WorkspaceService
+}
+
+func NewWorkspaceHandler(workspaceService service.WorkspaceService) WorkspaceHandler {
+	return &workspaceHandler{
+		workspaceService: workspaceService,
+	}
+}
+
+func (h *workspaceHandler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var workspaceRequest dto.WorkspaceRequest
+	err := json.NewDecoder(r.Body).Decode(&workspaceRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	workspace, err := h.workspaceService.CreateWorkspace(models.ExtractUser(r).UserID, workspaceRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(workspace)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := h.workspaceService.GetUserWorkspaces(models.ExtractUser(r).UserID)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(workspaces)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspace, err := h.workspaceService.GetWorkspace(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(workspace)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) UpdateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var workspaceRequest dto.WorkspaceRequest
+	err := json.NewDecoder(r.Body).Decode(&workspaceRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	workspace, err := h.workspaceService.UpdateWorkspace(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"), workspaceRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(workspace)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) DeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	err := h.workspaceService.DeleteWorkspace(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *workspaceHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	var memberRequest dto.AddWorkspaceMemberRequest
+	err := json.NewDecoder(r.Body).Decode(&memberRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	member, err := h.workspaceService.AddMember(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"), memberRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(member)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	var memberRequest dto.UpdateWorkspaceMemberRequest
+	err := json.NewDecoder(r.Body).Decode(&memberRequest)
+	if err != nil {
+		err = &httperror.BadClientRequestError{Message: "Bad JSON request"}
+	}
+	if checkErr(err, r) {
+		return
+	}
+
+	member, err := h.workspaceService.UpdateMember(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"), chi.URLParam(r, "userId"), memberRequest)
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(member)
+	if checkErr(err, r) {
+		return
+	}
+}
+
+func (h *workspaceHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	err := h.workspaceService.RemoveMember(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"), chi.URLParam(r, "userId"))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *workspaceHandler) GetWorkspaceNotes(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.workspaceService.GetWorkspaceNotes(models.ExtractUser(r).UserID, chi.URLParam(r, "workspaceId"), noteListQuery(r))
+	if checkErr(err, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(notes)
+	if checkErr(err, r) {
+		return
+	}
+}
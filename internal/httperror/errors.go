@@ -21,3 +21,38 @@ type BadClientRequestError struct {
 func (e *BadClientRequestError) Error() string {
 	return e.Message
 }
+
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+// ConflictError means the request was based on a state that has since
+// changed.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// TooManyRequestsError means the client has to wait before trying again.
+type TooManyRequestsError struct {
+	Message string
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,85 @@
+// Package ical writes iCalendar (RFC 5545) data. It only covers what the
+// calendar feed needs: events, todos and the timezones they're in.
+package ical
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// layout of local DATE-TIME values, used with TZID
+	localLayout = "20060102T150405"
+	utcLayout   = "20060102T150405Z"
+	dateLayout  = "20060102"
+	// lines longer than this many bytes are folded
+	lineLimit = 75
+)
+
+// Writer builds a calendar a property at a time. Values given to Text are
+// escaped, everything else is written as is.
+type Writer struct {
+	b strings.Builder
+}
+
+func (w *Writer) Begin(component string) {
+	w.Raw("BEGIN", component)
+}
+
+func (w *Writer) End(component string) {
+	w.Raw("END", component)
+}
+
+// Raw writes a property whose value is already in iCalendar form. name may
+// carry parameters, e.g. "DTSTART;TZID=Europe/Berlin".
+func (w *Writer) Raw(name string, value string) {
+	w.line(name + ":" + value)
+}
+
+func (w *Writer) Text(name string, value string) {
+	w.Raw(name, Escape(value))
+}
+
+// UTC writes a DATE-TIME in UTC.
+func (w *Writer) UTC(name string, t time.Time) {
+	w.Raw(name, t.UTC().Format(utcLayout))
+}
+
+// Local writes a DATE-TIME as wall clock time in t's location, which needs
+// a matching VTIMEZONE in the calendar.
+func (w *Writer) Local(name string, t time.Time) {
+	w.Raw(name+";TZID="+t.Location().String(), t.Format(localLayout))
+}
+
+func (w *Writer) Date(name string, t time.Time) {
+	w.Raw(name+";VALUE=DATE", t.Format(dateLayout))
+}
+
+// line writes a content line, folding it so no line is longer than 75
+// bytes without splitting a UTF-8 sequence.
+func (w *Writer) line(line string) {
+	limit := lineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// the leading space counts towards the next line
+		limit = lineLimit - 1
+	}
+	w.b.WriteString(line + "\r\n")
+}
+
+func (w *Writer) Bytes() []byte {
+	return []byte(w.b.String())
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escape escapes a TEXT value.
+func Escape(value string) string {
+	return escaper.Replace(value)
+}
@@ -0,0 +1,124 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+var weekdays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Timezone writes a VTIMEZONE for loc. The offset changes loc has in year
+// are turned into yearly rules, like "last Sunday in March", which is how
+// almost every zone with daylight saving time works today. Zones without
+// changes get a single fixed offset.
+func (w *Writer) Timezone(loc *time.Location, year int) {
+	w.Begin("VTIMEZONE")
+	w.Raw("TZID", loc.String())
+
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	changes := transitions(start, start.AddDate(1, 0, 0))
+	if len(changes) == 0 {
+		name, offset := start.Zone()
+		w.observance("STANDARD", time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), offset, offset, name, "")
+	}
+	for _, change := range changes {
+		_, from := change.Add(-time.Second).Zone()
+		name, to := change.Zone()
+		kind := "STANDARD"
+		if change.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		// observances start at the wall clock time before the change
+		rule, first := yearlyRule(change.In(time.FixedZone("", from)))
+		w.observance(kind, first, from, to, name, rule)
+	}
+
+	w.End("VTIMEZONE")
+}
+
+func (w *Writer) observance(kind string, start time.Time, from int, to int, name string, rule string) {
+	w.Begin(kind)
+	w.Raw("DTSTART", start.Format(localLayout))
+	w.Raw("TZOFFSETFROM", formatOffset(from))
+	w.Raw("TZOFFSETTO", formatOffset(to))
+	if rule != "" {
+		w.Raw("RRULE", rule)
+	}
+	// abbreviations like "+03" aren't names
+	if name != "" && name[0] != '+' && name[0] != '-' {
+		w.Text("TZNAME", name)
+	}
+	w.End(kind)
+}
+
+// transitions finds the moments in [from, to) when the UTC offset of from's
+// location changes.
+func transitions(from time.Time, to time.Time) []time.Time {
+	const step = 6 * time.Hour
+
+	var changes []time.Time
+	_, offset := from.Zone()
+	for t := from; t.Before(to); t = t.Add(step) {
+		next := t.Add(step)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			// narrow it down to the second
+			low, high := t, next
+			for high.Sub(low) > time.Second {
+				mid := low.Add(high.Sub(low) / 2)
+				if _, midOffset := mid.Zone(); midOffset == offset {
+					low = mid
+				} else {
+					high = mid
+				}
+			}
+			if high.Before(to) {
+				changes = append(changes, high)
+			}
+			_, offset = next.Zone()
+		}
+	}
+	return changes
+}
+
+// yearlyRule describes the day of change as the nth or last weekday of its
+// month, and returns it with its first occurrence in 1970 so the rule
+// covers older events too.
+func yearlyRule(change time.Time) (string, time.Time) {
+	month := change.Month()
+	day := change.Day()
+	daysInMonth := time.Date(change.Year(), month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	nth := (day-1)/7 + 1
+	if day+7 > daysInMonth {
+		nth = -1
+	}
+	rule := fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", month, nth, weekdays[change.Weekday()])
+
+	first := nthWeekday(1970, month, nth, change.Weekday())
+	first = first.Add(time.Duration(change.Hour())*time.Hour + time.Duration(change.Minute())*time.Minute + time.Duration(change.Second())*time.Second)
+	return rule, first
+}
+
+// nthWeekday returns the nth weekday of the month, counting from the end if
+// nth is negative.
+func nthWeekday(year int, month time.Month, nth int, weekday time.Weekday) time.Time {
+	if nth < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.AddDate(0, 0, -((int(last.Weekday())-int(weekday)+7)%7 + (-nth-1)*7))
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+(nth-1)*7)
+}
+
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	offset := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		offset += fmt.Sprintf("%02d", seconds%60)
+	}
+	return offset
+}
@@ -0,0 +1,344 @@
+package importer
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+	"time"
+)
+
+const enexTimeLayout = "20060102T150405Z"
+
+type enexNote struct {
+	Title     string
+	Content   string
+	Created   string
+	Updated   string
+	Tags      []string
+	Resources []enexResource
+	// the first resource that couldn't be spooled, reported once the rest
+	// of the note has been read past
+	err error
+}
+
+type enexResource struct {
+	Resource
+	Hash string
+	Mime string
+}
+
+// ENEXReader reads notes from an Evernote export one at a time. ENML
+// content is converted to Markdown and <en-media> elements point at the
+// note's resources.
+type ENEXReader struct {
+	decoder *xml.Decoder
+	dir     string
+	count   int
+	// removed once the caller moves on, so only one note's resources
+	// are ever on disk
+	previous []Resource
+}
+
+// NewENEXReader spools resources into dir.
+func NewENEXReader(r io.Reader, dir string) *ENEXReader {
+	return &ENEXReader{
+		decoder: xml.NewDecoder(r),
+		dir:     dir,
+	}
+}
+
+func (e *ENEXReader) Next() (*Note, error) {
+	removeResources(e.previous)
+	e.previous = nil
+
+	for {
+		token, err := e.decoder.Token()
+		if err != nil {
+			if err == io.EOF && e.count == 0 {
+				return nil, fmt.Errorf("no notes found, is this an ENEX file?")
+			}
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		e.count++
+
+		raw, err := e.readNote()
+		if err != nil {
+			return nil, err
+		}
+
+		note, err := e.convert(raw)
+		if err != nil {
+			return nil, &NoteError{Source: e.source(raw.Title), Err: err}
+		}
+		e.previous = note.Resources
+		return note, nil
+	}
+}
+
+// readNote reads the children of a <note>. Resources are spooled as they
+// come instead of decoding the whole element, which would hold every
+// attachment of the note in memory as base64.
+func (e *ENEXReader) readNote() (*enexNote, error) {
+	raw := &enexNote{}
+	for {
+		token, err := e.decoder.Token()
+		if err != nil {
+			removeEnexResources(raw.Resources)
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.EndElement:
+			return raw, nil
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "title":
+				err = e.decoder.DecodeElement(&raw.Title, &token)
+			case "content":
+				err = e.decoder.DecodeElement(&raw.Content, &token)
+			case "created":
+				err = e.decoder.DecodeElement(&raw.Created, &token)
+			case "updated":
+				err = e.decoder.DecodeElement(&raw.Updated, &token)
+			case "tag":
+				var tag string
+				err = e.decoder.DecodeElement(&tag, &token)
+				raw.Tags = append(raw.Tags, tag)
+			case "resource":
+				err = e.readResource(raw)
+			default:
+				err = e.decoder.Skip()
+			}
+			if err != nil {
+				removeEnexResources(raw.Resources)
+				return nil, err
+			}
+		}
+	}
+}
+
+// readResource reads a <resource> into raw.Resources. Problems with the
+// data are left in raw.err so the rest of the export can still be read.
+func (e *ENEXReader) readResource(raw *enexNote) error {
+	var resource enexResource
+	for {
+		token, err := e.decoder.Token()
+		if err != nil {
+			os.Remove(resource.Path)
+			return err
+		}
+
+		switch token := token.(type) {
+		case xml.EndElement:
+			if resource.Path == "" {
+				return nil
+			}
+			if resource.Filename == "" {
+				resource.Filename = fmt.Sprintf("attachment-%d", len(raw.Resources)+1)
+				if extensions, _ := mime.ExtensionsByType(resource.Mime); len(extensions) > 0 {
+					resource.Filename += extensions[0]
+				}
+			}
+			raw.Resources = append(raw.Resources, resource)
+			return nil
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "data":
+				if resource.Path != "" {
+					err = e.decoder.Skip()
+					break
+				}
+				var problem error
+				resource.Path, resource.Hash, problem, err = e.spool(token)
+				resource.Ref = enexRef(resource.Hash)
+				if problem != nil && raw.err == nil {
+					raw.err = problem
+				}
+			case "mime":
+				err = e.decoder.DecodeElement(&resource.Mime, &token)
+			case "resource-attributes":
+				var attributes struct {
+					Filename string `xml:"file-name"`
+				}
+				err = e.decoder.DecodeElement(&attributes, &token)
+				resource.Filename = strings.TrimSpace(attributes.Filename)
+			default:
+				err = e.decoder.Skip()
+			}
+			if err != nil {
+				os.Remove(resource.Path)
+				return err
+			}
+		}
+	}
+}
+
+func (e *ENEXReader) source(title string) string {
+	return fmt.Sprintf("#%d %s", e.count, title)
+}
+
+func (e *ENEXReader) convert(raw *enexNote) (*Note, error) {
+	if raw.err != nil {
+		removeEnexResources(raw.Resources)
+		return nil, raw.err
+	}
+
+	note := &Note{
+		Source: e.source(raw.Title),
+		Title:  strings.TrimSpace(raw.Title),
+		Tags:   make([]string, 0, len(raw.Tags)),
+	}
+	for _, tag := range raw.Tags {
+		note.Tags = append(note.Tags, tagName(tag))
+	}
+	// Evernote always writes both, a missing one is left for the server
+	note.Created, _ = time.Parse(enexTimeLayout, strings.TrimSpace(raw.Created))
+	note.Updated, _ = time.Parse(enexTimeLayout, strings.TrimSpace(raw.Updated))
+
+	mimeTypes := make(map[string]string)
+	for _, resource := range raw.Resources {
+		if _, ok := mimeTypes[resource.Hash]; ok {
+			// the same file attached twice
+			os.Remove(resource.Path)
+			continue
+		}
+		note.Resources = append(note.Resources, resource.Resource)
+		mimeTypes[resource.Hash] = resource.Mime
+	}
+
+	content, err := ToMarkdown(strings.NewReader(raw.Content), func(attrs map[string]string) string {
+		hash := strings.ToLower(attrs["hash"])
+		for _, resource := range note.Resources {
+			if resource.Ref == enexRef(hash) {
+				return resourceLink(resource, mimeTypes[hash])
+			}
+		}
+		return ""
+	})
+	if err != nil {
+		removeResources(note.Resources)
+		return nil, fmt.Errorf("couldn't convert content: %w", err)
+	}
+	note.Content = content
+
+	return note, nil
+}
+
+// spool decodes the <data> element start opens into a temporary file.
+// ENML refers to resources by the MD5 of their contents. A problem with
+// the data itself is returned as problem, with the element read past, err
+// means the export can't be read any further.
+func (e *ENEXReader) spool(start xml.StartElement) (path, digest string, problem, err error) {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "encoding" && attr.Value != "base64" {
+			return "", "", fmt.Errorf("unsupported resource encoding %q", attr.Value), e.decoder.Skip()
+		}
+	}
+
+	file, err := os.CreateTemp(e.dir, "resource-*")
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer file.Close()
+
+	data := &enexData{decoder: e.decoder}
+	hash := md5.New()
+	_, problem = io.Copy(io.MultiWriter(file, hash), base64.NewDecoder(base64.StdEncoding, data))
+	if data.err != nil {
+		os.Remove(file.Name())
+		return "", "", nil, data.err
+	}
+	if problem != nil {
+		os.Remove(file.Name())
+		if !data.done {
+			err = e.decoder.Skip()
+		}
+		return "", "", fmt.Errorf("couldn't decode resource: %w", problem), err
+	}
+
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), nil, nil
+}
+
+// enexData reads the text of the element the decoder is in, up to its end.
+// base64 in ENEX is wrapped and indented, the base64 decoder only skips
+// newlines, so spaces and tabs are dropped here.
+type enexData struct {
+	decoder *xml.Decoder
+	text    []byte
+	// the element's end has been read
+	done bool
+	// reading the XML failed
+	err error
+}
+
+func (d *enexData) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.text) == 0 {
+			if d.done || d.err != nil || n > 0 {
+				break
+			}
+			token, err := d.decoder.Token()
+			if err != nil {
+				d.err = err
+				break
+			}
+			switch token := token.(type) {
+			case xml.CharData:
+				d.text = token
+			case xml.EndElement:
+				d.done = true
+			case xml.StartElement:
+				d.err = fmt.Errorf("unexpected <%s> in resource data", token.Name.Local)
+			}
+			continue
+		}
+
+		c := d.text[0]
+		d.text = d.text[1:]
+		if c != ' ' && c != '\t' {
+			p[n] = c
+			n++
+		}
+	}
+
+	if n == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func removeEnexResources(resources []enexResource) {
+	for _, resource := range resources {
+		os.Remove(resource.Path)
+	}
+}
+
+func enexRef(hash string) string {
+	return "enex-resource:" + hash
+}
+
+// resourceLink embeds images and links everything else.
+func resourceLink(resource Resource, mimeType string) string {
+	link := "[" + escapeText(resource.Filename) + "](" + resource.Ref + ")"
+	if strings.HasPrefix(mimeType, "image/") {
+		return "!" + link
+	}
+	return link
+}
@@ -0,0 +1,69 @@
+// Package importer reads notes exported from other apps. Archives are read
+// as a stream and attachments are spooled to disk, so only one note is held
+// in memory at a time.
+package importer
+
+import (
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Note is a note read from an export, ready to be created.
+type Note struct {
+	// where the note came from in the archive, for reporting
+	Source  string
+	Title   string
+	Content string
+	Tags    []string
+	Folder  string
+	Created time.Time
+	Updated time.Time
+	// files Content refers to by their Ref
+	Resources []Resource
+}
+
+// Resource is an attachment spooled to a temporary file. The file is only
+// guaranteed to exist until the next call to Next.
+type Resource struct {
+	// placeholder in Note.Content to replace with a link to the attachment
+	Ref      string
+	Filename string
+	Path     string
+}
+
+// Reader returns notes one at a time, io.EOF after the last. A *NoteError
+// means only that note couldn't be read, other errors end the import.
+type Reader interface {
+	Next() (*Note, error)
+}
+
+func removeResources(resources []Resource) {
+	for _, resource := range resources {
+		os.Remove(resource.Path)
+	}
+}
+
+// NoteError is a problem with a single note. Reading can go on with the
+// next one.
+type NoteError struct {
+	Source string
+	Err    error
+}
+
+func (e *NoteError) Error() string {
+	return e.Source + ": " + e.Err.Error()
+}
+
+func (e *NoteError) Unwrap() error {
+	return e.Err
+}
+
+// tagName turns tags other apps allow, like "to read", into ones without
+// spaces or commas.
+func tagName(tag string) string {
+	return strings.Join(strings.FieldsFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	}), "-")
+}
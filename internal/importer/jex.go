@@ -0,0 +1,303 @@
+package importer
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Joplin item types
+const (
+	jexNote     = 1
+	jexFolder   = 2
+	jexResource = 4
+	jexTag      = 5
+	jexNoteTag  = 6
+)
+
+const maxJEXItemSize = 10 << 20
+
+// resources are linked as ![title](:/0123456789abcdef0123456789abcdef)
+var jexResourceRef = regexp.MustCompile(`:/([0-9a-f]{32})`)
+
+type jexItem struct {
+	title string
+	body  string
+	props map[string]string
+}
+
+func (i jexItem) itemType() int {
+	t, _ := strconv.Atoi(i.props["type_"])
+	return t
+}
+
+// time prefers the user-facing timestamps, which are the ones Joplin shows
+// and lets users edit.
+func (i jexItem) time(key string) time.Time {
+	for _, name := range []string{"user_" + key, key} {
+		if t, err := time.Parse(time.RFC3339Nano, i.props[name]); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+type jexNoteRef struct {
+	id     string
+	source string
+	// spooled body, notes can be big
+	bodyPath string
+	item     jexItem
+}
+
+// JEXReader reads a Joplin export. Items come in no particular order and
+// notes need their folders, tags and resources, so the archive is read in
+// full before the first note is returned, spooling bodies and resources
+// into dir.
+type JEXReader struct {
+	dir       string
+	notes     []jexNoteRef
+	folders   map[string]jexItem
+	tags      map[string]string
+	noteTags  map[string][]string
+	resources map[string]jexItem
+	files     map[string]string
+	next      int
+}
+
+func NewJEXReader(r io.Reader, dir string) (*JEXReader, error) {
+	j := &JEXReader{
+		dir:       dir,
+		folders:   make(map[string]jexItem),
+		tags:      make(map[string]string),
+		noteTags:  make(map[string][]string),
+		resources: make(map[string]jexItem),
+		files:     make(map[string]string),
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if dir, file := path.Split(name); dir == "resources/" {
+			err = j.spoolResource(file, archive)
+		} else if dir == "" && strings.HasSuffix(file, ".md") {
+			err = j.readItem(file, archive)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(j.notes) == 0 {
+		return nil, errors.New("no notes found, is this a JEX file?")
+	}
+	sort.Slice(j.notes, func(a, b int) bool {
+		return j.notes[a].source < j.notes[b].source
+	})
+
+	return j, nil
+}
+
+func (j *JEXReader) spoolResource(filename string, r io.Reader) error {
+	file, err := os.CreateTemp(j.dir, "resource-*")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		return err
+	}
+
+	id := strings.TrimSuffix(filename, path.Ext(filename))
+	j.files[id] = file.Name()
+	return nil
+}
+
+func (j *JEXReader) readItem(filename string, r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxJEXItemSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxJEXItemSize {
+		return fmt.Errorf("%s is larger than %d MB", filename, maxJEXItemSize>>20)
+	}
+
+	item := parseJEXItem(string(data))
+	id := item.props["id"]
+	switch item.itemType() {
+	case jexNote:
+		file, err := os.CreateTemp(j.dir, "note-*")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.WriteString(item.body)
+		if err != nil {
+			return err
+		}
+		item.body = ""
+		j.notes = append(j.notes, jexNoteRef{id: id, source: filename, bodyPath: file.Name(), item: item})
+	case jexFolder:
+		j.folders[id] = item
+	case jexResource:
+		j.resources[id] = item
+	case jexTag:
+		j.tags[id] = item.title
+	case jexNoteTag:
+		j.noteTags[item.props["note_id"]] = append(j.noteTags[item.props["note_id"]], item.props["tag_id"])
+	}
+	return nil
+}
+
+// parseJEXItem splits an item into its title, body and the "key: value"
+// properties Joplin appends after the last blank line.
+func parseJEXItem(data string) jexItem {
+	item := jexItem{props: make(map[string]string)}
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+
+	end := len(lines)
+	for end > 0 {
+		line := lines[end-1]
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			key, ok = strings.CutSuffix(line, ":")
+		}
+		if !ok || strings.ContainsAny(key, " \t") || key == "" {
+			break
+		}
+		// property values have their newlines escaped
+		item.props[key] = strings.NewReplacer(`\n`, "\n", `\r`, "\r").Replace(value)
+		end--
+	}
+
+	lines = lines[:end]
+	if len(lines) > 0 {
+		item.title = lines[0]
+	}
+	// the body starts after the blank line below the title and ends before
+	// the blank line above the properties
+	if len(lines) > 2 {
+		item.body = strings.TrimSuffix(strings.Join(lines[2:], "\n"), "\n")
+	}
+	return item
+}
+
+func (j *JEXReader) Next() (*Note, error) {
+	if j.next >= len(j.notes) {
+		return nil, io.EOF
+	}
+	ref := j.notes[j.next]
+	j.next++
+
+	note, err := j.convert(ref)
+	if err != nil {
+		return nil, &NoteError{Source: ref.source, Err: err}
+	}
+	return note, nil
+}
+
+func (j *JEXReader) convert(ref jexNoteRef) (*Note, error) {
+	if ref.item.props["encryption_applied"] == "1" {
+		return nil, errors.New("note is encrypted, decrypt it in Joplin before exporting")
+	}
+
+	body, err := os.ReadFile(ref.bodyPath)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(ref.bodyPath)
+
+	content := string(body)
+	// markup_language 2 is HTML
+	if ref.item.props["markup_language"] == "2" {
+		content, err = ToMarkdown(strings.NewReader(content), nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't convert content: %w", err)
+		}
+	}
+
+	note := &Note{
+		Source:  ref.source,
+		Title:   strings.TrimSpace(ref.item.title),
+		Content: content,
+		Tags:    make([]string, 0),
+		Folder:  j.folderPath(ref.item.props["parent_id"]),
+		Created: ref.item.time("created_time"),
+		Updated: ref.item.time("updated_time"),
+	}
+	for _, tagId := range j.noteTags[ref.id] {
+		if tag, ok := j.tags[tagId]; ok {
+			note.Tags = append(note.Tags, tagName(tag))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, match := range jexResourceRef.FindAllStringSubmatch(content, -1) {
+		id := match[1]
+		file, ok := j.files[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		note.Resources = append(note.Resources, Resource{
+			Ref:      match[0],
+			Filename: j.resourceFilename(id),
+			Path:     file,
+		})
+	}
+
+	return note, nil
+}
+
+// folderPath walks up the notebook tree. Notebook names can contain
+// slashes, which would otherwise read as nesting.
+func (j *JEXReader) folderPath(id string) string {
+	segments := make([]string, 0)
+	seen := make(map[string]bool)
+	for id != "" && !seen[id] {
+		seen[id] = true
+		folder, ok := j.folders[id]
+		if !ok {
+			break
+		}
+		segments = append([]string{strings.ReplaceAll(strings.TrimSpace(folder.title), "/", "-")}, segments...)
+		id = folder.props["parent_id"]
+	}
+	return strings.Join(segments, "/")
+}
+
+func (j *JEXReader) resourceFilename(id string) string {
+	resource := j.resources[id]
+	if name := strings.TrimSpace(resource.props["filename"]); name != "" {
+		return name
+	}
+	name := strings.TrimSpace(resource.title)
+	if name == "" {
+		name = id
+	}
+	if ext := resource.props["file_extension"]; ext != "" && !strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(ext)) {
+		name += "." + ext
+	}
+	return name
+}
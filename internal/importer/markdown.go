@@ -0,0 +1,396 @@
+package importer
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MediaFunc renders an embedded file, e.g. ENML's <en-media>, given the
+// element's attributes. Returning "" leaves it out.
+type MediaFunc func(attrs map[string]string) string
+
+// node is a parsed element or, with an empty name, a run of text.
+type node struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+var blockElements = map[string]bool{
+	"p": true, "div": true, "en-note": true, "body": true, "html": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true,
+	"hr": true, "table": true, "section": true, "article": true,
+}
+
+// skipped with everything inside them
+var ignoredElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+}
+
+// ToMarkdown converts HTML, or ENML which is a subset of it, to Markdown.
+// It's forgiving about markup the way browsers are, since exported notes
+// are rarely valid XHTML.
+func ToMarkdown(r io.Reader, media MediaFunc) (string, error) {
+	root, err := parseHTML(r)
+	if err != nil {
+		return "", err
+	}
+
+	c := &converter{media: media}
+	return strings.Join(c.blocks(root), "\n\n") + "\n", nil
+}
+
+func parseHTML(r io.Reader) (*node, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &node{name: "root"}
+	stack := []*node{root}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &node{name: strings.ToLower(t.Name.Local), attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				element.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			parent.children = append(parent.children, element)
+			stack = append(stack, element)
+		case xml.EndElement:
+			// close up to the matching element, tolerating unclosed ones
+			name := strings.ToLower(t.Name.Local)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &node{text: string(t)})
+		}
+	}
+
+	return root, nil
+}
+
+type converter struct {
+	media MediaFunc
+}
+
+// blocks renders the children of n as Markdown blocks. Runs of inline
+// content between block elements become paragraphs.
+func (c *converter) blocks(n *node) []string {
+	blocks := make([]string, 0)
+	var inline strings.Builder
+
+	flush := func() {
+		paragraph := strings.TrimSpace(inline.String())
+		inline.Reset()
+		if paragraph == "" {
+			return
+		}
+		// Evernote puts its checkboxes at the start of a line
+		if strings.HasPrefix(paragraph, "[ ] ") || strings.HasPrefix(paragraph, "[x] ") {
+			paragraph = "- " + paragraph
+		}
+		blocks = append(blocks, paragraph)
+	}
+
+	for _, child := range n.children {
+		if ignoredElements[child.name] {
+			continue
+		}
+		if child.name == "" || !blockElements[child.name] {
+			inline.WriteString(c.inline(child))
+			continue
+		}
+
+		flush()
+		blocks = append(blocks, c.block(child)...)
+	}
+	flush()
+
+	return blocks
+}
+
+func (c *converter) block(n *node) []string {
+	switch n.name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.name[1:])
+		text := strings.TrimSpace(c.inlineChildren(n))
+		if text == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\n", " ")}
+	case "hr":
+		return []string{"---"}
+	case "pre":
+		code := strings.Trim(textContent(n), "\n")
+		fence := "```"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return []string{fence + "\n" + code + "\n" + fence}
+	case "blockquote":
+		inner := strings.Join(c.blocks(n), "\n\n")
+		if inner == "" {
+			return nil
+		}
+		return []string{prefixLines(inner, "> ", "> ")}
+	case "ul", "ol":
+		return c.list(n)
+	case "table":
+		return c.table(n)
+	default:
+		return c.blocks(n)
+	}
+}
+
+func (c *converter) list(n *node) []string {
+	items := make([]string, 0)
+	number := 1
+	if start, err := strconv.Atoi(n.attrs["start"]); err == nil {
+		number = start
+	}
+
+	for _, child := range n.children {
+		if child.name != "li" {
+			continue
+		}
+		marker := "- "
+		if n.name == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		// items are kept tight, nested lists included
+		content := strings.Join(c.blocks(child), "\n")
+		if strings.HasPrefix(content, "- [") && marker == "- " {
+			content = strings.TrimPrefix(content, "- ")
+		}
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return []string{strings.Join(items, "\n")}
+}
+
+func (c *converter) table(n *node) []string {
+	var rows [][]string
+	var collect func(n *node)
+	collect = func(n *node) {
+		for _, child := range n.children {
+			switch child.name {
+			case "tr":
+				row := make([]string, 0)
+				for _, cell := range child.children {
+					if cell.name == "td" || cell.name == "th" {
+						text := strings.TrimSpace(c.inlineChildren(cell))
+						text = strings.ReplaceAll(text, "\n", " ")
+						row = append(row, strings.ReplaceAll(text, "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		// GFM tables need a header, the first row stands in for it
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return []string{strings.Join(lines, "\n")}
+}
+
+func (c *converter) inlineChildren(n *node) string {
+	var b strings.Builder
+	for _, child := range n.children {
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+func (c *converter) inline(n *node) string {
+	if n.name == "" {
+		return escapeText(collapseSpace(n.text))
+	}
+	if ignoredElements[n.name] {
+		return ""
+	}
+
+	switch n.name {
+	case "br":
+		return "  \n"
+	case "b", "strong":
+		return wrapInline(c.inlineChildren(n), "**")
+	case "i", "em":
+		return wrapInline(c.inlineChildren(n), "*")
+	case "s", "strike", "del":
+		return wrapInline(c.inlineChildren(n), "~~")
+	case "code", "tt":
+		code := strings.ReplaceAll(textContent(n), "\n", " ")
+		if code == "" {
+			return ""
+		}
+		fence := "`"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return fence + code + fence
+	case "a":
+		text := strings.TrimSpace(c.inlineChildren(n))
+		href := n.attrs["href"]
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = escapeText(href)
+		}
+		return "[" + text + "](" + linkDestination(href) + ")"
+	case "img":
+		src := n.attrs["src"]
+		if src == "" {
+			return ""
+		}
+		return "![" + escapeText(n.attrs["alt"]) + "](" + linkDestination(src) + ")"
+	case "en-media":
+		if c.media == nil {
+			return ""
+		}
+		return c.media(n.attrs)
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			return "[x] "
+		}
+		return "[ ] "
+	case "en-crypt":
+		return "*(encrypted content not imported)*"
+	}
+
+	// blocks nested in inline elements are flattened to a line break
+	if blockElements[n.name] {
+		return strings.Join(c.blocks(n), "  \n")
+	}
+	return c.inlineChildren(n)
+}
+
+// wrapInline puts markers around text, keeping surrounding spaces outside
+// since "** bold**" isn't emphasis.
+func wrapInline(text string, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+	return start + marker + trimmed + marker + end
+}
+
+func textContent(n *node) string {
+	if n.name == "" {
+		return n.text
+	}
+	if n.name == "br" {
+		return "\n"
+	}
+	var b strings.Builder
+	for _, child := range n.children {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+// collapseSpace treats whitespace the way HTML does, non-breaking spaces
+// included since Evernote uses them for ordinary spacing.
+func collapseSpace(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+)
+
+func escapeText(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// linkDestination wraps destinations Markdown would otherwise cut short.
+func linkDestination(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(url) + ">"
+	}
+	return url
+}
+
+// prefixLines puts first before the first line and rest before the others.
+func prefixLines(text string, first string, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,64 @@
+// Package keyed has locks that are taken per key, like a note or blob id.
+package keyed
+
+import (
+	"slices"
+	"sync"
+)
+
+// Mutex is a mutex for each key, so work on one key doesn't wait for
+// work on the others. A key's mutex only exists while it's held or waited
+// for.
+type Mutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu sync.Mutex
+	// goroutines holding or waiting for mu
+	users int
+}
+
+// Lock locks key and returns the function that unlocks it.
+func (m *Mutex) Lock(key string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyLock)
+	}
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		m.locks[key] = lock
+	}
+	lock.users++
+	m.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		m.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// LockAll locks every one of keys and returns the function that unlocks
+// them. They're taken in order, so two callers locking some of the same
+// keys can't each hold one the other is waiting for.
+func (m *Mutex) LockAll(keys []string) func() {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+	unlocks := make([]func(), len(keys))
+	for i, key := range keys {
+		unlocks[i] = m.Lock(key)
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
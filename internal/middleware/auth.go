@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/vaporii/v8box/internal/config"
@@ -47,13 +48,51 @@ func Auth(next http.Handler) http.Handler {
 	})
 }
 
+// checkErr reports auth failures to ErrorHandler as 401s.
 func checkErr(err error, r *http.Request) bool {
 	if err != nil {
-		ctx := context.WithValue(r.Context(), httperror.ErrorKey, err)
-		*r = *r.WithContext(ctx)
 		logging.Warning("HTTP Auth error: %v", err)
 
+		var unauthorized *httperror.UnauthorizedError
+		if !errors.As(err, &unauthorized) {
+			err = &httperror.UnauthorizedError{Message: "Not logged in"}
+		}
+		if errorVal, ok := r.Context().Value(httperror.ErrorKey).(*error); ok {
+			*errorVal = err
+		}
+
 		return true
 	}
 	return false
 }
+
+// RevocationChecker tells whether a token issued at issuedAt to userId has
+// been revoked since.
+type RevocationChecker interface {
+	IsRevoked(userId string, issuedAt time.Time) bool
+}
+
+// RejectRevoked refuses tokens that are validly signed but were revoked, for
+// example because the account was deleted. It runs after Auth.
+func RejectRevoked(checker RevocationChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(UserAuthContextKey).(dto.UserJwtPackage)
+			if !ok {
+				checkErr(errors.New("no claims in context"), r)
+				return
+			}
+
+			var issuedAt time.Time
+			if claims.IssuedAt != nil {
+				issuedAt = claims.IssuedAt.Time
+			}
+			if checker.IsRevoked(claims.UserID, issuedAt) {
+				checkErr(&httperror.UnauthorizedError{Message: "Session has been revoked"}, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,7 +2,7 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
 	"net/http"
 
 	"github.com/vaporii/v8box/internal/httperror"
@@ -28,6 +28,14 @@ func ErrorHandler(next http.Handler) http.Handler {
 			httpError(w, t.Error(), 404)
 		case *httperror.BadClientRequestError:
 			httpError(w, t.Error(), 400)
+		case *httperror.UnauthorizedError:
+			httpError(w, t.Error(), 401)
+		case *httperror.ForbiddenError:
+			httpError(w, t.Error(), 403)
+		case *httperror.ConflictError:
+			httpError(w, t.Error(), 409)
+		case *httperror.TooManyRequestsError:
+			httpError(w, t.Error(), 429)
 		}
 	})
 }
@@ -35,5 +43,8 @@ func ErrorHandler(next http.Handler) http.Handler {
 func httpError(w http.ResponseWriter, errorMsg string, status int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
-	fmt.Fprintf(w, "{\"error\":\"%s\"}", errorMsg)
+	// messages can quote request input, so they have to be escaped
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{errorMsg})
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type Attachment struct {
+	ID        string    `json:"id"`
+	NoteID    string    `json:"note_id"`
+	UserID    string    `json:"user_id"`
+	Filename  string    `json:"filename"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// CalendarFeed is a user's iCalendar subscription. The token in its URL is
+// all it takes to read the feed, so only its hash is kept.
+type CalendarFeed struct {
+	UserID    string    `json:"-"`
+	TokenHash string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// only known right after the token is generated
+	URL string `json:"url,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// DailyNote is the journal entry for one day.
+type DailyNote struct {
+	// 2006-01-02
+	Date string `json:"date"`
+	Note *Note  `json:"note"`
+	// whether this request made the note
+	Created bool `json:"created"`
+	// the closest days before and after that have an entry
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+// DailyCalendar lists the days of a month that have an entry.
+type DailyCalendar struct {
+	// 2006-01
+	Month string          `json:"month"`
+	Days  []DailyEntryDay `json:"days"`
+}
+
+type DailyEntryDay struct {
+	Date   string `json:"date"`
+	NoteID string `json:"note_id"`
+}
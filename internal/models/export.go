@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type ExportStatus string
+
+const (
+	ExportStatusPending ExportStatus = "pending"
+	ExportStatusRunning ExportStatus = "running"
+	ExportStatusDone    ExportStatus = "done"
+	ExportStatusFailed  ExportStatus = "failed"
+)
+
+// Export is a background job packing up everything stored about a user.
+type Export struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"user_id"`
+	Status      ExportStatus `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	// filled in by the service once the export is ready
+	DownloadURL string `json:"download_url,omitempty"`
+}
@@ -1,12 +1,69 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/vaporii/v8box/internal/render"
+)
 
 type Note struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// empty for personal notes
+	WorkspaceID string   `json:"workspace_id,omitempty"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Tags        []string `json:"tags"`
+	// slash separated, e.g. "Projects/2024", empty for the top level
+	Folder string `json:"folder,omitempty"`
+	// starts at 1 and goes up with every edit
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// the requesting user's effective role, filled in by the service
+	Role NoteRole `json:"role,omitempty"`
+	// preview of the first image attachment mentioned in Content
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// typed metadata by name, filled in by the service
+	Properties map[string]Property `json:"properties,omitempty"`
+	// the requesting user's own state for the note, filled in by the
+	// service. Archived notes are left out of listings unless asked for.
+	Pinned    bool `json:"pinned"`
+	Archived  bool `json:"archived"`
+	Favourite bool `json:"favourite"`
+	// set for end-to-end encrypted notes, whose Content is ciphertext
+	Encryption *NoteEncryption `json:"encryption,omitempty"`
+}
+
+// Encrypted reports whether the note's content is ciphertext the server
+// can't read.
+func (n *Note) Encrypted() bool {
+	return n.Encryption != nil
+}
+
+// NoteEncryption is how an end-to-end encrypted note's content was
+// encrypted. The server only stores it for clients, it never has the key.
+type NoteEncryption struct {
+	Algorithm string `json:"algorithm"`
+	// which note key it was encrypted with
+	KeyID string `json:"key_id"`
+	Nonce string `json:"nonce"`
+	// the note key wrapped with the requesting user's public key, filled
+	// in by the service
+	WrappedKey string `json:"wrapped_key,omitempty"`
+}
+
+// NoteState is how one user has marked a note. Every user with access to a
+// note has their own.
+type NoteState struct {
+	NoteID    string `json:"note_id"`
+	Pinned    bool   `json:"pinned"`
+	Archived  bool   `json:"archived"`
+	Favourite bool   `json:"favourite"`
+}
+
+// RenderedNote is a note with its content rendered to sanitized HTML.
+type RenderedNote struct {
+	Note
+	render.Document
 }
@@ -0,0 +1,38 @@
+package models
+
+type NoteOp string
+
+const (
+	NoteOpCreate NoteOp = "create"
+	NoteOpUpdate NoteOp = "update"
+	NoteOpDelete NoteOp = "delete"
+	NoteOpMove   NoteOp = "move"
+)
+
+// NoteWrite is one checked change of a batch, ready to be written.
+type NoteWrite struct {
+	Op NoteOp
+	// the note to change or delete
+	ID string
+	// the note to create
+	Note *Note
+	// updates and moves leave nil fields alone
+	Title   *string
+	Content *string
+	Tags    []string
+	Folder  *string
+	// the nonce new ciphertext of an encrypted note was made with
+	Nonce *string
+	// replace all of the note's properties, unless nil
+	Properties map[string]Property
+	// if set, the update only goes through while the note is at this
+	// version
+	Version int
+}
+
+// NoteWriteResult is the note a write left behind, nil for deletes, or
+// why it couldn't be made.
+type NoteWriteResult struct {
+	Note *Note
+	Err  error
+}
@@ -0,0 +1,31 @@
+package models
+
+// NoteLink is a [[wiki link]] from one note to another. TargetID is empty
+// while the link is dangling, i.e. no note matches Target.
+type NoteLink struct {
+	SourceID string `json:"source_id"`
+	// note title or id, as written in the link
+	Target   string `json:"target"`
+	TargetID string `json:"target_id,omitempty"`
+}
+
+type GraphNode struct {
+	ID     string   `json:"id"`
+	Title  string   `json:"title"`
+	Folder string   `json:"folder,omitempty"`
+	Tags   []string `json:"tags"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// NoteGraph is how a set of notes link to each other. Links to notes
+// outside the set are left out, links to no note at all are listed as
+// dangling.
+type NoteGraph struct {
+	Nodes    []GraphNode `json:"nodes"`
+	Edges    []GraphEdge `json:"edges"`
+	Dangling []NoteLink  `json:"dangling"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type NoteRole string
+
+const (
+	NoteRoleViewer NoteRole = "viewer"
+	NoteRoleEditor NoteRole = "editor"
+	NoteRoleOwner  NoteRole = "owner"
+)
+
+// Allows reports whether r grants at least the access of required.
+func (r NoteRole) Allows(required NoteRole) bool {
+	return r.rank() >= required.rank()
+}
+
+func (r NoteRole) rank() int {
+	switch r {
+	case NoteRoleViewer:
+		return 1
+	case NoteRoleEditor:
+		return 2
+	case NoteRoleOwner:
+		return 3
+	}
+	return 0
+}
+
+type NoteShare struct {
+	NoteID    string    `json:"note_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      NoteRole  `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+type PropertyType string
+
+const (
+	PropertyString PropertyType = "string"
+	PropertyNumber PropertyType = "number"
+	// 2006-01-02, which sorts the same as text
+	PropertyDate PropertyType = "date"
+	PropertyBool PropertyType = "bool"
+	// a string from the options in the notebook's schema
+	PropertyEnum PropertyType = "enum"
+)
+
+func (t PropertyType) Valid() bool {
+	switch t {
+	case PropertyString, PropertyNumber, PropertyDate, PropertyBool, PropertyEnum:
+		return true
+	}
+	return false
+}
+
+// Property is a typed value on a note. Value is a float64 for numbers, a
+// bool for bools and a string otherwise.
+type Property struct {
+	Type  PropertyType `json:"type"`
+	Value any          `json:"value"`
+}
+
+// PropertyDefinition fixes the type of a property for all notes in a
+// notebook.
+type PropertyDefinition struct {
+	Name string       `json:"name"`
+	Type PropertyType `json:"type"`
+	// the values an enum can take
+	Options []string `json:"options,omitempty"`
+}
+
+// PropertySchema lists the properties of a notebook, which is a workspace or
+// a user's personal notes. Properties it doesn't mention can still be set
+// on notes, with whatever type they're given.
+type PropertySchema struct {
+	WorkspaceID string               `json:"workspace_id,omitempty"`
+	Properties  []PropertyDefinition `json:"properties"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Reminder notifies a user about a note, once or on a schedule.
+type Reminder struct {
+	ID     string `json:"id"`
+	NoteID string `json:"note_id"`
+	UserID string `json:"user_id"`
+	// when the reminder first goes off
+	At time.Time `json:"at"`
+	// iCalendar RRULE for reminders that repeat, e.g. FREQ=WEEKLY;BYDAY=MO
+	RRule string `json:"rrule,omitempty"`
+	// repeats keep At's time of day in this timezone
+	Timezone string `json:"timezone"`
+	Message  string `json:"message,omitempty"`
+	// nil once the reminder won't go off again
+	NextAt      *time.Time `json:"next_at"`
+	FiredCount  int        `json:"fired_count"`
+	LastFiredAt *time.Time `json:"last_fired_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type NotificationChannelType string
+
+const (
+	NotificationChannelWebhook NotificationChannelType = "webhook"
+	NotificationChannelEmail   NotificationChannelType = "email"
+	NotificationChannelNtfy    NotificationChannelType = "ntfy"
+)
+
+func (t NotificationChannelType) Valid() bool {
+	switch t {
+	case NotificationChannelWebhook, NotificationChannelEmail, NotificationChannelNtfy:
+		return true
+	}
+	return false
+}
+
+// NotificationChannel is a way a user wants to hear about their reminders.
+// Each user has at most one of each type.
+type NotificationChannel struct {
+	Type    NotificationChannelType `json:"type"`
+	Enabled bool                    `json:"enabled"`
+	// webhook URL, email address or ntfy topic URL
+	Target string `json:"target"`
+	// webhook signing secret or ntfy access token, never sent back
+	Secret    string `json:"-"`
+	HasSecret bool   `json:"has_secret"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending DeliveryStatus = "pending"
+	DeliveryStatusSent    DeliveryStatus = "sent"
+	DeliveryStatusFailed  DeliveryStatus = "failed"
+)
+
+// ReminderDelivery is one occurrence of a reminder going out on one
+// channel. Deliveries are recorded when the reminder fires and retried
+// until they go through, so a restart doesn't lose or repeat them.
+type ReminderDelivery struct {
+	ID         string                  `json:"id"`
+	ReminderID string                  `json:"reminder_id"`
+	UserID     string                  `json:"user_id"`
+	NoteID     string                  `json:"note_id"`
+	Channel    NotificationChannelType `json:"channel"`
+	// the occurrence this is for
+	ScheduledAt   time.Time      `json:"scheduled_at"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"-"`
+	Error         string         `json:"error,omitempty"`
+}
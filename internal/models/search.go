@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SavedSearch is a search query a user keeps to run again.
+type SavedSearch struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// in the search language, e.g. tag:work -archived
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
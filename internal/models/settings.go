@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// UserSettings are per-user preferences, stored as one JSON document.
+type UserSettings struct {
+	Theme       Theme      `json:"theme"`
+	DefaultSort NoteSort   `json:"default_sort"`
+	Timezone    string     `json:"timezone"`
+	EditorMode  EditorMode `json:"editor_mode"`
+	// daily notes are the personal notes in this folder titled with their
+	// date, new ones start from the template if there is one
+	DailyFolder     string `json:"daily_folder"`
+	DailyTemplateID string `json:"daily_template_id"`
+}
+
+func DefaultUserSettings() UserSettings {
+	return UserSettings{
+		Theme:       ThemeSystem,
+		DefaultSort: NoteSortUpdatedDesc,
+		Timezone:    "UTC",
+		EditorMode:  EditorModeMarkdown,
+		DailyFolder: "Daily",
+	}
+}
+
+// Location returns the user's timezone, falling back to UTC for names the
+// system doesn't know anymore.
+func (s UserSettings) Location() *time.Location {
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+type Theme string
+
+const (
+	ThemeSystem Theme = "system"
+	ThemeLight  Theme = "light"
+	ThemeDark   Theme = "dark"
+)
+
+func (t Theme) Valid() bool {
+	return t == ThemeSystem || t == ThemeLight || t == ThemeDark
+}
+
+type EditorMode string
+
+const (
+	EditorModeMarkdown EditorMode = "markdown"
+	EditorModeRich     EditorMode = "rich"
+	EditorModePlain    EditorMode = "plain"
+)
+
+func (m EditorMode) Valid() bool {
+	return m == EditorModeMarkdown || m == EditorModeRich || m == EditorModePlain
+}
+
+// NoteSort is the order note listings come back in.
+type NoteSort string
+
+const (
+	NoteSortUpdatedDesc NoteSort = "updated_desc"
+	NoteSortUpdatedAsc  NoteSort = "updated_asc"
+	NoteSortCreatedDesc NoteSort = "created_desc"
+	NoteSortCreatedAsc  NoteSort = "created_asc"
+	NoteSortTitleAsc    NoteSort = "title_asc"
+	NoteSortTitleDesc   NoteSort = "title_desc"
+)
+
+func (s NoteSort) Valid() bool {
+	switch s {
+	case NoteSortUpdatedDesc, NoteSortUpdatedAsc, NoteSortCreatedDesc, NoteSortCreatedAsc, NoteSortTitleAsc, NoteSortTitleDesc:
+		return true
+	}
+	return false
+}
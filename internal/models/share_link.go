@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type ShareLink struct {
+	ID           string     `json:"id"`
+	NoteID       string     `json:"note_id"`
+	UserID       string     `json:"user_id"`
+	TokenHash    string     `json:"-"`
+	PasswordHash string     `json:"-"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxViews     int        `json:"max_views,omitempty"`
+	ViewCount    int        `json:"view_count"`
+	Revoked      bool       `json:"revoked"`
+	CreatedAt    time.Time  `json:"created_at"`
+	// only known right after the link is created
+	URL string `json:"url,omitempty"`
+}
+
+type ShareLinkAccess struct {
+	ID         int64     `json:"id"`
+	LinkID     string    `json:"link_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+// Task is a task list item, "- [ ] something", in a note.
+type Task struct {
+	ID        string `json:"id"`
+	NoteID    string `json:"note_id"`
+	NoteTitle string `json:"note_title"`
+	// zero based index of the task's line in the note's content
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+	// 2006-01-02, from @due(...)
+	Due string `json:"due,omitempty"`
+	// high, medium or low, from @priority(...)
+	Priority string `json:"priority,omitempty"`
+}
+
+type TaskStatus string
+
+const (
+	TaskStatusOpen TaskStatus = "open"
+	TaskStatusDone TaskStatus = "done"
+	TaskStatusAll  TaskStatus = "all"
+)
+
+// TaskFilter narrows down a task listing. Empty fields match everything.
+type TaskFilter struct {
+	Status TaskStatus
+	// tasks due on or before this date, which leaves out ones without a
+	// due date
+	DueBefore string
+	Priority  string
+	NoteID    string
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Template is a starting point for new notes. Templates belong to a user, or
+// to a workspace when WorkspaceID is set.
+type Template struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Name        string `json:"name"`
+	// title and content of the notes made from it, with {{variables}}
+	Title     string             `json:"title"`
+	Content   string             `json:"content"`
+	Tags      []string           `json:"tags"`
+	Folder    string             `json:"folder"`
+	Variables []TemplateVariable `json:"variables"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// TemplateVariable is a value asked for when a note is made from a
+// template. Built in variables like {{date}} don't need declaring.
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	// used when an optional variable isn't given
+	Default string `json:"default,omitempty"`
+}
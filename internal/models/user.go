@@ -3,11 +3,28 @@ package models
 import "time"
 
 type User struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"-"`
-	OAuthKey  string    `json:"oauth_key,omitempty"`
-	AvatarURL string    `json:"avatar_url,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// shown instead of the username when set
+	DisplayName string `json:"display_name,omitempty"`
+	Password    string `json:"-"`
+	OAuthKey    string `json:"oauth_key,omitempty"`
+	// where the avatar is served from, filled in by the service
+	AvatarURL string `json:"avatar_url,omitempty"`
+	// where the stored avatar came from, e.g. GitHub's avatar_url
+	AvatarSourceURL string       `json:"-"`
+	AvatarSource    AvatarSource `json:"-"`
+	Settings        UserSettings `json:"settings"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
 }
+
+type AvatarSource string
+
+const (
+	// no stored avatar, an identicon is shown
+	AvatarSourceNone   AvatarSource = ""
+	AvatarSourceGitHub AvatarSource = "github"
+	// uploaded by the user, never replaced by their GitHub avatar
+	AvatarSourceUpload AvatarSource = "upload"
+)
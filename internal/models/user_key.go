@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserKey is the key pair a user's devices encrypt notes with. The private
+// key is wrapped with a key derived from the user's passphrase before it
+// leaves the device, so the server can hand it to their other devices
+// without being able to use it.
+type UserKey struct {
+	UserID string `json:"user_id"`
+	// of the key pair, e.g. x25519
+	Algorithm         string `json:"algorithm"`
+	PublicKey         string `json:"public_key"`
+	WrappedPrivateKey string `json:"wrapped_private_key"`
+	// how the wrapping key is derived from the passphrase, e.g. the KDF,
+	// its salt and cost. Only clients read it.
+	KDF       json.RawMessage `json:"kdf"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// PublicKey is what other users get to see of a UserKey, to wrap note keys
+// for its owner.
+type PublicKey struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleViewer WorkspaceRole = "viewer"
+	WorkspaceRoleEditor WorkspaceRole = "editor"
+	WorkspaceRoleAdmin  WorkspaceRole = "admin"
+	WorkspaceRoleOwner  WorkspaceRole = "owner"
+)
+
+func (r WorkspaceRole) Valid() bool {
+	return r.rank() > 0
+}
+
+// Allows reports whether r grants at least the access of required.
+func (r WorkspaceRole) Allows(required WorkspaceRole) bool {
+	return r.rank() >= required.rank()
+}
+
+// NoteRole is the access a workspace member gets to the workspace's notes.
+// Admins can manage notes like their owners.
+func (r WorkspaceRole) NoteRole() NoteRole {
+	switch r {
+	case WorkspaceRoleOwner, WorkspaceRoleAdmin:
+		return NoteRoleOwner
+	case WorkspaceRoleEditor:
+		return NoteRoleEditor
+	case WorkspaceRoleViewer:
+		return NoteRoleViewer
+	}
+	return ""
+}
+
+func (r WorkspaceRole) rank() int {
+	switch r {
+	case WorkspaceRoleViewer:
+		return 1
+	case WorkspaceRoleEditor:
+		return 2
+	case WorkspaceRoleAdmin:
+		return 3
+	case WorkspaceRoleOwner:
+		return 4
+	}
+	return 0
+}
+
+type Workspace struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	CreatedBy string            `json:"created_by"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Role      WorkspaceRole     `json:"role,omitempty"`
+	Members   []WorkspaceMember `json:"members,omitempty"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID string        `json:"workspace_id"`
+	UserID      string        `json:"user_id"`
+	Username    string        `json:"username"`
+	Role        WorkspaceRole `json:"role"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
@@ -0,0 +1,64 @@
+// Package notetemplate fills in note templates. The only thing a template
+// can do is insert a variable with {{name}}: there are no functions, no
+// conditionals and values are never expanded again, so templates written by
+// one user can't do anything surprising to another.
+package notetemplate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variables are referenced as {{name}}, spaces inside the braces are fine
+var variablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidName reports whether name can be used as a variable.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// MissingError lists the variables a template needed but didn't get.
+type MissingError struct {
+	Names []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("missing variables: %s", strings.Join(e.Names, ", "))
+}
+
+// Variables lists the variables source uses, sorted and without repeats.
+// Braces that don't surround a variable name are plain text.
+func Variables(source string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, match := range variablePattern.FindAllStringSubmatch(source, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute replaces every variable in source with its value. Values are
+// inserted as is, a value containing {{name}} stays that way.
+func Execute(source string, values map[string]string) (string, error) {
+	var missing []string
+	for _, name := range Variables(source) {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", &MissingError{Names: missing}
+	}
+
+	return variablePattern.ReplaceAllStringFunc(source, func(match string) string {
+		return values[variablePattern.FindStringSubmatch(match)[1]]
+	}), nil
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is carrier-grade NAT, RFC 6598, which isn't on the
+// public internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewClient makes the client webhooks and ntfy messages are sent with.
+// Their URLs are whatever users set, so unless allowPrivate is set it
+// refuses to connect to loopback, private, link-local (which includes cloud
+// metadata endpoints) and other non-public addresses. That's checked on the
+// address each connection is actually made to, after DNS and on every
+// redirect, so a hostname can't resolve to one of them.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: Timeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+	return &http.Client{
+		Timeout: Timeout,
+		Transport: &http.Transport{
+			// a proxy would be what's dialed, not the target
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        100,
+		},
+	}
+}
+
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%s is not a public address", addr)
+	}
+	return nil
+}
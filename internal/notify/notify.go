@@ -0,0 +1,51 @@
+// Package notify delivers messages to users outside the app. Every way of
+// reaching someone is a Notifier, so callers don't care whether a message
+// ends up as a webhook call, an email or a push notification.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Message is what gets delivered. URL, if set, is where it leads to.
+type Message struct {
+	// identifies the message to receivers that want to skip duplicates
+	ID    string    `json:"id"`
+	Title string    `json:"title"`
+	Body  string    `json:"body"`
+	URL   string    `json:"url,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Timeout is how long a notifier waits for the other end.
+const Timeout = 15 * time.Second
+
+// client is used by notifiers that aren't given one.
+var client = NewClient(false)
+
+func clientOr(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return client
+}
+
+// checkResponse turns non-2xx responses into errors quoting the start of
+// the body, which usually says what was wrong.
+func checkResponse(response *http.Response) error {
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	return fmt.Errorf("got %s: %s", response.Status, body)
+}
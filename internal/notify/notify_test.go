@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	ID:    "delivery-1",
+	Title: "Reminder: Überweisung",
+	Body:  "Pay rent",
+	URL:   "https://v8box.example/notes/1",
+	Time:  time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC),
+}
+
+// request is what a stand-in server was sent.
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// standIn answers with status and records what it gets.
+func standIn(t *testing.T, status int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost {
+			t.Errorf("got a %s request", r.Method)
+		}
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(status)
+		io.WriteString(w, "stand-in says "+http.StatusText(status))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhook(t *testing.T) {
+	server, requests := standIn(t, http.StatusNoContent)
+	webhook := &Webhook{URL: server.URL, Secret: "s3cret", Client: NewClient(true)}
+
+	err := webhook.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-requests
+	if contentType := got.header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type is %q", contentType)
+	}
+	var message Message
+	err = json.Unmarshal(got.body, &message)
+	if err != nil {
+		t.Fatalf("body isn't JSON: %v", err)
+	}
+	if message != testMessage {
+		t.Errorf("got %+v, want %+v", message, testMessage)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(got.body)
+	if signature := got.header.Get("X-V8box-Signature"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("wrong signature %q", signature)
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	webhook := &Webhook{URL: server.URL, Client: NewClient(true)}
+
+	err := webhook.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature := (<-requests).header.Get("X-V8box-Signature"); signature != "" {
+		t.Errorf("unsigned message has signature %q", signature)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	ntfy := &Ntfy{URL: server.URL + "/reminders", Token: "tk_123", Client: NewClient(true)}
+
+	err := ntfy.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-requests
+	if string(got.body) != testMessage.Body {
+		t.Errorf("body is %q", got.body)
+	}
+	title, err := new(mime.WordDecoder).DecodeHeader(got.header.Get("Title"))
+	if err != nil || title != testMessage.Title {
+		t.Errorf("Title header %q decodes to %q, %v", got.header.Get("Title"), title, err)
+	}
+	for _, r := range got.header.Get("Title") {
+		if r > 127 {
+			t.Errorf("Title header %q isn't ASCII", got.header.Get("Title"))
+			break
+		}
+	}
+	if click := got.header.Get("Click"); click != testMessage.URL {
+		t.Errorf("Click is %q", click)
+	}
+	if authorization := got.header.Get("Authorization"); authorization != "Bearer tk_123" {
+		t.Errorf("Authorization is %q", authorization)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError, http.StatusBadGateway} {
+		server, requests := standIn(t, status)
+		for _, notifier := range []Notifier{
+			&Webhook{URL: server.URL, Client: NewClient(true)},
+			&Ntfy{URL: server.URL, Client: NewClient(true)},
+		} {
+			err := notifier.Send(context.Background(), testMessage)
+			<-requests
+			if err == nil {
+				t.Fatalf("%T: no error for %d", notifier, status)
+			}
+			// the body says what was wrong
+			if !strings.Contains(err.Error(), "stand-in says") {
+				t.Errorf("%T: error %q doesn't quote the body", notifier, err)
+			}
+		}
+	}
+}
+
+func TestRefusesPrivateAddresses(t *testing.T) {
+	server, requests := standIn(t, http.StatusOK)
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	for _, url := range []string{
+		server.URL,
+		"http://localhost" + port,
+		"http://[::1]" + port,
+		"http://169.254.169.254" + port,
+		"http://10.0.0.1" + port,
+		"http://100.64.0.1" + port,
+	} {
+		for _, notifier := range []Notifier{
+			&Webhook{URL: url},
+			&Ntfy{URL: url, Client: NewClient(false)},
+		} {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err := notifier.Send(ctx, testMessage)
+			cancel()
+			if err == nil || !strings.Contains(err.Error(), "not a public address") {
+				t.Errorf("%T to %s: got %v", notifier, url, err)
+			}
+		}
+	}
+
+	select {
+	case <-requests:
+		t.Error("the stand-in was reached")
+	default:
+	}
+}
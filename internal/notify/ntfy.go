@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Ntfy publishes to a topic URL the way ntfy.sh and compatible servers
+// expect: the body is the message and everything else goes in headers.
+type Ntfy struct {
+	// e.g. https://ntfy.sh/my-reminders
+	URL string
+	// access token for protected topics
+	Token string
+	// see NewClient, nil is one that only connects to public addresses
+	Client *http.Client
+}
+
+func (n *Ntfy) Send(ctx context.Context, message Message) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(message.Body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", "v8box")
+	// headers have to be ASCII, ntfy decodes RFC 2047 encoded words
+	request.Header.Set("Title", mime.QEncoding.Encode("utf-8", message.Title))
+	request.Header.Set("Tags", "bell")
+	if message.URL != "" {
+		request.Header.Set("Click", message.URL)
+	}
+	if n.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	response, err := clientOr(n.Client).Do(request)
+	if err != nil {
+		return err
+	}
+	return checkResponse(response)
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP security modes
+const (
+	// upgrade with STARTTLS, and refuse to go on if the server can't
+	SMTPStartTLS = "starttls"
+	// TLS from the start, usually on port 465
+	SMTPTLS = "tls"
+	// plain text, only for local relays
+	SMTPNone = "none"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// starttls, tls or none
+	Security string
+}
+
+// Email sends messages as plain text mail to one address.
+type Email struct {
+	Config SMTPConfig
+	To     string
+}
+
+func (e *Email) Send(ctx context.Context, message Message) error {
+	from, err := mail.ParseAddress(e.Config.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(e.To)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	address := net.JoinHostPort(e.Config.Host, strconv.Itoa(e.Config.Port))
+	dialer := &net.Dialer{Timeout: Timeout}
+	var conn net.Conn
+	if e.Config.Security == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: e.Config.Host}}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, e.Config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if e.Config.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS", e.Config.Host)
+		}
+		err = client.StartTLS(&tls.Config{ServerName: e.Config.Host})
+		if err != nil {
+			return err
+		}
+	}
+	if e.Config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", e.Config.Username, e.Config.Password, e.Config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(compose(from, to, message))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func compose(from *mail.Address, to *mail.Address, message Message) []byte {
+	body := message.Body
+	if message.URL != "" {
+		body += "\n\n" + message.URL
+	}
+	// bare newlines aren't allowed in mail
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+
+	var b strings.Builder
+	b.WriteString("From: " + from.String() + "\r\n")
+	b.WriteString("To: " + to.String() + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Title) + "\r\n")
+	b.WriteString("Date: " + message.Time.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Message-ID: " + messageID(from) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("Auto-Submitted: auto-generated\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body + "\r\n")
+	return []byte(b.String())
+}
+
+func messageID(from *mail.Address) string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	domain := "v8box"
+	if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+		domain = from.Address[at+1:]
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">"
+}
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// smtpStandIn is a mail server that accepts one message and keeps the DATA
+// exactly as it came over the wire, before undoing dot-stuffing.
+type smtpStandIn struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     []string
+	done     chan struct{}
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) config() SMTPConfig {
+	address := s.listener.Addr().(*net.TCPAddr)
+	return SMTPConfig{
+		Host:     address.IP.String(),
+		Port:     address.Port,
+		From:     "v8box <reminders@v8box.example>",
+		Security: SMTPNone,
+	}
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 stand-in ready")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 stand-in")
+		case "MAIL":
+			s.from = argument
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, argument)
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			for {
+				line, err := text.ReadLine()
+				if err != nil {
+					return
+				}
+				if line == "." {
+					break
+				}
+				s.data = append(s.data, line)
+			}
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	server := newSMTPStandIn(t)
+	email := &Email{Config: server.config(), To: "Ann <ann@example.com>"}
+	message := testMessage
+	message.Body = "Line one\n.hidden if not stuffed\n..two dots\r\nlast"
+
+	err := email.Send(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "FROM:<reminders@v8box.example>" {
+		t.Errorf("MAIL %s", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "TO:<ann@example.com>" {
+		t.Errorf("RCPT %v", server.to)
+	}
+
+	// lines starting with a dot are sent with another one in front
+	wire := strings.Join(server.data, "\n")
+	for _, want := range []string{"\n..hidden if not stuffed\n", "\n...two dots\n"} {
+		if !strings.Contains(wire, want) {
+			t.Errorf("%q isn't dot-stuffed in\n%s", strings.TrimSpace(want), wire)
+		}
+	}
+
+	unstuffed := make([]string, len(server.data))
+	for i, line := range server.data {
+		unstuffed[i] = strings.TrimPrefix(line, ".")
+	}
+	parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(strings.Join(unstuffed, "\r\n") + "\r\n")))
+	if err != nil {
+		t.Fatalf("reading the message: %v", err)
+	}
+
+	subject := parsed.Header.Get("Subject")
+	if !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Errorf("subject %q isn't Q-encoded", subject)
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+	if err != nil || decoded != message.Title {
+		t.Errorf("subject decodes to %q, %v", decoded, err)
+	}
+	if to := parsed.Header.Get("To"); to != `"Ann" <ann@example.com>` {
+		t.Errorf("To is %q", to)
+	}
+	if date, err := parsed.Header.Date(); err != nil || !date.Equal(message.Time) {
+		t.Errorf("Date is %v, %v", date, err)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@v8box.example>") {
+		t.Errorf("Message-ID is %q", id)
+	}
+
+	body, _ := io.ReadAll(parsed.Body)
+	want := "Line one\r\n.hidden if not stuffed\r\n..two dots\r\nlast\r\n\r\n" + message.URL + "\r\n"
+	if string(body) != want {
+		t.Errorf("body is %q, want %q", body, want)
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	server := newSMTPStandIn(t)
+	config := server.config()
+	config.Security = SMTPStartTLS
+	email := &Email{Config: config, To: "ann@example.com"}
+
+	err := email.Send(context.Background(), testMessage)
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("got %v", err)
+	}
+	<-server.done
+	if server.from != "" {
+		t.Error("sent without STARTTLS")
+	}
+}
+
+func TestEmailInvalidAddress(t *testing.T) {
+	email := &Email{Config: SMTPConfig{Host: "127.0.0.1", Port: 1, From: "v8box@example.com"}, To: "not an address"}
+	err := email.Send(context.Background(), testMessage)
+	if err == nil || !strings.Contains(err.Error(), "invalid address") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// Webhook POSTs messages as JSON. With a secret, the body is signed with
+// HMAC-SHA256 in the X-V8box-Signature header as "sha256=<hex>", so
+// receivers can check it came from us.
+type Webhook struct {
+	URL    string
+	Secret string
+	// see NewClient, nil is one that only connects to public addresses
+	Client *http.Client
+}
+
+func (w *Webhook) Send(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "v8box")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		request.Header.Set("X-V8box-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := clientOr(w.Client).Do(request)
+	if err != nil {
+		return err
+	}
+	return checkResponse(response)
+}
@@ -0,0 +1,88 @@
+package render
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps recently rendered documents in memory, dropping the least
+// recently used once the total size goes over the limit. Entries are keyed
+// by note and version, so an edit never serves stale HTML and the old
+// version simply ages out.
+type Cache struct {
+	mu      sync.Mutex
+	limit   int
+	size    int
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	id      string
+	version int
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	document *Document
+	size     int
+}
+
+// NewCache holds up to limit bytes of rendered HTML. A limit of 0 turns
+// caching off.
+func NewCache(limit int) *Cache {
+	return &Cache{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// Markdown renders source, or returns the document rendered earlier for the
+// same id and version.
+func (c *Cache) Markdown(id string, version int, source string) (*Document, error) {
+	key := cacheKey{id: id, version: version}
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cacheEntry).document, nil
+	}
+	c.mu.Unlock()
+
+	document, err := Markdown(source)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, document)
+	return document, nil
+}
+
+func (c *Cache) add(key cacheKey, document *Document) {
+	size := len(document.HTML)
+	for _, heading := range document.TOC {
+		size += len(heading.Text) + len(heading.ID)
+	}
+	if size > c.limit {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// rendered twice at the same time
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, document: document, size: size})
+	c.size += size
+
+	for c.size > c.limit {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
@@ -0,0 +1,174 @@
+// Package render turns note content into HTML clients can show as is.
+// Output is sanitized against an allowlist, so raw HTML in notes can't run
+// scripts in the reader's browser.
+package render
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// Heading is an entry in a document's table of contents.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	// id of the heading element, link to it with "#" + ID
+	ID string `json:"id"`
+}
+
+// Document is rendered note content.
+type Document struct {
+	HTML string    `json:"html"`
+	TOC  []Heading `json:"toc"`
+}
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.TaskList,
+		extension.Linkify,
+	),
+	// raw HTML is passed through here and dealt with by the sanitizer, so
+	// harmless markup like <kbd> or <details> still works
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+var policy = newPolicy()
+
+// headingID matches the ids slug generates.
+var headingID = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements(
+		"p", "br", "hr", "blockquote", "pre", "code", "kbd", "samp", "var",
+		"em", "i", "strong", "b", "del", "s", "ins", "mark", "sub", "sup", "small",
+		"ul", "li", "dl", "dt", "dd", "details", "summary",
+		"table", "thead", "tbody", "tfoot", "tr",
+	)
+	p.AllowAttrs("id").Matching(headingID).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowElements("ol")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowElements("th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("open").Matching(regexp.MustCompile(`^$`)).OnElements("details")
+
+	// task list items, goldmark renders them disabled
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+
+	p.AllowAttrs("href", "title").OnElements("a")
+	p.AllowAttrs("src", "alt", "title").OnElements("img")
+	p.AllowAttrs("width", "height").Matching(bluemonday.Integer).OnElements("img")
+	// attachments are linked by path, so relative URLs have to be allowed
+	p.AllowRelativeURLs(true)
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnFullyQualifiedLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return p
+}
+
+// Markdown renders CommonMark with the GitHub extensions: tables, task
+// lists, strikethrough and autolinks. Headings get ids for linking, and are
+// listed in the document's table of contents.
+func Markdown(source string) (*Document, error) {
+	src := []byte(source)
+	root := markdown.Parser().Parse(text.NewReader(src), parser.WithContext(parser.NewContext()))
+
+	toc := make([]Heading, 0)
+	used := make(map[string]bool)
+	err := ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		title := strings.TrimSpace(plainText(heading, src))
+		id := uniqueID(used, slug(title))
+		heading.SetAttributeString("id", []byte(id))
+		toc = append(toc, Heading{Level: heading.Level, Text: title, ID: id})
+		return ast.WalkSkipChildren, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = markdown.Renderer().Render(&buf, src, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{
+		HTML: policy.Sanitize(buf.String()),
+		TOC:  toc,
+	}, nil
+}
+
+// plainText is the text of n without any markup.
+func plainText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := n.(type) {
+		case *ast.Text:
+			b.Write(t.Segment.Value(source))
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(t.Value)
+		case *ast.RawHTML:
+			// tags aren't text
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
+
+// slug makes an id the way GitHub does: lowercase, spaces become hyphens and
+// punctuation is dropped.
+func slug(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsNumber(r), r == '_', r == '-':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteByte('-')
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// uniqueID numbers repeated ids, "notes", "notes-1", "notes-2".
+func uniqueID(used map[string]bool, id string) string {
+	unique := id
+	for n := 1; used[unique]; n++ {
+		unique = id + "-" + strconv.Itoa(n)
+	}
+	used[unique] = true
+	return unique
+}
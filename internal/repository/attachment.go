@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type AttachmentRepository interface {
+	BlobExists(sha256 string) (bool, error)
+	CreateAttachment(attachment *models.Attachment) (*models.Attachment, error)
+	GetAttachment(id string) (*models.Attachment, error)
+	GetNoteAttachments(noteID string) ([]models.Attachment, error)
+	// GetAttachmentsOfType returns the attachments of all the given notes
+	// that have one of mimeTypes.
+	GetAttachmentsOfType(noteIDs []string, mimeTypes []string) ([]models.Attachment, error)
+	DeleteAttachment(id string) error
+	// DeleteOrphanedAttachments removes attachments whose note is gone.
+	DeleteOrphanedAttachments() (int64, error)
+	GetUnreferencedBlobs() ([]string, error)
+	// DeleteBlob only removes the blob row if nothing references it.
+	DeleteBlob(sha256 string) (bool, error)
+}
+
+type attachmentRepository struct {
+	db *sql.DB
+}
+
+func NewAttachmentRepository(db *sql.DB) (AttachmentRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting attachment tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS attachments;
+			DROP TABLE IF EXISTS blobs;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS blobs (
+			sha256			VARCHAR(64) PRIMARY KEY,
+			size			INTEGER NOT NULL,
+			mime_type		VARCHAR(255) NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS attachments (
+			id				VARCHAR(255) PRIMARY KEY,
+			note_id			VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			blob_sha256		VARCHAR(64) NOT NULL,
+			filename		VARCHAR(255) NOT NULL,
+			mime_type		VARCHAR(255) NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(note_id) REFERENCES notes(id),
+			FOREIGN KEY(user_id) REFERENCES users(id),
+			FOREIGN KEY(blob_sha256) REFERENCES blobs(sha256)
+		);
+
+		CREATE INDEX IF NOT EXISTS attachments_note_id ON attachments(note_id);
+		CREATE INDEX IF NOT EXISTS attachments_blob_sha256 ON attachments(blob_sha256);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attachmentRepository{
+		db: db,
+	}, nil
+}
+
+const attachmentColumns = `a.id, a.note_id, a.user_id, a.filename, a.mime_type, b.size, a.blob_sha256, a.created_at`
+
+func scanAttachment(row interface{ Scan(dest ...any) error }) (*models.Attachment, error) {
+	attachment := &models.Attachment{}
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.NoteID,
+		&attachment.UserID,
+		&attachment.Filename,
+		&attachment.MimeType,
+		&attachment.Size,
+		&attachment.SHA256,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (r *attachmentRepository) BlobExists(sha256 string) (bool, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM blobs WHERE sha256=?", sha256).Scan(&count)
+	return count > 0, err
+}
+
+func (r *attachmentRepository) CreateAttachment(attachment *models.Attachment) (*models.Attachment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO blobs (
+			sha256, size, mime_type
+		) VALUES (?, ?, ?)
+		ON CONFLICT(sha256) DO NOTHING;
+	`, attachment.SHA256, attachment.Size, attachment.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO attachments (
+			id, note_id, user_id, blob_sha256, filename, mime_type
+		) VALUES (?, ?, ?, ?, ?, ?)
+	`, attachment.ID, attachment.NoteID, attachment.UserID, attachment.SHA256, attachment.Filename, attachment.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanAttachment(tx.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		JOIN blobs b ON b.sha256 = a.blob_sha256
+		WHERE a.id=?
+	`, attachment.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return created, tx.Commit()
+}
+
+func (r *attachmentRepository) GetAttachment(id string) (*models.Attachment, error) {
+	return scanAttachment(r.db.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		JOIN blobs b ON b.sha256 = a.blob_sha256
+		WHERE a.id=?
+	`, id))
+}
+
+func (r *attachmentRepository) GetNoteAttachments(noteID string) ([]models.Attachment, error) {
+	rows, err := r.db.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		JOIN blobs b ON b.sha256 = a.blob_sha256
+		WHERE a.note_id=?
+		ORDER BY a.created_at
+	`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]models.Attachment, 0)
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	if err = rows.Err(); err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+func (r *attachmentRepository) GetAttachmentsOfType(noteIDs []string, mimeTypes []string) ([]models.Attachment, error) {
+	attachments := make([]models.Attachment, 0)
+	if len(mimeTypes) == 0 {
+		return attachments, nil
+	}
+
+	for _, batch := range idBatches(noteIDs) {
+		args := batch
+		for _, mimeType := range mimeTypes {
+			args = append(args, mimeType)
+		}
+
+		rows, err := r.db.Query(`
+			SELECT `+attachmentColumns+`
+			FROM attachments a
+			JOIN blobs b ON b.sha256 = a.blob_sha256
+			WHERE a.note_id IN (`+placeholders(len(batch))+`)
+			AND a.mime_type IN (`+placeholders(len(mimeTypes))+`)
+			ORDER BY a.created_at
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			attachment, err := scanAttachment(rows)
+			if err != nil {
+				rows.Close()
+				return attachments, err
+			}
+			attachments = append(attachments, *attachment)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return attachments, err
+		}
+	}
+	return attachments, nil
+}
+
+func (r *attachmentRepository) DeleteAttachment(id string) error {
+	_, err := r.db.Exec("DELETE FROM attachments WHERE id=?", id)
+	return err
+}
+
+func (r *attachmentRepository) DeleteOrphanedAttachments() (int64, error) {
+	res, err := r.db.Exec("DELETE FROM attachments WHERE note_id NOT IN (SELECT id FROM notes)")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *attachmentRepository) GetUnreferencedBlobs() ([]string, error) {
+	rows, err := r.db.Query(`
+		SELECT sha256 FROM blobs
+		WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE blob_sha256 = blobs.sha256)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := make([]string, 0)
+
+	for rows.Next() {
+		var sha256 string
+		if err := rows.Scan(&sha256); err != nil {
+			return blobs, err
+		}
+		blobs = append(blobs, sha256)
+	}
+	if err = rows.Err(); err != nil {
+		return blobs, err
+	}
+	return blobs, nil
+}
+
+func (r *attachmentRepository) DeleteBlob(sha256 string) (bool, error) {
+	res, err := r.db.Exec(`
+		DELETE FROM blobs
+		WHERE sha256=? AND NOT EXISTS (SELECT 1 FROM attachments WHERE blob_sha256 = blobs.sha256)
+	`, sha256)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type CalendarRepository interface {
+	GetFeed(userID string) (*models.CalendarFeed, error)
+	GetFeedByTokenHash(tokenHash string) (*models.CalendarFeed, error)
+	// SetFeed creates the user's feed or replaces its token.
+	SetFeed(userID string, tokenHash string) (*models.CalendarFeed, error)
+	DeleteFeed(userID string) error
+}
+
+type calendarRepository struct {
+	db *sql.DB
+}
+
+func NewCalendarRepository(db *sql.DB) (CalendarRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting calendar_feeds table")
+		db.Exec(`
+			DROP TABLE IF EXISTS calendar_feeds;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS calendar_feeds (
+			user_id		VARCHAR(255) PRIMARY KEY,
+			token_hash	VARCHAR(64) NOT NULL UNIQUE,
+			created_at	TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &calendarRepository{
+		db: db,
+	}, nil
+}
+
+const calendarFeedColumns = `user_id, token_hash, created_at`
+
+func scanCalendarFeed(row *sql.Row) (*models.CalendarFeed, error) {
+	feed := &models.CalendarFeed{}
+	err := row.Scan(&feed.UserID, &feed.TokenHash, &feed.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (r *calendarRepository) GetFeed(userID string) (*models.CalendarFeed, error) {
+	return scanCalendarFeed(r.db.QueryRow("SELECT "+calendarFeedColumns+" FROM calendar_feeds WHERE user_id=?", userID))
+}
+
+func (r *calendarRepository) GetFeedByTokenHash(tokenHash string) (*models.CalendarFeed, error) {
+	return scanCalendarFeed(r.db.QueryRow("SELECT "+calendarFeedColumns+" FROM calendar_feeds WHERE token_hash=?", tokenHash))
+}
+
+func (r *calendarRepository) SetFeed(userID string, tokenHash string) (*models.CalendarFeed, error) {
+	return scanCalendarFeed(r.db.QueryRow(`
+		INSERT INTO calendar_feeds (user_id, token_hash) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token_hash=excluded.token_hash, created_at=CURRENT_TIMESTAMP
+		RETURNING `+calendarFeedColumns,
+		userID, tokenHash,
+	))
+}
+
+func (r *calendarRepository) DeleteFeed(userID string) error {
+	_, err := r.db.Exec("DELETE FROM calendar_feeds WHERE user_id=?", userID)
+	return err
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenDB opens the SQLite database at path. Writers wait for each other
+// rather than failing, and transactions take the write lock when they begin
+// so two of them can't both read and then be unable to write. That lets
+// commands like keys rotate work on the database while the server runs.
+// pragmas are set on each connection as well, written like
+// secure_delete(on).
+func OpenDB(path string, pragmas ...string) (*sql.DB, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	params := "_pragma=busy_timeout(5000)&_txlock=immediate"
+	for _, pragma := range pragmas {
+		params += "&_pragma=" + pragma
+	}
+	return sql.Open("sqlite", path+separator+params)
+}
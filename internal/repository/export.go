@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type ExportRepository interface {
+	CreateExport(export *models.Export) (*models.Export, error)
+	GetExport(id string) (*models.Export, error)
+	GetLatestExport(userID string) (*models.Export, error)
+	// ClaimPendingExport marks the oldest pending export as running and
+	// returns it, or sql.ErrNoRows if there is nothing to do.
+	ClaimPendingExport() (*models.Export, error)
+	CompleteExport(id string, size int64, expiresAt time.Time) error
+	FailExport(id string, message string) error
+	// ResetRunningExports puts exports interrupted by a restart back in the
+	// queue.
+	ResetRunningExports() (int64, error)
+	GetExpiredExports(now time.Time) ([]models.Export, error)
+	GetUserExports(userID string) ([]models.Export, error)
+	DeleteExport(id string) error
+}
+
+type exportRepository struct {
+	db *sql.DB
+}
+
+func NewExportRepository(db *sql.DB) (ExportRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting exports table")
+		db.Exec(`
+			DROP TABLE IF EXISTS exports;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS exports (
+			id				VARCHAR(255) PRIMARY KEY,
+			user_id			VARCHAR(255) NOT NULL,
+			status			VARCHAR(16) NOT NULL,
+			error			TEXT,
+			size			INTEGER,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at	TIMESTAMP,
+			expires_at		TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS exports_user_id ON exports(user_id);
+		CREATE INDEX IF NOT EXISTS exports_status ON exports(status);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &exportRepository{
+		db: db,
+	}, nil
+}
+
+const exportColumns = `id, user_id, status, COALESCE(error, ''), COALESCE(size, 0), created_at, completed_at, expires_at`
+
+func scanExport(row interface{ Scan(dest ...any) error }) (*models.Export, error) {
+	export := &models.Export{}
+	var completedAt, expiresAt sql.NullTime
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Error, &export.Size, &export.CreatedAt, &completedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export, nil
+}
+
+func scanExports(rows *sql.Rows) ([]models.Export, error) {
+	defer rows.Close()
+
+	exports := make([]models.Export, 0)
+
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return exports, err
+		}
+		exports = append(exports, *export)
+	}
+	if err := rows.Err(); err != nil {
+		return exports, err
+	}
+	return exports, nil
+}
+
+func (r *exportRepository) CreateExport(export *models.Export) (*models.Export, error) {
+	return scanExport(r.db.QueryRow(`
+		INSERT INTO exports (
+			id, user_id, status
+		) VALUES (?, ?, ?) RETURNING `+exportColumns,
+		export.ID, export.UserID, models.ExportStatusPending,
+	))
+}
+
+func (r *exportRepository) GetExport(id string) (*models.Export, error) {
+	return scanExport(r.db.QueryRow("SELECT "+exportColumns+" FROM exports WHERE id=?", id))
+}
+
+func (r *exportRepository) GetLatestExport(userID string) (*models.Export, error) {
+	return scanExport(r.db.QueryRow(`
+		SELECT `+exportColumns+` FROM exports
+		WHERE user_id=?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT 1
+	`, userID))
+}
+
+func (r *exportRepository) ClaimPendingExport() (*models.Export, error) {
+	return scanExport(r.db.QueryRow(`
+		UPDATE exports SET status=?
+		WHERE id = (
+			SELECT id FROM exports WHERE status=? ORDER BY created_at, rowid LIMIT 1
+		)
+		RETURNING `+exportColumns,
+		models.ExportStatusRunning, models.ExportStatusPending,
+	))
+}
+
+func (r *exportRepository) CompleteExport(id string, size int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE exports
+		SET status=?, size=?, completed_at=CURRENT_TIMESTAMP, expires_at=?
+		WHERE id=?
+	`, models.ExportStatusDone, size, expiresAt.UTC(), id)
+	return err
+}
+
+func (r *exportRepository) FailExport(id string, message string) error {
+	_, err := r.db.Exec(`
+		UPDATE exports
+		SET status=?, error=?, completed_at=CURRENT_TIMESTAMP
+		WHERE id=?
+	`, models.ExportStatusFailed, message, id)
+	return err
+}
+
+func (r *exportRepository) ResetRunningExports() (int64, error) {
+	res, err := r.db.Exec("UPDATE exports SET status=? WHERE status=?", models.ExportStatusPending, models.ExportStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *exportRepository) GetExpiredExports(now time.Time) ([]models.Export, error) {
+	rows, err := r.db.Query(`
+		SELECT `+exportColumns+` FROM exports
+		WHERE (expires_at IS NOT NULL AND expires_at < ?)
+		OR (status=? AND completed_at < ?)
+	`, now.UTC(), models.ExportStatusFailed, now.UTC().Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanExports(rows)
+}
+
+func (r *exportRepository) GetUserExports(userID string) ([]models.Export, error) {
+	rows, err := r.db.Query("SELECT "+exportColumns+" FROM exports WHERE user_id=?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanExports(rows)
+}
+
+func (r *exportRepository) DeleteExport(id string) error {
+	_, err := r.db.Exec("DELETE FROM exports WHERE id=?", id)
+	return err
+}
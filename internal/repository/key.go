@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+// KeyRepository stores the keys of end-to-end encrypted notes. Everything
+// in it is wrapped by clients, the server can't unwrap any of it.
+type KeyRepository interface {
+	GetUserKey(userID string) (*models.UserKey, error)
+	// SetUserKey creates or replaces the user's key pair.
+	SetUserKey(key *models.UserKey) (*models.UserKey, error)
+	// GetNoteKeys returns the user's wrapped keys of each of the notes that
+	// has one, by note id.
+	GetNoteKeys(userID string, noteIDs []string) (map[string]string, error)
+	// CountNoteKeys counts the notes keys have been wrapped for the user,
+	// which only the user's current key pair can unwrap.
+	CountNoteKeys(userID string) (int, error)
+}
+
+type keyRepository struct {
+	db *sql.DB
+}
+
+func NewKeyRepository(db *sql.DB) (KeyRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting user_keys and note_keys tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS user_keys;
+			DROP TABLE IF EXISTS note_keys;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_keys (
+			user_id				VARCHAR(255) PRIMARY KEY,
+			algorithm			VARCHAR(64) NOT NULL,
+			public_key			TEXT NOT NULL,
+			wrapped_private_key	TEXT NOT NULL,
+			kdf					TEXT NOT NULL,
+			created_at			TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at			TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		-- one row for everyone who can read an encrypted note, the owner
+		-- included
+		CREATE TABLE IF NOT EXISTS note_keys (
+			note_id		VARCHAR(255) NOT NULL,
+			user_id		VARCHAR(255) NOT NULL,
+			wrapped_key	TEXT NOT NULL,
+			PRIMARY KEY(note_id, user_id),
+			FOREIGN KEY(note_id) REFERENCES notes(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS note_keys_user_id ON note_keys(user_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyRepository{
+		db: db,
+	}, nil
+}
+
+const userKeyColumns = `user_id, algorithm, public_key, wrapped_private_key, kdf, created_at, updated_at`
+
+func scanUserKey(row interface{ Scan(dest ...any) error }) (*models.UserKey, error) {
+	key := &models.UserKey{}
+	var kdf string
+	err := row.Scan(&key.UserID, &key.Algorithm, &key.PublicKey, &key.WrappedPrivateKey, &kdf, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.KDF = []byte(kdf)
+	return key, nil
+}
+
+func (r *keyRepository) GetUserKey(userID string) (*models.UserKey, error) {
+	return scanUserKey(r.db.QueryRow("SELECT "+userKeyColumns+" FROM user_keys WHERE user_id=?", userID))
+}
+
+func (r *keyRepository) SetUserKey(key *models.UserKey) (*models.UserKey, error) {
+	return scanUserKey(r.db.QueryRow(`
+		INSERT INTO user_keys (user_id, algorithm, public_key, wrapped_private_key, kdf)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			algorithm=excluded.algorithm,
+			public_key=excluded.public_key,
+			wrapped_private_key=excluded.wrapped_private_key,
+			kdf=excluded.kdf,
+			updated_at=CURRENT_TIMESTAMP
+		RETURNING `+userKeyColumns,
+		key.UserID, key.Algorithm, key.PublicKey, key.WrappedPrivateKey, string(key.KDF),
+	))
+}
+
+func (r *keyRepository) GetNoteKeys(userID string, noteIDs []string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, batch := range idBatches(noteIDs) {
+		rows, err := r.db.Query(`
+			SELECT note_id, wrapped_key FROM note_keys
+			WHERE user_id=? AND note_id IN (`+placeholders(len(batch))+`)
+		`, append([]any{userID}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var noteID, wrappedKey string
+			err := rows.Scan(&noteID, &wrappedKey)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			keys[noteID] = wrappedKey
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// setNoteKey is for the note and share repositories, which write keys in
+// the same transaction as the note or share they're for.
+func setNoteKey(q rowQuerier, noteID string, userID string, wrappedKey string) error {
+	_, err := q.Exec(`
+		INSERT INTO note_keys (note_id, user_id, wrapped_key) VALUES (?, ?, ?)
+		ON CONFLICT(note_id, user_id) DO UPDATE SET wrapped_key=excluded.wrapped_key
+	`, noteID, userID, wrappedKey)
+	return err
+}
+
+func (r *keyRepository) CountNoteKeys(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM note_keys WHERE user_id=?", userID).Scan(&count)
+	return count, err
+}
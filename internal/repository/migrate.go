@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/vaporii/v8box/internal/logging"
+)
+
+// addColumnIfMissing lets tables created by older versions pick up new
+// columns, since CREATE TABLE IF NOT EXISTS leaves existing tables alone.
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) error {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	logging.Info("adding column %s to %s", column, table)
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// placeholders returns "?, ?, ..." with n parameters for IN lists.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// maxInList keeps IN lists of ids well under SQLite's limit on the number of
+// parameters in one statement.
+const maxInList = 500
+
+// idBatches splits ids into lists short enough to look up with IN.
+func idBatches(ids []string) [][]any {
+	var batches [][]any
+	for start := 0; start < len(ids); start += maxInList {
+		end := min(start+maxInList, len(ids))
+		batch := make([]any, 0, end-start)
+		for _, id := range ids[start:end] {
+			batch = append(batch, id)
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
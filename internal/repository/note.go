@@ -2,7 +2,15 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/vaporii/v8box/internal/atrest"
 	"github.com/vaporii/v8box/internal/config"
 	"github.com/vaporii/v8box/internal/dto"
 	"github.com/vaporii/v8box/internal/httperror"
@@ -15,15 +23,42 @@ import (
 type NoteRepository interface {
 	CreateNote(note *models.Note) (*models.Note, error)
 	GetNoteByID(id string) (*models.Note, error)
-	GetUserNotes(userId string) ([]models.Note, error)
-	UpdateNote(id string, request dto.CreateNoteRequest) (*models.Note, error)
+	// the listings only include notes matching every property filter
+	GetUserNotes(userId string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error)
+	GetWorkspaceNotes(workspaceId string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error)
+	// GetAuthoredNotes lists every note userId owns, personal or in a
+	// workspace.
+	GetAuthoredNotes(userId string) ([]models.Note, error)
+	// GetDatedNotes lists the ids of userId's personal notes in folder that
+	// are titled like a date, by title and then oldest first, without
+	// reading their content.
+	GetDatedNotes(userId string, folder string) ([]models.DailyEntryDay, error)
+	// UpdateNote replaces the note's properties with properties unless
+	// they're nil.
+	UpdateNote(id string, request dto.CreateNoteRequest, properties map[string]models.Property) (*models.Note, error)
+	// DeleteNote removes the note with everything that hangs off it in
+	// one transaction.
+	DeleteNote(id string) error
+	// WriteNotes makes writes in order in one transaction. A write whose
+	// note is gone fails with sql.ErrNoRows, and one with a version the
+	// note isn't at with ErrVersionChanged: in an atomic batch that stops
+	// it and nothing is kept, otherwise the others go ahead. The error is
+	// only set when the whole batch failed.
+	WriteNotes(writes []models.NoteWrite, atomic bool) ([]models.NoteWriteResult, error)
 }
 
+// ErrVersionChanged is returned when a write made from a copy of a note
+// read earlier finds the note has changed since.
+var ErrVersionChanged = errors.New("note has changed since it was read")
+
 type noteRepository struct {
-	db *sql.DB
+	db   *sql.DB
+	keys *atrest.Keyring
 }
 
-func NewNoteRepository(db *sql.DB) (NoteRepository, error) {
+// NewNoteRepository encrypts note content at rest with keys, and fails if
+// notes were encrypted with master keys that are no longer in it.
+func NewNoteRepository(db *sql.DB, keys *atrest.Keyring) (NoteRepository, error) {
 	if config.LoadConfig().Environment == "dev" {
 		logging.Info("dev environment, deleting notes table")
 		db.Exec(`
@@ -34,18 +69,32 @@ func NewNoteRepository(db *sql.DB) (NoteRepository, error) {
 		CREATE TABLE IF NOT EXISTS notes (
 			id				VARCHAR(255) PRIMARY KEY,
 			user_id			VARCHAR(255) NOT NULL,
+			workspace_id	VARCHAR(255),
 			title			VARCHAR(255) NOT NULL,
 			content			TEXT,
+			tags			TEXT NOT NULL DEFAULT '[]',
+			folder			VARCHAR(1024) NOT NULL DEFAULT '',
+			version			INTEGER NOT NULL DEFAULT 1,
+			enc_algorithm	VARCHAR(64) NOT NULL DEFAULT '',
+			enc_key_id		VARCHAR(255) NOT NULL DEFAULT '',
+			enc_nonce		VARCHAR(255) NOT NULL DEFAULT '',
+			content_key_id	VARCHAR(64) NOT NULL DEFAULT '',
+			content_key		TEXT NOT NULL DEFAULT '',
 			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(user_id) REFERENCES users(id)
+			FOREIGN KEY(user_id) REFERENCES users(id),
+			FOREIGN KEY(workspace_id) REFERENCES workspaces(id)
 		);
 
 		DROP TRIGGER IF EXISTS update_notes_updated_at;
 		
+		-- content encrypted again with another key without a new version is
+		-- a key rotation, which doesn't change the note
 		CREATE TRIGGER update_notes_updated_at
 		AFTER UPDATE ON notes
 		FOR EACH ROW
+		WHEN NEW.updated_at IS OLD.updated_at
+			AND (NEW.version IS NOT OLD.version OR NEW.content_key IS OLD.content_key)
 		BEGIN
 			UPDATE notes SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 		END;
@@ -54,37 +103,295 @@ func NewNoteRepository(db *sql.DB) (NoteRepository, error) {
 		return nil, err
 	}
 
+	err = addColumnIfMissing(db, "notes", "workspace_id", "VARCHAR(255) REFERENCES workspaces(id)")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "notes", "tags", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "notes", "folder", "VARCHAR(1024) NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "notes", "version", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return nil, err
+	}
+	// encrypted notes have an algorithm, plain ones leave all three empty
+	for _, column := range []string{"enc_algorithm", "enc_key_id", "enc_nonce"} {
+		err = addColumnIfMissing(db, "notes", column, "VARCHAR(255) NOT NULL DEFAULT ''")
+		if err != nil {
+			return nil, err
+		}
+	}
+	// content is in plaintext when the key id is empty, otherwise content_key
+	// is its data key wrapped with that master key
+	err = addColumnIfMissing(db, "notes", "content_key_id", "VARCHAR(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "notes", "content_key", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS notes_user_id ON notes(user_id);
+		CREATE INDEX IF NOT EXISTS notes_workspace_id ON notes(workspace_id);
+		CREATE INDEX IF NOT EXISTS notes_content_key_id ON notes(content_key_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMasterKeys(db, keys, "notes", "content_key_id")
+	if err != nil {
+		return nil, err
+	}
+
 	return &noteRepository{
-		db: db,
+		db:   db,
+		keys: keys,
 	}, nil
 }
 
+// checkMasterKeys makes sure everything in table that column names the
+// master key of can be decrypted, rather than finding out one request at a
+// time.
+func checkMasterKeys(db *sql.DB, keys *atrest.Keyring, table string, column string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s != ''", column, table, column))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var keyID string
+		err := rows.Scan(&keyID)
+		if err != nil {
+			return err
+		}
+		if !keys.Has(keyID) {
+			return fmt.Errorf("%s are encrypted with master key %q, which isn't configured", table, keyID)
+		}
+	}
+	return rows.Err()
+}
+
+// noteColumns is qualified with the table name so it also works in joins.
+const noteColumns = `notes.id, notes.user_id, COALESCE(notes.workspace_id, ''), notes.title, notes.content, notes.tags, notes.folder, notes.version, notes.created_at, notes.updated_at, notes.enc_algorithm, notes.enc_key_id, notes.enc_nonce, notes.content_key_id, notes.content_key`
+
+// scanNote decrypts the note's content with keys.
+func scanNote(keys *atrest.Keyring, row interface{ Scan(dest ...any) error }, extra ...any) (*models.Note, error) {
+	note := &models.Note{}
+	var tags string
+	var encryption models.NoteEncryption
+	var content atrest.Sealed
+	dest := append([]any{&note.ID, &note.UserID, &note.WorkspaceID, &note.Title, &content.Value, &tags, &note.Folder, &note.Version, &note.CreatedAt, &note.UpdatedAt,
+		&encryption.Algorithm, &encryption.KeyID, &encryption.Nonce, &content.KeyID, &content.DataKey}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	var err error
+	note.Content, err = keys.Open(note.ID, content)
+	if err != nil {
+		return nil, fmt.Errorf("note %s: %w", note.ID, err)
+	}
+	if encryption.Algorithm != "" {
+		note.Encryption = &encryption
+	}
+	if err := json.Unmarshal([]byte(tags), &note.Tags); err != nil {
+		return nil, err
+	}
+	if note.Tags == nil {
+		note.Tags = make([]string, 0)
+	}
+	return note, nil
+}
+
+func encodeTags(tags []string) (string, error) {
+	if tags == nil {
+		tags = make([]string, 0)
+	}
+	encoded, err := json.Marshal(tags)
+	return string(encoded), err
+}
+
+// timestampOrNow lets CURRENT_TIMESTAMP fill in times that weren't given.
+func timestampOrNow(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// noteOrder turns a sort into an ORDER BY clause. Unknown sorts fall back to
+// the most recently updated first.
+func noteOrder(sort models.NoteSort) string {
+	switch sort {
+	case models.NoteSortUpdatedAsc:
+		return "notes.updated_at ASC, notes.id"
+	case models.NoteSortCreatedDesc:
+		return "notes.created_at DESC, notes.id"
+	case models.NoteSortCreatedAsc:
+		return "notes.created_at ASC, notes.id"
+	case models.NoteSortTitleAsc:
+		return "notes.title COLLATE NOCASE ASC, notes.id"
+	case models.NoteSortTitleDesc:
+		return "notes.title COLLATE NOCASE DESC, notes.id"
+	default:
+		return "notes.updated_at DESC, notes.id"
+	}
+}
+
+// propertyComparisons maps filter operators to SQL. Only these strings ever
+// reach the query, names and values are always parameters.
+var propertyComparisons = map[string]string{
+	"=":  "=",
+	"!=": "=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// propertyConditions turns filters into " AND ..." conditions on notes. Each
+// one is a lookup in the note_properties indexes rather than a scan of the
+// notes. Values that look like numbers compare numerically with number
+// properties and as text with the rest, != also matches notes without the
+// property.
+func propertyConditions(filters []dto.PropertyFilter) (string, []any) {
+	var conditions strings.Builder
+	args := make([]any, 0, len(filters)*3)
+	for _, filter := range filters {
+		if filter.Op == "" {
+			conditions.WriteString(" AND notes.id IN (SELECT note_id FROM note_properties WHERE name=?)")
+			args = append(args, filter.Name)
+			continue
+		}
+		comparison, ok := propertyComparisons[filter.Op]
+		if !ok {
+			continue
+		}
+
+		in := "IN"
+		if filter.Op == "!=" {
+			in = "NOT IN"
+		}
+		number, err := strconv.ParseFloat(filter.Value, 64)
+		if err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) {
+			fmt.Fprintf(&conditions, " AND notes.id %s (SELECT note_id FROM note_properties WHERE name=? AND (value_num %s ? OR (value_num IS NULL AND value_text %s ?)))", in, comparison, comparison)
+			args = append(args, filter.Name, number, filter.Value)
+		} else {
+			fmt.Fprintf(&conditions, " AND notes.id %s (SELECT note_id FROM note_properties WHERE name=? AND value_text %s ?)", in, comparison)
+			args = append(args, filter.Name, filter.Value)
+		}
+	}
+	return conditions.String(), args
+}
+
+func scanNotes(keys *atrest.Keyring, rows *sql.Rows) ([]models.Note, error) {
+	defer rows.Close()
+
+	var notes []models.Note = make([]models.Note, 0)
+
+	for rows.Next() {
+		note, err := scanNote(keys, rows)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, *note)
+	}
+	if err := rows.Err(); err != nil {
+		return notes, err
+	}
+	return notes, nil
+}
+
+// rowQuerier is a *sql.DB or a *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// CreateNote keeps the note's timestamps if they're set, so imported notes
+// don't all look like they were written today.
 func (r *noteRepository) CreateNote(note *models.Note) (*models.Note, error) {
-	var retNote models.Note
-	err := r.db.QueryRow(`
-		INSERT INTO notes (
-			id, user_id, title, content
-		) VALUES (?, ?, ?, ?) RETURNING
-			id, user_id, title, content, created_at, updated_at;
-	`, note.ID, note.UserID, note.Title, note.Content).Scan(&retNote.ID, &retNote.UserID, &retNote.Title, &retNote.Content, &retNote.CreatedAt, &retNote.UpdatedAt)
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	return &retNote, nil
+	created, err := createNote(tx, r.keys, note)
+	if err != nil {
+		return nil, err
+	}
+	return created, tx.Commit()
 }
 
-func (r *noteRepository) GetNoteByID(id string) (*models.Note, error) {
-	note := &models.Note{}
-	err := r.db.QueryRow("SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE id=?", id).Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt)
+// createNote also adds the note to the search index, which can't be left to
+// triggers since they'd only see encrypted content. The note's properties
+// are stored with it, and an end-to-end encrypted note with a wrapped key
+// gets it stored for its owner.
+func createNote(q rowQuerier, keys *atrest.Keyring, note *models.Note) (*models.Note, error) {
+	tags, err := encodeTags(note.Tags)
+	if err != nil {
+		return nil, err
+	}
+	content, err := keys.Seal(note.ID, note.Content)
 	if err != nil {
 		return nil, err
 	}
 
-	return note, nil
+	var encryption models.NoteEncryption
+	if note.Encryption != nil {
+		encryption = *note.Encryption
+	}
+
+	row := q.QueryRow(`
+		INSERT INTO notes (
+			id, user_id, workspace_id, title, content, tags, folder, created_at, updated_at,
+			enc_algorithm, enc_key_id, enc_nonce, content_key_id, content_key
+		) VALUES (
+			?, ?, NULLIF(?, ''), ?, ?, ?, ?,
+			COALESCE(?, CURRENT_TIMESTAMP), COALESCE(?, CURRENT_TIMESTAMP),
+			?, ?, ?, ?, ?
+		) RETURNING `+noteColumns,
+		note.ID, note.UserID, note.WorkspaceID, note.Title, content.Value, tags, note.Folder,
+		timestampOrNow(note.CreatedAt), timestampOrNow(note.UpdatedAt),
+		encryption.Algorithm, encryption.KeyID, encryption.Nonce, content.KeyID, content.DataKey,
+	)
+
+	created, err := scanNote(keys, row)
+	if err != nil {
+		return nil, err
+	}
+	if len(note.Properties) > 0 {
+		err = setNoteProperties(q, created.ID, note.Properties)
+		if err != nil {
+			return nil, err
+		}
+		created.Properties = note.Properties
+	}
+	if encryption.WrappedKey != "" {
+		err = setNoteKey(q, created.ID, created.UserID, encryption.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return created, indexNote(q, keys, created)
 }
 
-func (r *noteRepository) GetUserNotes(userId string) ([]models.Note, error) {
+func (r *noteRepository) GetNoteByID(id string) (*models.Note, error) {
+	return scanNote(r.keys, r.db.QueryRow("SELECT "+noteColumns+" FROM notes WHERE id=?", id))
+}
+
+// GetUserNotes lists the user's personal notes, leaving out any they wrote in
+// workspaces.
+func (r *noteRepository) GetUserNotes(userId string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error) {
 	var userCount int
 	err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE id=?", userId).Scan(&userCount)
 	if err != nil {
@@ -95,40 +402,234 @@ func (r *noteRepository) GetUserNotes(userId string) ([]models.Note, error) {
 		return nil, &httperror.NotFoundError{Entity: "User"}
 	}
 
-	rows, err := r.db.Query("SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE user_id=?", userId)
+	conditions, args := propertyConditions(filters)
+	rows, err := r.db.Query("SELECT "+noteColumns+" FROM notes WHERE user_id=? AND workspace_id IS NULL"+conditions+" ORDER BY "+noteOrder(sort), append([]any{userId}, args...)...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var notes []models.Note = make([]models.Note, 0)
+	return scanNotes(r.keys, rows)
+}
 
+func (r *noteRepository) GetWorkspaceNotes(workspaceId string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error) {
+	conditions, args := propertyConditions(filters)
+	rows, err := r.db.Query("SELECT "+noteColumns+" FROM notes WHERE workspace_id=?"+conditions+" ORDER BY "+noteOrder(sort), append([]any{workspaceId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNotes(r.keys, rows)
+}
+
+func (r *noteRepository) GetAuthoredNotes(userId string) ([]models.Note, error) {
+	rows, err := r.db.Query("SELECT "+noteColumns+" FROM notes WHERE user_id=? ORDER BY notes.created_at, notes.id", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNotes(r.keys, rows)
+}
+
+func (r *noteRepository) GetDatedNotes(userId string, folder string) ([]models.DailyEntryDay, error) {
+	rows, err := r.db.Query(`
+		SELECT title, id FROM notes
+		WHERE user_id=? AND workspace_id IS NULL AND folder=?
+		AND title GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]'
+		ORDER BY title, created_at, id
+	`, userId, folder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]models.DailyEntryDay, 0)
 	for rows.Next() {
-		var note models.Note
-		if err := rows.Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt); err != nil {
-			return notes, err
+		var day models.DailyEntryDay
+		if err := rows.Scan(&day.Date, &day.NoteID); err != nil {
+			return days, err
 		}
-		notes = append(notes, note)
+		days = append(days, day)
 	}
 	if err = rows.Err(); err != nil {
-		return notes, err
+		return days, err
 	}
-	return notes, nil
+	return days, nil
 }
 
-func (r *noteRepository) UpdateNote(id string, request dto.CreateNoteRequest) (*models.Note, error) {
-	var note models.Note
+// UpdateNote only changes tags and folder if the request has them. The
+// update time is set to now unless the request carries one, and the version
+// goes up by one. A request with a version fails with ErrVersionChanged if
+// the note isn't at it anymore.
+func (r *noteRepository) UpdateNote(id string, request dto.CreateNoteRequest, properties map[string]models.Property) (*models.Note, error) {
+	write := models.NoteWrite{
+		ID:         id,
+		Title:      &request.Title,
+		Content:    &request.Content,
+		Tags:       request.Tags,
+		Folder:     request.Folder,
+		Properties: properties,
+		Version:    request.Version,
+	}
+	if request.Encryption != nil {
+		write.Nonce = &request.Encryption.Nonce
+	}
 
-	err := r.db.QueryRow(`
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	note, err := updateNote(tx, r.keys, write, request.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return note, tx.Commit()
+}
+
+// updateNote changes the fields of write that are set, properties
+// included, and reindexes the note when its text changed.
+func updateNote(q rowQuerier, keys *atrest.Keyring, write models.NoteWrite, updatedAt time.Time) (*models.Note, error) {
+	if write.Version != 0 {
+		// writes take the lock when their transaction begins, so the
+		// version can't change between here and the update
+		var version int
+		err := q.QueryRow("SELECT version FROM notes WHERE id=?", write.ID).Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		if version != write.Version {
+			return nil, ErrVersionChanged
+		}
+	}
+
+	var tags any
+	if write.Tags != nil {
+		encoded, err := encodeTags(write.Tags)
+		if err != nil {
+			return nil, err
+		}
+		tags = encoded
+	}
+	// new content gets a new data key
+	var content, contentKeyID, contentKey any
+	if write.Content != nil {
+		sealed, err := keys.Seal(write.ID, *write.Content)
+		if err != nil {
+			return nil, err
+		}
+		content, contentKeyID, contentKey = sealed.Value, sealed.KeyID, sealed.DataKey
+	}
+
+	row := q.QueryRow(`
 		UPDATE notes
-		SET title=?,
-			content=?
+		SET title=COALESCE(?, title),
+			content=COALESCE(?, content),
+			content_key_id=COALESCE(?, content_key_id),
+			content_key=COALESCE(?, content_key),
+			tags=COALESCE(?, tags),
+			folder=COALESCE(?, folder),
+			enc_nonce=COALESCE(?, enc_nonce),
+			updated_at=COALESCE(?, updated_at),
+			version=version+1
 		WHERE id=?
-		RETURNING
-			id, user_id, title, content, created_at, updated_at;
-	`, request.Title, request.Content, id).Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt)
+		RETURNING `+noteColumns,
+		write.Title, content, contentKeyID, contentKey, tags, write.Folder, write.Nonce, timestampOrNow(updatedAt), write.ID,
+	)
+
+	note, err := scanNote(keys, row)
 	if err != nil {
 		return nil, err
 	}
-	return &note, nil
+	if write.Properties != nil {
+		err = setNoteProperties(q, note.ID, write.Properties)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if write.Title == nil && write.Content == nil {
+		return note, nil
+	}
+	return note, indexNote(q, keys, note)
+}
+
+func (r *noteRepository) DeleteNote(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteNotes(tx, "id=?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *noteRepository) WriteNotes(writes []models.NoteWrite, atomic bool) ([]models.NoteWriteResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]models.NoteWriteResult, 0, len(writes))
+	for _, write := range writes {
+		var result models.NoteWriteResult
+		switch write.Op {
+		case models.NoteOpCreate:
+			result.Note, result.Err = createNote(tx, r.keys, write.Note)
+		case models.NoteOpUpdate, models.NoteOpMove:
+			result.Note, result.Err = updateNote(tx, r.keys, write, time.Time{})
+		case models.NoteOpDelete:
+			var count int
+			result.Err = tx.QueryRow("SELECT COUNT(*) FROM notes WHERE id=?", write.ID).Scan(&count)
+			if result.Err == nil && count == 0 {
+				result.Err = sql.ErrNoRows
+			}
+			if result.Err == nil {
+				result.Err = deleteNotes(tx, "id=?", write.ID)
+			}
+		}
+		if result.Err != nil && !errors.Is(result.Err, sql.ErrNoRows) && !errors.Is(result.Err, ErrVersionChanged) {
+			return nil, result.Err
+		}
+		results = append(results, result)
+		if result.Err != nil && atomic {
+			return results, nil
+		}
+	}
+
+	return results, tx.Commit()
+}
+
+// deleteNotes removes the notes matching where together with everything
+// that hangs off them. Attachment blobs are left for the garbage collector.
+func deleteNotes(tx *sql.Tx, where string, args ...any) error {
+	selected := "SELECT id FROM notes WHERE " + where
+
+	statements := []string{
+		"DELETE FROM share_link_accesses WHERE link_id IN (SELECT id FROM share_links WHERE note_id IN (" + selected + "))",
+		"DELETE FROM share_link_attempts WHERE link_id IN (SELECT id FROM share_links WHERE note_id IN (" + selected + "))",
+		"DELETE FROM share_links WHERE note_id IN (" + selected + ")",
+		"DELETE FROM note_shares WHERE note_id IN (" + selected + ")",
+		"DELETE FROM attachments WHERE note_id IN (" + selected + ")",
+		"DELETE FROM tasks WHERE note_id IN (" + selected + ")",
+		"DELETE FROM note_states WHERE note_id IN (" + selected + ")",
+		"DELETE FROM note_properties WHERE note_id IN (" + selected + ")",
+		"DELETE FROM note_keys WHERE note_id IN (" + selected + ")",
+		"DELETE FROM reminder_deliveries WHERE note_id IN (" + selected + ")",
+		"DELETE FROM reminders WHERE note_id IN (" + selected + ")",
+		"DELETE FROM note_links WHERE source_id IN (" + selected + ")",
+		"UPDATE note_links SET target_id=NULL WHERE target_id IN (" + selected + ")",
+		"DELETE FROM notes WHERE " + where,
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(statement, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
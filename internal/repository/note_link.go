@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type NoteLinkRepository interface {
+	// SetLinks replaces every link going out of sourceID.
+	SetLinks(sourceID string, links []models.NoteLink) error
+	GetLinks(sourceIDs []string) ([]models.NoteLink, error)
+	GetBacklinks(targetID string) ([]models.NoteLink, error)
+	// FindTitle returns the id of the oldest note titled title, ignoring
+	// case, next to a note in workspaceID or, without one, among userID's
+	// personal notes. It's empty if there's no such note.
+	FindTitle(workspaceID string, userID string, title string) (string, error)
+	// ResolveDangling points dangling links to note's title from notes next
+	// to it at note.
+	ResolveDangling(note *models.Note) error
+}
+
+type noteLinkRepository struct {
+	db   *sql.DB
+	keys *atrest.Keyring
+}
+
+// NewNoteLinkRepository encrypts link targets at rest with keys, and fails
+// if they were encrypted with master keys that are no longer in it.
+func NewNoteLinkRepository(db *sql.DB, keys *atrest.Keyring) (NoteLinkRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting note_links table")
+		db.Exec(`
+			DROP TABLE IF EXISTS note_links;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS note_links (
+			source_id		VARCHAR(255) NOT NULL,
+			target			VARCHAR(1024) NOT NULL COLLATE NOCASE,
+			target_id		VARCHAR(255),
+			target_text		TEXT NOT NULL DEFAULT '',
+			target_key_id	VARCHAR(64) NOT NULL DEFAULT '',
+			target_key		TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(source_id, target),
+			FOREIGN KEY(source_id) REFERENCES notes(id),
+			FOREIGN KEY(target_id) REFERENCES notes(id)
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// target is what links are looked up by, the lowercased target or a
+	// hash of it when encrypted at rest. target_text is the target as it's
+	// written, in plaintext when the key id is empty.
+	err = addColumnIfMissing(db, "note_links", "target_text", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range []string{"target_key_id", "target_key"} {
+		err = addColumnIfMissing(db, "note_links", column, "TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE note_links SET target_text = target WHERE target_text = '' AND target_key_id = '';
+
+		CREATE INDEX IF NOT EXISTS note_links_target_id ON note_links(target_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMasterKeys(db, keys, "note_links", "target_key_id")
+	if err != nil {
+		return nil, err
+	}
+
+	return &noteLinkRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+// insertLink stores the link with its target encrypted, keyed by a hash of
+// the target.
+func insertLink(tx *sql.Tx, keys *atrest.Keyring, link models.NoteLink) error {
+	target, err := keys.Seal(link.SourceID, link.Target)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO note_links (source_id, target, target_id, target_text, target_key_id, target_key)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?)
+		ON CONFLICT(source_id, target) DO NOTHING
+	`, link.SourceID, keys.Blind(strings.ToLower(link.Target)), link.TargetID, target.Value, target.KeyID, target.DataKey)
+	return err
+}
+
+// scope is the WHERE clause matching the notes a title link can point to:
+// the workspace's notes, or a user's personal ones.
+func scope(workspaceID string, userID string) (string, []any) {
+	if workspaceID != "" {
+		return "workspace_id=?", []any{workspaceID}
+	}
+	return "user_id=? AND workspace_id IS NULL", []any{userID}
+}
+
+func (r *noteLinkRepository) SetLinks(sourceID string, links []models.NoteLink) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM note_links WHERE source_id=?", sourceID)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		link.SourceID = sourceID
+		err = insertLink(tx, r.keys, link)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const noteLinkColumns = `l.source_id, COALESCE(l.target_id, ''), l.target_text, l.target_key_id, l.target_key`
+
+// scanNoteLinks decrypts the targets of the links with keys.
+func scanNoteLinks(keys *atrest.Keyring, rows *sql.Rows) ([]models.NoteLink, error) {
+	defer rows.Close()
+
+	links := make([]models.NoteLink, 0)
+	for rows.Next() {
+		var link models.NoteLink
+		var target atrest.Sealed
+		if err := rows.Scan(&link.SourceID, &link.TargetID, &target.Value, &target.KeyID, &target.DataKey); err != nil {
+			return links, err
+		}
+		var err error
+		link.Target, err = keys.Open(link.SourceID, target)
+		if err != nil {
+			return links, fmt.Errorf("link of note %s: %w", link.SourceID, err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return links, err
+	}
+	return links, nil
+}
+
+func (r *noteLinkRepository) GetLinks(sourceIDs []string) ([]models.NoteLink, error) {
+	if len(sourceIDs) == 0 {
+		return make([]models.NoteLink, 0), nil
+	}
+
+	args := make([]any, len(sourceIDs))
+	for i, id := range sourceIDs {
+		args[i] = id
+	}
+	rows, err := r.db.Query(`
+		SELECT `+noteLinkColumns+`
+		FROM note_links l
+		WHERE l.source_id IN (`+placeholders(len(sourceIDs))+`)
+		ORDER BY l.source_id, l.target
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNoteLinks(r.keys, rows)
+}
+
+func (r *noteLinkRepository) GetBacklinks(targetID string) ([]models.NoteLink, error) {
+	rows, err := r.db.Query(`
+		SELECT `+noteLinkColumns+`
+		FROM note_links l
+		JOIN notes ON notes.id = l.source_id
+		WHERE l.target_id=?
+		ORDER BY notes.updated_at DESC, notes.id
+	`, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanNoteLinks(r.keys, rows)
+}
+
+func (r *noteLinkRepository) FindTitle(workspaceID string, userID string, title string) (string, error) {
+	where, args := scope(workspaceID, userID)
+
+	var id string
+	err := r.db.QueryRow(`
+		SELECT id FROM notes
+		WHERE title=? COLLATE NOCASE AND `+where+`
+		ORDER BY created_at, id
+		LIMIT 1
+	`, append([]any{title}, args...)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+func (r *noteLinkRepository) ResolveDangling(note *models.Note) error {
+	where, scopeArgs := scope(note.WorkspaceID, note.UserID)
+	targets := r.keys.Blinds(strings.ToLower(note.Title))
+	args := []any{note.ID}
+	for _, target := range targets {
+		args = append(args, target)
+	}
+
+	_, err := r.db.Exec(`
+		UPDATE note_links SET target_id=?
+		WHERE target_id IS NULL AND target IN (`+placeholders(len(targets))+`)
+		AND source_id IN (SELECT id FROM notes WHERE `+where+`)
+	`, append(args, scopeArgs...)...)
+	return err
+}
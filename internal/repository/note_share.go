@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type NoteShareRepository interface {
+	// UpsertShare stores wrappedKey, if there is one, for the user the note
+	// is shared with in the same transaction as the share.
+	UpsertShare(share *models.NoteShare, wrappedKey string) (*models.NoteShare, error)
+	GetShare(noteID string, userID string) (*models.NoteShare, error)
+	GetNoteShares(noteID string) ([]models.NoteShare, error)
+	GetNotesSharedWithUser(userID string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error)
+	// DeleteShare also removes the user's key to the note, if it's
+	// encrypted.
+	DeleteShare(noteID string, userID string) error
+	TransferNote(noteID string, previousOwnerID string, newOwnerID string) error
+}
+
+type noteShareRepository struct {
+	db   *sql.DB
+	keys *atrest.Keyring
+}
+
+// NewNoteShareRepository decrypts the content of shared notes with keys.
+func NewNoteShareRepository(db *sql.DB, keys *atrest.Keyring) (NoteShareRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting note_shares table")
+		db.Exec(`
+			DROP TABLE IF EXISTS note_shares;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS note_shares (
+			note_id			VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			role			VARCHAR(16) NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(note_id, user_id),
+			FOREIGN KEY(note_id) REFERENCES notes(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS note_shares_user_id ON note_shares(user_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &noteShareRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+func (r *noteShareRepository) UpsertShare(share *models.NoteShare, wrappedKey string) (*models.NoteShare, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO note_shares (
+			note_id, user_id, role
+		) VALUES (?, ?, ?)
+		ON CONFLICT(note_id, user_id) DO UPDATE SET role=excluded.role;
+	`, share.NoteID, share.UserID, share.Role)
+	if err != nil {
+		return nil, err
+	}
+	if wrappedKey != "" {
+		err = setNoteKey(tx, share.NoteID, share.UserID, wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upserted, err := getShare(tx, share.NoteID, share.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return upserted, tx.Commit()
+}
+
+func (r *noteShareRepository) GetShare(noteID string, userID string) (*models.NoteShare, error) {
+	return getShare(r.db, noteID, userID)
+}
+
+func getShare(q rowQuerier, noteID string, userID string) (*models.NoteShare, error) {
+	share := &models.NoteShare{}
+	err := q.QueryRow(`
+		SELECT s.note_id, s.user_id, u.username, s.role, s.created_at
+		FROM note_shares s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.note_id=? AND s.user_id=?
+	`, noteID, userID).Scan(&share.NoteID, &share.UserID, &share.Username, &share.Role, &share.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+func (r *noteShareRepository) GetNoteShares(noteID string) ([]models.NoteShare, error) {
+	rows, err := r.db.Query(`
+		SELECT s.note_id, s.user_id, u.username, s.role, s.created_at
+		FROM note_shares s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.note_id=?
+		ORDER BY s.created_at
+	`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]models.NoteShare, 0)
+
+	for rows.Next() {
+		var share models.NoteShare
+		if err := rows.Scan(&share.NoteID, &share.UserID, &share.Username, &share.Role, &share.CreatedAt); err != nil {
+			return shares, err
+		}
+		shares = append(shares, share)
+	}
+	if err = rows.Err(); err != nil {
+		return shares, err
+	}
+	return shares, nil
+}
+
+func (r *noteShareRepository) GetNotesSharedWithUser(userID string, sort models.NoteSort, filters []dto.PropertyFilter) ([]models.Note, error) {
+	conditions, args := propertyConditions(filters)
+	rows, err := r.db.Query(`
+		SELECT `+noteColumns+`, s.role
+		FROM note_shares s
+		JOIN notes ON notes.id = s.note_id
+		WHERE s.user_id=?`+conditions+`
+		ORDER BY `+noteOrder(sort), append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make([]models.Note, 0)
+
+	for rows.Next() {
+		var role models.NoteRole
+		note, err := scanNote(r.keys, rows, &role)
+		if err != nil {
+			return notes, err
+		}
+		note.Role = role
+		notes = append(notes, *note)
+	}
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+	return notes, nil
+}
+
+func (r *noteShareRepository) DeleteShare(noteID string, userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM note_shares WHERE note_id=? AND user_id=?", noteID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM note_keys WHERE note_id=? AND user_id=?", noteID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TransferNote hands the note to newOwnerID and keeps the previous owner on as
+// an editor.
+func (r *noteShareRepository) TransferNote(noteID string, previousOwnerID string, newOwnerID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE notes SET user_id=? WHERE id=?", newOwnerID, noteID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM note_shares WHERE note_id=? AND user_id=?", noteID, newOwnerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO note_shares (
+			note_id, user_id, role
+		) VALUES (?, ?, ?)
+		ON CONFLICT(note_id, user_id) DO UPDATE SET role=excluded.role;
+	`, noteID, previousOwnerID, models.NoteRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type NoteStateRepository interface {
+	// GetStates returns the user's state for each of the notes that has
+	// one, by note id.
+	GetStates(userID string, noteIDs []string) (map[string]models.NoteState, error)
+	// SetStates changes the fields set in request for all of its notes in
+	// one transaction.
+	SetStates(userID string, request dto.NoteStateRequest) error
+}
+
+type noteStateRepository struct {
+	db *sql.DB
+}
+
+func NewNoteStateRepository(db *sql.DB) (NoteStateRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting note_states table")
+		db.Exec(`
+			DROP TABLE IF EXISTS note_states;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS note_states (
+			user_id		VARCHAR(255) NOT NULL,
+			note_id		VARCHAR(255) NOT NULL,
+			pinned		BOOLEAN NOT NULL DEFAULT 0,
+			archived	BOOLEAN NOT NULL DEFAULT 0,
+			favourite	BOOLEAN NOT NULL DEFAULT 0,
+			PRIMARY KEY(user_id, note_id),
+			FOREIGN KEY(user_id) REFERENCES users(id),
+			FOREIGN KEY(note_id) REFERENCES notes(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS note_states_note_id ON note_states(note_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &noteStateRepository{
+		db: db,
+	}, nil
+}
+
+func (r *noteStateRepository) GetStates(userID string, noteIDs []string) (map[string]models.NoteState, error) {
+	states := make(map[string]models.NoteState)
+	for _, batch := range idBatches(noteIDs) {
+		rows, err := r.db.Query(`
+			SELECT note_id, pinned, archived, favourite FROM note_states
+			WHERE user_id=? AND note_id IN (`+placeholders(len(batch))+`)
+		`, append([]any{userID}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var state models.NoteState
+			err := rows.Scan(&state.NoteID, &state.Pinned, &state.Archived, &state.Favourite)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			states[state.NoteID] = state
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+func (r *noteStateRepository) SetStates(userID string, request dto.NoteStateRequest) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, noteID := range request.NoteIDs {
+		_, err = tx.Exec(`
+			INSERT INTO note_states (user_id, note_id, pinned, archived, favourite)
+			VALUES (?, ?, COALESCE(?, 0), COALESCE(?, 0), COALESCE(?, 0))
+			ON CONFLICT(user_id, note_id) DO UPDATE SET
+				pinned=COALESCE(?, pinned),
+				archived=COALESCE(?, archived),
+				favourite=COALESCE(?, favourite)
+		`, userID, noteID, request.Pinned, request.Archived, request.Favourite, request.Pinned, request.Archived, request.Favourite)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a row with nothing set is the same as no row
+	_, err = tx.Exec("DELETE FROM note_states WHERE user_id=? AND NOT pinned AND NOT archived AND NOT favourite", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
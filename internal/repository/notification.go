@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type NotificationRepository interface {
+	GetChannels(userID string) ([]models.NotificationChannel, error)
+	// SetChannels replaces all of the user's channels.
+	SetChannels(userID string, channels []models.NotificationChannel) error
+}
+
+type notificationRepository struct {
+	db *sql.DB
+}
+
+func NewNotificationRepository(db *sql.DB) (NotificationRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting notification_channels table")
+		db.Exec(`
+			DROP TABLE IF EXISTS notification_channels;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_channels (
+			user_id		VARCHAR(255) NOT NULL,
+			type		VARCHAR(16) NOT NULL,
+			enabled		BOOLEAN NOT NULL DEFAULT 1,
+			target		TEXT NOT NULL,
+			secret		TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(user_id, type),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notificationRepository{
+		db: db,
+	}, nil
+}
+
+func (r *notificationRepository) GetChannels(userID string) ([]models.NotificationChannel, error) {
+	rows, err := r.db.Query(`
+		SELECT type, enabled, target, secret FROM notification_channels
+		WHERE user_id=?
+		ORDER BY type
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make([]models.NotificationChannel, 0)
+	for rows.Next() {
+		var channel models.NotificationChannel
+		err := rows.Scan(&channel.Type, &channel.Enabled, &channel.Target, &channel.Secret)
+		if err != nil {
+			return channels, err
+		}
+		channel.HasSecret = channel.Secret != ""
+		channels = append(channels, channel)
+	}
+	if err = rows.Err(); err != nil {
+		return channels, err
+	}
+	return channels, nil
+}
+
+func (r *notificationRepository) SetChannels(userID string, channels []models.NotificationChannel) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM notification_channels WHERE user_id=?", userID)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		_, err = tx.Exec(`
+			INSERT INTO notification_channels (user_id, type, enabled, target, secret)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, channel.Type, channel.Enabled, channel.Target, channel.Secret)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type PropertyRepository interface {
+	// GetProperties returns the properties of each of the notes that has
+	// any, by note id.
+	GetProperties(noteIDs []string) (map[string]map[string]models.Property, error)
+	// GetSchema returns the definitions of a workspace's notebook, or the
+	// user's personal one when workspaceID is empty.
+	GetSchema(userID string, workspaceID string) ([]models.PropertyDefinition, error)
+	SetSchema(userID string, workspaceID string, definitions []models.PropertyDefinition) error
+	// GetPropertyValues lists the different values notes in the notebook
+	// have for the property.
+	GetPropertyValues(userID string, workspaceID string, name string) ([]models.Property, error)
+}
+
+type propertyRepository struct {
+	db *sql.DB
+}
+
+func NewPropertyRepository(db *sql.DB) (PropertyRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting note_properties and property_schemas tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS note_properties;
+			DROP TABLE IF EXISTS property_schemas;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS note_properties (
+			note_id		VARCHAR(255) NOT NULL,
+			name		VARCHAR(64) NOT NULL,
+			type		VARCHAR(16) NOT NULL,
+			value_text	TEXT NOT NULL,
+			value_num	REAL,
+			PRIMARY KEY(note_id, name),
+			FOREIGN KEY(note_id) REFERENCES notes(id)
+		);
+
+		-- filters look notes up by value, the note id makes them covering
+		CREATE INDEX IF NOT EXISTS note_properties_text ON note_properties(name, value_text, note_id);
+		CREATE INDEX IF NOT EXISTS note_properties_num ON note_properties(name, value_num, note_id);
+
+		-- a schema belongs to a user or to a workspace, the other is empty
+		CREATE TABLE IF NOT EXISTS property_schemas (
+			user_id			VARCHAR(255) NOT NULL DEFAULT '',
+			workspace_id	VARCHAR(255) NOT NULL DEFAULT '',
+			name			VARCHAR(64) NOT NULL,
+			type			VARCHAR(16) NOT NULL,
+			options			TEXT NOT NULL DEFAULT '[]',
+			position		INTEGER NOT NULL,
+			PRIMARY KEY(user_id, workspace_id, name)
+		);
+
+		CREATE INDEX IF NOT EXISTS property_schemas_workspace_id ON property_schemas(workspace_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &propertyRepository{
+		db: db,
+	}, nil
+}
+
+// propertyColumns turns a property into what's stored: its value as text,
+// which is what strings, dates, enums and bools are compared by, and as a
+// number for numbers.
+func propertyColumns(property models.Property) (string, any) {
+	switch value := property.Value.(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), value
+	case bool:
+		return strconv.FormatBool(value), nil
+	case string:
+		return value, nil
+	}
+	return "", nil
+}
+
+func scanProperty(propertyType models.PropertyType, text string, number sql.NullFloat64) models.Property {
+	property := models.Property{Type: propertyType, Value: text}
+	switch propertyType {
+	case models.PropertyNumber:
+		property.Value = number.Float64
+	case models.PropertyBool:
+		property.Value = text == "true"
+	}
+	return property
+}
+
+func (r *propertyRepository) GetProperties(noteIDs []string) (map[string]map[string]models.Property, error) {
+	properties := make(map[string]map[string]models.Property)
+	for _, batch := range idBatches(noteIDs) {
+		rows, err := r.db.Query(`
+			SELECT note_id, name, type, value_text, value_num FROM note_properties
+			WHERE note_id IN (`+placeholders(len(batch))+`)
+		`, batch...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var noteID, name, text string
+			var propertyType models.PropertyType
+			var number sql.NullFloat64
+			err := rows.Scan(&noteID, &name, &propertyType, &text, &number)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if properties[noteID] == nil {
+				properties[noteID] = make(map[string]models.Property)
+			}
+			properties[noteID][name] = scanProperty(propertyType, text, number)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return properties, nil
+}
+
+// setNoteProperties replaces all of a note's properties. The note
+// repository calls it in the transaction that writes the note.
+func setNoteProperties(q rowQuerier, noteID string, properties map[string]models.Property) error {
+	_, err := q.Exec("DELETE FROM note_properties WHERE note_id=?", noteID)
+	if err != nil {
+		return err
+	}
+	for name, property := range properties {
+		text, number := propertyColumns(property)
+		_, err = q.Exec(`
+			INSERT INTO note_properties (note_id, name, type, value_text, value_num)
+			VALUES (?, ?, ?, ?, ?)
+		`, noteID, name, property.Type, text, number)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaOwner is the user_id and workspace_id a notebook's schema is stored
+// under.
+func schemaOwner(userID string, workspaceID string) (string, string) {
+	if workspaceID != "" {
+		return "", workspaceID
+	}
+	return userID, ""
+}
+
+func (r *propertyRepository) GetSchema(userID string, workspaceID string) ([]models.PropertyDefinition, error) {
+	userID, workspaceID = schemaOwner(userID, workspaceID)
+	rows, err := r.db.Query(`
+		SELECT name, type, options FROM property_schemas
+		WHERE user_id=? AND workspace_id=?
+		ORDER BY position
+	`, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := make([]models.PropertyDefinition, 0)
+	for rows.Next() {
+		var definition models.PropertyDefinition
+		var options string
+		err := rows.Scan(&definition.Name, &definition.Type, &options)
+		if err != nil {
+			return definitions, err
+		}
+		err = json.Unmarshal([]byte(options), &definition.Options)
+		if err != nil {
+			return definitions, err
+		}
+		definitions = append(definitions, definition)
+	}
+	if err = rows.Err(); err != nil {
+		return definitions, err
+	}
+	return definitions, nil
+}
+
+func (r *propertyRepository) SetSchema(userID string, workspaceID string, definitions []models.PropertyDefinition) error {
+	userID, workspaceID = schemaOwner(userID, workspaceID)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM property_schemas WHERE user_id=? AND workspace_id=?", userID, workspaceID)
+	if err != nil {
+		return err
+	}
+	for position, definition := range definitions {
+		options := definition.Options
+		if options == nil {
+			options = make([]string, 0)
+		}
+		encoded, err := json.Marshal(options)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO property_schemas (user_id, workspace_id, name, type, options, position)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, userID, workspaceID, definition.Name, definition.Type, string(encoded), position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *propertyRepository) GetPropertyValues(userID string, workspaceID string, name string) ([]models.Property, error) {
+	notebook := "notes.user_id=? AND notes.workspace_id IS NULL"
+	args := []any{name, userID}
+	if workspaceID != "" {
+		notebook = "notes.workspace_id=?"
+		args = []any{name, workspaceID}
+	}
+
+	rows, err := r.db.Query(`
+		SELECT DISTINCT p.type, p.value_text, p.value_num
+		FROM note_properties p
+		JOIN notes ON notes.id = p.note_id
+		WHERE p.name=? AND `+notebook, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]models.Property, 0)
+	for rows.Next() {
+		var propertyType models.PropertyType
+		var text string
+		var number sql.NullFloat64
+		err := rows.Scan(&propertyType, &text, &number)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, scanProperty(propertyType, text, number))
+	}
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	return values, nil
+}
@@ -0,0 +1,306 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type ReminderRepository interface {
+	CreateReminder(reminder *models.Reminder) (*models.Reminder, error)
+	GetReminder(id string) (*models.Reminder, error)
+	// GetUserReminders lists the user's reminders, only those on noteID if
+	// it's set, soonest first.
+	GetUserReminders(userID string, noteID string) ([]models.Reminder, error)
+	DeleteReminder(id string) error
+	DeleteNoteReminders(noteID string) error
+	GetDueReminders(now time.Time, limit int) ([]models.Reminder, error)
+	// FireReminder moves a due reminder on to next, nil if it's done, and
+	// queues a delivery on each channel. It reports false without changing
+	// anything if the reminder has already been fired for this occurrence.
+	FireReminder(reminder *models.Reminder, next *time.Time, channels []models.NotificationChannelType) (bool, error)
+	GetPendingDeliveries(now time.Time, limit int) ([]models.ReminderDelivery, error)
+	CompleteDelivery(id string) error
+	// RetryDelivery records a failed attempt. Without a retryAt the
+	// delivery is given up on.
+	RetryDelivery(id string, retryAt *time.Time, message string) error
+}
+
+type reminderRepository struct {
+	db *sql.DB
+}
+
+func NewReminderRepository(db *sql.DB) (ReminderRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting reminders and reminder_deliveries tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS reminder_deliveries;
+			DROP TABLE IF EXISTS reminders;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS reminders (
+			id				VARCHAR(255) PRIMARY KEY,
+			note_id			VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			at				TIMESTAMP NOT NULL,
+			rrule			TEXT NOT NULL DEFAULT '',
+			timezone		VARCHAR(64) NOT NULL,
+			message			TEXT NOT NULL DEFAULT '',
+			next_at			TIMESTAMP,
+			fired_count		INTEGER NOT NULL DEFAULT 0,
+			last_fired_at	TIMESTAMP,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(note_id) REFERENCES notes(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS reminders_user_id ON reminders(user_id);
+		CREATE INDEX IF NOT EXISTS reminders_note_id ON reminders(note_id);
+		CREATE INDEX IF NOT EXISTS reminders_next_at ON reminders(next_at);
+
+		CREATE TABLE IF NOT EXISTS reminder_deliveries (
+			id				VARCHAR(255) PRIMARY KEY,
+			reminder_id		VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			note_id			VARCHAR(255) NOT NULL,
+			channel			VARCHAR(16) NOT NULL,
+			scheduled_at	TIMESTAMP NOT NULL,
+			status			VARCHAR(16) NOT NULL,
+			attempts		INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at	TIMESTAMP NOT NULL,
+			error			TEXT,
+			UNIQUE(reminder_id, scheduled_at, channel)
+		);
+
+		CREATE INDEX IF NOT EXISTS reminder_deliveries_status ON reminder_deliveries(status, next_attempt_at);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reminderRepository{
+		db: db,
+	}, nil
+}
+
+// dbTime stores times in UTC at whole seconds, so they compare correctly as
+// text.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+const reminderColumns = `id, note_id, user_id, at, rrule, timezone, message, next_at, fired_count, last_fired_at, created_at`
+
+func scanReminder(row interface{ Scan(dest ...any) error }) (*models.Reminder, error) {
+	reminder := &models.Reminder{}
+	var nextAt, lastFiredAt sql.NullTime
+	err := row.Scan(&reminder.ID, &reminder.NoteID, &reminder.UserID, &reminder.At, &reminder.RRule, &reminder.Timezone,
+		&reminder.Message, &nextAt, &reminder.FiredCount, &lastFiredAt, &reminder.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextAt.Valid {
+		reminder.NextAt = &nextAt.Time
+	}
+	if lastFiredAt.Valid {
+		reminder.LastFiredAt = &lastFiredAt.Time
+	}
+	return reminder, nil
+}
+
+func scanReminders(rows *sql.Rows) ([]models.Reminder, error) {
+	defer rows.Close()
+
+	reminders := make([]models.Reminder, 0)
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return reminders, err
+		}
+		reminders = append(reminders, *reminder)
+	}
+	if err := rows.Err(); err != nil {
+		return reminders, err
+	}
+	return reminders, nil
+}
+
+func (r *reminderRepository) CreateReminder(reminder *models.Reminder) (*models.Reminder, error) {
+	var nextAt any
+	if reminder.NextAt != nil {
+		nextAt = dbTime(*reminder.NextAt)
+	}
+	return scanReminder(r.db.QueryRow(`
+		INSERT INTO reminders (
+			id, note_id, user_id, at, rrule, timezone, message, next_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING `+reminderColumns,
+		reminder.ID, reminder.NoteID, reminder.UserID, dbTime(reminder.At), reminder.RRule, reminder.Timezone, reminder.Message, nextAt,
+	))
+}
+
+func (r *reminderRepository) GetReminder(id string) (*models.Reminder, error) {
+	return scanReminder(r.db.QueryRow("SELECT "+reminderColumns+" FROM reminders WHERE id=?", id))
+}
+
+func (r *reminderRepository) GetUserReminders(userID string, noteID string) ([]models.Reminder, error) {
+	rows, err := r.db.Query(`
+		SELECT `+reminderColumns+` FROM reminders
+		WHERE user_id=? AND (?='' OR note_id=?)
+		ORDER BY next_at IS NULL, next_at, created_at
+	`, userID, noteID, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanReminders(rows)
+}
+
+func (r *reminderRepository) DeleteReminder(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM reminder_deliveries WHERE reminder_id=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reminders WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *reminderRepository) DeleteNoteReminders(noteID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM reminder_deliveries WHERE note_id=?", noteID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reminders WHERE note_id=?", noteID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *reminderRepository) GetDueReminders(now time.Time, limit int) ([]models.Reminder, error) {
+	rows, err := r.db.Query(`
+		SELECT `+reminderColumns+` FROM reminders
+		WHERE next_at IS NOT NULL AND next_at <= ?
+		ORDER BY next_at
+		LIMIT ?
+	`, dbTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanReminders(rows)
+}
+
+func (r *reminderRepository) FireReminder(reminder *models.Reminder, next *time.Time, channels []models.NotificationChannelType) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var nextAt any
+	if next != nil {
+		nextAt = dbTime(*next)
+	}
+	scheduledAt := dbTime(*reminder.NextAt)
+
+	// only whoever moves next_at on gets to queue the deliveries
+	result, err := tx.Exec(`
+		UPDATE reminders
+		SET next_at=?, fired_count=fired_count+1, last_fired_at=?
+		WHERE id=? AND next_at=?
+	`, nextAt, scheduledAt, reminder.ID, scheduledAt)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	for _, channel := range channels {
+		_, err = tx.Exec(`
+			INSERT INTO reminder_deliveries (
+				id, reminder_id, user_id, note_id, channel, scheduled_at, status, next_attempt_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(reminder_id, scheduled_at, channel) DO NOTHING
+		`, uuid.NewString(), reminder.ID, reminder.UserID, reminder.NoteID, channel, scheduledAt, models.DeliveryStatusPending, scheduledAt)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+const deliveryColumns = `id, reminder_id, user_id, note_id, channel, scheduled_at, status, attempts, next_attempt_at, COALESCE(error, '')`
+
+func (r *reminderRepository) GetPendingDeliveries(now time.Time, limit int) ([]models.ReminderDelivery, error) {
+	rows, err := r.db.Query(`
+		SELECT `+deliveryColumns+` FROM reminder_deliveries
+		WHERE status=? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT ?
+	`, models.DeliveryStatusPending, dbTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.ReminderDelivery, 0)
+	for rows.Next() {
+		var delivery models.ReminderDelivery
+		err := rows.Scan(&delivery.ID, &delivery.ReminderID, &delivery.UserID, &delivery.NoteID, &delivery.Channel,
+			&delivery.ScheduledAt, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.Error)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+func (r *reminderRepository) CompleteDelivery(id string) error {
+	_, err := r.db.Exec(`
+		UPDATE reminder_deliveries SET status=?, attempts=attempts+1, error=NULL WHERE id=?
+	`, models.DeliveryStatusSent, id)
+	return err
+}
+
+func (r *reminderRepository) RetryDelivery(id string, retryAt *time.Time, message string) error {
+	if retryAt == nil {
+		_, err := r.db.Exec(`
+			UPDATE reminder_deliveries SET status=?, attempts=attempts+1, error=? WHERE id=?
+		`, models.DeliveryStatusFailed, message, id)
+		return err
+	}
+	_, err := r.db.Exec(`
+		UPDATE reminder_deliveries SET attempts=attempts+1, next_attempt_at=?, error=? WHERE id=?
+	`, dbTime(*retryAt), message, id)
+	return err
+}
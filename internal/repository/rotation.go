@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/models"
+)
+
+// RotationRepository encrypts what's stored of notes at rest again with the
+// current master key: their content, the text of their tasks, the targets of
+// their links and the words of the search index. That includes what was
+// stored before encryption at rest was turned on. It doesn't set up any
+// tables, so it can be used next to a running server.
+type RotationRepository interface {
+	// CountStale counts the notes with anything that isn't encrypted with
+	// the current master key.
+	CountStale() (int, error)
+	// RotateBatch encrypts up to limit of them again in one transaction and
+	// returns how many it did, none once they're all done.
+	RotateBatch(limit int) (int, error)
+	// PurgeIndex drops what the search index still has of words it was
+	// told to forget, such as the words of content indexed in plaintext.
+	// They otherwise stay in it until its segments happen to be merged.
+	PurgeIndex() error
+}
+
+type rotationRepository struct {
+	db   *sql.DB
+	keys *atrest.Keyring
+}
+
+func NewRotationRepository(db *sql.DB, keys *atrest.Keyring) RotationRepository {
+	return &rotationRepository{
+		db:   db,
+		keys: keys,
+	}
+}
+
+// staleNotes matches the notes CountStale counts, given the current master
+// key once for each ?.
+const staleNotes = `(
+	notes.content_key_id != ?
+	OR EXISTS (SELECT 1 FROM tasks WHERE tasks.note_id = notes.id AND tasks.text_key_id != ?)
+	OR EXISTS (SELECT 1 FROM note_links WHERE note_links.source_id = notes.id AND note_links.target_key_id != ?)
+	OR EXISTS (SELECT 1 FROM note_search WHERE note_search.note_id = notes.id AND note_search.key_id != ?)
+)`
+
+func (r *rotationRepository) staleArgs() []any {
+	current := r.keys.Current()
+	return []any{current, current, current, current}
+}
+
+func (r *rotationRepository) CountStale() (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM notes WHERE "+staleNotes, r.staleArgs()...).Scan(&count)
+	return count, err
+}
+
+func (r *rotationRepository) RotateBatch(limit int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT `+noteColumns+` FROM notes
+		WHERE `+staleNotes+`
+		LIMIT ?
+	`, append(r.staleArgs(), limit)...)
+	if err != nil {
+		return 0, err
+	}
+	notes, err := scanNotes(r.keys, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range notes {
+		err = r.rotateNote(tx, &notes[i])
+		if err != nil {
+			return 0, fmt.Errorf("note %s: %w", notes[i].ID, err)
+		}
+	}
+
+	return len(notes), tx.Commit()
+}
+
+func (r *rotationRepository) rotateNote(tx *sql.Tx, note *models.Note) error {
+	sealed, err := r.keys.Seal(note.ID, note.Content)
+	if err != nil {
+		return err
+	}
+	// the version stays, so the note isn't seen as changed
+	_, err = tx.Exec(
+		"UPDATE notes SET content=?, content_key_id=?, content_key=? WHERE id=?",
+		sealed.Value, sealed.KeyID, sealed.DataKey, note.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT "+taskColumns+" FROM tasks JOIN notes ON notes.id = tasks.note_id WHERE tasks.note_id=?", note.ID)
+	if err != nil {
+		return err
+	}
+	tasks := make([]models.Task, 0)
+	for rows.Next() {
+		task, err := scanTask(r.keys, rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tasks = append(tasks, *task)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tasks WHERE note_id=?", note.ID)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		err = insertTask(tx, r.keys, task)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err = tx.Query("SELECT "+noteLinkColumns+" FROM note_links l WHERE l.source_id=?", note.ID)
+	if err != nil {
+		return err
+	}
+	links, err := scanNoteLinks(r.keys, rows)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM note_links WHERE source_id=?", note.ID)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		err = insertLink(tx, r.keys, link)
+		if err != nil {
+			return err
+		}
+	}
+
+	return indexNote(tx, r.keys, note)
+}
+
+func (r *rotationRepository) PurgeIndex() error {
+	_, err := r.db.Exec("INSERT INTO notes_fts (notes_fts) VALUES ('optimize')")
+	return err
+}
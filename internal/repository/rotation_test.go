@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/search"
+)
+
+const (
+	oldKey = "2026-01:FLqaHEUYqnSKvtcxrpFcrRToKuxbkz1UUiDlr8P0qEc="
+	newKey = "2026-10:+7pIKSzBQHM/C5CZkNUl2tXgUGpkb6sSSrs/PLlTEss="
+)
+
+// rotationRepos are the repositories of everything rotation encrypts again,
+// on one database and with one keyring.
+type rotationRepos struct {
+	notes  NoteRepository
+	tasks  TaskRepository
+	links  NoteLinkRepository
+	search SearchRepository
+}
+
+func openRepos(t *testing.T, db *sql.DB, list string) rotationRepos {
+	t.Helper()
+	keys, err := atrest.Parse(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repos rotationRepos
+	repos.notes, err = NewNoteRepository(db, keys)
+	if err != nil {
+		t.Fatalf("opening notes with keys %q: %v", list, err)
+	}
+	repos.tasks, err = NewTaskRepository(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos.links, err = NewNoteLinkRepository(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWorkspaceRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewNoteShareRepository(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos.search, err = NewSearchRepository(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repos
+}
+
+// writeNotes writes count notes of generation with a task each and a link
+// to a note that doesn't exist yet.
+func (repos rotationRepos) writeNotes(t *testing.T, generation string, count int) {
+	t.Helper()
+	for i := range count {
+		id := fmt.Sprintf("%s-%d", generation, i)
+		note, err := repos.notes.CreateNote(&models.Note{
+			ID:      id,
+			UserID:  "user",
+			Title:   "Note " + id,
+			Content: generation + " list for [[Shopping]]\n- [ ] buy milk",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repos.tasks.SetNoteTasks(note.ID, []models.Task{{ID: "task-" + id, Line: 1, Text: "buy milk"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repos.links.SetLinks(note.ID, []models.NoteLink{{Target: "Shopping"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// check makes sure everything written by writeNotes can be read and found.
+func (repos rotationRepos) check(t *testing.T, generations []string, count int) {
+	t.Helper()
+	for _, generation := range generations {
+		for i := range count {
+			id := fmt.Sprintf("%s-%d", generation, i)
+			note, err := repos.notes.GetNoteByID(id)
+			if err != nil {
+				t.Fatalf("note %s: %v", id, err)
+			}
+			if note.Content != generation+" list for [[Shopping]]\n- [ ] buy milk" {
+				t.Errorf("note %s has content %q", id, note.Content)
+			}
+			task, err := repos.tasks.GetTask("task-" + id)
+			if err != nil {
+				t.Fatalf("task of note %s: %v", id, err)
+			}
+			if task.ID != "task-"+id || task.NoteID != id || task.Text != "buy milk" {
+				t.Errorf("task of note %s is %+v", id, task)
+			}
+		}
+
+		found := repos.find(t, generation)
+		if len(found) != count {
+			t.Errorf("searching %q found %d notes", generation, len(found))
+		}
+	}
+	if found := repos.find(t, "milk"); len(found) != len(generations)*count {
+		t.Errorf("searching milk found %d notes", len(found))
+	}
+}
+
+func (repos rotationRepos) find(t *testing.T, text string) []models.Note {
+	t.Helper()
+	query, err := search.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, err := repos.search.SearchNotes("user", query, time.UTC, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notes
+}
+
+func TestRotation(t *testing.T) {
+	t.Setenv("V8BOX_ENVIRONMENT", "test")
+	db, err := OpenDB(filepath.Join(t.TempDir(), "v8box.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// notes from before encryption at rest, then from under the old key
+	const count = 3
+	openRepos(t, db, "").writeNotes(t, "plain", count)
+	openRepos(t, db, oldKey).writeNotes(t, "early", count)
+
+	keys, err := atrest.Parse(newKey + "\n" + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotating := openRepos(t, db, newKey+"\n"+oldKey)
+	rotating.writeNotes(t, "late", count)
+	generations := []string{"plain", "early", "late"}
+
+	rotation := NewRotationRepository(db, keys)
+	stale, err := rotation.CountStale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale != 2*count {
+		t.Fatalf("%d stale notes, want %d", stale, 2*count)
+	}
+
+	batches := 0
+	for {
+		rotated, err := rotation.RotateBatch(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated == 0 {
+			break
+		}
+		batches++
+		if batches > count {
+			t.Fatal("rotation doesn't finish")
+		}
+		// halfway through, rows under either key are found
+		rotating.check(t, generations, count)
+	}
+	if batches != count {
+		t.Errorf("rotated in %d batches of 2", batches)
+	}
+	stale, err = rotation.CountStale()
+	if err != nil || stale != 0 {
+		t.Fatalf("%d stale notes after rotating, %v", stale, err)
+	}
+	err = rotation.PurgeIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing needs the old key anymore
+	rotated := openRepos(t, db, newKey)
+	rotated.check(t, generations, count)
+
+	// links are found by their target under the new key
+	target, err := rotated.notes.CreateNote(&models.Note{ID: "target", UserID: "user", Title: "Shopping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rotated.links.ResolveDangling(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backlinks, err := rotated.links.GetBacklinks(target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backlinks) != len(generations)*count {
+		t.Fatalf("%d backlinks", len(backlinks))
+	}
+	for _, link := range backlinks {
+		if link.Target != "Shopping" {
+			t.Errorf("link from %s to %q", link.SourceID, link.Target)
+		}
+	}
+
+	// and the plaintext is gone from the index
+	if found := rotated.find(t, "plain"); len(found) != count {
+		t.Errorf("searching plain found %d notes", len(found))
+	}
+	var plain int
+	err = db.QueryRow("SELECT COUNT(*) FROM notes_fts WHERE notes_fts MATCH 'content:milk'").Scan(&plain)
+	if err != nil || plain != 0 {
+		t.Errorf("%d notes indexed with plaintext words, %v", plain, err)
+	}
+}
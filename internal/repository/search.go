@@ -0,0 +1,485 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/search"
+
+	_ "modernc.org/sqlite"
+)
+
+type SearchRepository interface {
+	// SearchNotes lists up to limit notes userID can see that match every
+	// term of query, with the user's role on each. Dates are days in loc.
+	// An empty sort orders by how well the text terms match.
+	SearchNotes(userID string, query *search.Query, loc *time.Location, sort models.NoteSort, limit int) ([]models.Note, error)
+
+	CreateSavedSearch(savedSearch *models.SavedSearch) (*models.SavedSearch, error)
+	GetSavedSearch(id string) (*models.SavedSearch, error)
+	// GetSavedSearches lists the user's saved searches by name.
+	GetSavedSearches(userID string) ([]models.SavedSearch, error)
+	CountSavedSearches(userID string) (int, error)
+	UpdateSavedSearch(savedSearch *models.SavedSearch) (*models.SavedSearch, error)
+	DeleteSavedSearch(id string) error
+}
+
+type searchRepository struct {
+	db   *sql.DB
+	keys *atrest.Keyring
+}
+
+// NewSearchRepository sets up the full text index of notes, which the note
+// repository keeps up to date, and indexes notes that aren't in it yet. It
+// has to be made after the note repository.
+func NewSearchRepository(db *sql.DB, keys *atrest.Keyring) (SearchRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting notes_fts, note_search and saved_searches tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS notes_fts;
+			DROP TABLE IF EXISTS note_search;
+			DROP TABLE IF EXISTS saved_searches;
+		`)
+	}
+	// the index used to keep a copy of the text, which would leave notes
+	// readable when their content is encrypted at rest
+	var contentless int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE name = 'notes_fts' AND sql LIKE '%contentless_delete%'
+	`).Scan(&contentless)
+	if err != nil {
+		return nil, err
+	}
+	if contentless == 0 {
+		logging.Info("rebuilding notes_fts without a copy of note text")
+		_, err = db.Exec("DROP TABLE IF EXISTS notes_fts")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.Exec(`
+		-- fts5 rows are keyed by an integer, and the implicit rowid of notes
+		-- can change on VACUUM, so notes get one of their own here
+		CREATE TABLE IF NOT EXISTS note_search (
+			rowid		INTEGER PRIMARY KEY,
+			note_id		VARCHAR(255) NOT NULL UNIQUE,
+			key_id		VARCHAR(64) NOT NULL DEFAULT '',
+			FOREIGN KEY(note_id) REFERENCES notes(id)
+		);
+
+		-- only which words are in which notes is kept, not the text, and
+		-- with encryption at rest the words of content are hashed
+		CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(
+			title, content,
+			content='', contentless_delete=1,
+			tokenize='porter unicode61 remove_diacritics 2'
+		);
+
+		DROP TRIGGER IF EXISTS notes_fts_insert;
+		DROP TRIGGER IF EXISTS notes_fts_update;
+
+		CREATE TRIGGER notes_fts_insert
+		AFTER INSERT ON notes
+		BEGIN
+			INSERT INTO note_search (note_id) VALUES (NEW.id);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS notes_fts_delete
+		AFTER DELETE ON notes
+		BEGIN
+			DELETE FROM notes_fts WHERE rowid = (SELECT rowid FROM note_search WHERE note_id = OLD.id);
+			DELETE FROM note_search WHERE note_id = OLD.id;
+		END;
+
+		INSERT INTO note_search (note_id)
+		SELECT id FROM notes WHERE id NOT IN (SELECT note_id FROM note_search);
+
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id				VARCHAR(255) PRIMARY KEY,
+			user_id			VARCHAR(255) NOT NULL,
+			name			VARCHAR(255) NOT NULL,
+			query			TEXT NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS saved_searches_user_id ON saved_searches(user_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// the master key the words of the note's content were hashed with,
+	// empty when they're in plaintext
+	err = addColumnIfMissing(db, "note_search", "key_id", "VARCHAR(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	err = checkMasterKeys(db, keys, "note_search", "key_id")
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexMissingNotes(db, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &searchRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+// indexMissingNotes indexes notes written before the index existed, or
+// before it was rebuilt.
+func indexMissingNotes(db *sql.DB, keys *atrest.Keyring) error {
+	rows, err := db.Query(`
+		SELECT ` + noteColumns + ` FROM notes
+		JOIN note_search s ON s.note_id = notes.id
+		WHERE s.rowid NOT IN (SELECT rowid FROM notes_fts)
+	`)
+	if err != nil {
+		return err
+	}
+	notes, err := scanNotes(keys, rows)
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+
+	logging.Info("indexing %d notes for search", len(notes))
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range notes {
+		err = indexNote(tx, keys, &notes[i])
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// indexNote puts the note's current title and content in the index. The
+// content of end-to-end encrypted notes is ciphertext, only their titles are
+// searchable. With encryption at rest the words of the content are hashed
+// with the current master key.
+func indexNote(q rowQuerier, keys *atrest.Keyring, note *models.Note) error {
+	content := note.Content
+	if note.Encrypted() {
+		content = ""
+	} else if keys.Enabled() {
+		content = strings.Join(blindWords(keys, content)[1], " ")
+	}
+	_, err := q.Exec(`
+		INSERT OR REPLACE INTO notes_fts (rowid, title, content)
+		SELECT rowid, ?, ? FROM note_search WHERE note_id = ?
+	`, note.Title, content, note.ID)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec("UPDATE note_search SET key_id=? WHERE note_id=?", keys.Current(), note.ID)
+	return err
+}
+
+// blindWords splits text into lowercase words and returns them as they're
+// indexed with each master key, in the order of Blinds, the first being the
+// words themselves.
+func blindWords(keys *atrest.Keyring, text string) [][]string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	blinded := make([][]string, len(keys.Blinds("")))
+	for i := range blinded {
+		blinded[i] = make([]string, len(words))
+	}
+	for i, word := range words {
+		for j, blind := range keys.Blinds(word) {
+			blinded[j][i] = blind
+		}
+	}
+	return blinded
+}
+
+// quote quotes text for an fts5 query, so nothing in it is read as query
+// syntax. Inside quotes the tokenizer still splits it into words.
+func quote(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+// ftsPhrase turns a text term into an fts5 query.
+func ftsPhrase(keys *atrest.Keyring, term search.Term) string {
+	phrase := quote(term.Value)
+	if term.Prefix {
+		phrase += " *"
+	}
+	switch term.Field {
+	case search.FieldTitle:
+		return "title : " + phrase
+	case search.FieldContent:
+		return "content : " + contentPhrase(keys, term, phrase)
+	}
+	if !keys.Enabled() {
+		return phrase
+	}
+	return "(title : " + phrase + " OR content : " + contentPhrase(keys, term, phrase) + ")"
+}
+
+// contentPhrase matches phrase in content. With encryption at rest that's
+// the hashes of its words with any of the master keys, whole words only, or
+// phrase itself in notes indexed before encryption at rest was turned on.
+func contentPhrase(keys *atrest.Keyring, term search.Term, phrase string) string {
+	if !keys.Enabled() {
+		return phrase
+	}
+	phrases := []string{phrase}
+	for _, words := range blindWords(keys, term.Value)[1:] {
+		if len(words) == 0 {
+			break
+		}
+		phrases = append(phrases, quote(strings.Join(words, " ")))
+	}
+	return "(" + strings.Join(phrases, " OR ") + ")"
+}
+
+// the tables has: looks in, reminders are per user
+var hasConditions = map[string]string{
+	search.HasAttachment: "EXISTS (SELECT 1 FROM attachments WHERE attachments.note_id = notes.id)",
+	search.HasTask:       "EXISTS (SELECT 1 FROM tasks WHERE tasks.note_id = notes.id)",
+	search.HasReminder:   "EXISTS (SELECT 1 FROM reminders WHERE reminders.note_id = notes.id AND reminders.user_id = ?)",
+	search.HasLink:       "EXISTS (SELECT 1 FROM note_links WHERE note_links.source_id = notes.id)",
+}
+
+// the columns is: looks at in the user's note_states, shared and
+// encrypted are handled separately
+var stateColumns = map[string]string{
+	search.IsPinned:    "pinned",
+	search.IsArchived:  "archived",
+	search.IsFavourite: "favourite",
+}
+
+var dateColumns = map[search.Field]string{
+	search.FieldCreated: "notes.created_at",
+	search.FieldUpdated: "notes.updated_at",
+}
+
+// dateCondition compares a timestamp column with the day term names in
+// loc. Timestamps are stored in UTC as text that sorts by time, so they're
+// compared with the UTC times the day starts and ends.
+func dateCondition(column string, term search.Term, loc *time.Location) (string, []any) {
+	day, _ := time.ParseInLocation(search.DateLayout, term.Value, loc)
+	start := day.UTC().Format("2006-01-02 15:04:05")
+	end := day.AddDate(0, 0, 1).UTC().Format("2006-01-02 15:04:05")
+
+	switch term.Op {
+	case ">":
+		return column + " >= ?", []any{end}
+	case ">=":
+		return column + " >= ?", []any{start}
+	case "<":
+		return column + " < ?", []any{start}
+	case "<=":
+		return column + " < ?", []any{end}
+	}
+	return "(" + column + " >= ? AND " + column + " < ?)", []any{start, end}
+}
+
+// termCondition turns everything but positive text terms into a condition
+// on notes. Only fixed strings end up in the SQL, values are parameters.
+func termCondition(keys *atrest.Keyring, userID string, term search.Term, loc *time.Location) (string, []any) {
+	switch term.Field {
+	case search.FieldText, search.FieldTitle, search.FieldContent:
+		// only negated ones get here, positive ones are in the MATCH
+		return `notes.id IN (
+			SELECT s.note_id FROM notes_fts JOIN note_search s ON s.rowid = notes_fts.rowid
+			WHERE notes_fts MATCH ?
+		)`, []any{ftsPhrase(keys, term)}
+
+	case search.FieldTag:
+		return "EXISTS (SELECT 1 FROM json_each(notes.tags) WHERE json_each.value = ? COLLATE NOCASE)", []any{term.Value}
+
+	case search.FieldFolder:
+		// the folder itself and everything under it
+		prefix := term.Value + "/"
+		return "(notes.folder = ? OR substr(notes.folder, 1, ?) = ?)", []any{term.Value, utf8.RuneCountInString(prefix), prefix}
+
+	case search.FieldCreated, search.FieldUpdated:
+		return dateCondition(dateColumns[term.Field], term, loc)
+
+	case search.FieldHas:
+		if term.Value == search.HasReminder {
+			return hasConditions[term.Value], []any{userID}
+		}
+		return hasConditions[term.Value], nil
+
+	case search.FieldIs:
+		switch term.Value {
+		case search.IsShared:
+			return "EXISTS (SELECT 1 FROM note_shares WHERE note_shares.note_id = notes.id)", nil
+		case search.IsEncrypted:
+			return "notes.enc_algorithm != ''", nil
+		}
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM note_states WHERE note_states.note_id = notes.id AND note_states.user_id = ? AND note_states.%s)", stateColumns[term.Value]), []any{userID}
+
+	case search.FieldProp:
+		conditions, args := propertyConditions([]dto.PropertyFilter{{Name: term.Name, Op: term.Op, Value: term.Value}})
+		return strings.TrimPrefix(conditions, " AND "), args
+	}
+	return "1", nil
+}
+
+func (r *searchRepository) SearchNotes(userID string, query *search.Query, loc *time.Location, sort models.NoteSort, limit int) ([]models.Note, error) {
+	var conditions strings.Builder
+	var phrases []string
+	var args []any
+	for _, term := range query.Terms {
+		text := term.Field == search.FieldText || term.Field == search.FieldTitle || term.Field == search.FieldContent
+		if text && !term.Negated {
+			phrases = append(phrases, ftsPhrase(r.keys, term))
+			continue
+		}
+
+		condition, conditionArgs := termCondition(r.keys, userID, term, loc)
+		if term.Negated {
+			condition = "NOT (" + condition + ")"
+		}
+		conditions.WriteString(" AND " + condition)
+		args = append(args, conditionArgs...)
+	}
+
+	from := "notes"
+	order := noteOrder(sort)
+	matchArgs := []any{}
+	if len(phrases) > 0 {
+		from = "notes_fts JOIN note_search ns ON ns.rowid = notes_fts.rowid JOIN notes ON notes.id = ns.note_id"
+		conditions.WriteString(" AND notes_fts MATCH ?")
+		matchArgs = append(matchArgs, strings.Join(phrases, " AND "))
+		if sort == "" {
+			// matches in the title count for more
+			order = "bm25(notes_fts, 10.0, 1.0), notes.updated_at DESC, notes.id"
+		}
+	}
+
+	// the same access as authorize: personal notes, notes of workspaces
+	// the user is in and notes shared with them
+	rows, err := r.db.Query(`
+		SELECT `+noteColumns+`, COALESCE(m.role, ''), COALESCE(sh.role, '')
+		FROM `+from+`
+		LEFT JOIN workspace_members m ON m.workspace_id = notes.workspace_id AND m.user_id = ?
+		LEFT JOIN note_shares sh ON sh.note_id = notes.id AND sh.user_id = ?
+		WHERE (
+			(notes.user_id = ? AND notes.workspace_id IS NULL)
+			OR m.user_id IS NOT NULL
+			OR sh.user_id IS NOT NULL
+		)`+conditions.String()+`
+		ORDER BY `+order+`
+		LIMIT ?
+	`, append(append(append([]any{userID, userID, userID}, args...), matchArgs...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make([]models.Note, 0)
+	for rows.Next() {
+		var memberRole models.WorkspaceRole
+		var shareRole models.NoteRole
+		note, err := scanNote(r.keys, rows, &memberRole, &shareRole)
+		if err != nil {
+			return notes, err
+		}
+		if note.WorkspaceID != "" {
+			note.Role = memberRole.NoteRole()
+		} else if note.UserID == userID {
+			note.Role = models.NoteRoleOwner
+		}
+		if shareRole != "" && !note.Role.Allows(shareRole) {
+			note.Role = shareRole
+		}
+		notes = append(notes, *note)
+	}
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+	return notes, nil
+}
+
+const savedSearchColumns = `id, user_id, name, query, created_at, updated_at`
+
+func scanSavedSearch(row interface{ Scan(dest ...any) error }) (*models.SavedSearch, error) {
+	savedSearch := &models.SavedSearch{}
+	err := row.Scan(&savedSearch.ID, &savedSearch.UserID, &savedSearch.Name, &savedSearch.Query, &savedSearch.CreatedAt, &savedSearch.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return savedSearch, nil
+}
+
+func (r *searchRepository) CreateSavedSearch(savedSearch *models.SavedSearch) (*models.SavedSearch, error) {
+	return scanSavedSearch(r.db.QueryRow(`
+		INSERT INTO saved_searches (id, user_id, name, query)
+		VALUES (?, ?, ?, ?) RETURNING `+savedSearchColumns,
+		savedSearch.ID, savedSearch.UserID, savedSearch.Name, savedSearch.Query,
+	))
+}
+
+func (r *searchRepository) GetSavedSearch(id string) (*models.SavedSearch, error) {
+	return scanSavedSearch(r.db.QueryRow("SELECT "+savedSearchColumns+" FROM saved_searches WHERE id=?", id))
+}
+
+func (r *searchRepository) GetSavedSearches(userID string) ([]models.SavedSearch, error) {
+	rows, err := r.db.Query("SELECT "+savedSearchColumns+" FROM saved_searches WHERE user_id=? ORDER BY name COLLATE NOCASE, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	savedSearches := make([]models.SavedSearch, 0)
+	for rows.Next() {
+		savedSearch, err := scanSavedSearch(rows)
+		if err != nil {
+			return savedSearches, err
+		}
+		savedSearches = append(savedSearches, *savedSearch)
+	}
+	if err = rows.Err(); err != nil {
+		return savedSearches, err
+	}
+	return savedSearches, nil
+}
+
+func (r *searchRepository) CountSavedSearches(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM saved_searches WHERE user_id=?", userID).Scan(&count)
+	return count, err
+}
+
+func (r *searchRepository) UpdateSavedSearch(savedSearch *models.SavedSearch) (*models.SavedSearch, error) {
+	return scanSavedSearch(r.db.QueryRow(`
+		UPDATE saved_searches SET name=?, query=?, updated_at=CURRENT_TIMESTAMP
+		WHERE id=? RETURNING `+savedSearchColumns,
+		savedSearch.Name, savedSearch.Query, savedSearch.ID,
+	))
+}
+
+func (r *searchRepository) DeleteSavedSearch(id string) error {
+	_, err := r.db.Exec("DELETE FROM saved_searches WHERE id=?", id)
+	return err
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+
+	_ "modernc.org/sqlite"
+)
+
+// SessionRepository records users whose tokens were revoked. JWTs can't be
+// taken back, so every token issued to them before the revocation is
+// refused instead.
+type SessionRepository interface {
+	GetRevocations() (map[string]time.Time, error)
+}
+
+type sessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) (SessionRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting session_revocations table")
+		db.Exec(`
+			DROP TABLE IF EXISTS session_revocations;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS session_revocations (
+			user_id			VARCHAR(255) PRIMARY KEY,
+			revoked_at		TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionRepository{
+		db: db,
+	}, nil
+}
+
+func (r *sessionRepository) GetRevocations() (map[string]time.Time, error) {
+	rows, err := r.db.Query("SELECT user_id, revoked_at FROM session_revocations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := make(map[string]time.Time)
+
+	for rows.Next() {
+		var userID string
+		var revokedAt time.Time
+		if err := rows.Scan(&userID, &revokedAt); err != nil {
+			return revocations, err
+		}
+		revocations[userID] = revokedAt
+	}
+	if err = rows.Err(); err != nil {
+		return revocations, err
+	}
+	return revocations, nil
+}
+
+// revokeSessions is run inside other transactions, like account deletion,
+// so the revocation can't get lost.
+func revokeSessions(tx *sql.Tx, userID string, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO session_revocations (
+			user_id, revoked_at
+		) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET revoked_at=excluded.revoked_at;
+	`, userID, at.UTC())
+	return err
+}
@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type ShareLinkRepository interface {
+	CreateLink(link *models.ShareLink) (*models.ShareLink, error)
+	GetLinkByID(id string) (*models.ShareLink, error)
+	GetLinkByTokenHash(tokenHash string) (*models.ShareLink, error)
+	GetNoteLinks(noteID string) ([]models.ShareLink, error)
+	RevokeLink(id string) error
+	// CountView bumps the view count unless the limit has been reached and
+	// reports whether the view was allowed.
+	CountView(id string) (bool, error)
+	RecordAccess(access *models.ShareLinkAccess) error
+	GetLinkAccesses(linkID string) ([]models.ShareLinkAccess, error)
+	// TakePasswordAttempt records an attempt at the link's password from
+	// remoteAddr, unless limit attempts have been recorded since then, and
+	// returns its id. Attempts from before since are dropped.
+	TakePasswordAttempt(linkID string, remoteAddr string, since time.Time, limit int) (int64, bool, error)
+	// ForgetPasswordAttempt drops an attempt that had the right password,
+	// so only wrong ones count towards the limit.
+	ForgetPasswordAttempt(id int64) error
+}
+
+type shareLinkRepository struct {
+	db *sql.DB
+}
+
+func NewShareLinkRepository(db *sql.DB) (ShareLinkRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting share link tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS share_link_attempts;
+			DROP TABLE IF EXISTS share_link_accesses;
+			DROP TABLE IF EXISTS share_links;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS share_links (
+			id				VARCHAR(255) PRIMARY KEY,
+			note_id			VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			token_hash		VARCHAR(64) NOT NULL UNIQUE,
+			password_hash	TEXT NOT NULL DEFAULT '',
+			expires_at		TIMESTAMP,
+			max_views		INTEGER NOT NULL DEFAULT 0,
+			view_count		INTEGER NOT NULL DEFAULT 0,
+			revoked			BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(note_id) REFERENCES notes(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS share_links_note_id ON share_links(note_id);
+
+		CREATE TABLE IF NOT EXISTS share_link_accesses (
+			id				INTEGER PRIMARY KEY AUTOINCREMENT,
+			link_id			VARCHAR(255) NOT NULL,
+			remote_addr		TEXT NOT NULL,
+			user_agent		TEXT NOT NULL,
+			accessed_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(link_id) REFERENCES share_links(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS share_link_accesses_link_id ON share_link_accesses(link_id);
+
+		-- password attempts that haven't turned out right (yet), kept until
+		-- they're too old to count
+		CREATE TABLE IF NOT EXISTS share_link_attempts (
+			id				INTEGER PRIMARY KEY AUTOINCREMENT,
+			link_id			VARCHAR(255) NOT NULL,
+			remote_addr		TEXT NOT NULL,
+			attempted_at	TIMESTAMP NOT NULL,
+			FOREIGN KEY(link_id) REFERENCES share_links(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS share_link_attempts_link_id ON share_link_attempts(link_id, attempted_at);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shareLinkRepository{
+		db: db,
+	}, nil
+}
+
+const shareLinkColumns = `id, note_id, user_id, token_hash, password_hash, expires_at, max_views, view_count, revoked, created_at`
+
+func scanShareLink(row interface{ Scan(dest ...any) error }) (*models.ShareLink, error) {
+	link := &models.ShareLink{}
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&link.ID,
+		&link.NoteID,
+		&link.UserID,
+		&link.TokenHash,
+		&link.PasswordHash,
+		&expiresAt,
+		&link.MaxViews,
+		&link.ViewCount,
+		&link.Revoked,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	link.HasPassword = link.PasswordHash != ""
+
+	return link, nil
+}
+
+func (r *shareLinkRepository) CreateLink(link *models.ShareLink) (*models.ShareLink, error) {
+	var expiresAt sql.NullTime
+	if link.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: link.ExpiresAt.UTC(), Valid: true}
+	}
+
+	row := r.db.QueryRow(`
+		INSERT INTO share_links (
+			id, note_id, user_id, token_hash, password_hash, expires_at, max_views
+		) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING `+shareLinkColumns,
+		link.ID, link.NoteID, link.UserID, link.TokenHash, link.PasswordHash, expiresAt, link.MaxViews,
+	)
+
+	return scanShareLink(row)
+}
+
+func (r *shareLinkRepository) GetLinkByID(id string) (*models.ShareLink, error) {
+	return scanShareLink(r.db.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE id=?", id))
+}
+
+func (r *shareLinkRepository) GetLinkByTokenHash(tokenHash string) (*models.ShareLink, error) {
+	return scanShareLink(r.db.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE token_hash=?", tokenHash))
+}
+
+func (r *shareLinkRepository) GetNoteLinks(noteID string) ([]models.ShareLink, error) {
+	rows, err := r.db.Query("SELECT "+shareLinkColumns+" FROM share_links WHERE note_id=? ORDER BY created_at", noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]models.ShareLink, 0)
+
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return links, err
+		}
+		links = append(links, *link)
+	}
+	if err = rows.Err(); err != nil {
+		return links, err
+	}
+	return links, nil
+}
+
+func (r *shareLinkRepository) RevokeLink(id string) error {
+	_, err := r.db.Exec("UPDATE share_links SET revoked=TRUE WHERE id=?", id)
+	return err
+}
+
+func (r *shareLinkRepository) CountView(id string) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE share_links
+		SET view_count=view_count+1
+		WHERE id=? AND (max_views=0 OR view_count<max_views)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *shareLinkRepository) RecordAccess(access *models.ShareLinkAccess) error {
+	_, err := r.db.Exec(`
+		INSERT INTO share_link_accesses (
+			link_id, remote_addr, user_agent, accessed_at
+		) VALUES (?, ?, ?, ?)
+	`, access.LinkID, access.RemoteAddr, access.UserAgent, time.Now().UTC())
+	return err
+}
+
+func (r *shareLinkRepository) GetLinkAccesses(linkID string) ([]models.ShareLinkAccess, error) {
+	rows, err := r.db.Query(`
+		SELECT id, link_id, remote_addr, user_agent, accessed_at
+		FROM share_link_accesses
+		WHERE link_id=?
+		ORDER BY accessed_at DESC
+	`, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accesses := make([]models.ShareLinkAccess, 0)
+
+	for rows.Next() {
+		var access models.ShareLinkAccess
+		if err := rows.Scan(&access.ID, &access.LinkID, &access.RemoteAddr, &access.UserAgent, &access.AccessedAt); err != nil {
+			return accesses, err
+		}
+		accesses = append(accesses, access)
+	}
+	if err = rows.Err(); err != nil {
+		return accesses, err
+	}
+	return accesses, nil
+}
+
+func (r *shareLinkRepository) TakePasswordAttempt(linkID string, remoteAddr string, since time.Time, limit int) (int64, bool, error) {
+	// the transaction takes the write lock when it begins, so attempts made
+	// at the same time are counted one after the other
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	since = since.UTC()
+	_, err = tx.Exec("DELETE FROM share_link_attempts WHERE link_id=? AND attempted_at<?", linkID, since)
+	if err != nil {
+		return 0, false, err
+	}
+	var attempts int
+	err = tx.QueryRow("SELECT COUNT(*) FROM share_link_attempts WHERE link_id=?", linkID).Scan(&attempts)
+	if err != nil {
+		return 0, false, err
+	}
+	if attempts >= limit {
+		return 0, false, nil
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO share_link_attempts (link_id, remote_addr, attempted_at)
+		VALUES (?, ?, ?) RETURNING id
+	`, linkID, remoteAddr, time.Now().UTC()).Scan(&id)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, tx.Commit()
+}
+
+func (r *shareLinkRepository) ForgetPasswordAttempt(id int64) error {
+	_, err := r.db.Exec("DELETE FROM share_link_attempts WHERE id=?", id)
+	return err
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/vaporii/v8box/internal/atrest"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type TaskRepository interface {
+	// SetNoteTasks replaces the tasks of a note.
+	SetNoteTasks(noteID string, tasks []models.Task) error
+	GetTask(id string) (*models.Task, error)
+	// GetUserTasks lists tasks in every note userID can see, soonest due
+	// first, then by priority.
+	GetUserTasks(userID string, filter models.TaskFilter) ([]models.Task, error)
+}
+
+type taskRepository struct {
+	db   *sql.DB
+	keys *atrest.Keyring
+}
+
+// NewTaskRepository encrypts task text at rest with keys, and fails if tasks
+// were encrypted with master keys that are no longer in it.
+func NewTaskRepository(db *sql.DB, keys *atrest.Keyring) (TaskRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting tasks table")
+		db.Exec(`
+			DROP TABLE IF EXISTS tasks;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tasks (
+			id				VARCHAR(64) PRIMARY KEY,
+			note_id			VARCHAR(255) NOT NULL,
+			line			INTEGER NOT NULL,
+			text			TEXT NOT NULL,
+			done			BOOLEAN NOT NULL DEFAULT FALSE,
+			due				VARCHAR(10),
+			priority		VARCHAR(16) NOT NULL DEFAULT '',
+			text_key_id		VARCHAR(64) NOT NULL DEFAULT '',
+			text_key		TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(note_id) REFERENCES notes(id)
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// like notes.content, text is in plaintext when the key id is empty
+	err = addColumnIfMissing(db, "tasks", "text_key_id", "VARCHAR(64) NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "tasks", "text_key", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS tasks_note_id ON tasks(note_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMasterKeys(db, keys, "tasks", "text_key_id")
+	if err != nil {
+		return nil, err
+	}
+
+	return &taskRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+const taskColumns = `tasks.id, tasks.note_id, notes.title, tasks.line, tasks.text, tasks.done, COALESCE(tasks.due, ''), tasks.priority, tasks.text_key_id, tasks.text_key`
+
+// sealTask encrypts the task's text. Its id is made from the text, so when
+// the text is encrypted the id is too, along with it, and the row is keyed
+// by a hash of the id instead.
+func sealTask(keys *atrest.Keyring, task models.Task) (string, atrest.Sealed, error) {
+	if !keys.Enabled() {
+		return task.ID, atrest.Sealed{Value: task.Text}, nil
+	}
+	sealed, err := keys.Seal(task.NoteID, task.ID+"\n"+task.Text)
+	return keys.Blind(task.ID), sealed, err
+}
+
+// scanTask decrypts the task's id and text with keys.
+func scanTask(keys *atrest.Keyring, row interface{ Scan(dest ...any) error }) (*models.Task, error) {
+	task := &models.Task{}
+	var text atrest.Sealed
+	err := row.Scan(&task.ID, &task.NoteID, &task.NoteTitle, &task.Line, &text.Value, &task.Done, &task.Due, &task.Priority, &text.KeyID, &text.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	if text.KeyID == "" {
+		task.Text = text.Value
+		return task, nil
+	}
+
+	opened, err := keys.Open(task.NoteID, text)
+	if err != nil {
+		return nil, fmt.Errorf("task of note %s: %w", task.NoteID, err)
+	}
+	task.ID, task.Text, _ = strings.Cut(opened, "\n")
+	return task, nil
+}
+
+func (r *taskRepository) SetNoteTasks(noteID string, tasks []models.Task) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM tasks WHERE note_id=?", noteID)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		task.NoteID = noteID
+		err = insertTask(tx, r.keys, task)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertTask(tx *sql.Tx, keys *atrest.Keyring, task models.Task) error {
+	id, text, err := sealTask(keys, task)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tasks (id, note_id, line, text, done, due, priority, text_key_id, text_key)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)
+	`, id, task.NoteID, task.Line, text.Value, task.Done, task.Due, task.Priority, text.KeyID, text.DataKey)
+	return err
+}
+
+func (r *taskRepository) GetTask(id string) (*models.Task, error) {
+	ids := r.keys.Blinds(id)
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return scanTask(r.keys, r.db.QueryRow(`
+		SELECT `+taskColumns+` FROM tasks JOIN notes ON notes.id = tasks.note_id
+		WHERE tasks.id IN (`+placeholders(len(args))+`)
+	`, args...))
+}
+
+func (r *taskRepository) GetUserTasks(userID string, filter models.TaskFilter) ([]models.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		JOIN notes ON notes.id = tasks.note_id
+		WHERE (
+			(notes.user_id=? AND notes.workspace_id IS NULL)
+			OR notes.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id=?)
+			OR notes.id IN (SELECT note_id FROM note_shares WHERE user_id=?)
+		)`
+	args := []any{userID, userID, userID}
+
+	switch filter.Status {
+	case models.TaskStatusOpen:
+		query += " AND NOT tasks.done"
+	case models.TaskStatusDone:
+		query += " AND tasks.done"
+	}
+	if filter.DueBefore != "" {
+		query += " AND tasks.due <= ?"
+		args = append(args, filter.DueBefore)
+	}
+	if filter.Priority != "" {
+		query += " AND tasks.priority=?"
+		args = append(args, filter.Priority)
+	}
+	if filter.NoteID != "" {
+		query += " AND tasks.note_id=?"
+		args = append(args, filter.NoteID)
+	}
+	query += `
+		ORDER BY tasks.due IS NULL, tasks.due,
+			CASE tasks.priority WHEN 'high' THEN 1 WHEN 'medium' THEN 2 WHEN 'low' THEN 3 ELSE 4 END,
+			notes.updated_at DESC, tasks.note_id, tasks.line`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]models.Task, 0)
+	for rows.Next() {
+		task, err := scanTask(r.keys, rows)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, *task)
+	}
+	if err = rows.Err(); err != nil {
+		return tasks, err
+	}
+	return tasks, nil
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type TemplateRepository interface {
+	CreateTemplate(template *models.Template) (*models.Template, error)
+	GetTemplate(id string) (*models.Template, error)
+	// GetUserTemplates lists the user's own templates and those of the
+	// workspaces they're in, by name.
+	GetUserTemplates(userID string) ([]models.Template, error)
+	UpdateTemplate(template *models.Template) (*models.Template, error)
+	DeleteTemplate(id string) error
+}
+
+type templateRepository struct {
+	db *sql.DB
+}
+
+func NewTemplateRepository(db *sql.DB) (TemplateRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting note_templates table")
+		db.Exec(`
+			DROP TABLE IF EXISTS note_templates;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS note_templates (
+			id				VARCHAR(255) PRIMARY KEY,
+			user_id			VARCHAR(255) NOT NULL,
+			workspace_id	VARCHAR(255),
+			name			VARCHAR(255) NOT NULL,
+			title			TEXT NOT NULL DEFAULT '',
+			content			TEXT NOT NULL DEFAULT '',
+			tags			TEXT NOT NULL DEFAULT '[]',
+			folder			TEXT NOT NULL DEFAULT '',
+			variables		TEXT NOT NULL DEFAULT '[]',
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id),
+			FOREIGN KEY(workspace_id) REFERENCES workspaces(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS note_templates_user_id ON note_templates(user_id);
+		CREATE INDEX IF NOT EXISTS note_templates_workspace_id ON note_templates(workspace_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateRepository{
+		db: db,
+	}, nil
+}
+
+const templateColumns = `id, user_id, COALESCE(workspace_id, ''), name, title, content, tags, folder, variables, created_at, updated_at`
+
+func scanTemplate(row interface{ Scan(dest ...any) error }) (*models.Template, error) {
+	template := &models.Template{}
+	var tags, variables string
+	err := row.Scan(&template.ID, &template.UserID, &template.WorkspaceID, &template.Name, &template.Title, &template.Content,
+		&tags, &template.Folder, &variables, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &template.Tags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &template.Variables); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func encodeVariables(variables []models.TemplateVariable) (string, error) {
+	if variables == nil {
+		variables = make([]models.TemplateVariable, 0)
+	}
+	encoded, err := json.Marshal(variables)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func (r *templateRepository) CreateTemplate(template *models.Template) (*models.Template, error) {
+	tags, err := encodeTags(template.Tags)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := encodeVariables(template.Variables)
+	if err != nil {
+		return nil, err
+	}
+	var workspaceID any
+	if template.WorkspaceID != "" {
+		workspaceID = template.WorkspaceID
+	}
+
+	return scanTemplate(r.db.QueryRow(`
+		INSERT INTO note_templates (
+			id, user_id, workspace_id, name, title, content, tags, folder, variables
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING `+templateColumns,
+		template.ID, template.UserID, workspaceID, template.Name, template.Title, template.Content, tags, template.Folder, variables,
+	))
+}
+
+func (r *templateRepository) GetTemplate(id string) (*models.Template, error) {
+	return scanTemplate(r.db.QueryRow("SELECT "+templateColumns+" FROM note_templates WHERE id=?", id))
+}
+
+func (r *templateRepository) GetUserTemplates(userID string) ([]models.Template, error) {
+	rows, err := r.db.Query(`
+		SELECT `+templateColumns+` FROM note_templates
+		WHERE (user_id=? AND workspace_id IS NULL)
+		OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id=?)
+		ORDER BY name COLLATE NOCASE, created_at
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]models.Template, 0)
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, *template)
+	}
+	if err = rows.Err(); err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+func (r *templateRepository) UpdateTemplate(template *models.Template) (*models.Template, error) {
+	tags, err := encodeTags(template.Tags)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := encodeVariables(template.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanTemplate(r.db.QueryRow(`
+		UPDATE note_templates
+		SET name=?, title=?, content=?, tags=?, folder=?, variables=?, updated_at=CURRENT_TIMESTAMP
+		WHERE id=? RETURNING `+templateColumns,
+		template.Name, template.Title, template.Content, tags, template.Folder, variables, template.ID,
+	))
+}
+
+func (r *templateRepository) DeleteTemplate(id string) error {
+	_, err := r.db.Exec("DELETE FROM note_templates WHERE id=?", id)
+	return err
+}
@@ -2,6 +2,9 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/vaporii/v8box/internal/config"
 	"github.com/vaporii/v8box/internal/logging"
@@ -15,8 +18,18 @@ type UserRepository interface {
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByOAuthKey(oauthKey string) (*models.User, error)
 	GetUserById(userId string) (*models.User, error)
+	UpdateAvatar(userId string, source models.AvatarSource, sourceURL string) error
+	UpdateProfile(user *models.User) error
+	// DeleteUser removes the user and their personal notes and revokes
+	// their sessions, all in one transaction. See the method for what
+	// happens to workspace content.
+	DeleteUser(userId string, revokedAt time.Time) error
 }
 
+// ErrSoleOwner is returned when deleting a user would leave a workspace
+// with other members but no owner.
+var ErrSoleOwner = errors.New("user is the only owner of a workspace with other members")
+
 type userRepository struct {
 	db *sql.DB
 }
@@ -52,6 +65,25 @@ func NewUserRepository(db *sql.DB) (UserRepository, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = addColumnIfMissing(db, "users", "avatar_source", "VARCHAR(16)")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "users", "display_name", "VARCHAR(100)")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfMissing(db, "users", "settings", "TEXT")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS users_username ON users(username)")
+	if err != nil {
+		// older databases could end up with duplicate usernames, they keep
+		// working and the service still checks before renaming
+		logging.Warning("couldn't make usernames unique: %v", err)
+	}
 	logging.Verbose("created users table")
 
 	return &userRepository{
@@ -67,20 +99,23 @@ func (r *userRepository) CreateUser(user *models.User) error {
 			username,
 			password_hash,
 			oauth_key,
-			avatar_url
+			avatar_url,
+			display_name
 		) VALUES (
 			?,
 			?,
 			?,
 			?,
-			?
+			?,
+			NULLIF(?, '')
 		)
 	`,
 		user.ID,
 		user.Username,
 		user.Password,
 		user.OAuthKey,
-		user.AvatarURL,
+		user.AvatarSourceURL,
+		user.DisplayName,
 	)
 	if err != nil {
 		return err
@@ -90,92 +125,189 @@ func (r *userRepository) CreateUser(user *models.User) error {
 	return nil
 }
 
-func (r *userRepository) GetUserByUsername(username string) (*models.User, error) {
+const userColumns = `id, username, password_hash, COALESCE(oauth_key, ''), COALESCE(avatar_url, ''), COALESCE(avatar_source, ''), COALESCE(display_name, ''), COALESCE(settings, ''), created_at, updated_at`
+
+func scanUser(row interface{ Scan(dest ...any) error }) (*models.User, error) {
 	user := &models.User{}
-	logging.Verbose("getting user by username")
-	err := r.db.QueryRow(`
-		SELECT
-			id,
-			username,
-			password_hash,
-			oauth_key,
-			avatar_url,
-			created_at,
-			updated_at
-		FROM users WHERE username=?
-	`, username).Scan(
+	var settings string
+	err := row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password,
 		&user.OAuthKey,
-		&user.AvatarURL,
+		&user.AvatarSourceURL,
+		&user.AvatarSource,
+		&user.DisplayName,
+		&settings,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
-	logging.Verbose("got user by username")
+
+	// stored settings are laid over the defaults so new settings get a value
+	user.Settings = models.DefaultUserSettings()
+	if settings != "" {
+		err = json.Unmarshal([]byte(settings), &user.Settings)
+		if err != nil {
+			logging.Warning("err reading settings of user %s, using defaults: %v", user.ID, err)
+			user.Settings = models.DefaultUserSettings()
+		}
+	}
 
 	return user, nil
 }
 
+func (r *userRepository) GetUserByUsername(username string) (*models.User, error) {
+	logging.Verbose("getting user by username")
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username=?", username))
+}
+
 func (r *userRepository) GetUserByOAuthKey(oauthKey string) (*models.User, error) {
-	user := &models.User{}
 	logging.Verbose("getting user by oauth key")
-	err := r.db.QueryRow(`
-		SELECT
-			id,
-			username,
-			password_hash,
-			oauth_key,
-			avatar_url,
-			created_at,
-			updated_at
-		FROM users WHERE oauth_key=?
-	`, oauthKey).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Password,
-		&user.OAuthKey,
-		&user.AvatarURL,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE oauth_key=?", oauthKey))
+}
+
+func (r *userRepository) GetUserById(userId string) (*models.User, error) {
+	logging.Verbose("getting user by id")
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id=?", userId))
+}
+
+// UpdateProfile saves the user's username, display name and settings.
+func (r *userRepository) UpdateProfile(user *models.User) error {
+	settings, err := json.Marshal(user.Settings)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	logging.Verbose("got user by oauth key")
 
-	return user, nil
+	_, err = r.db.Exec(`
+		UPDATE users
+		SET username=?,
+			display_name=NULLIF(?, ''),
+			settings=?
+		WHERE id=?
+	`, user.Username, user.DisplayName, string(settings), user.ID)
+	return err
 }
 
-func (r *userRepository) GetUserById(userId string) (*models.User, error) {
-	user := &models.User{}
-	logging.Verbose("getting user by id")
-	err := r.db.QueryRow(`
-		SELECT
-			id,
-			username,
-			password_hash,
-			oauth_key,
-			avatar_url,
-			created_at,
-			updated_at
-		FROM users WHERE id=?
-	`, userId).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Password,
-		&user.OAuthKey,
-		&user.AvatarURL,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+func (r *userRepository) UpdateAvatar(userId string, source models.AvatarSource, sourceURL string) error {
+	_, err := r.db.Exec("UPDATE users SET avatar_source=?, avatar_url=? WHERE id=?", source, sourceURL, userId)
+	return err
+}
+
+// DeleteUser deletes the account. Workspaces the user is the only member of
+// go with them. Notes they wrote in shared workspaces stay there and are
+// handed to another member, preferring owners, as are attachments they
+// added to other people's notes.
+func (r *userRepository) DeleteUser(userId string, revokedAt time.Time) error {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	logging.Verbose("got user by id")
+	defer tx.Rollback()
 
-	return user, nil
+	var stranded int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM workspace_members m
+		WHERE m.user_id=? AND m.role=?
+		AND NOT EXISTS (
+			SELECT 1 FROM workspace_members o
+			WHERE o.workspace_id=m.workspace_id AND o.user_id!=? AND o.role=?
+		)
+		AND EXISTS (
+			SELECT 1 FROM workspace_members o
+			WHERE o.workspace_id=m.workspace_id AND o.user_id!=?
+		)
+	`, userId, models.WorkspaceRoleOwner, userId, models.WorkspaceRoleOwner, userId).Scan(&stranded)
+	if err != nil {
+		return err
+	}
+	if stranded > 0 {
+		return ErrSoleOwner
+	}
+
+	soleWorkspaces := `
+		SELECT m.workspace_id FROM workspace_members m
+		WHERE m.user_id=?
+		AND NOT EXISTS (
+			SELECT 1 FROM workspace_members o
+			WHERE o.workspace_id=m.workspace_id AND o.user_id!=?
+		)
+	`
+	err = deleteNotes(tx, "workspace_id IN ("+soleWorkspaces+")", userId, userId)
+	if err != nil {
+		return err
+	}
+	err = deleteNotes(tx, "user_id=? AND workspace_id IS NULL", userId)
+	if err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM note_templates WHERE workspace_id IN (" + soleWorkspaces + ")", []any{userId, userId}},
+		{"DELETE FROM note_templates WHERE user_id=? AND workspace_id IS NULL", []any{userId}},
+		{"DELETE FROM property_schemas WHERE workspace_id IN (" + soleWorkspaces + ")", []any{userId, userId}},
+		{"DELETE FROM property_schemas WHERE user_id=?", []any{userId}},
+		{"DELETE FROM workspaces WHERE id IN (" + soleWorkspaces + ")", []any{userId, userId}},
+		{`
+			UPDATE notes SET user_id = (
+				SELECT o.user_id FROM workspace_members o
+				WHERE o.workspace_id=notes.workspace_id AND o.user_id!=?
+				ORDER BY o.role=? DESC, o.created_at
+				LIMIT 1
+			)
+			WHERE user_id=? AND workspace_id IS NOT NULL
+		`, []any{userId, models.WorkspaceRoleOwner, userId}},
+		{`
+			UPDATE note_templates SET user_id = (
+				SELECT o.user_id FROM workspace_members o
+				WHERE o.workspace_id=note_templates.workspace_id AND o.user_id!=?
+				ORDER BY o.role=? DESC, o.created_at
+				LIMIT 1
+			)
+			WHERE user_id=? AND workspace_id IS NOT NULL
+		`, []any{userId, models.WorkspaceRoleOwner, userId}},
+		{`
+			UPDATE workspaces SET created_by = (
+				SELECT o.user_id FROM workspace_members o
+				WHERE o.workspace_id=workspaces.id AND o.user_id!=? AND o.role=?
+				ORDER BY o.created_at
+				LIMIT 1
+			)
+			WHERE created_by=?
+		`, []any{userId, models.WorkspaceRoleOwner, userId}},
+		{"DELETE FROM workspace_members WHERE user_id=?", []any{userId}},
+		{"DELETE FROM note_shares WHERE user_id=?", []any{userId}},
+		{"DELETE FROM share_link_accesses WHERE link_id IN (SELECT id FROM share_links WHERE user_id=?)", []any{userId}},
+		{"DELETE FROM share_link_attempts WHERE link_id IN (SELECT id FROM share_links WHERE user_id=?)", []any{userId}},
+		{"DELETE FROM share_links WHERE user_id=?", []any{userId}},
+		{"UPDATE attachments SET user_id = (SELECT user_id FROM notes WHERE notes.id=attachments.note_id) WHERE user_id=?", []any{userId}},
+		{"DELETE FROM exports WHERE user_id=?", []any{userId}},
+		{"DELETE FROM reminder_deliveries WHERE user_id=?", []any{userId}},
+		{"DELETE FROM reminders WHERE user_id=?", []any{userId}},
+		{"DELETE FROM notification_channels WHERE user_id=?", []any{userId}},
+		{"DELETE FROM calendar_feeds WHERE user_id=?", []any{userId}},
+		{"DELETE FROM note_states WHERE user_id=?", []any{userId}},
+		{"DELETE FROM saved_searches WHERE user_id=?", []any{userId}},
+		{"DELETE FROM note_keys WHERE user_id=?", []any{userId}},
+		{"DELETE FROM user_keys WHERE user_id=?", []any{userId}},
+		{"DELETE FROM users WHERE id=?", []any{userId}},
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = revokeSessions(tx, userId, revokedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,324 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type WorkspaceRepository interface {
+	CreateWorkspace(workspace *models.Workspace) (*models.Workspace, error)
+	GetWorkspaceByID(id string) (*models.Workspace, error)
+	GetUserWorkspaces(userID string) ([]models.Workspace, error)
+	UpdateWorkspace(id string, name string) (*models.Workspace, error)
+	DeleteWorkspace(id string, ownerID string) error
+	GetMember(workspaceID string, userID string) (*models.WorkspaceMember, error)
+	GetMembers(workspaceID string) ([]models.WorkspaceMember, error)
+	// UpsertMember and DeleteMember fail with ErrLastOwner and change
+	// nothing if the workspace would be left without an owner.
+	UpsertMember(member *models.WorkspaceMember) (*models.WorkspaceMember, error)
+	DeleteMember(workspaceID string, userID string) error
+}
+
+// ErrLastOwner is returned when a change to a workspace's members would
+// leave it without an owner.
+var ErrLastOwner = errors.New("workspace would have no owner left")
+
+type workspaceRepository struct {
+	db *sql.DB
+}
+
+func NewWorkspaceRepository(db *sql.DB) (WorkspaceRepository, error) {
+	if config.LoadConfig().Environment == "dev" {
+		logging.Info("dev environment, deleting workspace tables")
+		db.Exec(`
+			DROP TABLE IF EXISTS workspace_members;
+			DROP TABLE IF EXISTS workspaces;
+		`)
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS workspaces (
+			id				VARCHAR(255) PRIMARY KEY,
+			name			VARCHAR(255) NOT NULL,
+			created_by		VARCHAR(255) NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(created_by) REFERENCES users(id)
+		);
+
+		DROP TRIGGER IF EXISTS update_workspaces_updated_at;
+
+		CREATE TRIGGER update_workspaces_updated_at
+		AFTER UPDATE ON workspaces
+		FOR EACH ROW
+		BEGIN
+			UPDATE workspaces SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+		END;
+
+		CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id	VARCHAR(255) NOT NULL,
+			user_id			VARCHAR(255) NOT NULL,
+			role			VARCHAR(16) NOT NULL,
+			created_at		TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(workspace_id, user_id),
+			FOREIGN KEY(workspace_id) REFERENCES workspaces(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS workspace_members_user_id ON workspace_members(user_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workspaceRepository{
+		db: db,
+	}, nil
+}
+
+// CreateWorkspace creates the workspace with its creator as the owner.
+func (r *workspaceRepository) CreateWorkspace(workspace *models.Workspace) (*models.Workspace, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var ret models.Workspace
+	err = tx.QueryRow(`
+		INSERT INTO workspaces (
+			id, name, created_by
+		) VALUES (?, ?, ?) RETURNING
+			id, name, created_by, created_at, updated_at;
+	`, workspace.ID, workspace.Name, workspace.CreatedBy).Scan(&ret.ID, &ret.Name, &ret.CreatedBy, &ret.CreatedAt, &ret.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO workspace_members (
+			workspace_id, user_id, role
+		) VALUES (?, ?, ?)
+	`, workspace.ID, workspace.CreatedBy, models.WorkspaceRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	ret.Role = models.WorkspaceRoleOwner
+
+	return &ret, nil
+}
+
+func (r *workspaceRepository) GetWorkspaceByID(id string) (*models.Workspace, error) {
+	workspace := &models.Workspace{}
+	err := r.db.QueryRow(`
+		SELECT id, name, created_by, created_at, updated_at
+		FROM workspaces WHERE id=?
+	`, id).Scan(&workspace.ID, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace, nil
+}
+
+func (r *workspaceRepository) GetUserWorkspaces(userID string) ([]models.Workspace, error) {
+	rows, err := r.db.Query(`
+		SELECT w.id, w.name, w.created_by, w.created_at, w.updated_at, m.role
+		FROM workspace_members m
+		JOIN workspaces w ON w.id = m.workspace_id
+		WHERE m.user_id=?
+		ORDER BY w.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := make([]models.Workspace, 0)
+
+	for rows.Next() {
+		var workspace models.Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt, &workspace.UpdatedAt, &workspace.Role); err != nil {
+			return workspaces, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err = rows.Err(); err != nil {
+		return workspaces, err
+	}
+	return workspaces, nil
+}
+
+func (r *workspaceRepository) UpdateWorkspace(id string, name string) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := r.db.QueryRow(`
+		UPDATE workspaces
+		SET name=?
+		WHERE id=?
+		RETURNING
+			id, name, created_by, created_at, updated_at;
+	`, name, id).Scan(&workspace.ID, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// DeleteWorkspace removes the workspace and hands its notes to ownerID as
+// personal notes so nothing is left without an owner.
+func (r *workspaceRepository) DeleteWorkspace(id string, ownerID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE notes SET workspace_id=NULL, user_id=? WHERE workspace_id=?", ownerID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE note_templates SET workspace_id=NULL, user_id=? WHERE workspace_id=?", ownerID, id)
+	if err != nil {
+		return err
+	}
+
+	// the notes keep their properties, but the owner's own schema applies
+	// to them now
+	_, err = tx.Exec("DELETE FROM property_schemas WHERE workspace_id=?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM workspace_members WHERE workspace_id=?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM workspaces WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *workspaceRepository) GetMember(workspaceID string, userID string) (*models.WorkspaceMember, error) {
+	return getMember(r.db, workspaceID, userID)
+}
+
+func getMember(q rowQuerier, workspaceID string, userID string) (*models.WorkspaceMember, error) {
+	member := &models.WorkspaceMember{}
+	err := q.QueryRow(`
+		SELECT m.workspace_id, m.user_id, u.username, m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id=? AND m.user_id=?
+	`, workspaceID, userID).Scan(&member.WorkspaceID, &member.UserID, &member.Username, &member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (r *workspaceRepository) GetMembers(workspaceID string) ([]models.WorkspaceMember, error) {
+	rows, err := r.db.Query(`
+		SELECT m.workspace_id, m.user_id, u.username, m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id=?
+		ORDER BY m.created_at
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]models.WorkspaceMember, 0)
+
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			return members, err
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return members, err
+	}
+	return members, nil
+}
+
+func (r *workspaceRepository) UpsertMember(member *models.WorkspaceMember) (*models.WorkspaceMember, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO workspace_members (
+			workspace_id, user_id, role
+		) VALUES (?, ?, ?)
+		ON CONFLICT(workspace_id, user_id) DO UPDATE SET role=excluded.role;
+	`, member.WorkspaceID, member.UserID, member.Role)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOwners(tx, member.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	upserted, err := getMember(tx, member.WorkspaceID, member.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return upserted, tx.Commit()
+}
+
+func (r *workspaceRepository) DeleteMember(workspaceID string, userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM workspace_members WHERE workspace_id=? AND user_id=?", workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	err = checkOwners(tx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkOwners counts the owners after a change in the same transaction, so
+// two owners stepping down at once can't both go through.
+func checkOwners(tx *sql.Tx, workspaceID string) error {
+	var owners int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM workspace_members WHERE workspace_id=? AND role=?",
+		workspaceID, models.WorkspaceRoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
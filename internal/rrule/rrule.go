@@ -0,0 +1,414 @@
+// Package rrule reads iCalendar (RFC 5545) recurrence rules like
+// "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10" and works out when they next occur.
+//
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH are supported.
+// Occurrences keep the clock time of the start in its location, so a daily
+// 09:00 reminder stays at 09:00 across daylight saving changes.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Hourly  Frequency = "HOURLY"
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// how many periods in a row without an occurrence are looked at before
+// giving up, e.g. for a rule asking for February 30th
+const maxPeriods = 100000
+
+// Weekday is a BYDAY entry. N picks the nth such day of the month, counting
+// from the end if negative, and 0 means every one.
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// 0 for no limit
+	Count int
+	// zero for no limit
+	Until time.Time
+	// UNTIL without a Z is a time in the start's location
+	floating   bool
+	ByDay      []Weekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+	source     string
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// Parse reads a rule, with or without the "RRULE:" prefix.
+func Parse(value string) (*Rule, error) {
+	source := strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	rule := &Rule{Interval: 1, source: source}
+
+	for _, part := range strings.Split(source, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = Frequency(strings.ToUpper(value))
+			switch rule.Freq {
+			case Hourly, Daily, Weekly, Monthly, Yearly:
+			default:
+				return nil, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if err == nil && rule.Interval < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if err == nil && rule.Count < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "UNTIL":
+			rule.Until, rule.floating, err = parseUntil(value)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseInts(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(value, 1, 12)
+			for _, month := range months {
+				rule.ByMonth = append(rule.ByMonth, time.Month(month))
+			}
+		case "WKST":
+			// weeks start on Monday, which is also the default
+			if strings.ToUpper(value) != "MO" {
+				err = errors.New("only MO is supported")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL can't be used together")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly && (rule.Freq != Yearly || len(rule.ByMonth) == 0) {
+			return nil, errors.New("numbered BYDAY needs FREQ=MONTHLY, or FREQ=YEARLY with BYMONTH")
+		}
+	}
+
+	return rule, nil
+}
+
+func (r *Rule) String() string {
+	return r.source
+}
+
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102T150405", value); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		// a plain date includes the whole day
+		return t.AddDate(0, 0, 1).Add(-time.Second), true, nil
+	}
+	return time.Time{}, false, errors.New("expected a date like 20261231 or 20261231T235959Z")
+}
+
+func parseByDay(value string) ([]Weekday, error) {
+	var days []Weekday
+	for _, item := range strings.Split(strings.ToUpper(value), ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("unknown day %q", item)
+		}
+		day, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", item)
+		}
+		n := 0
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			n, err = strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("invalid day %q", item)
+			}
+		}
+		days = append(days, Weekday{Day: day, N: n})
+	}
+	return days, nil
+}
+
+func parseInts(value string, min int, max int) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n == 0 || n < min || n > max {
+			return nil, fmt.Errorf("invalid value %q", item)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// After returns the first occurrence later than after, for a series that
+// starts at start. As in iCalendar, the start is the first occurrence even
+// if the rule wouldn't pick it. It reports false once the series is over.
+func (r *Rule) After(start time.Time, after time.Time) (time.Time, bool) {
+	if start.After(after) {
+		return start, true
+	}
+
+	// without a COUNT nothing before after matters, so there's no need to
+	// go through the periods before it. The one before is looked at too as
+	// periods don't line up exactly with after's clock.
+	first := 0
+	if r.Count == 0 {
+		first = max(r.period(start, after)-1, 0)
+	}
+
+	count := 1
+	empty := 0
+	for period := first; empty < maxPeriods; period++ {
+		empty++
+		for _, occurrence := range r.expand(start, period) {
+			if !occurrence.After(start) {
+				continue
+			}
+			empty = 0
+			if !r.Until.IsZero() && occurrence.After(r.untilIn(start.Location())) {
+				return time.Time{}, false
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return time.Time{}, false
+			}
+			if occurrence.After(after) {
+				return occurrence, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// period returns which period after start t falls in, as counted by expand.
+func (r *Rule) period(start time.Time, t time.Time) int {
+	t = t.In(start.Location())
+	var n int
+	switch r.Freq {
+	case Hourly:
+		n = int(t.Sub(start) / time.Hour)
+	case Daily:
+		n = daysBetween(start, t)
+	case Weekly:
+		// counted from the Monday of the start's week
+		n = (daysBetween(start, t) + (int(start.Weekday())+6)%7) / 7
+	case Monthly:
+		n = (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+	case Yearly:
+		n = t.Year() - start.Year()
+	}
+	return n / r.Interval
+}
+
+// daysBetween counts calendar days from a's date to b's.
+func daysBetween(a time.Time, b time.Time) int {
+	from := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from) / (24 * time.Hour))
+}
+
+func (r *Rule) untilIn(loc *time.Location) time.Time {
+	if !r.floating {
+		return r.Until
+	}
+	u := r.Until
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+}
+
+// expand lists the occurrences in the nth period after start, in order.
+func (r *Rule) expand(start time.Time, n int) []time.Time {
+	step := n * r.Interval
+	clock := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+
+	var candidates []time.Time
+	switch r.Freq {
+	case Hourly:
+		candidates = []time.Time{start.Add(time.Duration(step) * time.Hour)}
+	case Daily:
+		candidates = []time.Time{start.AddDate(0, 0, step)}
+	case Weekly:
+		// the Monday of the week
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := clock(start.Year(), start.Month(), start.Day()-offset+7*step)
+		if len(r.ByDay) == 0 {
+			candidates = []time.Time{monday.AddDate(0, 0, offset)}
+			break
+		}
+		for i := 0; i < 7; i++ {
+			day := monday.AddDate(0, 0, i)
+			if r.matchesDay(day) {
+				candidates = append(candidates, day)
+			}
+		}
+	case Monthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, start.Location())
+		candidates = r.daysOfMonth(start, first.Year(), first.Month(), clock)
+	case Yearly:
+		year := start.Year() + step
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{start.Month()}
+		}
+		for _, month := range months {
+			candidates = append(candidates, r.daysOfMonth(start, year, month, clock)...)
+		}
+	}
+
+	occurrences := make([]time.Time, 0, len(candidates))
+	for _, candidate := range candidates {
+		if r.matches(candidate) {
+			occurrences = append(occurrences, candidate)
+		}
+	}
+	sort.Slice(occurrences, func(a, b int) bool {
+		return occurrences[a].Before(occurrences[b])
+	})
+	return occurrences
+}
+
+// daysOfMonth lists the days of a month the rule picks, or the start's day
+// of the month if it doesn't pick any. Months without that day are skipped.
+func (r *Rule) daysOfMonth(start time.Time, year int, month time.Month, clock func(int, time.Month, int) time.Time) []time.Time {
+	length := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	var days []int
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, day := range r.ByMonthDay {
+			if day < 0 {
+				day = length + day + 1
+			}
+			if day >= 1 && day <= length {
+				days = append(days, day)
+			}
+		}
+	case len(r.ByDay) > 0:
+		for _, weekday := range r.ByDay {
+			days = append(days, nthWeekdays(year, month, length, weekday)...)
+		}
+	default:
+		if start.Day() <= length {
+			days = []int{start.Day()}
+		}
+	}
+
+	candidates := make([]time.Time, 0, len(days))
+	seen := make(map[int]bool)
+	for _, day := range days {
+		if !seen[day] {
+			seen[day] = true
+			candidates = append(candidates, clock(year, month, day))
+		}
+	}
+	return candidates
+}
+
+// nthWeekdays lists the days of the month falling on weekday.Day, or just
+// the nth one.
+func nthWeekdays(year int, month time.Month, length int, weekday Weekday) []int {
+	var days []int
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+	for day := 1 + (int(weekday.Day)-int(first)+7)%7; day <= length; day += 7 {
+		days = append(days, day)
+	}
+	switch {
+	case weekday.N > 0 && weekday.N <= len(days):
+		return days[weekday.N-1 : weekday.N]
+	case weekday.N < 0 && -weekday.N <= len(days):
+		return days[len(days)+weekday.N : len(days)+weekday.N+1]
+	case weekday.N != 0:
+		return nil
+	}
+	return days
+}
+
+// matches applies the BY parts that limit which candidates occur.
+func (r *Rule) matches(t time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsMonth(r.ByMonth, t.Month()) {
+		return false
+	}
+	switch r.Freq {
+	case Hourly, Daily:
+		if len(r.ByMonthDay) > 0 && !matchesMonthDay(r.ByMonthDay, t) {
+			return false
+		}
+		if len(r.ByDay) > 0 && !r.matchesDay(t) {
+			return false
+		}
+	case Monthly, Yearly:
+		// BYDAY limits BYMONTHDAY, e.g. Friday the 13th
+		if len(r.ByMonthDay) > 0 && len(r.ByDay) > 0 && !r.matchesDay(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) matchesDay(t time.Time) bool {
+	for _, day := range r.ByDay {
+		if day.Day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMonthDay(days []int, t time.Time) bool {
+	length := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, day := range days {
+		if day == t.Day() || length+day+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
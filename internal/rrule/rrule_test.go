@@ -0,0 +1,231 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, value string) *Rule {
+	t.Helper()
+	rule, err := Parse(value)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", value, err)
+	}
+	return rule
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("no time zone data for %s: %v", name, err)
+	}
+	return loc
+}
+
+// occurrences lists the series from start on, up to limit occurrences.
+func occurrences(rule *Rule, start time.Time, limit int) []time.Time {
+	var times []time.Time
+	after := start.Add(-time.Second)
+	for len(times) < limit {
+		next, ok := rule.After(start, after)
+		if !ok {
+			break
+		}
+		times = append(times, next)
+		after = next
+	}
+	return times
+}
+
+func checkOccurrences(t *testing.T, got []time.Time, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d occurrences %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if formatted := got[i].Format("2006-01-02 15:04 MST"); formatted != want[i] {
+			t.Errorf("occurrence %d is %s, want %s", i, formatted, want[i])
+		}
+	}
+}
+
+func TestWeeklyByDay(t *testing.T) {
+	rule := mustParse(t, "FREQ=WEEKLY;BYDAY=MO,WE")
+	// a Wednesday
+	start := time.Date(2026, 1, 7, 9, 0, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 5),
+		"2026-01-07 09:00 UTC",
+		"2026-01-12 09:00 UTC",
+		"2026-01-14 09:00 UTC",
+		"2026-01-19 09:00 UTC",
+		"2026-01-21 09:00 UTC",
+	)
+}
+
+func TestStartIsFirstOccurrence(t *testing.T) {
+	// a Tuesday, which the rule wouldn't pick
+	rule := mustParse(t, "RRULE:FREQ=WEEKLY;BYDAY=FR")
+	start := time.Date(2026, 1, 6, 9, 0, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 2),
+		"2026-01-06 09:00 UTC",
+		"2026-01-09 09:00 UTC",
+	)
+}
+
+func TestMonthlyNthWeekday(t *testing.T) {
+	rule := mustParse(t, "FREQ=MONTHLY;BYDAY=2TU,-1FR")
+	start := time.Date(2026, 1, 13, 18, 30, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 4),
+		"2026-01-13 18:30 UTC",
+		"2026-01-30 18:30 UTC",
+		"2026-02-10 18:30 UTC",
+		"2026-02-27 18:30 UTC",
+	)
+}
+
+func TestByMonthDay(t *testing.T) {
+	rule := mustParse(t, "FREQ=MONTHLY;BYMONTHDAY=31")
+	start := time.Date(2026, 1, 31, 8, 0, 0, 0, time.UTC)
+	// months without a 31st are skipped
+	checkOccurrences(t, occurrences(rule, start, 4),
+		"2026-01-31 08:00 UTC",
+		"2026-03-31 08:00 UTC",
+		"2026-05-31 08:00 UTC",
+		"2026-07-31 08:00 UTC",
+	)
+
+	rule = mustParse(t, "FREQ=MONTHLY;BYMONTHDAY=1,-1")
+	start = time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 5),
+		"2026-01-01 08:00 UTC",
+		"2026-01-31 08:00 UTC",
+		"2026-02-01 08:00 UTC",
+		"2026-02-28 08:00 UTC",
+		"2026-03-01 08:00 UTC",
+	)
+}
+
+func TestFridayThe13th(t *testing.T) {
+	rule := mustParse(t, "FREQ=MONTHLY;BYMONTHDAY=13;BYDAY=FR")
+	start := time.Date(2026, 2, 13, 12, 0, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 3),
+		"2026-02-13 12:00 UTC",
+		"2026-03-13 12:00 UTC",
+		"2026-11-13 12:00 UTC",
+	)
+}
+
+func TestCount(t *testing.T) {
+	rule := mustParse(t, "FREQ=DAILY;INTERVAL=2;COUNT=3")
+	start := time.Date(2026, 1, 1, 7, 0, 0, 0, time.UTC)
+	checkOccurrences(t, occurrences(rule, start, 10),
+		"2026-01-01 07:00 UTC",
+		"2026-01-03 07:00 UTC",
+		"2026-01-05 07:00 UTC",
+	)
+
+	if _, ok := rule.After(start, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("series with a COUNT still going years later")
+	}
+}
+
+func TestUntil(t *testing.T) {
+	rule := mustParse(t, "FREQ=WEEKLY;UNTIL=20260115T090000Z")
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	// UNTIL is inclusive
+	checkOccurrences(t, occurrences(rule, start, 10),
+		"2026-01-01 09:00 UTC",
+		"2026-01-08 09:00 UTC",
+		"2026-01-15 09:00 UTC",
+	)
+
+	// without a Z it's in the start's time zone, and a date is the whole day
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	rule = mustParse(t, "FREQ=DAILY;UNTIL=20260103")
+	start = time.Date(2026, 1, 1, 23, 0, 0, 0, tokyo)
+	checkOccurrences(t, occurrences(rule, start, 10),
+		"2026-01-01 23:00 JST",
+		"2026-01-02 23:00 JST",
+		"2026-01-03 23:00 JST",
+	)
+}
+
+func TestDaylightSaving(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+
+	// clocks go forward on March 29th 2026, a daily reminder stays at 09:00
+	rule := mustParse(t, "FREQ=DAILY")
+	start := time.Date(2026, 3, 28, 9, 0, 0, 0, berlin)
+	checkOccurrences(t, occurrences(rule, start, 3),
+		"2026-03-28 09:00 CET",
+		"2026-03-29 09:00 CEST",
+		"2026-03-30 09:00 CEST",
+	)
+
+	// and back on October 25th
+	rule = mustParse(t, "FREQ=WEEKLY;BYDAY=SU")
+	start = time.Date(2026, 10, 18, 9, 0, 0, 0, berlin)
+	checkOccurrences(t, occurrences(rule, start, 2),
+		"2026-10-18 09:00 CEST",
+		"2026-10-25 09:00 CET",
+	)
+
+	// hourly counts real hours, so 02:00 is skipped in spring
+	rule = mustParse(t, "FREQ=HOURLY")
+	start = time.Date(2026, 3, 29, 1, 0, 0, 0, berlin)
+	checkOccurrences(t, occurrences(rule, start, 3),
+		"2026-03-29 01:00 CET",
+		"2026-03-29 03:00 CEST",
+		"2026-03-29 04:00 CEST",
+	)
+}
+
+func TestLongRunningSeries(t *testing.T) {
+	// far more periods than maxPeriods after the start
+	rule := mustParse(t, "FREQ=HOURLY;INTERVAL=2")
+	start := time.Date(2000, 1, 1, 0, 30, 0, 0, time.UTC)
+	after := time.Date(2050, 6, 1, 13, 45, 0, 0, time.UTC)
+	next, ok := rule.After(start, after)
+	if !ok {
+		t.Fatal("hourly series reported over after 50 years")
+	}
+	if want := time.Date(2050, 6, 1, 14, 30, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("got %s, want %s", next, want)
+	}
+
+	rule = mustParse(t, "FREQ=WEEKLY;INTERVAL=3;BYDAY=TU,TH")
+	// a Thursday
+	start = time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	after = time.Date(2026, 1, 21, 9, 0, 0, 0, time.UTC)
+	next, _ = rule.After(start, after)
+	if want := time.Date(2026, 1, 22, 9, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("got %s, want %s", next, want)
+	}
+}
+
+func TestImpossibleRule(t *testing.T) {
+	rule := mustParse(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if next, ok := rule.After(start, start); ok {
+		t.Errorf("got %s for February 30th", next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=SECONDLY",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;WKST=SU",
+		"FREQ=DAILY;BYSETPOS=1",
+	} {
+		if _, err := Parse(value); err == nil {
+			t.Errorf("%q parsed", value)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Package search parses the note search language. A query is a list of
+// terms that all have to match:
+//
+//	plan "q3 plan" plan*        words and phrases anywhere in a note
+//	title:"q3 plan" content:x   words and phrases in one field
+//	tag:work folder:Projects    tags, and folders with their subfolders
+//	created:2026-01-01          dates, also with >, >=, < and <=
+//	updated:>=2026-01-01
+//	has:attachment              has:task, has:reminder and has:link too
+//	is:pinned                   is:archived, is:favourite, is:shared and
+//	                            is:encrypted too
+//	prop.status:open            properties, also with >, >=, < and <=
+//	prop.priority:>=2
+//
+// A leading - negates a term. Archived on its own is short for is:archived,
+// so -archived leaves archived notes out, quote it to search for the word.
+// Field names that aren't known are an error rather than text, so a typo
+// doesn't silently search for something else.
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxLength = 1000
+	MaxTerms  = 30
+)
+
+// DateLayout is how dates are written in created: and updated: terms.
+const DateLayout = "2006-01-02"
+
+type Field string
+
+const (
+	// plain words and phrases, matched against title and content
+	FieldText    Field = ""
+	FieldTitle   Field = "title"
+	FieldContent Field = "content"
+	FieldTag     Field = "tag"
+	FieldFolder  Field = "folder"
+	FieldCreated Field = "created"
+	FieldUpdated Field = "updated"
+	FieldHas     Field = "has"
+	FieldIs      Field = "is"
+	FieldProp    Field = "prop"
+)
+
+// values has: and is: take, plurals are read as the singular
+const (
+	HasAttachment = "attachment"
+	HasTask       = "task"
+	HasReminder   = "reminder"
+	HasLink       = "link"
+
+	IsPinned    = "pinned"
+	IsArchived  = "archived"
+	IsFavourite = "favourite"
+	IsShared    = "shared"
+	IsEncrypted = "encrypted"
+)
+
+var hasValues = map[string]string{
+	"attachment": HasAttachment, "attachments": HasAttachment,
+	"task": HasTask, "tasks": HasTask,
+	"reminder": HasReminder, "reminders": HasReminder,
+	"link": HasLink, "links": HasLink,
+}
+
+var isValues = map[string]string{
+	"pinned":    IsPinned,
+	"archived":  IsArchived,
+	"favourite": IsFavourite,
+	"favorite":  IsFavourite,
+	"shared":    IsShared,
+	"encrypted": IsEncrypted,
+}
+
+var propertyName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// operators created:, updated: and prop. values can start with, longest
+// first
+var comparisons = []string{">=", "<=", ">", "<", "="}
+
+// Term is one condition of a query.
+type Term struct {
+	Field   Field
+	Negated bool
+	// the property a prop. term is about
+	Name string
+	// how dates and properties compare to Value, = when it isn't given
+	Op    string
+	Value string
+	// a quoted phrase, or a word ending in * that matches as a prefix
+	Phrase bool
+	Prefix bool
+}
+
+type Query struct {
+	Terms []Term
+}
+
+// HasText reports whether the query searches the text of notes, which is
+// what results can be ranked by.
+func (q *Query) HasText() bool {
+	for _, term := range q.Terms {
+		if !term.Negated && (term.Field == FieldText || term.Field == FieldTitle || term.Field == FieldContent) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyntaxError is a query that can't be parsed. Column counts characters
+// from 1.
+type SyntaxError struct {
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) fail(pos int, format string, args ...any) error {
+	return &SyntaxError{
+		Column:  utf8.RuneCountInString(p.input[:pos]) + 1,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return r
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.peek()) {
+		p.pos += utf8.RuneLen(p.peek())
+	}
+}
+
+// word reads up to the next space, quote or colon.
+func (p *parser) word() string {
+	start := p.pos
+	for !p.done() {
+		r := p.peek()
+		if unicode.IsSpace(r) || r == '"' || r == ':' {
+			break
+		}
+		p.pos += utf8.RuneLen(r)
+	}
+	return p.input[start:p.pos]
+}
+
+// quoted reads a "phrase", in which \" and \\ stand for themselves.
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+	var phrase strings.Builder
+	for !p.done() {
+		r := p.peek()
+		p.pos += utf8.RuneLen(r)
+		switch r {
+		case '"':
+			return phrase.String(), nil
+		case '\\':
+			if !p.done() && (p.peek() == '"' || p.peek() == '\\') {
+				r = p.peek()
+				p.pos++
+			}
+		}
+		phrase.WriteRune(r)
+	}
+	return "", p.fail(start, "quote isn't closed")
+}
+
+// Parse reads a query. Errors are *SyntaxError.
+func Parse(input string) (*Query, error) {
+	if utf8.RuneCountInString(input) > MaxLength {
+		return nil, &SyntaxError{Column: MaxLength + 1, Message: fmt.Sprintf("query is longer than %d characters", MaxLength)}
+	}
+	if !utf8.ValidString(input) {
+		return nil, &SyntaxError{Column: 1, Message: "query isn't valid UTF-8"}
+	}
+
+	p := &parser{input: input}
+	query := &Query{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		if len(query.Terms) == MaxTerms {
+			return nil, p.fail(p.pos, "a query can have at most %d terms", MaxTerms)
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		query.Terms = append(query.Terms, term)
+	}
+	if len(query.Terms) == 0 {
+		return nil, &SyntaxError{Column: 1, Message: "query is empty"}
+	}
+	return query, nil
+}
+
+func (p *parser) term() (Term, error) {
+	start := p.pos
+	var term Term
+	if p.peek() == '-' {
+		term.Negated = true
+		p.pos++
+		if p.done() || unicode.IsSpace(p.peek()) {
+			return term, p.fail(start, "- has to come right before the term it leaves out")
+		}
+	}
+
+	if p.peek() == '"' {
+		phrase, err := p.quoted()
+		if err != nil {
+			return term, err
+		}
+		term.Value, term.Phrase = phrase, true
+		return term, p.endOfTerm()
+	}
+
+	wordStart := p.pos
+	word := p.word()
+	if p.done() || p.peek() != ':' {
+		if !p.done() && p.peek() == '"' {
+			return term, p.fail(p.pos, "quotes have to go around a whole word or field value")
+		}
+		if strings.EqualFold(word, IsArchived) {
+			term.Field, term.Value = FieldIs, IsArchived
+			return term, nil
+		}
+		return textTerm(p, term, word, wordStart)
+	}
+
+	// field:value
+	p.pos++
+	name := strings.ToLower(word)
+	if strings.HasPrefix(name, "prop.") {
+		term.Field, term.Name = FieldProp, word[len("prop."):]
+		if !propertyName.MatchString(term.Name) {
+			return term, p.fail(wordStart, "%q isn't a property name", term.Name)
+		}
+	} else {
+		term.Field = Field(name)
+	}
+
+	valueStart := p.pos
+	switch term.Field {
+	case FieldTitle, FieldContent, FieldTag, FieldFolder, FieldCreated, FieldUpdated, FieldHas, FieldIs, FieldProp:
+	case FieldText:
+		return term, p.fail(wordStart, "a value can't start with :")
+	default:
+		return term, p.fail(wordStart, "unknown field %q, put it in quotes to search for it as text", word)
+	}
+
+	if !p.done() && p.peek() == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return term, err
+		}
+		term.Value, term.Phrase = value, true
+		err = p.endOfTerm()
+		if err != nil {
+			return term, err
+		}
+	} else {
+		for !p.done() && !unicode.IsSpace(p.peek()) {
+			if p.peek() == '"' {
+				return term, p.fail(p.pos, "quotes have to go around a whole word or field value")
+			}
+			p.pos += utf8.RuneLen(p.peek())
+		}
+		term.Value = p.input[valueStart:p.pos]
+	}
+	if strings.TrimSpace(term.Value) == "" {
+		return term, p.fail(valueStart, "%s: needs a value", word)
+	}
+
+	return fieldTerm(p, term, valueStart)
+}
+
+func (p *parser) endOfTerm() error {
+	if !p.done() && !unicode.IsSpace(p.peek()) {
+		return p.fail(p.pos, "expected a space after the closing quote")
+	}
+	return nil
+}
+
+func textTerm(p *parser, term Term, word string, start int) (Term, error) {
+	term.Value = word
+	if strings.HasSuffix(word, "*") {
+		term.Value, term.Prefix = strings.TrimSuffix(word, "*"), true
+		if term.Value == "" || strings.Contains(term.Value, "*") {
+			return term, p.fail(start, "* can only end a word")
+		}
+	}
+	return term, nil
+}
+
+func fieldTerm(p *parser, term Term, start int) (Term, error) {
+	switch term.Field {
+	case FieldTitle, FieldContent:
+		return textTerm(p, term, term.Value, start)
+
+	case FieldTag:
+		term.Value = strings.TrimPrefix(term.Value, "#")
+
+	case FieldFolder:
+		term.Value = strings.Trim(term.Value, "/")
+		if term.Value == "" {
+			return term, p.fail(start, "folder: needs a folder")
+		}
+
+	case FieldHas:
+		value, ok := hasValues[strings.ToLower(term.Value)]
+		if !ok {
+			return term, p.fail(start, "has: takes attachment, task, reminder or link")
+		}
+		term.Value = value
+
+	case FieldIs:
+		value, ok := isValues[strings.ToLower(term.Value)]
+		if !ok {
+			return term, p.fail(start, "is: takes pinned, archived, favourite, shared or encrypted")
+		}
+		term.Value = value
+
+	case FieldCreated, FieldUpdated:
+		term.Op, term.Value = comparison(term.Value)
+		if _, err := time.Parse(DateLayout, term.Value); err != nil {
+			return term, p.fail(start, "%s: takes a date like 2006-01-02", term.Field)
+		}
+
+	case FieldProp:
+		term.Op, term.Value = comparison(term.Value)
+		if term.Value == "" {
+			return term, p.fail(start, "prop.%s: needs a value after %s", term.Name, term.Op)
+		}
+	}
+	return term, nil
+}
+
+// comparison splits a leading operator off value.
+func comparison(value string) (string, string) {
+	for _, op := range comparisons {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
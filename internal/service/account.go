@@ -0,0 +1,82 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vaporii/v8box/internal/avatar"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/security"
+)
+
+// OAuth accounts have no password to ask for again, so deleting one needs a
+// login this recent instead.
+const recentLoginWindow = 10 * time.Minute
+
+type AccountService interface {
+	DeleteAccount(claims dto.UserJwtPackage, request dto.DeleteAccountRequest) error
+}
+
+type accountService struct {
+	userRepo       repository.UserRepository
+	sessionService SessionService
+	exportService  ExportService
+	avatars        *avatar.Store
+}
+
+func NewAccountService(userRepo repository.UserRepository, sessionService SessionService, exportService ExportService, avatars *avatar.Store) AccountService {
+	return &accountService{
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		exportService:  exportService,
+		avatars:        avatars,
+	}
+}
+
+func (s *accountService) DeleteAccount(claims dto.UserJwtPackage, request dto.DeleteAccountRequest) error {
+	user, err := s.userRepo.GetUserById(claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &httperror.NotFoundError{Entity: "User"}
+		}
+		return err
+	}
+
+	if request.Confirm != user.Username {
+		return &httperror.BadClientRequestError{Message: "confirm must be your username"}
+	}
+
+	if user.Password != "" {
+		if !security.CheckPasswordHash(request.Password, user.Password) {
+			return &httperror.UnauthorizedError{Message: "Wrong password"}
+		}
+	} else if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time) > recentLoginWindow {
+		return &httperror.UnauthorizedError{Message: "Log in again to delete your account"}
+	}
+
+	now := time.Now()
+	err = s.userRepo.DeleteUser(user.ID, now)
+	if err != nil {
+		if errors.Is(err, repository.ErrSoleOwner) {
+			return &httperror.BadClientRequestError{Message: "You are the only owner of a workspace with other members, hand over ownership first"}
+		}
+		return err
+	}
+	s.sessionService.Revoked(user.ID, now)
+
+	// the account is gone either way, leftover files are only logged
+	err = s.exportService.DeleteUserExports(user.ID)
+	if err != nil {
+		logging.Error("err deleting exports of user %s: %v", user.ID, err)
+	}
+	err = s.avatars.Delete(user.ID)
+	if err != nil {
+		logging.Error("err deleting avatar of user %s: %v", user.ID, err)
+	}
+
+	return nil
+}
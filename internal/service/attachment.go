@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/vaporii/v8box/internal/keyed"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/storage"
+	"github.com/vaporii/v8box/internal/thumbnail"
+)
+
+type AttachmentService interface {
+	Upload(ctx context.Context, userId string, noteId string, filename string, r io.Reader) (*models.Attachment, error)
+	GetNoteAttachments(userId string, noteId string) ([]models.Attachment, error)
+	// Open returns the attachment and its contents. The caller closes the
+	// reader.
+	Open(ctx context.Context, userId string, noteId string, id string) (*models.Attachment, io.ReadSeekCloser, error)
+	// Thumbnail returns a preview of an image attachment at one of the
+	// configured sizes, or the smallest if size is 0.
+	Thumbnail(ctx context.Context, userId string, noteId string, id string, size int) (*models.Attachment, *os.File, string, error)
+	Delete(userId string, noteId string, id string) error
+	CollectGarbage(ctx context.Context) error
+	RunGarbageCollector(ctx context.Context, interval time.Duration)
+}
+
+type attachmentService struct {
+	attachmentRepo repository.AttachmentRepository
+	noteService    NoteService
+	store          storage.BlobStore
+	thumbnails     *thumbnail.Cache
+	// locked by digest while uploading and collecting, so a blob being
+	// attached again isn't removed between the existence check and the
+	// insert
+	blobLocks keyed.Mutex
+}
+
+func NewAttachmentService(attachmentRepo repository.AttachmentRepository, noteService NoteService, store storage.BlobStore, thumbnails *thumbnail.Cache) AttachmentService {
+	return &attachmentService{
+		attachmentRepo: attachmentRepo,
+		noteService:    noteService,
+		store:          store,
+		thumbnails:     thumbnails,
+	}
+}
+
+func (s *attachmentService) Upload(ctx context.Context, userId string, noteId string, filename string, r io.Reader) (*models.Attachment, error) {
+	_, err := s.authorize(userId, noteId, models.NoteRoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	filename = sanitizeFilename(filename)
+	if filename == "" {
+		return nil, &httperror.BadClientRequestError{Message: "Attachment needs a file name"}
+	}
+
+	// spool to disk first, the digest is the blob key and isn't known until
+	// the whole upload has been read
+	tmp, err := os.CreateTemp("", "v8box-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, &httperror.BadClientRequestError{Message: "Attachment is empty"}
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	mimeType, err := sniffMimeType(tmp, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.blobLocks.Lock(digest)
+	defer unlock()
+
+	exists, err := s.attachmentRepo.BlobExists(digest)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		_, err = tmp.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		err = s.store.Put(ctx, digest, tmp, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.attachmentRepo.CreateAttachment(&models.Attachment{
+		ID:       uuid.NewString(),
+		NoteID:   noteId,
+		UserID:   userId,
+		Filename: filename,
+		MimeType: mimeType,
+		Size:     size,
+		SHA256:   digest,
+	})
+}
+
+func (s *attachmentService) GetNoteAttachments(userId string, noteId string) ([]models.Attachment, error) {
+	_, err := s.authorize(userId, noteId, models.NoteRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.attachmentRepo.GetNoteAttachments(noteId)
+}
+
+func (s *attachmentService) Open(ctx context.Context, userId string, noteId string, id string) (*models.Attachment, io.ReadSeekCloser, error) {
+	attachment, err := s.getAttachment(userId, noteId, id, models.NoteRoleViewer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blob, err := s.store.Open(ctx, attachment.SHA256)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			logging.Error("blob %s for attachment %s is missing from storage", attachment.SHA256, attachment.ID)
+			return nil, nil, &httperror.NotFoundError{Entity: "Attachment"}
+		}
+		return nil, nil, err
+	}
+
+	return attachment, blob, nil
+}
+
+func (s *attachmentService) Thumbnail(ctx context.Context, userId string, noteId string, id string, size int) (*models.Attachment, *os.File, string, error) {
+	if size == 0 {
+		size = s.thumbnails.Sizes()[0]
+	}
+	if !s.thumbnails.ValidSize(size) {
+		return nil, nil, "", &httperror.BadClientRequestError{Message: fmt.Sprintf("Thumbnail size must be one of %v", s.thumbnails.Sizes())}
+	}
+
+	attachment, err := s.getAttachment(userId, noteId, id, models.NoteRoleViewer)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(attachment.MimeType)
+	if !thumbnail.Supports(mediaType) {
+		return nil, nil, "", &httperror.BadClientRequestError{Message: "Thumbnails are only available for JPEG, PNG, GIF and WebP images"}
+	}
+
+	path, mimeType, err := s.thumbnails.Get(attachment.SHA256, size, func() (io.ReadSeekCloser, error) {
+		return s.store.Open(ctx, attachment.SHA256)
+	})
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrUnsupported) {
+			return nil, nil, "", &httperror.BadClientRequestError{Message: "Attachment couldn't be read as an image"}
+		}
+		if errors.Is(err, thumbnail.ErrTooLarge) {
+			return nil, nil, "", &httperror.BadClientRequestError{Message: "Image is too large to preview"}
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, "", &httperror.NotFoundError{Entity: "Attachment"}
+		}
+		return nil, nil, "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return attachment, file, mimeType, nil
+}
+
+// Delete removes the attachment from the note. The blob stays until the
+// garbage collector finds nothing else uses it.
+func (s *attachmentService) Delete(userId string, noteId string, id string) error {
+	_, err := s.getAttachment(userId, noteId, id, models.NoteRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	return s.attachmentRepo.DeleteAttachment(id)
+}
+
+// CollectGarbage drops attachments of deleted notes and then removes blobs
+// no attachment refers to anymore.
+func (s *attachmentService) CollectGarbage(ctx context.Context) error {
+	orphaned, err := s.attachmentRepo.DeleteOrphanedAttachments()
+	if err != nil {
+		return err
+	}
+	if orphaned > 0 {
+		logging.Info("removed %d attachments of deleted notes", orphaned)
+	}
+
+	blobs, err := s.attachmentRepo.GetUnreferencedBlobs()
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range blobs {
+		err = s.deleteBlob(ctx, digest)
+		if err != nil {
+			return err
+		}
+	}
+	if len(blobs) > 0 {
+		logging.Info("removed %d unreferenced blobs", len(blobs))
+	}
+
+	return nil
+}
+
+// deleteBlob removes the blob unless an upload attached it again since it
+// was found unreferenced.
+func (s *attachmentService) deleteBlob(ctx context.Context, digest string) error {
+	unlock := s.blobLocks.Lock(digest)
+	defer unlock()
+
+	deleted, err := s.attachmentRepo.DeleteBlob(digest)
+	if err != nil || !deleted {
+		return err
+	}
+	// if this fails the file is left behind, but nothing points at it and a
+	// later upload of the same content overwrites it
+	err = s.store.Delete(ctx, digest)
+	if err != nil {
+		logging.Error("err deleting blob %s: %v", digest, err)
+	}
+	err = s.thumbnails.Delete(digest)
+	if err != nil {
+		logging.Error("err deleting thumbnails of blob %s: %v", digest, err)
+	}
+	return nil
+}
+
+func (s *attachmentService) RunGarbageCollector(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.CollectGarbage(ctx)
+			if err != nil {
+				logging.Error("err collecting unreferenced blobs: %v", err)
+			}
+		}
+	}
+}
+
+func (s *attachmentService) authorize(userId string, noteId string, required models.NoteRole) (*models.Note, error) {
+	note, err := s.noteService.GetNoteByID(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+	if !note.Role.Allows(required) {
+		return nil, &httperror.ForbiddenError{Message: "You need " + string(required) + " access to this note to do that"}
+	}
+	return note, nil
+}
+
+func (s *attachmentService) getAttachment(userId string, noteId string, id string, required models.NoteRole) (*models.Attachment, error) {
+	_, err := s.authorize(userId, noteId, required)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := s.attachmentRepo.GetAttachment(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Attachment"}
+		}
+		return nil, err
+	}
+	if attachment.NoteID != noteId {
+		return nil, &httperror.NotFoundError{Entity: "Attachment"}
+	}
+
+	return attachment, nil
+}
+
+// attachmentURL is what note content links to an attachment with.
+func noteURL(baseURL string, noteId string) string {
+	return baseURL + "/api/v1/me/note/" + noteId
+}
+
+func attachmentURL(baseURL string, noteId string, attachmentId string) string {
+	return noteURL(baseURL, noteId) + "/attachments/" + attachmentId
+}
+
+// sniffMimeType looks at the content first. The extension is only trusted
+// when the content doesn't say anything more specific.
+func sniffMimeType(f io.ReadSeeker, filename string) (string, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	mimeType := http.DetectContentType(head[:n])
+	if mimeType == "application/octet-stream" || strings.HasPrefix(mimeType, "text/plain") {
+		if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" && !strings.HasPrefix(byExt, "text/html") {
+			mimeType = byExt
+		}
+	}
+
+	return mimeType, nil
+}
+
+func sanitizeFilename(filename string) string {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+	if filename == "." || filename == "/" {
+		return ""
+	}
+	if len(filename) > 255 {
+		ext := filepath.Ext(filename)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		filename = strings.ToValidUTF8(filename[:255-len(ext)], "") + ext
+	}
+	return filename
+}
@@ -1,18 +1,24 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/avatar"
 	"github.com/vaporii/v8box/internal/config"
 	"github.com/vaporii/v8box/internal/config/provider"
 	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/logging"
 	"github.com/vaporii/v8box/internal/models"
 	githubprovider "github.com/vaporii/v8box/internal/models/github_provider"
 	"github.com/vaporii/v8box/internal/repository"
@@ -28,12 +34,14 @@ type AuthService interface {
 
 type authService struct {
 	userRepo repository.UserRepository
+	avatars  *avatar.Store
 	conf     config.Config
 }
 
-func NewAuthService(userRepo repository.UserRepository, conf config.Config) AuthService {
+func NewAuthService(userRepo repository.UserRepository, avatars *avatar.Store, conf config.Config) AuthService {
 	return &authService{
 		userRepo: userRepo,
+		avatars:  avatars,
 		conf:     conf,
 	}
 }
@@ -87,30 +95,45 @@ func (r *authService) GetGitHubOAuthJwt(ctx context.Context, code string) (dto.U
 	dbUser, err := r.userRepo.GetUserByOAuthKey(fmt.Sprintf("github_%d", user.ID))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			user := &models.User{
-				ID:        uuid.NewString(),
-				Username:  user.Login,
-				OAuthKey:  fmt.Sprintf("github_%d", user.ID),
-				AvatarURL: user.AvatarURL,
+			username := user.Login
+			// usernames can be changed, so a local user may already have it
+			if _, err := r.userRepo.GetUserByUsername(username); err == nil {
+				username = fmt.Sprintf("%s-%d", user.Login, user.ID)
 			}
 
-			err = r.userRepo.CreateUser(user)
+			newUser := &models.User{
+				ID:       uuid.NewString(),
+				Username: username,
+				OAuthKey: fmt.Sprintf("github_%d", user.ID),
+			}
+			if user.Name != nil {
+				newUser.DisplayName = *user.Name
+			}
+
+			err = r.userRepo.CreateUser(newUser)
 			if err != nil {
 				return dto.UserJwtPackage{}, err
 			}
-			dbUser = user
+			dbUser = newUser
 		} else {
 			return dto.UserJwtPackage{}, err
 		}
 	}
 
+	err = r.syncGitHubAvatar(ctx, dbUser, user.AvatarURL)
+	if err != nil {
+		// not worth failing the login over, the identicon is shown instead
+		logging.Warning("err fetching github avatar of user %s: %v", dbUser.ID, err)
+	}
+
 	claims := dto.UserJwtPackage{
 		Username:  dbUser.Username,
 		UserID:    dbUser.ID,
-		AvatarURL: dbUser.AvatarURL,
+		AvatarURL: avatarURL(r.conf, dbUser.ID),
 		OAuthKey:  fmt.Sprintf("github_%d", user.ID),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    r.conf.Issuer,
 		},
 	}
@@ -122,3 +145,54 @@ func (r *authService) CreateJWT(claims dto.UserJwtPackage) (string, error) {
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return t.SignedString([]byte(r.conf.JwtSecret))
 }
+
+// syncGitHubAvatar downloads the user's GitHub avatar when it changed since
+// the last login. Avatars the user uploaded themselves are left alone.
+func (r *authService) syncGitHubAvatar(ctx context.Context, user *models.User, remoteURL string) error {
+	if remoteURL == "" || user.AvatarSource == models.AvatarSourceUpload {
+		return nil
+	}
+	if user.AvatarSource == models.AvatarSourceGitHub && user.AvatarSourceURL == remoteURL && r.avatars.Exists(user.ID) {
+		return nil
+	}
+
+	parsed, err := url.Parse(remoteURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("refusing non-https avatar url %q", remoteURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("avatar download returned %s", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, avatar.MaxSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > avatar.MaxSize {
+		return errors.New("avatar is too large")
+	}
+
+	err = r.avatars.Save(user.ID, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return r.userRepo.UpdateAvatar(user.ID, models.AvatarSourceGitHub, remoteURL)
+}
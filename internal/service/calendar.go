@@ -0,0 +1,200 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/vaporii/v8box/internal/checklist"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/ical"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/security"
+)
+
+// iCalendar PRIORITY values, 1 is the highest
+var taskPriorities = map[string]string{
+	string(checklist.PriorityHigh):   "1",
+	string(checklist.PriorityMedium): "5",
+	string(checklist.PriorityLow):    "9",
+}
+
+// CalendarService publishes a user's reminders and task due dates as an
+// iCalendar feed that calendar apps can subscribe to.
+type CalendarService interface {
+	GetFeed(userId string) (*models.CalendarFeed, error)
+	// RegenerateToken gives the user's feed a new URL. Subscriptions to the
+	// old one stop working.
+	RegenerateToken(userId string) (*models.CalendarFeed, error)
+	DeleteFeed(userId string) error
+	// RenderFeed returns the calendar behind a feed token.
+	RenderFeed(token string) ([]byte, error)
+}
+
+type calendarService struct {
+	calendarRepo repository.CalendarRepository
+	reminderRepo repository.ReminderRepository
+	taskRepo     repository.TaskRepository
+	noteService  NoteService
+	conf         config.Config
+}
+
+func NewCalendarService(calendarRepo repository.CalendarRepository, reminderRepo repository.ReminderRepository, taskRepo repository.TaskRepository, noteService NoteService, conf config.Config) CalendarService {
+	return &calendarService{
+		calendarRepo: calendarRepo,
+		reminderRepo: reminderRepo,
+		taskRepo:     taskRepo,
+		noteService:  noteService,
+		conf:         conf,
+	}
+}
+
+func (s *calendarService) GetFeed(userId string) (*models.CalendarFeed, error) {
+	feed, err := s.calendarRepo.GetFeed(userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Calendar feed"}
+		}
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (s *calendarService) RegenerateToken(userId string) (*models.CalendarFeed, error) {
+	token := security.GenerateToken()
+	feed, err := s.calendarRepo.SetFeed(userId, security.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	feed.URL = s.conf.URL + "/api/v1/public/calendar/" + token + ".ics"
+	return feed, nil
+}
+
+func (s *calendarService) DeleteFeed(userId string) error {
+	_, err := s.GetFeed(userId)
+	if err != nil {
+		return err
+	}
+	return s.calendarRepo.DeleteFeed(userId)
+}
+
+func (s *calendarService) RenderFeed(token string) ([]byte, error) {
+	feed, err := s.calendarRepo.GetFeedByTokenHash(security.HashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Calendar feed"}
+		}
+		return nil, err
+	}
+
+	reminders, err := s.reminderRepo.GetUserReminders(feed.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.taskRepo.GetUserTasks(feed.UserID, models.TaskFilter{Status: models.TaskStatusAll})
+	if err != nil {
+		return nil, err
+	}
+
+	// reminders can outlive access to their note, those are left out
+	titles := make(map[string]string)
+	locations := make(map[string]*time.Location)
+	events := make([]models.Reminder, 0, len(reminders))
+	for _, reminder := range reminders {
+		if _, ok := titles[reminder.NoteID]; !ok {
+			note, err := s.noteService.GetNoteByID(feed.UserID, reminder.NoteID)
+			var notFound *httperror.NotFoundError
+			if errors.As(err, &notFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			titles[note.ID] = note.Title
+		}
+
+		location, err := time.LoadLocation(reminder.Timezone)
+		if err != nil {
+			logging.Warning("reminder %s has unknown timezone %q", reminder.ID, reminder.Timezone)
+			location = time.UTC
+		}
+		locations[location.String()] = location
+		reminder.At = reminder.At.In(location)
+		events = append(events, reminder)
+	}
+
+	now := time.Now()
+	var w ical.Writer
+	w.Begin("VCALENDAR")
+	w.Raw("VERSION", "2.0")
+	w.Raw("PRODID", "-//v8box//Calendar feed//EN")
+	w.Raw("CALSCALE", "GREGORIAN")
+	w.Raw("METHOD", "PUBLISH")
+	w.Text("X-WR-CALNAME", "v8box")
+	w.Raw("REFRESH-INTERVAL;VALUE=DURATION", "PT15M")
+	w.Raw("X-PUBLISHED-TTL", "PT15M")
+
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Timezone(locations[name], now.Year())
+	}
+
+	for _, reminder := range events {
+		title := titles[reminder.NoteID]
+		w.Begin("VEVENT")
+		w.Raw("UID", "reminder-"+reminder.ID+"@v8box")
+		w.UTC("DTSTAMP", now)
+		w.UTC("CREATED", reminder.CreatedAt)
+		w.Local("DTSTART", reminder.At)
+		if reminder.RRule != "" {
+			w.Raw("RRULE", reminder.RRule)
+		}
+		w.Text("SUMMARY", title)
+		if reminder.Message != "" {
+			w.Text("DESCRIPTION", reminder.Message)
+		}
+		w.Raw("URL", noteURL(s.conf.URL, reminder.NoteID))
+		w.Begin("VALARM")
+		w.Raw("ACTION", "DISPLAY")
+		w.Raw("TRIGGER", "PT0S")
+		w.Text("DESCRIPTION", title)
+		w.End("VALARM")
+		w.End("VEVENT")
+	}
+
+	for _, task := range tasks {
+		due, err := time.Parse(checklist.DateLayout, task.Due)
+		if err != nil {
+			continue
+		}
+		w.Begin("VTODO")
+		// task ids come from the note and the task's text, so they stay
+		// put while the task does
+		w.Raw("UID", "task-"+task.ID+"@v8box")
+		w.UTC("DTSTAMP", now)
+		w.Date("DUE", due)
+		w.Text("SUMMARY", task.Text)
+		w.Text("DESCRIPTION", task.NoteTitle)
+		if task.Done {
+			w.Raw("STATUS", "COMPLETED")
+		} else {
+			w.Raw("STATUS", "NEEDS-ACTION")
+		}
+		if priority, ok := taskPriorities[task.Priority]; ok {
+			w.Raw("PRIORITY", priority)
+		}
+		w.Raw("URL", noteURL(s.conf.URL, task.NoteID))
+		w.End("VTODO")
+	}
+
+	w.End("VCALENDAR")
+	return w.Bytes(), nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"github.com/vaporii/v8box/internal/keyed"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const (
+	dailyDateLayout  = "2006-01-02"
+	dailyMonthLayout = "2006-01"
+)
+
+// DailyService keeps a journal of one note per day. A day's note is the
+// user's personal note in their daily folder titled with the date, so daily
+// notes are ordinary notes that can be found, edited and exported as such.
+type DailyService interface {
+	// GetDailyNote returns the note for date, or for today in the user's
+	// timezone if date is "today", creating it if it doesn't exist yet.
+	GetDailyNote(userId string, date string) (*models.DailyNote, error)
+	// GetCalendar lists the days of month that have a note, the current
+	// month if it's empty.
+	GetCalendar(userId string, month string) (*models.DailyCalendar, error)
+}
+
+type dailyService struct {
+	noteRepo        repository.NoteRepository
+	noteService     NoteService
+	templateService TemplateService
+	userService     UserService
+	// locked by user, keeps two requests for a new day from both creating
+	// its note
+	createLocks keyed.Mutex
+}
+
+func NewDailyService(noteRepo repository.NoteRepository, noteService NoteService, templateService TemplateService, userService UserService) DailyService {
+	return &dailyService{
+		noteRepo:        noteRepo,
+		noteService:     noteService,
+		templateService: templateService,
+		userService:     userService,
+	}
+}
+
+func (s *dailyService) GetDailyNote(userId string, date string) (*models.DailyNote, error) {
+	settings := s.userService.GetSettings(userId)
+	now := time.Now().In(settings.Location())
+
+	day := now
+	if date != "today" {
+		var err error
+		day, err = time.ParseInLocation(dailyDateLayout, date, settings.Location())
+		if err != nil {
+			return nil, &httperror.BadClientRequestError{Message: "date must be a date like 2026-10-19 or today"}
+		}
+	}
+	date = day.Format(dailyDateLayout)
+
+	unlock := s.createLocks.Lock(userId)
+	defer unlock()
+
+	notes, err := s.dailyNotes(userId, settings.DailyFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	daily := &models.DailyNote{Date: date}
+	if id, ok := notes[date]; ok {
+		daily.Note, err = s.noteService.GetNoteByID(userId, id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// {{time}} in the template is the time of day it was created at
+		at := time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, now.Location())
+		daily.Note, err = s.create(userId, date, settings, at)
+		if err != nil {
+			return nil, err
+		}
+		daily.Created = true
+		notes[date] = daily.Note.ID
+	}
+
+	days := make([]string, 0, len(notes))
+	for day := range notes {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	i := sort.SearchStrings(days, date)
+	if i > 0 {
+		daily.Previous = days[i-1]
+	}
+	if i+1 < len(days) {
+		daily.Next = days[i+1]
+	}
+
+	return daily, nil
+}
+
+func (s *dailyService) GetCalendar(userId string, month string) (*models.DailyCalendar, error) {
+	settings := s.userService.GetSettings(userId)
+	if month == "" {
+		month = time.Now().In(settings.Location()).Format(dailyMonthLayout)
+	}
+	if _, err := time.Parse(dailyMonthLayout, month); err != nil {
+		return nil, &httperror.BadClientRequestError{Message: "month must be a month like 2026-10"}
+	}
+
+	notes, err := s.dailyNotes(userId, settings.DailyFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &models.DailyCalendar{
+		Month: month,
+		Days:  make([]models.DailyEntryDay, 0),
+	}
+	for date, id := range notes {
+		if strings.HasPrefix(date, month+"-") {
+			calendar.Days = append(calendar.Days, models.DailyEntryDay{Date: date, NoteID: id})
+		}
+	}
+	sort.Slice(calendar.Days, func(i, j int) bool {
+		return calendar.Days[i].Date < calendar.Days[j].Date
+	})
+
+	return calendar, nil
+}
+
+// dailyNotes finds the ids of the user's daily notes by date. If a day
+// somehow has more than one, the oldest counts.
+func (s *dailyService) dailyNotes(userId string, folder string) (map[string]string, error) {
+	days, err := s.noteRepo.GetDatedNotes(userId, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	daily := make(map[string]string)
+	for _, day := range days {
+		// the query only matched the shape of a date
+		if _, err := time.Parse(dailyDateLayout, day.Date); err != nil {
+			continue
+		}
+		if _, ok := daily[day.Date]; !ok {
+			daily[day.Date] = day.NoteID
+		}
+	}
+	return daily, nil
+}
+
+func (s *dailyService) create(userId string, date string, settings models.UserSettings, at time.Time) (*models.Note, error) {
+	folder := settings.DailyFolder
+	request := dto.CreateNoteRequest{
+		UserID: userId,
+		Title:  date,
+		Folder: &folder,
+	}
+
+	if settings.DailyTemplateID != "" {
+		request.TemplateID = settings.DailyTemplateID
+		err := s.templateService.ApplyTemplate(userId, &request, at)
+		var notFound *httperror.NotFoundError
+		if errors.As(err, &notFound) {
+			// the template was deleted or is in a workspace the user left
+			logging.Warning("daily template %s of user %s is gone, starting a blank note", settings.DailyTemplateID, userId)
+			request.Content = ""
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.noteService.Create(request)
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/storage"
+	"github.com/vaporii/v8box/internal/vault"
+)
+
+type ExportService interface {
+	// RequestExport returns the user's current export, queueing a new one
+	// if there is none or refresh is set.
+	RequestExport(userId string, refresh bool) (*models.Export, error)
+	OpenExport(userId string, id string) (*models.Export, *os.File, error)
+	DeleteUserExports(userId string) error
+	// Run works through queued exports until ctx is done.
+	Run(ctx context.Context)
+}
+
+type exportService struct {
+	exportRepo     repository.ExportRepository
+	noteRepo       repository.NoteRepository
+	attachmentRepo repository.AttachmentRepository
+	keyRepo        repository.KeyRepository
+	userService    UserService
+	store          storage.BlobStore
+	conf           config.Config
+	wake           chan struct{}
+}
+
+func NewExportService(exportRepo repository.ExportRepository, noteRepo repository.NoteRepository, attachmentRepo repository.AttachmentRepository, keyRepo repository.KeyRepository, userService UserService, store storage.BlobStore, conf config.Config) ExportService {
+	return &exportService{
+		exportRepo:     exportRepo,
+		noteRepo:       noteRepo,
+		attachmentRepo: attachmentRepo,
+		keyRepo:        keyRepo,
+		userService:    userService,
+		store:          store,
+		conf:           conf,
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+func (s *exportService) RequestExport(userId string, refresh bool) (*models.Export, error) {
+	latest, err := s.exportRepo.GetLatestExport(userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if latest != nil {
+		inProgress := latest.Status == models.ExportStatusPending || latest.Status == models.ExportStatusRunning
+		usable := latest.Status == models.ExportStatusDone && latest.ExpiresAt != nil && time.Now().Before(*latest.ExpiresAt)
+		// a running export can't be refreshed, it would only be repeated
+		if inProgress || (usable && !refresh) {
+			return s.withURL(latest), nil
+		}
+	}
+
+	export, err := s.exportRepo.CreateExport(&models.Export{
+		ID:     uuid.NewString(),
+		UserID: userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return export, nil
+}
+
+func (s *exportService) OpenExport(userId string, id string) (*models.Export, *os.File, error) {
+	export, err := s.exportRepo.GetExport(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, &httperror.NotFoundError{Entity: "Export"}
+		}
+		return nil, nil, err
+	}
+	if export.UserID != userId {
+		return nil, nil, &httperror.NotFoundError{Entity: "Export"}
+	}
+	if export.Status != models.ExportStatusDone || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return nil, nil, &httperror.NotFoundError{Entity: "Export"}
+	}
+
+	file, err := os.Open(s.path(export))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, &httperror.NotFoundError{Entity: "Export"}
+		}
+		return nil, nil, err
+	}
+
+	return export, file, nil
+}
+
+func (s *exportService) DeleteUserExports(userId string) error {
+	if userId == "" || filepath.Base(userId) != userId {
+		return fmt.Errorf("invalid user id %q", userId)
+	}
+	return os.RemoveAll(filepath.Join(s.conf.ExportPath, userId))
+}
+
+func (s *exportService) Run(ctx context.Context) {
+	reset, err := s.exportRepo.ResetRunningExports()
+	if err != nil {
+		logging.Error("err requeueing interrupted exports: %v", err)
+	}
+	if reset > 0 {
+		logging.Info("requeued %d interrupted exports", reset)
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		s.processPending(ctx)
+		s.removeExpired()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *exportService) processPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		export, err := s.exportRepo.ClaimPendingExport()
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				logging.Error("err claiming export: %v", err)
+			}
+			return
+		}
+
+		logging.Info("building export %s", export.ID)
+		size, err := s.build(ctx, export)
+		if err != nil {
+			logging.Error("err building export %s: %v", export.ID, err)
+			err = s.exportRepo.FailExport(export.ID, "Export failed, please try again")
+			if err != nil {
+				logging.Error("err marking export %s failed: %v", export.ID, err)
+			}
+			continue
+		}
+
+		err = s.exportRepo.CompleteExport(export.ID, size, time.Now().Add(s.conf.ExportTTL))
+		if err != nil {
+			logging.Error("err completing export %s: %v", export.ID, err)
+		}
+
+		// the account may have been deleted while the export was built
+		if _, err = s.exportRepo.GetExport(export.ID); errors.Is(err, sql.ErrNoRows) {
+			os.Remove(s.path(export))
+		}
+	}
+}
+
+func (s *exportService) removeExpired() {
+	exports, err := s.exportRepo.GetExpiredExports(time.Now())
+	if err != nil {
+		logging.Error("err finding expired exports: %v", err)
+		return
+	}
+
+	for _, export := range exports {
+		err = os.Remove(s.path(&export))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logging.Error("err removing export %s: %v", export.ID, err)
+			continue
+		}
+		err = s.exportRepo.DeleteExport(export.ID)
+		if err != nil {
+			logging.Error("err deleting export %s: %v", export.ID, err)
+		}
+	}
+}
+
+// build writes the ZIP next to its final name and moves it in place once
+// complete, so a download never sees half an archive.
+func (s *exportService) build(ctx context.Context, export *models.Export) (int64, error) {
+	final := s.path(export)
+	err := os.MkdirAll(filepath.Dir(final), 0o750)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(final), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = s.writeArchive(ctx, zip.NewWriter(tmp), export.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	stat, err := tmp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), os.Rename(tmp.Name(), final)
+}
+
+// writeArchive lays the export out as:
+//
+//	profile.json
+//	notes.json
+//	notes/<folder>/<title>.md
+//	attachments/<note id>/<filename>
+func (s *exportService) writeArchive(ctx context.Context, archive *zip.Writer, userId string) error {
+	user, err := s.userService.GetUser(userId)
+	if err != nil {
+		return err
+	}
+
+	notes, err := s.noteRepo.GetAuthoredNotes(userId)
+	if err != nil {
+		return err
+	}
+	// encrypted notes are only any use with their keys, which are as
+	// wrapped here as they are on the server
+	err = setWrappedKeys(s.keyRepo, userId, notes)
+	if err != nil {
+		return err
+	}
+
+	err = writeZipJSON(archive, "profile.json", user)
+	if err != nil {
+		return err
+	}
+	key, err := s.keyRepo.GetUserKey(userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if key != nil {
+		err = writeZipJSON(archive, "key.json", key)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeZipJSON(archive, "notes.json", notes)
+	if err != nil {
+		return err
+	}
+
+	// the same layout as a Markdown export, so it can be imported again
+	notesWriter := vault.NewWriter(archive, "notes")
+	for _, note := range notes {
+		err = notesWriter.Add(note)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, note := range notes {
+		attachments, err := s.attachmentRepo.GetNoteAttachments(note.ID)
+		if err != nil {
+			return err
+		}
+
+		usedNames := make(map[string]bool)
+		for _, attachment := range attachments {
+			ext := path.Ext(attachment.Filename)
+			name := vault.UniqueName(usedNames, "attachments/"+note.ID+"/"+strings.TrimSuffix(attachment.Filename, ext), ext)
+
+			err = s.writeAttachment(ctx, archive, name, attachment)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+func (s *exportService) writeAttachment(ctx context.Context, archive *zip.Writer, name string, attachment models.Attachment) error {
+	blob, err := s.store.Open(ctx, attachment.SHA256)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			logging.Warning("blob of attachment %s is missing, leaving it out of the export", attachment.ID)
+			return nil
+		}
+		return err
+	}
+	defer blob.Close()
+
+	// most attachments are already compressed
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: attachment.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, blob)
+	return err
+}
+
+func (s *exportService) path(export *models.Export) string {
+	return filepath.Join(s.conf.ExportPath, export.UserID, export.ID+".zip")
+}
+
+func (s *exportService) withURL(export *models.Export) *models.Export {
+	if export.Status == models.ExportStatusDone {
+		export.DownloadURL = s.conf.URL + "/api/v1/me/export/" + export.ID + "/download"
+	}
+	return export
+}
+
+func writeZipJSON(archive *zip.Writer, name string, v any) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
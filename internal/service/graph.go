@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+// GraphService answers questions about how notes link to each other. The
+// links themselves are kept up to date by the note service as notes are
+// saved.
+type GraphService interface {
+	// GetBacklinks lists the notes the user can see that link to the note.
+	GetBacklinks(userId string, id string) ([]models.Note, error)
+	// GetGraph covers the user's personal notes and the notes shared with
+	// them, or a workspace's notes if workspaceId is set.
+	GetGraph(userId string, workspaceId string) (*models.NoteGraph, error)
+}
+
+type graphService struct {
+	linkRepo         repository.NoteLinkRepository
+	noteService      NoteService
+	workspaceService WorkspaceService
+}
+
+func NewGraphService(linkRepo repository.NoteLinkRepository, noteService NoteService, workspaceService WorkspaceService) GraphService {
+	return &graphService{
+		linkRepo:         linkRepo,
+		noteService:      noteService,
+		workspaceService: workspaceService,
+	}
+}
+
+func (s *graphService) GetBacklinks(userId string, id string) ([]models.Note, error) {
+	_, err := s.noteService.GetNoteByID(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := s.linkRepo.GetBacklinks(id)
+	if err != nil {
+		return nil, err
+	}
+
+	notes := make([]models.Note, 0, len(links))
+	seen := make(map[string]bool)
+	for _, link := range links {
+		// linked both by title and by id
+		if seen[link.SourceID] {
+			continue
+		}
+		seen[link.SourceID] = true
+
+		note, err := s.noteService.GetNoteByID(userId, link.SourceID)
+		var notFound *httperror.NotFoundError
+		if errors.As(err, &notFound) {
+			// linked from a note the user can't see
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *note)
+	}
+
+	return notes, nil
+}
+
+func (s *graphService) GetGraph(userId string, workspaceId string) (*models.NoteGraph, error) {
+	var notes []models.Note
+	if workspaceId != "" {
+		workspaceNotes, err := s.workspaceService.GetWorkspaceNotes(userId, workspaceId, dto.NoteListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		notes = workspaceNotes
+	} else {
+		personal, err := s.noteService.GetUserNotes(userId, dto.NoteListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		shared, err := s.noteService.GetSharedNotes(userId, dto.NoteListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		notes = append(personal, shared...)
+	}
+
+	graph := &models.NoteGraph{
+		Nodes:    make([]models.GraphNode, 0, len(notes)),
+		Edges:    make([]models.GraphEdge, 0),
+		Dangling: make([]models.NoteLink, 0),
+	}
+	ids := make([]string, 0, len(notes))
+	included := make(map[string]bool)
+	for _, note := range notes {
+		if included[note.ID] {
+			continue
+		}
+		included[note.ID] = true
+		ids = append(ids, note.ID)
+		graph.Nodes = append(graph.Nodes, models.GraphNode{
+			ID:     note.ID,
+			Title:  note.Title,
+			Folder: note.Folder,
+			Tags:   note.Tags,
+		})
+	}
+
+	links, err := s.linkRepo.GetLinks(ids)
+	if err != nil {
+		return nil, err
+	}
+	edges := make(map[models.GraphEdge]bool)
+	for _, link := range links {
+		edge := models.GraphEdge{Source: link.SourceID, Target: link.TargetID}
+		switch {
+		case link.TargetID == "":
+			graph.Dangling = append(graph.Dangling, link)
+		case included[link.TargetID] && !edges[edge]:
+			edges[edge] = true
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+
+	return graph, nil
+}
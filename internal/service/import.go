@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/importer"
+	"github.com/vaporii/v8box/internal/logging"
+)
+
+// ImportService brings in notes exported from other apps. Notes are created
+// through the note and attachment services, so the same checks apply as
+// when they're written by hand.
+type ImportService interface {
+	// ImportENEX reads an Evernote export. ENEX has no notebooks, so every
+	// note goes in folder.
+	ImportENEX(ctx context.Context, userId string, workspaceId string, folder string, r io.Reader) (*dto.ImportReport, error)
+	ImportJEX(ctx context.Context, userId string, workspaceId string, r io.Reader) (*dto.ImportReport, error)
+}
+
+type importService struct {
+	noteService       NoteService
+	attachmentService AttachmentService
+	conf              config.Config
+}
+
+func NewImportService(noteService NoteService, attachmentService AttachmentService, conf config.Config) ImportService {
+	return &importService{
+		noteService:       noteService,
+		attachmentService: attachmentService,
+		conf:              conf,
+	}
+}
+
+func (s *importService) ImportENEX(ctx context.Context, userId string, workspaceId string, folder string, r io.Reader) (*dto.ImportReport, error) {
+	dir, err := os.MkdirTemp("", "v8box-enex-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	return s.run(ctx, userId, workspaceId, importer.NewENEXReader(r, dir), func(note *importer.Note) {
+		note.Folder = folder
+	})
+}
+
+func (s *importService) ImportJEX(ctx context.Context, userId string, workspaceId string, r io.Reader) (*dto.ImportReport, error) {
+	dir, err := os.MkdirTemp("", "v8box-jex-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	reader, err := importer.NewJEXReader(r, dir)
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	return s.run(ctx, userId, workspaceId, reader, nil)
+}
+
+func (s *importService) run(ctx context.Context, userId string, workspaceId string, reader importer.Reader, prepare func(note *importer.Note)) (*dto.ImportReport, error) {
+	report := &dto.ImportReport{Results: make([]dto.ImportResult, 0)}
+	for {
+		note, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		var noteErr *importer.NoteError
+		if errors.As(err, &noteErr) {
+			report.Add(dto.ImportResult{Path: noteErr.Source, Status: dto.ImportStatusFailed, Message: noteErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			if len(report.Results) == 0 {
+				return nil, readError(err)
+			}
+			// notes already created stay, the report says where it stopped
+			report.Add(dto.ImportResult{Status: dto.ImportStatusFailed, Message: readError(err).Error() + ", the rest of the archive was skipped"})
+			return report, nil
+		}
+
+		if prepare != nil {
+			prepare(note)
+		}
+		result, err := s.importNote(ctx, userId, workspaceId, note)
+		if err != nil {
+			return nil, err
+		}
+		report.Add(result)
+	}
+}
+
+// importNote creates the note, then uploads its resources and points the
+// content at them. Only errors that stop the whole import are returned.
+func (s *importService) importNote(ctx context.Context, userId string, workspaceId string, note *importer.Note) (dto.ImportResult, error) {
+	result := dto.ImportResult{Path: note.Source, Status: dto.ImportStatusFailed}
+
+	title := note.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	request := dto.CreateNoteRequest{
+		Title:       title,
+		UserID:      userId,
+		WorkspaceID: workspaceId,
+		Content:     note.Content,
+		Tags:        note.Tags,
+		Folder:      &note.Folder,
+		CreatedAt:   note.Created,
+		UpdatedAt:   note.Updated,
+	}
+	if request.UpdatedAt.IsZero() {
+		request.UpdatedAt = request.CreatedAt
+	}
+	// the update time is set by the edit adding the links, and has to
+	// differ from the one set here for that to stick
+	if len(note.Resources) > 0 {
+		request.UpdatedAt = time.Time{}
+	}
+
+	created, err := s.noteService.Create(request)
+	if err != nil {
+		return noteResult(result, err)
+	}
+	result.Status = dto.ImportStatusCreated
+	result.NoteID = created.ID
+	if len(note.Resources) == 0 {
+		return result, nil
+	}
+
+	content := note.Content
+	for _, resource := range note.Resources {
+		link, err := s.upload(ctx, userId, created.ID, resource)
+		if err != nil {
+			var badRequest *httperror.BadClientRequestError
+			if !errors.As(err, &badRequest) {
+				return result, err
+			}
+			result.Warnings = append(result.Warnings, resource.Filename+": "+badRequest.Message)
+			link = ""
+		}
+		content = strings.ReplaceAll(content, resource.Ref, link)
+	}
+
+	updatedAt := note.Updated
+	if updatedAt.IsZero() {
+		updatedAt = note.Created
+	}
+	_, err = s.noteService.EditNoteByID(userId, created.ID, dto.CreateNoteRequest{
+		Title:     created.Title,
+		Content:   content,
+		UpdatedAt: updatedAt,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *importService) upload(ctx context.Context, userId string, noteId string, resource importer.Resource) (string, error) {
+	file, err := os.Open(resource.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	attachment, err := s.attachmentService.Upload(ctx, userId, noteId, resource.Filename, file)
+	if err != nil {
+		return "", err
+	}
+	return attachmentURL(s.conf.URL, noteId, attachment.ID), nil
+}
+
+// noteResult records validation errors on the note and stops the import on
+// anything else, e.g. a workspace the user can't add notes to.
+func noteResult(result dto.ImportResult, err error) (dto.ImportResult, error) {
+	var badRequest *httperror.BadClientRequestError
+	if errors.As(err, &badRequest) {
+		result.Message = badRequest.Message
+		return result, nil
+	}
+	return result, err
+}
+
+// readError blames archives that can't be read on the upload.
+func readError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &httperror.BadClientRequestError{Message: "Import is too large"}
+	}
+	logging.Info("err reading import: %v", err)
+	return &httperror.BadClientRequestError{Message: "Couldn't read import: " + err.Error()}
+}
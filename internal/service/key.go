@@ -0,0 +1,219 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const (
+	maxPublicKey  = 4096
+	maxWrappedKey = 8192
+	maxKDF        = 2048
+	maxNonce      = 255
+)
+
+// algorithm names and key ids are only stored for clients, but they're
+// kept to something that can't be mistaken for anything else
+var cryptoNamePattern = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]{1,255}$`)
+
+// KeyService keeps the key pairs users encrypt notes with. The server only
+// ever sees public keys and private keys wrapped on the user's device.
+type KeyService interface {
+	GetKey(userId string) (*models.UserKey, error)
+	// SetKey sets up the user's key pair, or rewraps its private key after
+	// a passphrase change. The public key can't change while note keys are
+	// wrapped with it.
+	SetKey(userId string, request dto.UserKeyRequest) (*models.UserKey, error)
+	// GetPublicKey is what a note's owner wraps its key with to share it
+	// with username.
+	GetPublicKey(username string) (*models.PublicKey, error)
+}
+
+type keyService struct {
+	keyRepo     repository.KeyRepository
+	userService UserService
+}
+
+func NewKeyService(keyRepo repository.KeyRepository, userService UserService) KeyService {
+	return &keyService{
+		keyRepo:     keyRepo,
+		userService: userService,
+	}
+}
+
+func (s *keyService) GetKey(userId string) (*models.UserKey, error) {
+	key, err := s.keyRepo.GetUserKey(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.NotFoundError{Entity: "Key"}
+	}
+	return key, err
+}
+
+func (s *keyService) SetKey(userId string, request dto.UserKeyRequest) (*models.UserKey, error) {
+	if !cryptoNamePattern.MatchString(request.Algorithm) || len(request.Algorithm) > 64 {
+		return nil, &httperror.BadClientRequestError{Message: "algorithm must be up to 64 letters, digits and punctuation"}
+	}
+	if request.PublicKey == "" || len(request.PublicKey) > maxPublicKey {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("public_key must be between 1 and %d characters", maxPublicKey)}
+	}
+	if request.WrappedPrivateKey == "" || len(request.WrappedPrivateKey) > maxWrappedKey {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("wrapped_private_key must be between 1 and %d characters", maxWrappedKey)}
+	}
+	var kdf map[string]any
+	if len(request.KDF) > maxKDF || json.Unmarshal(request.KDF, &kdf) != nil || kdf == nil {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("kdf must be a JSON object of up to %d characters", maxKDF)}
+	}
+
+	existing, err := s.keyRepo.GetUserKey(userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if existing != nil && existing.PublicKey != request.PublicKey {
+		count, err := s.keyRepo.CountNoteKeys(userId)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, &httperror.ConflictError{Message: fmt.Sprintf("The keys of %d notes are wrapped with your current public key, keep it and only rewrap the private key", count)}
+		}
+	}
+
+	return s.keyRepo.SetUserKey(&models.UserKey{
+		UserID:            userId,
+		Algorithm:         request.Algorithm,
+		PublicKey:         request.PublicKey,
+		WrappedPrivateKey: request.WrappedPrivateKey,
+		KDF:               request.KDF,
+	})
+}
+
+func (s *keyService) GetPublicKey(username string) (*models.PublicKey, error) {
+	user, err := s.userService.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.keyRepo.GetUserKey(user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.NotFoundError{Entity: "Key"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.PublicKey{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Algorithm: key.Algorithm,
+		PublicKey: key.PublicKey,
+	}, nil
+}
+
+// checkEncryption checks what a client says about how it encrypted a note.
+func checkEncryption(request *dto.NoteEncryptionRequest) error {
+	if !cryptoNamePattern.MatchString(request.Algorithm) || len(request.Algorithm) > 64 {
+		return &httperror.BadClientRequestError{Message: "encryption.algorithm must be up to 64 letters, digits and punctuation"}
+	}
+	if !cryptoNamePattern.MatchString(request.KeyID) {
+		return &httperror.BadClientRequestError{Message: "encryption.key_id must be up to 255 letters, digits and punctuation"}
+	}
+	if request.Nonce == "" || len(request.Nonce) > maxNonce {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("encryption.nonce must be between 1 and %d characters", maxNonce)}
+	}
+	return nil
+}
+
+func checkWrappedKey(wrappedKey string) error {
+	if wrappedKey == "" || len(wrappedKey) > maxWrappedKey {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("wrapped_key must be between 1 and %d characters", maxWrappedKey)}
+	}
+	return nil
+}
+
+// checkEncryptedEdit holds an edit to the note's encryption. Notes are
+// encrypted or not for good, and new ciphertext needs a new nonce, since
+// reusing one with the same key gives the plaintext away.
+func checkEncryptedEdit(existing *models.Note, request *dto.NoteEncryptionRequest) error {
+	if !existing.Encrypted() {
+		if request != nil {
+			return &httperror.BadClientRequestError{Message: "Only new notes can be encrypted"}
+		}
+		return nil
+	}
+
+	if request == nil {
+		return &httperror.BadClientRequestError{Message: "Edits of an encrypted note need its encryption with a new nonce"}
+	}
+	err := checkEncryption(request)
+	if err != nil {
+		return err
+	}
+	if request.Algorithm != existing.Encryption.Algorithm || request.KeyID != existing.Encryption.KeyID {
+		return &httperror.BadClientRequestError{Message: "An encrypted note keeps the algorithm and key it was created with"}
+	}
+	if request.Nonce == existing.Encryption.Nonce {
+		return &httperror.BadClientRequestError{Message: "Each edit of an encrypted note needs a new nonce"}
+	}
+	return nil
+}
+
+// setWrappedKeys gives each encrypted note the user's wrapped copy of its
+// key.
+func setWrappedKeys(keyRepo repository.KeyRepository, userId string, notes []models.Note) error {
+	noteIDs := make([]string, 0)
+	for _, note := range notes {
+		if note.Encrypted() {
+			noteIDs = append(noteIDs, note.ID)
+		}
+	}
+	if len(noteIDs) == 0 {
+		return nil
+	}
+
+	keys, err := keyRepo.GetNoteKeys(userId, noteIDs)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		if notes[i].Encrypted() {
+			withKey(&notes[i], keys[notes[i].ID])
+		}
+	}
+	return nil
+}
+
+func setWrappedKey(keyRepo repository.KeyRepository, userId string, note *models.Note) error {
+	notes := []models.Note{*note}
+	err := setWrappedKeys(keyRepo, userId, notes)
+	if err != nil {
+		return err
+	}
+	note.Encryption = notes[0].Encryption
+	return nil
+}
+
+// withKey gives note its own copy of its encryption carrying wrappedKey,
+// so copies of the note made for other users don't share it.
+func withKey(note *models.Note, wrappedKey string) {
+	if note.Encryption == nil {
+		return
+	}
+	encryption := *note.Encryption
+	encryption.WrappedKey = wrappedKey
+	note.Encryption = &encryption
+}
+
+// plainContent is the part of a note's content the server can read, none
+// of it for encrypted notes.
+func plainContent(note *models.Note) string {
+	if note.Encrypted() {
+		return ""
+	}
+	return note.Content
+}
@@ -3,30 +3,94 @@ package service
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/checklist"
+	"github.com/vaporii/v8box/internal/config"
 	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/events"
 	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
 	"github.com/vaporii/v8box/internal/models"
 	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/wikilink"
 )
 
 type NoteService interface {
 	Create(request dto.CreateNoteRequest) (*models.Note, error)
-	GetUserNotes(userId string) ([]models.Note, error)
-	GetNoteByID(id string) (*models.Note, error)
-	EditNoteByID(id string, request dto.CreateNoteRequest) (*models.Note, error)
+	GetUserNotes(userId string, query dto.NoteListQuery) ([]models.Note, error)
+	GetSharedNotes(userId string, query dto.NoteListQuery) ([]models.Note, error)
+	GetNoteByID(userId string, id string) (*models.Note, error)
+	// EditNoteByID fails with a ConflictError if the request has a version
+	// and the note has changed since then.
+	EditNoteByID(userId string, id string, request dto.CreateNoteRequest) (*models.Note, error)
+	DeleteNoteByID(userId string, id string) error
+	GetNoteShares(userId string, id string) ([]models.NoteShare, error)
+	ShareNote(userId string, id string, request dto.ShareNoteRequest) (*models.NoteShare, error)
+	UnshareNote(userId string, id string, targetUserId string) error
+	TransferNote(userId string, id string, request dto.TransferNoteRequest) (*models.Note, error)
+	// SetNoteStates pins, archives or favourites notes for the user, all of
+	// them or none.
+	SetNoteStates(userId string, request dto.NoteStateRequest) ([]models.NoteState, error)
+	// BatchNotes applies many creates, updates, deletes and moves in one
+	// transaction, each checked like the single note routes check them.
+	BatchNotes(userId string, request dto.NoteBatchRequest) (*dto.NoteBatchReport, error)
+	// GetNoteRole is the user's role on the note, for checking access given
+	// earlier still holds.
+	GetNoteRole(userId string, id string) (models.NoteRole, error)
+
+	// SetLiveSessions hands the note service the live editing sessions,
+	// which are made after it.
+	SetLiveSessions(live LiveSessions)
+	// SaveLiveContent writes what a live editing session made of the note.
+	// The session has already checked its peers can edit, so the write
+	// isn't made as any one of them.
+	SaveLiveContent(id string, content string) (*models.Note, error)
+}
+
+// LiveSessions is how writes made outside a live editing session reach it,
+// so the session doesn't save over them later.
+type LiveSessions interface {
+	// Edit calls write with the text of those of noteIDs that have a
+	// session open. write saves the notes and returns the content each has
+	// now, which the sessions take on. Nothing else reaches them while it
+	// runs, and they're left alone if it fails.
+	Edit(noteIDs []string, write func(live map[string]string) (map[string]string, error)) error
 }
 
 type noteService struct {
-	noteRepo    repository.NoteRepository
-	userService UserService
+	noteRepo       repository.NoteRepository
+	shareRepo      repository.NoteShareRepository
+	workspaceRepo  repository.WorkspaceRepository
+	attachmentRepo repository.AttachmentRepository
+	linkRepo       repository.NoteLinkRepository
+	taskRepo       repository.TaskRepository
+	stateRepo      repository.NoteStateRepository
+	propertyRepo   repository.PropertyRepository
+	keyRepo        repository.KeyRepository
+	userService    UserService
+	bus            *events.Bus
+	conf           config.Config
+	live           LiveSessions
 }
 
-func NewNoteService(noteRepo repository.NoteRepository, userService UserService) NoteService {
+func NewNoteService(noteRepo repository.NoteRepository, shareRepo repository.NoteShareRepository, workspaceRepo repository.WorkspaceRepository, attachmentRepo repository.AttachmentRepository, linkRepo repository.NoteLinkRepository, taskRepo repository.TaskRepository, stateRepo repository.NoteStateRepository, propertyRepo repository.PropertyRepository, keyRepo repository.KeyRepository, userService UserService, bus *events.Bus, conf config.Config) NoteService {
 	return &noteService{
-		noteRepo:    noteRepo,
-		userService: userService,
+		noteRepo:       noteRepo,
+		shareRepo:      shareRepo,
+		workspaceRepo:  workspaceRepo,
+		attachmentRepo: attachmentRepo,
+		linkRepo:       linkRepo,
+		taskRepo:       taskRepo,
+		stateRepo:      stateRepo,
+		propertyRepo:   propertyRepo,
+		keyRepo:        keyRepo,
+		userService:    userService,
+		bus:            bus,
+		conf:           conf,
 	}
 }
 
@@ -36,23 +100,154 @@ func (s *noteService) Create(request dto.CreateNoteRequest) (*models.Note, error
 		return nil, &httperror.BadClientRequestError{Message: "User with ID doesn't exist"}
 	}
 
+	role, err := s.creatorRole(request.UserID, request.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := normalizeTags(request.Tags)
+	if err != nil {
+		return nil, err
+	}
+	var folder string
+	if request.Folder != nil {
+		folder, err = normalizeFolder(*request.Folder)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var properties map[string]models.Property
+	if len(request.Properties) > 0 {
+		properties, err = parseProperties(s.propertyRepo, request.UserID, request.WorkspaceID, request.Properties)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var encryption *models.NoteEncryption
+	if request.Encryption != nil {
+		encryption, err = s.newEncryption(request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	note := &models.Note{
-		ID:      uuid.NewString(),
-		UserID:  request.UserID,
-		Title:   request.Title,
-		Content: request.Content,
+		ID:          uuid.NewString(),
+		UserID:      request.UserID,
+		WorkspaceID: request.WorkspaceID,
+		Title:       request.Title,
+		Content:     request.Content,
+		Tags:        tags,
+		Folder:      folder,
+		CreatedAt:   request.CreatedAt,
+		UpdatedAt:   request.UpdatedAt,
+		Encryption:  encryption,
+		Properties:  properties,
 	}
 
-	note, err := s.noteRepo.CreateNote(note)
+	note, err = s.noteRepo.CreateNote(note)
 	if err != nil {
 		return nil, err
 	}
+	note.Role = role
+	s.updateLinks(note, "")
+	s.saveTasks(note)
+	s.publish(note, events.NoteCreated)
+	if encryption != nil {
+		withKey(note, request.Encryption.WrappedKey)
+	}
 
 	return note, nil
 }
 
-func (s *noteService) GetUserNotes(userId string) ([]models.Note, error) {
-	notes, err := s.noteRepo.GetUserNotes(userId)
+// newEncryption checks a new note can be end-to-end encrypted. Only
+// personal notes can, since everyone in a workspace would need its key.
+func (s *noteService) newEncryption(request dto.CreateNoteRequest) (*models.NoteEncryption, error) {
+	if request.WorkspaceID != "" {
+		return nil, &httperror.BadClientRequestError{Message: "Workspace notes can't be encrypted"}
+	}
+	if request.TemplateID != "" {
+		return nil, &httperror.BadClientRequestError{Message: "Encrypted notes can't be made from a template"}
+	}
+	err := checkEncryption(request.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	err = checkWrappedKey(request.Encryption.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.keyRepo.GetUserKey(request.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.BadClientRequestError{Message: "Set up your keys with PUT /me/keys before encrypting notes"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.NoteEncryption{
+		Algorithm:  request.Encryption.Algorithm,
+		KeyID:      request.Encryption.KeyID,
+		Nonce:      request.Encryption.Nonce,
+		WrappedKey: request.Encryption.WrappedKey,
+	}, nil
+}
+
+// creatorRole checks userId can add notes to the workspace, if there is
+// one, and returns the role they'll have on them.
+func (s *noteService) creatorRole(userId string, workspaceId string) (models.NoteRole, error) {
+	if workspaceId == "" {
+		return models.NoteRoleOwner, nil
+	}
+
+	member, err := s.workspaceRepo.GetMember(workspaceId, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", &httperror.NotFoundError{Entity: "Workspace"}
+		}
+		return "", err
+	}
+	if !member.Role.Allows(models.WorkspaceRoleEditor) {
+		return "", &httperror.ForbiddenError{Message: "You need editor access to the workspace to add notes"}
+	}
+	return member.Role.NoteRole(), nil
+}
+
+func (s *noteService) GetUserNotes(userId string, query dto.NoteListQuery) ([]models.Note, error) {
+	sort, err := listSort(s.userService, userId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPropertyFilters(query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.noteRepo.GetUserNotes(userId, sort, query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range notes {
+		notes[i].Role = models.NoteRoleOwner
+	}
+
+	notes, err = applyNoteStates(s.stateRepo, userId, notes, query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setThumbnailURLs(s.attachmentRepo, s.conf.URL, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setProperties(s.propertyRepo, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setWrappedKeys(s.keyRepo, userId, notes)
 	if err != nil {
 		return nil, err
 	}
@@ -60,20 +255,355 @@ func (s *noteService) GetUserNotes(userId string) ([]models.Note, error) {
 	return notes, nil
 }
 
-func (s *noteService) GetNoteByID(id string) (*models.Note, error) {
-	note, err := s.noteRepo.GetNoteByID(id)
+func (s *noteService) GetSharedNotes(userId string, query dto.NoteListQuery) ([]models.Note, error) {
+	sort, err := listSort(s.userService, userId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPropertyFilters(query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.shareRepo.GetNotesSharedWithUser(userId, sort, query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err = applyNoteStates(s.stateRepo, userId, notes, query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setThumbnailURLs(s.attachmentRepo, s.conf.URL, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setProperties(s.propertyRepo, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setWrappedKeys(s.keyRepo, userId, notes)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+func (s *noteService) GetNoteByID(userId string, id string) (*models.Note, error) {
+	note, err := s.authorize(userId, id, models.NoteRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	err = setNoteState(s.stateRepo, userId, note)
+	if err != nil {
+		return nil, err
+	}
+	err = setNoteProperties(s.propertyRepo, note)
+	if err != nil {
+		return nil, err
+	}
+	err = setWrappedKey(s.keyRepo, userId, note)
+	if err != nil {
+		return nil, err
+	}
+
+	return note, s.setThumbnailURL(note)
+}
+
+func (s *noteService) EditNoteByID(userId string, id string, request dto.CreateNoteRequest) (*models.Note, error) {
+	existing, err := s.authorize(userId, id, models.NoteRoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	err = checkEncryptedEdit(existing, request.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Tags != nil {
+		request.Tags, err = normalizeTags(request.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if request.Folder != nil {
+		folder, err := normalizeFolder(*request.Folder)
+		if err != nil {
+			return nil, err
+		}
+		request.Folder = &folder
+	}
+	var properties map[string]models.Property
+	if request.Properties != nil {
+		// the schema is the note's notebook's, not the editor's
+		properties, err = parseProperties(s.propertyRepo, existing.UserID, existing.WorkspaceID, request.Properties)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if request.Version != 0 && request.Version != existing.Version {
+		return nil, noteChanged
+	}
+	var note *models.Note
+	err = s.editLive([]string{id}, func(live map[string]string) (map[string]string, error) {
+		// a session's text is newer than the saved note while its edits wait
+		// to be saved, an edit made from the saved note would undo them
+		if text, open := live[id]; open && request.Version != 0 && text != existing.Content {
+			return nil, noteChanged
+		}
+		var err error
+		note, err = s.noteRepo.UpdateNote(id, request, properties)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{id: request.Content}, nil
+	})
+	if errors.Is(err, repository.ErrVersionChanged) {
+		return nil, noteChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	note.Role = existing.Role
+	err = setNoteProperties(s.propertyRepo, note)
+	if err != nil {
+		return nil, err
+	}
+	s.updateLinks(note, existing.Title)
+	s.saveTasks(note)
+	err = s.setThumbnailURL(note)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(note, events.NoteUpdated)
+
+	// after publishing, the state and key are the editor's alone
+	err = setNoteState(s.stateRepo, userId, note)
+	if err != nil {
+		return nil, err
+	}
+	err = setWrappedKey(s.keyRepo, userId, note)
+	if err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+func (s *noteService) DeleteNoteByID(userId string, id string) error {
+	note, err := s.authorize(userId, id, models.NoteRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	// looked up before the shares go away so everyone with access hears about it
+	audience := s.audience(note)
+
+	err = s.noteRepo.DeleteNote(id)
+	if err != nil {
+		return err
+	}
+	for userId := range audience {
+		s.bus.Publish(userId, events.NoteDeleted, dto.DeletedNote{ID: note.ID})
+	}
+
+	return nil
+}
+
+func (s *noteService) GetNoteShares(userId string, id string) ([]models.NoteShare, error) {
+	_, err := s.authorize(userId, id, models.NoteRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shareRepo.GetNoteShares(id)
+}
+
+func (s *noteService) ShareNote(userId string, id string, request dto.ShareNoteRequest) (*models.NoteShare, error) {
+	role := models.NoteRole(request.Role)
+	if role != models.NoteRoleViewer && role != models.NoteRoleEditor {
+		return nil, &httperror.BadClientRequestError{Message: "Role must be viewer or editor"}
+	}
+
+	note, err := s.authorize(userId, id, models.NoteRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.userService.GetUserByUsername(request.Username)
 	if err != nil {
+		return nil, err
+	}
+	if target.ID == note.UserID {
+		return nil, &httperror.BadClientRequestError{Message: "Can't share a note with its owner"}
+	}
+
+	// the server can't read an encrypted note's key, so the owner wraps it
+	// for the recipient
+	if note.Encrypted() {
+		err = checkWrappedKey(request.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.keyRepo.GetUserKey(target.ID)
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, &httperror.NotFoundError{Entity: "Note"}
+			return nil, &httperror.BadClientRequestError{Message: target.Username + " hasn't set up keys for encrypted notes yet"}
+		}
+		if err != nil {
+			return nil, err
 		}
+	} else if request.WrappedKey != "" {
+		return nil, &httperror.BadClientRequestError{Message: "Only encrypted notes are shared with a wrapped_key"}
+	}
+
+	share, err := s.shareRepo.UpsertShare(&models.NoteShare{
+		NoteID: id,
+		UserID: target.ID,
+		Role:   role,
+	}, request.WrappedKey)
+	if err != nil {
 		return nil, err
 	}
 
+	shared := *note
+	shared.Role = role
+	withKey(&shared, request.WrappedKey)
+	s.bus.Publish(target.ID, events.NoteShared, shared)
+
+	return share, nil
+}
+
+// UnshareNote revokes targetUserId's access. Owners can revoke anyone and
+// other users can remove themselves.
+func (s *noteService) UnshareNote(userId string, id string, targetUserId string) error {
+	required := models.NoteRoleOwner
+	if userId == targetUserId {
+		required = models.NoteRoleViewer
+	}
+
+	note, err := s.authorize(userId, id, required)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.shareRepo.GetShare(id, targetUserId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &httperror.NotFoundError{Entity: "Share"}
+		}
+		return err
+	}
+
+	// they may have kept the key, but they won't get the next ciphertext
+	err = s.shareRepo.DeleteShare(id, targetUserId)
+	if err != nil {
+		return err
+	}
+	s.bus.Publish(targetUserId, events.NoteUnshared, dto.DeletedNote{ID: note.ID})
+
+	return nil
+}
+
+func (s *noteService) TransferNote(userId string, id string, request dto.TransferNoteRequest) (*models.Note, error) {
+	note, err := s.authorize(userId, id, models.NoteRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	if note.WorkspaceID != "" {
+		return nil, &httperror.BadClientRequestError{Message: "Workspace notes belong to their workspace and can't be transferred"}
+	}
+
+	target, err := s.userService.GetUserByUsername(request.Username)
+	if err != nil {
+		return nil, err
+	}
+	if target.ID == note.UserID {
+		return nil, &httperror.BadClientRequestError{Message: "User already owns this note"}
+	}
+	if note.Encrypted() {
+		keys, err := s.keyRepo.GetNoteKeys(target.ID, []string{id})
+		if err != nil {
+			return nil, err
+		}
+		if keys[id] == "" {
+			return nil, &httperror.BadClientRequestError{Message: "Share the encrypted note with " + target.Username + " first, so they have its key"}
+		}
+	}
+
+	err = s.shareRepo.TransferNote(id, note.UserID, target.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	transferred, err := s.GetNoteByID(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(transferred, events.NoteUpdated)
+
+	return transferred, nil
+}
+
+func (s *noteService) GetNoteRole(userId string, id string) (models.NoteRole, error) {
+	note, err := s.authorize(userId, id, models.NoteRoleViewer)
+	if err != nil {
+		return "", err
+	}
+	return note.Role, nil
+}
+
+func (s *noteService) SetLiveSessions(live LiveSessions) {
+	s.live = live
+}
+
+// noteChanged is the error for edits made from a copy of a note that's
+// changed since it was read.
+var noteChanged = &httperror.ConflictError{Message: "The note has changed, reload it and try again"}
+
+// editLive makes write, which saves notes, through their live sessions, see
+// LiveSessions.
+func (s *noteService) editLive(ids []string, write func(live map[string]string) (map[string]string, error)) error {
+	if s.live == nil {
+		_, err := write(map[string]string{})
+		return err
+	}
+	return s.live.Edit(ids, write)
+}
+
+func (s *noteService) SaveLiveContent(id string, content string) (*models.Note, error) {
+	existing, err := s.noteRepo.GetNoteByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.NotFoundError{Entity: "Note"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	note, err := s.noteRepo.UpdateNote(id, dto.CreateNoteRequest{Title: existing.Title, Content: content}, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.NotFoundError{Entity: "Note"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.updateLinks(note, existing.Title)
+	s.saveTasks(note)
+	err = s.setThumbnailURL(note)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(note, events.NoteUpdated)
 	return note, nil
 }
 
-func (s *noteService) EditNoteByID(id string, request dto.CreateNoteRequest) (*models.Note, error) {
-	_, err := s.noteRepo.GetNoteByID(id)
+// authorize loads the note and checks userId holds at least the required
+// role on it. Notes the user can't see at all are reported as missing.
+func (s *noteService) authorize(userId string, id string, required models.NoteRole) (*models.Note, error) {
+	note, err := s.noteRepo.GetNoteByID(id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, &httperror.NotFoundError{Entity: "Note"}
@@ -81,10 +611,320 @@ func (s *noteService) EditNoteByID(id string, request dto.CreateNoteRequest) (*m
 		return nil, err
 	}
 
-	note, err := s.noteRepo.UpdateNote(id, request)
+	// workspace notes belong to the workspace, so writing one doesn't keep
+	// you access to it after leaving
+	if note.WorkspaceID != "" {
+		member, err := s.workspaceRepo.GetMember(note.WorkspaceID, userId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if member != nil {
+			note.Role = member.Role.NoteRole()
+		}
+	} else if note.UserID == userId {
+		note.Role = models.NoteRoleOwner
+	}
+
+	if note.Role != models.NoteRoleOwner {
+		share, err := s.shareRepo.GetShare(id, userId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if share != nil && !note.Role.Allows(share.Role) {
+			note.Role = share.Role
+		}
+	}
+
+	if note.Role == "" {
+		return nil, &httperror.NotFoundError{Entity: "Note"}
+	}
+	if !note.Role.Allows(required) {
+		return nil, &httperror.ForbiddenError{Message: "You need " + string(required) + " access to do that"}
+	}
+
+	return note, nil
+}
+
+// audience maps everyone with access to note to their effective role on it.
+func (s *noteService) audience(note *models.Note) map[string]models.NoteRole {
+	audience := make(map[string]models.NoteRole)
+
+	if note.WorkspaceID != "" {
+		members, err := s.workspaceRepo.GetMembers(note.WorkspaceID)
+		if err != nil {
+			logging.Warning("couldn't load members of workspace %s: %v", note.WorkspaceID, err)
+		}
+		for _, member := range members {
+			audience[member.UserID] = member.Role.NoteRole()
+		}
+	} else {
+		audience[note.UserID] = models.NoteRoleOwner
+	}
+
+	shares, err := s.shareRepo.GetNoteShares(note.ID)
+	if err != nil {
+		logging.Warning("couldn't load shares for note %s: %v", note.ID, err)
+	}
+	for _, share := range shares {
+		if !audience[share.UserID].Allows(share.Role) {
+			audience[share.UserID] = share.Role
+		}
+	}
+
+	return audience
+}
+
+// publish sends an event carrying note to everyone with access to it, with
+// the role field adjusted for each recipient.
+func (s *noteService) publish(note *models.Note, eventType events.EventType) {
+	for userId, role := range s.audience(note) {
+		data := *note
+		data.Role = role
+		// wrapped keys are for the one user they were wrapped for
+		withKey(&data, "")
+		s.bus.Publish(userId, eventType, data)
+	}
+}
+
+// updateLinks keeps the links table in step with a saved note: its own links
+// are parsed again, links to its old title are rewritten when it's renamed,
+// and dangling links to its title now point at it. The note is already
+// saved, so problems are logged rather than failing the request.
+func (s *noteService) updateLinks(note *models.Note, previousTitle string) {
+	err := s.saveLinks(note)
+	if err != nil {
+		logging.Warning("couldn't save links of note %s: %v", note.ID, err)
+	}
+	if previousTitle != "" && previousTitle != note.Title {
+		err = s.renameLinks(note)
+		if err != nil {
+			logging.Warning("couldn't rewrite links to note %s: %v", note.ID, err)
+		}
+	}
+	err = s.linkRepo.ResolveDangling(note)
+	if err != nil {
+		logging.Warning("couldn't resolve links to note %s: %v", note.ID, err)
+	}
+}
+
+// saveLinks records the wiki links in the note's content. Titles are looked
+// up among the notes next to it, ids can point at any note.
+func (s *noteService) saveLinks(note *models.Note) error {
+	parsed := wikilink.Parse(plainContent(note))
+	links := make([]models.NoteLink, 0, len(parsed))
+	for _, link := range parsed {
+		saved := models.NoteLink{SourceID: note.ID, Target: link.Target}
+		if link.IsID() {
+			target, err := s.noteRepo.GetNoteByID(strings.ToLower(link.Target))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if target != nil {
+				saved.TargetID = target.ID
+			}
+		} else {
+			id, err := s.linkRepo.FindTitle(note.WorkspaceID, note.UserID, link.Target)
+			if err != nil {
+				return err
+			}
+			saved.TargetID = id
+		}
+		links = append(links, saved)
+	}
+	return s.linkRepo.SetLinks(note.ID, links)
+}
+
+// renameLinks rewrites [[Old Title]] in the notes linking to note, so the
+// links follow it to its new title. Titles that can't be written in a link
+// are linked by id instead.
+func (s *noteService) renameLinks(note *models.Note) error {
+	backlinks, err := s.linkRepo.GetBacklinks(note.ID)
+	if err != nil {
+		return err
+	}
+
+	target := note.Title
+	if !wikilink.CanLinkTitle(target) {
+		target = note.ID
+	}
+	for _, link := range backlinks {
+		if (wikilink.Link{Target: link.Target}).IsID() {
+			continue
+		}
+		err = s.renameLink(note, link.SourceID, link.Target, target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameAttempts is how many times a source note's links are rewritten
+// before giving up on a note that keeps changing in between.
+const renameAttempts = 3
+
+// renameLink rewrites the links from sourceID to note. The rewrite is only
+// saved while the source is at the version it was made from, so an edit
+// saved in the meantime isn't undone, and is made again from the edited
+// note otherwise.
+func (s *noteService) renameLink(note *models.Note, sourceID string, from string, to string) error {
+	var updated *models.Note
+	var err error
+	for range renameAttempts {
+		updated, err = s.rewriteLink(sourceID, from, to)
+		if !errors.Is(err, repository.ErrVersionChanged) {
+			break
+		}
+	}
+	if err != nil || updated == nil {
+		return err
+	}
+
+	err = s.saveLinks(updated)
+	if err != nil {
+		return err
+	}
+	s.saveTasks(updated)
+	// a note linking to itself goes out with the rest of the edit
+	if updated.ID == note.ID {
+		note.Content, note.Version, note.UpdatedAt = updated.Content, updated.Version, updated.UpdatedAt
+		return nil
+	}
+	s.publish(updated, events.NoteUpdated)
+	return nil
+}
+
+// rewriteLink makes one attempt at renameLink, the note is nil if there
+// was nothing to rewrite.
+func (s *noteService) rewriteLink(sourceID string, from string, to string) (*models.Note, error) {
+	source, err := s.noteRepo.GetNoteByID(sourceID)
 	if err != nil {
 		return nil, err
 	}
+	var updated *models.Note
+	err = s.editLive([]string{source.ID}, func(live map[string]string) (map[string]string, error) {
+		// a live session may have edits that aren't saved yet, the links are
+		// rewritten in those too
+		text, open := live[source.ID]
+		if !open {
+			text = source.Content
+		}
+		content, changed := wikilink.Rewrite(text, from, to)
+		if !changed && content == source.Content {
+			return nil, nil
+		}
+		var err error
+		updated, err = s.noteRepo.UpdateNote(source.ID, dto.CreateNoteRequest{
+			Title:   source.Title,
+			Content: content,
+			Version: source.Version,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{source.ID: content}, nil
+	})
+	return updated, err
+}
 
-	return note, nil
+// saveTasks records the note's task list items for GET /me/tasks.
+func (s *noteService) saveTasks(note *models.Note) {
+	items := checklist.Parse(note.ID, plainContent(note))
+	tasks := make([]models.Task, len(items))
+	for i, item := range items {
+		tasks[i] = models.Task{
+			ID:       item.ID,
+			NoteID:   note.ID,
+			Line:     item.Line,
+			Text:     item.Text,
+			Done:     item.Done,
+			Due:      item.Due,
+			Priority: string(item.Priority),
+		}
+	}
+	err := s.taskRepo.SetNoteTasks(note.ID, tasks)
+	if err != nil {
+		logging.Warning("couldn't save tasks of note %s: %v", note.ID, err)
+	}
+}
+
+func (s *noteService) setThumbnailURL(note *models.Note) error {
+	notes := []models.Note{*note}
+	err := setThumbnailURLs(s.attachmentRepo, s.conf.URL, notes)
+	if err != nil {
+		return err
+	}
+	note.ThumbnailURL = notes[0].ThumbnailURL
+	return nil
+}
+
+// listSort picks the order for a note listing, defaulting to the one in the
+// user's settings.
+func listSort(userService UserService, userId string, query dto.NoteListQuery) (models.NoteSort, error) {
+	if query.Sort == "" {
+		return userService.GetSettings(userId).DefaultSort, nil
+	}
+
+	sort := models.NoteSort(query.Sort)
+	if !sort.Valid() {
+		return "", errInvalidSort
+	}
+	return sort, nil
+}
+
+const (
+	maxTags      = 50
+	maxTagLength = 100
+	maxFolderLen = 1024
+)
+
+// normalizeTags drops a leading '#' and duplicates, which differ only in
+// case, keeping the first spelling.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxTagLength || strings.ContainsFunc(tag, func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsControl(r) || r == ','
+		}) {
+			return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("Invalid tag %q, tags can't contain spaces or commas", tag)}
+		}
+		if seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("A note can have at most %d tags", maxTags)}
+	}
+	return normalized, nil
+}
+
+// normalizeFolder trims the segments of a folder path and drops empty ones,
+// so "/Projects//2024/" becomes "Projects/2024".
+func normalizeFolder(folder string) (string, error) {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(folder, "/") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+		if segment == "." || segment == ".." || strings.ContainsFunc(segment, func(r rune) bool {
+			return unicode.IsControl(r) || r == '\\'
+		}) {
+			return "", &httperror.BadClientRequestError{Message: fmt.Sprintf("Invalid folder %q", folder)}
+		}
+		segments = append(segments, segment)
+	}
+
+	normalized := strings.Join(segments, "/")
+	if len(normalized) > maxFolderLen {
+		return "", &httperror.BadClientRequestError{Message: "Folder path is too long"}
+	}
+	return normalized, nil
 }
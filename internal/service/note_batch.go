@@ -0,0 +1,305 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/events"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const maxBatchOperations = 500
+
+// batchOperation is one operation of a batch after it's been checked. A
+// result with a status means it failed the check and won't be written.
+type batchOperation struct {
+	result dto.NoteBatchResult
+	write  models.NoteWrite
+	// the role the user has on the note, or will have on a new one
+	role models.NoteRole
+	// the note's title before the batch, for rewriting links to it
+	previousTitle string
+	// and its content, to tell if a live session has changed it since
+	previousContent string
+	// who to tell about a deleted note, looked up while it's still there
+	audience map[string]models.NoteRole
+}
+
+func (s *noteService) BatchNotes(userId string, request dto.NoteBatchRequest) (*dto.NoteBatchReport, error) {
+	mode := request.Mode
+	if mode == "" {
+		mode = dto.NoteBatchAtomic
+	}
+	if mode != dto.NoteBatchAtomic && mode != dto.NoteBatchBestEffort {
+		return nil, &httperror.BadClientRequestError{Message: "mode must be atomic or best_effort"}
+	}
+	if len(request.Operations) == 0 || len(request.Operations) > maxBatchOperations {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("A batch must have between 1 and %d operations", maxBatchOperations)}
+	}
+	atomic := mode == dto.NoteBatchAtomic
+
+	// everything is checked before anything is written, so an atomic batch
+	// with a bad operation doesn't touch the database at all
+	operations := make([]*batchOperation, len(request.Operations))
+	pending := make([]*batchOperation, 0, len(request.Operations))
+	writes := make([]models.NoteWrite, 0, len(request.Operations))
+	for i, op := range request.Operations {
+		operation, err := s.checkOperation(userId, op)
+		if err != nil {
+			return nil, err
+		}
+		operations[i] = operation
+		if operation.result.Status == "" {
+			pending = append(pending, operation)
+			writes = append(writes, operation.write)
+		}
+	}
+
+	committed := !atomic || len(pending) == len(operations)
+	if committed {
+		var err error
+		committed, err = s.writeOperations(pending, atomic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &dto.NoteBatchReport{
+		Mode:      mode,
+		Committed: committed,
+		Results:   make([]dto.NoteBatchResult, len(operations)),
+	}
+	deleted := make(map[string]bool)
+	for i, operation := range operations {
+		if !committed && operation.result.Status != dto.NoteBatchStatusFailed {
+			operation.result.Status = dto.NoteBatchStatusAborted
+		}
+		switch operation.result.Status {
+		case dto.NoteBatchStatusDone:
+			report.Done++
+			if operation.write.Op == models.NoteOpDelete {
+				deleted[operation.write.ID] = true
+			}
+		case dto.NoteBatchStatusFailed:
+			report.Failed++
+		}
+		report.Results[i] = operation.result
+	}
+	for _, operation := range operations {
+		// a note changed and then deleted in the same batch is just gone
+		if operation.result.Status == dto.NoteBatchStatusDone &&
+			(operation.write.Op == models.NoteOpDelete || !deleted[operation.write.ID]) {
+			s.afterWrite(operation)
+		}
+	}
+
+	return report, nil
+}
+
+// writeOperations writes the operations that passed their checks through
+// the live sessions of the notes they update, see LiveSessions, and puts
+// how it went in their results. It reports false if an atomic batch was
+// rolled back.
+func (s *noteService) writeOperations(pending []*batchOperation, atomic bool) (bool, error) {
+	ids := make([]string, 0, len(pending))
+	for _, operation := range pending {
+		if operation.write.Op == models.NoteOpUpdate && operation.write.Content != nil {
+			ids = append(ids, operation.write.ID)
+		}
+	}
+
+	committed := true
+	err := s.editLive(ids, func(live map[string]string) (map[string]string, error) {
+		writes := make([]models.NoteWrite, 0, len(pending))
+		checked := make([]*batchOperation, 0, len(pending))
+		for _, operation := range pending {
+			// edits in a session that aren't saved yet are newer than the
+			// version the update was made from
+			text, open := live[operation.write.ID]
+			if open && operation.write.Version != 0 && operation.write.Content != nil && text != operation.previousContent {
+				operation.result.Status = dto.NoteBatchStatusFailed
+				operation.result.Message = noteChanged.Error()
+				continue
+			}
+			writes = append(writes, operation.write)
+			checked = append(checked, operation)
+		}
+		if atomic && len(checked) < len(pending) {
+			committed = false
+			return nil, nil
+		}
+
+		results, err := s.noteRepo.WriteNotes(writes, atomic)
+		if err != nil {
+			return nil, err
+		}
+		contents := make(map[string]string)
+		for i, result := range results {
+			operation := checked[i]
+			if result.Err != nil {
+				// deleted or changed earlier in the batch, or by someone
+				// else since it was checked
+				operation.result.Status = dto.NoteBatchStatusFailed
+				operation.result.Message = (&httperror.NotFoundError{Entity: "Note"}).Error()
+				if errors.Is(result.Err, repository.ErrVersionChanged) {
+					operation.result.Message = noteChanged.Error()
+				}
+				committed = !atomic
+				continue
+			}
+			operation.result.Status = dto.NoteBatchStatusDone
+			if result.Note != nil {
+				result.Note.Role = operation.role
+				operation.write.Note = result.Note
+			}
+			if operation.write.Op == models.NoteOpUpdate && operation.write.Content != nil {
+				contents[operation.write.ID] = *operation.write.Content
+			}
+		}
+		// a rolled back batch leaves the sessions as they were
+		if !committed {
+			return nil, nil
+		}
+		return contents, nil
+	})
+	return committed, err
+}
+
+// checkOperation holds op to the same rules as the note routes. Problems
+// with op itself go in the result, an error means the batch can't go on.
+func (s *noteService) checkOperation(userId string, op dto.NoteOperation) (*batchOperation, error) {
+	operation := &batchOperation{
+		result: dto.NoteBatchResult{Op: op.Op, NoteID: op.ID},
+		write:  models.NoteWrite{Op: models.NoteOp(op.Op), ID: op.ID},
+	}
+
+	err := s.prepareOperation(userId, op, operation)
+	var notFound *httperror.NotFoundError
+	var badRequest *httperror.BadClientRequestError
+	var forbidden *httperror.ForbiddenError
+	var conflict *httperror.ConflictError
+	if errors.As(err, &notFound) || errors.As(err, &badRequest) || errors.As(err, &forbidden) || errors.As(err, &conflict) {
+		operation.result.Status = dto.NoteBatchStatusFailed
+		operation.result.Message = err.Error()
+		return operation, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+func (s *noteService) prepareOperation(userId string, op dto.NoteOperation, operation *batchOperation) error {
+	var err error
+	switch operation.write.Op {
+	case models.NoteOpCreate:
+		if op.ID != "" {
+			return &httperror.BadClientRequestError{Message: "New notes can't be given an id"}
+		}
+		operation.role, err = s.creatorRole(userId, op.WorkspaceID)
+		if err != nil {
+			return err
+		}
+
+		note := &models.Note{
+			ID:          uuid.NewString(),
+			UserID:      userId,
+			WorkspaceID: op.WorkspaceID,
+		}
+		if op.Title != nil {
+			note.Title = *op.Title
+		}
+		if op.Content != nil {
+			note.Content = *op.Content
+		}
+		note.Tags, err = normalizeTags(op.Tags)
+		if err != nil {
+			return err
+		}
+		if op.Folder != nil {
+			note.Folder, err = normalizeFolder(*op.Folder)
+			if err != nil {
+				return err
+			}
+		}
+		operation.write.ID = note.ID
+		operation.write.Note = note
+		operation.result.NoteID = note.ID
+		return nil
+
+	case models.NoteOpUpdate, models.NoteOpMove:
+		if operation.write.Op == models.NoteOpMove {
+			if op.Folder == nil || op.Title != nil || op.Content != nil || op.Tags != nil {
+				return &httperror.BadClientRequestError{Message: "A move only takes a folder"}
+			}
+		} else if op.Title == nil && op.Content == nil && op.Tags == nil && op.Folder == nil {
+			return &httperror.BadClientRequestError{Message: "An update needs a title, content, tags or folder"}
+		}
+
+		existing, err := s.authorize(userId, op.ID, models.NoteRoleEditor)
+		if err != nil {
+			return err
+		}
+		if existing.Encrypted() && op.Content != nil {
+			return &httperror.BadClientRequestError{Message: "The content of encrypted notes can only be changed on its own, with a new nonce"}
+		}
+		if op.Version != 0 && op.Version != existing.Version {
+			return noteChanged
+		}
+		operation.role = existing.Role
+		operation.previousTitle = existing.Title
+		operation.previousContent = existing.Content
+		operation.write.Version = op.Version
+
+		operation.write.Title = op.Title
+		operation.write.Content = op.Content
+		if op.Tags != nil {
+			operation.write.Tags, err = normalizeTags(op.Tags)
+			if err != nil {
+				return err
+			}
+		}
+		if op.Folder != nil {
+			folder, err := normalizeFolder(*op.Folder)
+			if err != nil {
+				return err
+			}
+			operation.write.Folder = &folder
+		}
+		return nil
+
+	case models.NoteOpDelete:
+		existing, err := s.authorize(userId, op.ID, models.NoteRoleOwner)
+		if err != nil {
+			return err
+		}
+		operation.audience = s.audience(existing)
+		return nil
+	}
+
+	return &httperror.BadClientRequestError{Message: "op must be create, update, delete or move"}
+}
+
+// afterWrite does what the single note routes do once a note is saved. The
+// batch is committed by now, so problems are only logged.
+func (s *noteService) afterWrite(operation *batchOperation) {
+	note := operation.write.Note
+	switch operation.write.Op {
+	case models.NoteOpCreate:
+		s.updateLinks(note, "")
+		s.saveTasks(note)
+		s.publish(note, events.NoteCreated)
+	case models.NoteOpUpdate, models.NoteOpMove:
+		s.updateLinks(note, operation.previousTitle)
+		s.saveTasks(note)
+		s.publish(note, events.NoteUpdated)
+	case models.NoteOpDelete:
+		for userId := range operation.audience {
+			s.bus.Publish(userId, events.NoteDeleted, dto.DeletedNote{ID: operation.write.ID})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/events"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const maxStateNotes = 500
+
+// parseStateFilter reads one of the pinned, archived or favourite list
+// parameters, nil when both kinds of note are listed.
+func parseStateFilter(name string, value string) (*bool, error) {
+	switch value {
+	case "", "all":
+		return nil, nil
+	case "true":
+		yes := true
+		return &yes, nil
+	case "false":
+		no := false
+		return &no, nil
+	}
+	return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("%s must be true, false or all", name)}
+}
+
+func matchesState(filter *bool, value bool) bool {
+	return filter == nil || *filter == value
+}
+
+// applyNoteStates fills in the user's state of each note, drops those the
+// query filters out and moves pinned notes to the front, keeping the order
+// otherwise.
+func applyNoteStates(stateRepo repository.NoteStateRepository, userId string, notes []models.Note, query dto.NoteListQuery) ([]models.Note, error) {
+	pinned, err := parseStateFilter("pinned", query.Pinned)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := parseStateFilter("archived", query.Archived)
+	if err != nil {
+		return nil, err
+	}
+	favourite, err := parseStateFilter("favourite", query.Favourite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillNoteStates(stateRepo, userId, notes)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]models.Note, 0, len(notes))
+	for _, note := range notes {
+		if matchesState(pinned, note.Pinned) && matchesState(archived, note.Archived) && matchesState(favourite, note.Favourite) {
+			filtered = append(filtered, note)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Pinned && !filtered[j].Pinned
+	})
+	return filtered, nil
+}
+
+// fillNoteStates fills in the user's state of each note.
+func fillNoteStates(stateRepo repository.NoteStateRepository, userId string, notes []models.Note) error {
+	noteIDs := make([]string, len(notes))
+	for i, note := range notes {
+		noteIDs[i] = note.ID
+	}
+	states, err := stateRepo.GetStates(userId, noteIDs)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		state := states[notes[i].ID]
+		notes[i].Pinned, notes[i].Archived, notes[i].Favourite = state.Pinned, state.Archived, state.Favourite
+	}
+	return nil
+}
+
+// setNoteState fills in the user's state of a single note.
+func setNoteState(stateRepo repository.NoteStateRepository, userId string, note *models.Note) error {
+	states, err := stateRepo.GetStates(userId, []string{note.ID})
+	if err != nil {
+		return err
+	}
+	state := states[note.ID]
+	note.Pinned, note.Archived, note.Favourite = state.Pinned, state.Archived, state.Favourite
+	return nil
+}
+
+func (s *noteService) SetNoteStates(userId string, request dto.NoteStateRequest) ([]models.NoteState, error) {
+	if request.Pinned == nil && request.Archived == nil && request.Favourite == nil {
+		return nil, &httperror.BadClientRequestError{Message: "Set at least one of pinned, archived or favourite"}
+	}
+
+	noteIDs := make([]string, 0, len(request.NoteIDs))
+	seen := make(map[string]bool)
+	for _, id := range request.NoteIDs {
+		if !seen[id] {
+			seen[id] = true
+			noteIDs = append(noteIDs, id)
+		}
+	}
+	if len(noteIDs) == 0 || len(noteIDs) > maxStateNotes {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("note_ids must list between 1 and %d notes", maxStateNotes)}
+	}
+	request.NoteIDs = noteIDs
+
+	// everything is checked first so the change is all or nothing
+	for _, id := range noteIDs {
+		_, err := s.authorize(userId, id, models.NoteRoleViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.stateRepo.SetStates(userId, request)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := s.stateRepo.GetStates(userId, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	changed := make([]models.NoteState, len(noteIDs))
+	for i, id := range noteIDs {
+		changed[i] = states[id]
+		changed[i].NoteID = id
+	}
+	s.bus.Publish(userId, events.NoteStateChanged, changed)
+
+	return changed, nil
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vaporii/v8box/internal/checklist"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const (
+	// per note and per schema
+	maxProperties      = 50
+	maxPropertyName    = 64
+	maxPropertyText    = 1000
+	maxEnumOptions     = 100
+	maxPropertyFilters = 20
+)
+
+// names end up in prop.<name> listing parameters, so they can't contain
+// anything that looks like an operator
+var propertyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// PropertyService manages the property schemas of notebooks. A notebook is
+// a workspace, or a user's personal notes.
+type PropertyService interface {
+	// GetSchema returns the schema of a workspace, or of the user's
+	// personal notes when workspaceId is empty.
+	GetSchema(userId string, workspaceId string) (*models.PropertySchema, error)
+	// SetSchema replaces a notebook's schema. Values notes already have
+	// must fit the new definitions.
+	SetSchema(userId string, workspaceId string, request dto.PropertySchemaRequest) (*models.PropertySchema, error)
+}
+
+type propertyService struct {
+	propertyRepo  repository.PropertyRepository
+	workspaceRepo repository.WorkspaceRepository
+}
+
+func NewPropertyService(propertyRepo repository.PropertyRepository, workspaceRepo repository.WorkspaceRepository) PropertyService {
+	return &propertyService{
+		propertyRepo:  propertyRepo,
+		workspaceRepo: workspaceRepo,
+	}
+}
+
+func (s *propertyService) GetSchema(userId string, workspaceId string) (*models.PropertySchema, error) {
+	if workspaceId != "" {
+		err := checkWorkspaceRole(s.workspaceRepo, userId, workspaceId, models.WorkspaceRoleViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	definitions, err := s.propertyRepo.GetSchema(userId, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PropertySchema{WorkspaceID: workspaceId, Properties: definitions}, nil
+}
+
+func (s *propertyService) SetSchema(userId string, workspaceId string, request dto.PropertySchemaRequest) (*models.PropertySchema, error) {
+	// a schema constrains every member's notes, so it's for admins
+	if workspaceId != "" {
+		err := checkWorkspaceRole(s.workspaceRepo, userId, workspaceId, models.WorkspaceRoleAdmin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(request.Properties) > maxProperties {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("A schema can have at most %d properties", maxProperties)}
+	}
+	definitions := make([]models.PropertyDefinition, 0, len(request.Properties))
+	seen := make(map[string]bool)
+	for _, property := range request.Properties {
+		definition, err := checkDefinition(property)
+		if err != nil {
+			return nil, err
+		}
+		if seen[definition.Name] {
+			return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q is defined twice", definition.Name)}
+		}
+		seen[definition.Name] = true
+
+		values, err := s.propertyRepo.GetPropertyValues(userId, workspaceId, definition.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if !fitsDefinition(value, definition) {
+				return nil, &httperror.ConflictError{Message: fmt.Sprintf("Some notes have a value of %q that isn't a valid %s, change them first", definition.Name, definition.Type)}
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+
+	err := s.propertyRepo.SetSchema(userId, workspaceId, definitions)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PropertySchema{WorkspaceID: workspaceId, Properties: definitions}, nil
+}
+
+func checkPropertyName(name string) error {
+	if len(name) > maxPropertyName || !propertyNamePattern.MatchString(name) {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("Invalid property name %q, use up to %d letters, digits, '_' and '-'", name, maxPropertyName)}
+	}
+	return nil
+}
+
+func checkDefinition(request dto.PropertyDefinitionRequest) (models.PropertyDefinition, error) {
+	definition := models.PropertyDefinition{
+		Name: request.Name,
+		Type: models.PropertyType(request.Type),
+	}
+	err := checkPropertyName(definition.Name)
+	if err != nil {
+		return definition, err
+	}
+	if !definition.Type.Valid() {
+		return definition, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q needs a type of string, number, date, bool or enum", definition.Name)}
+	}
+
+	if definition.Type != models.PropertyEnum {
+		if len(request.Options) > 0 {
+			return definition, &httperror.BadClientRequestError{Message: fmt.Sprintf("Only enums have options, %q is a %s", definition.Name, definition.Type)}
+		}
+		return definition, nil
+	}
+
+	if len(request.Options) == 0 || len(request.Options) > maxEnumOptions {
+		return definition, &httperror.BadClientRequestError{Message: fmt.Sprintf("Enum %q needs between 1 and %d options", definition.Name, maxEnumOptions)}
+	}
+	for _, option := range request.Options {
+		if option == "" || utf8.RuneCountInString(option) > maxPropertyText {
+			return definition, &httperror.BadClientRequestError{Message: fmt.Sprintf("Options of %q must be between 1 and %d characters", definition.Name, maxPropertyText)}
+		}
+		if slices.Contains(definition.Options, option) {
+			return definition, &httperror.BadClientRequestError{Message: fmt.Sprintf("Option %q of %q is given twice", option, definition.Name)}
+		}
+		definition.Options = append(definition.Options, option)
+	}
+	return definition, nil
+}
+
+func fitsDefinition(property models.Property, definition models.PropertyDefinition) bool {
+	if property.Type != definition.Type {
+		return false
+	}
+	if definition.Type == models.PropertyEnum {
+		value, _ := property.Value.(string)
+		return slices.Contains(definition.Options, value)
+	}
+	return true
+}
+
+// parseProperties checks the properties given for a note in the notebook of
+// userId or workspaceId against its schema.
+func parseProperties(propertyRepo repository.PropertyRepository, userId string, workspaceId string, given map[string]dto.PropertyValue) (map[string]models.Property, error) {
+	if len(given) > maxProperties {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("A note can have at most %d properties", maxProperties)}
+	}
+
+	schema, err := propertyRepo.GetSchema(userId, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]*models.PropertyDefinition)
+	for i := range schema {
+		definitions[schema[i].Name] = &schema[i]
+	}
+
+	properties := make(map[string]models.Property, len(given))
+	for name, value := range given {
+		err := checkPropertyName(name)
+		if err != nil {
+			return nil, err
+		}
+		properties[name], err = parseProperty(name, value, definitions[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return properties, nil
+}
+
+// parseProperty works out the type of a value from the schema's definition
+// if there is one, then the type given with it, then its JSON type.
+func parseProperty(name string, given dto.PropertyValue, definition *models.PropertyDefinition) (models.Property, error) {
+	property := models.Property{Type: models.PropertyType(given.Type)}
+	if definition != nil {
+		if property.Type != "" && property.Type != definition.Type {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q is a %s", name, definition.Type)}
+		}
+		property.Type = definition.Type
+	}
+
+	var value any
+	if len(given.Value) > 0 {
+		err := json.Unmarshal(given.Value, &value)
+		if err != nil {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q has an invalid value", name)}
+		}
+	}
+	if value == nil {
+		return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q needs a value", name)}
+	}
+
+	if property.Type == "" {
+		switch value.(type) {
+		case string:
+			property.Type = models.PropertyString
+		case float64:
+			property.Type = models.PropertyNumber
+		case bool:
+			property.Type = models.PropertyBool
+		}
+	}
+
+	wrongType := &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q must be a %s", name, property.Type)}
+	switch property.Type {
+	case models.PropertyString:
+		text, ok := value.(string)
+		if !ok {
+			return property, wrongType
+		}
+		if utf8.RuneCountInString(text) > maxPropertyText {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q can be at most %d characters", name, maxPropertyText)}
+		}
+	case models.PropertyNumber:
+		number, ok := value.(float64)
+		if !ok || math.IsInf(number, 0) {
+			return property, wrongType
+		}
+	case models.PropertyBool:
+		if _, ok := value.(bool); !ok {
+			return property, wrongType
+		}
+	case models.PropertyDate:
+		text, ok := value.(string)
+		if !ok {
+			return property, wrongType
+		}
+		if _, err := time.Parse(checklist.DateLayout, text); err != nil {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q must be a date like 2006-01-02", name)}
+		}
+	case models.PropertyEnum:
+		if definition == nil {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Enum %q needs a schema listing its options", name)}
+		}
+		text, _ := value.(string)
+		if !slices.Contains(definition.Options, text) {
+			return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q must be one of the schema's options", name)}
+		}
+	default:
+		return property, &httperror.BadClientRequestError{Message: fmt.Sprintf("Property %q needs a type of string, number, date, bool or enum", name)}
+	}
+
+	property.Value = value
+	return property, nil
+}
+
+func checkPropertyFilters(filters []dto.PropertyFilter) error {
+	if len(filters) > maxPropertyFilters {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("At most %d property filters can be used at once", maxPropertyFilters)}
+	}
+	for _, filter := range filters {
+		err := checkPropertyName(filter.Name)
+		if err != nil {
+			return err
+		}
+		switch filter.Op {
+		case "", "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("Unknown operator %q in filter on %q", filter.Op, filter.Name)}
+		}
+	}
+	return nil
+}
+
+// setProperties fills in the properties of each note.
+func setProperties(propertyRepo repository.PropertyRepository, notes []models.Note) error {
+	noteIDs := make([]string, len(notes))
+	for i, note := range notes {
+		noteIDs[i] = note.ID
+	}
+	properties, err := propertyRepo.GetProperties(noteIDs)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		notes[i].Properties = properties[notes[i].ID]
+	}
+	return nil
+}
+
+func setNoteProperties(propertyRepo repository.PropertyRepository, note *models.Note) error {
+	properties, err := propertyRepo.GetProperties([]string{note.ID})
+	if err != nil {
+		return err
+	}
+	note.Properties = properties[note.ID]
+	return nil
+}
@@ -0,0 +1,501 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/events"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/notify"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/rrule"
+)
+
+const (
+	maxReminderMessage = 1000
+	// how many reminders or deliveries are handled per query
+	reminderBatch = 100
+)
+
+// how long to wait before retrying a delivery, by attempt. A delivery is
+// given up on when it has failed once more than there are delays.
+var deliveryRetryDelays = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// errUndeliverable means a delivery can never go out, so it isn't retried.
+var errUndeliverable = errors.New("undeliverable")
+
+// ReminderService manages reminders on notes and delivers them on the
+// notification channels users have set up.
+type ReminderService interface {
+	CreateReminder(userId string, noteId string, request dto.ReminderRequest) (*models.Reminder, error)
+	// GetReminders lists the user's reminders, only those on noteId if it's
+	// set.
+	GetReminders(userId string, noteId string) ([]models.Reminder, error)
+	DeleteReminder(userId string, id string) error
+	GetChannels(userId string) ([]models.NotificationChannel, error)
+	// SetChannels replaces the user's notification channels.
+	SetChannels(userId string, requests []dto.NotificationChannelRequest) ([]models.NotificationChannel, error)
+	// TestChannels sends a test message on each of the user's channels.
+	TestChannels(ctx context.Context, userId string) ([]dto.NotificationChannelResult, error)
+	// Run fires due reminders and delivers them until ctx is done.
+	Run(ctx context.Context)
+}
+
+type reminderService struct {
+	reminderRepo     repository.ReminderRepository
+	notificationRepo repository.NotificationRepository
+	noteService      NoteService
+	userService      UserService
+	bus              *events.Bus
+	conf             config.Config
+	// sends webhook and ntfy messages
+	client *http.Client
+}
+
+func NewReminderService(reminderRepo repository.ReminderRepository, notificationRepo repository.NotificationRepository, noteService NoteService, userService UserService, bus *events.Bus, conf config.Config) ReminderService {
+	return &reminderService{
+		reminderRepo:     reminderRepo,
+		notificationRepo: notificationRepo,
+		noteService:      noteService,
+		userService:      userService,
+		bus:              bus,
+		conf:             conf,
+		client:           notify.NewClient(conf.NotifyAllowPrivate),
+	}
+}
+
+func (s *reminderService) CreateReminder(userId string, noteId string, request dto.ReminderRequest) (*models.Reminder, error) {
+	_, err := s.noteService.GetNoteByID(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.At.IsZero() {
+		return nil, &httperror.BadClientRequestError{Message: "at is required"}
+	}
+	if utf8.RuneCountInString(request.Message) > maxReminderMessage {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("message can't be longer than %d characters", maxReminderMessage)}
+	}
+
+	timezone := request.Timezone
+	if timezone == "" {
+		timezone = s.userService.GetSettings(userId).Timezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return nil, &httperror.BadClientRequestError{Message: "timezone must be an IANA time zone name like Europe/Berlin"}
+	}
+
+	start := request.At.In(location)
+	now := time.Now()
+	next := start
+	if request.RRule != "" {
+		rule, err := rrule.Parse(request.RRule)
+		if err != nil {
+			return nil, &httperror.BadClientRequestError{Message: "Invalid rrule: " + err.Error()}
+		}
+		var ok bool
+		next, ok = rule.After(start, now)
+		if !ok {
+			return nil, &httperror.BadClientRequestError{Message: "The reminder wouldn't go off again"}
+		}
+	} else if !start.After(now) {
+		return nil, &httperror.BadClientRequestError{Message: "at must be in the future"}
+	}
+
+	return s.reminderRepo.CreateReminder(&models.Reminder{
+		ID:       uuid.NewString(),
+		NoteID:   noteId,
+		UserID:   userId,
+		At:       start,
+		RRule:    request.RRule,
+		Timezone: location.String(),
+		Message:  request.Message,
+		NextAt:   &next,
+	})
+}
+
+func (s *reminderService) GetReminders(userId string, noteId string) ([]models.Reminder, error) {
+	if noteId != "" {
+		_, err := s.noteService.GetNoteByID(userId, noteId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.reminderRepo.GetUserReminders(userId, noteId)
+}
+
+func (s *reminderService) DeleteReminder(userId string, id string) error {
+	reminder, err := s.reminderRepo.GetReminder(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &httperror.NotFoundError{Entity: "Reminder"}
+		}
+		return err
+	}
+	if reminder.UserID != userId {
+		return &httperror.NotFoundError{Entity: "Reminder"}
+	}
+	return s.reminderRepo.DeleteReminder(id)
+}
+
+func (s *reminderService) GetChannels(userId string) ([]models.NotificationChannel, error) {
+	return s.notificationRepo.GetChannels(userId)
+}
+
+func (s *reminderService) SetChannels(userId string, requests []dto.NotificationChannelRequest) ([]models.NotificationChannel, error) {
+	existing, err := s.notificationRepo.GetChannels(userId)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[models.NotificationChannelType]string)
+	for _, channel := range existing {
+		secrets[channel.Type] = channel.Secret
+	}
+
+	channels := make([]models.NotificationChannel, 0, len(requests))
+	seen := make(map[models.NotificationChannelType]bool)
+	for _, request := range requests {
+		channel := models.NotificationChannel{
+			Type:    models.NotificationChannelType(request.Type),
+			Enabled: request.Enabled == nil || *request.Enabled,
+			Target:  request.Target,
+		}
+		if !channel.Type.Valid() {
+			return nil, &httperror.BadClientRequestError{Message: "Unknown channel type, expected webhook, email or ntfy"}
+		}
+		if seen[channel.Type] {
+			return nil, &httperror.BadClientRequestError{Message: "Only one " + request.Type + " channel is allowed"}
+		}
+		seen[channel.Type] = true
+
+		switch channel.Type {
+		case models.NotificationChannelWebhook, models.NotificationChannelNtfy:
+			target, err := url.Parse(channel.Target)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return nil, &httperror.BadClientRequestError{Message: request.Type + " target must be an http or https URL"}
+			}
+			channel.Secret = secrets[channel.Type]
+			if request.Secret != nil {
+				channel.Secret = *request.Secret
+			}
+		case models.NotificationChannelEmail:
+			if !s.emailConfigured() {
+				return nil, &httperror.BadClientRequestError{Message: "Email notifications aren't set up on this server"}
+			}
+			address, err := mail.ParseAddress(channel.Target)
+			if err != nil {
+				return nil, &httperror.BadClientRequestError{Message: "email target must be an email address"}
+			}
+			channel.Target = address.Address
+		}
+		channel.HasSecret = channel.Secret != ""
+		channels = append(channels, channel)
+	}
+
+	err = s.notificationRepo.SetChannels(userId, channels)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (s *reminderService) TestChannels(ctx context.Context, userId string) ([]dto.NotificationChannelResult, error) {
+	channels, err := s.notificationRepo.GetChannels(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]dto.NotificationChannelResult, 0, len(channels))
+	for _, channel := range channels {
+		result := dto.NotificationChannelResult{Type: string(channel.Type), OK: true}
+		err := s.send(ctx, channel, notify.Message{
+			ID:    uuid.NewString(),
+			Title: "Test notification",
+			Body:  "Your v8box reminders will show up here.",
+			Time:  time.Now(),
+		})
+		if err != nil {
+			result.OK = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *reminderService) emailConfigured() bool {
+	return s.conf.SMTPHost != "" && s.conf.SMTPFrom != ""
+}
+
+func (s *reminderService) notifier(channel models.NotificationChannel) (notify.Notifier, error) {
+	switch channel.Type {
+	case models.NotificationChannelWebhook:
+		return &notify.Webhook{URL: channel.Target, Secret: channel.Secret, Client: s.client}, nil
+	case models.NotificationChannelNtfy:
+		return &notify.Ntfy{URL: channel.Target, Token: channel.Secret, Client: s.client}, nil
+	case models.NotificationChannelEmail:
+		if !s.emailConfigured() {
+			return nil, errors.New("email notifications aren't set up on this server")
+		}
+		return &notify.Email{
+			Config: notify.SMTPConfig{
+				Host:     s.conf.SMTPHost,
+				Port:     s.conf.SMTPPort,
+				Username: s.conf.SMTPUsername,
+				Password: s.conf.SMTPPassword,
+				From:     s.conf.SMTPFrom,
+				Security: s.conf.SMTPSecurity,
+			},
+			To: channel.Target,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+func (s *reminderService) send(ctx context.Context, channel models.NotificationChannel, message notify.Message) error {
+	notifier, err := s.notifier(channel)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notify.Timeout)
+	defer cancel()
+	return notifier.Send(ctx, message)
+}
+
+func (s *reminderService) Run(ctx context.Context) {
+	interval := s.conf.ReminderInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.fireDue(ctx)
+		s.deliverPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fireDue moves every due reminder on to its next occurrence and queues its
+// deliveries. Occurrences missed while the server was down are skipped, the
+// reminder only goes off once for them. Reminders that fail stay due and are
+// tried again on the next tick.
+func (s *reminderService) fireDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		reminders, err := s.reminderRepo.GetDueReminders(now, reminderBatch)
+		if err != nil {
+			logging.Error("err getting due reminders: %v", err)
+			return
+		}
+
+		handled := 0
+		for _, reminder := range reminders {
+			if s.fire(&reminder, now) {
+				handled++
+			}
+		}
+		// a full batch that all failed would come straight back
+		if len(reminders) < reminderBatch || handled == 0 {
+			return
+		}
+	}
+}
+
+// fire reports whether the reminder is no longer due, false when it failed
+// and was left as it was.
+func (s *reminderService) fire(reminder *models.Reminder, now time.Time) bool {
+	// the user may have lost access to the note since setting the reminder
+	_, err := s.noteService.GetNoteByID(reminder.UserID, reminder.NoteID)
+	var notFound *httperror.NotFoundError
+	if errors.As(err, &notFound) {
+		logging.Info("removing reminder %s, its note is gone", reminder.ID)
+		err = s.reminderRepo.DeleteReminder(reminder.ID)
+		if err != nil {
+			logging.Error("err removing reminder %s: %v", reminder.ID, err)
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		logging.Error("err checking note for reminder %s: %v", reminder.ID, err)
+		return false
+	}
+
+	var next *time.Time
+	if reminder.RRule != "" {
+		rule, err := rrule.Parse(reminder.RRule)
+		if err != nil {
+			logging.Error("err parsing rrule of reminder %s: %v", reminder.ID, err)
+		} else {
+			location, err := time.LoadLocation(reminder.Timezone)
+			if err != nil {
+				location = time.UTC
+			}
+			if occurrence, ok := rule.After(reminder.At.In(location), now); ok {
+				next = &occurrence
+			}
+		}
+	}
+
+	channels, err := s.notificationRepo.GetChannels(reminder.UserID)
+	if err != nil {
+		logging.Error("err getting notification channels for reminder %s: %v", reminder.ID, err)
+		return false
+	}
+	types := make([]models.NotificationChannelType, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Enabled {
+			types = append(types, channel.Type)
+		}
+	}
+
+	fired, err := s.reminderRepo.FireReminder(reminder, next, types)
+	if err != nil {
+		logging.Error("err firing reminder %s: %v", reminder.ID, err)
+		return false
+	}
+	// not fired means it was changed or fired elsewhere in the meantime
+	if !fired {
+		return true
+	}
+
+	reminder.FiredCount++
+	reminder.LastFiredAt = reminder.NextAt
+	reminder.NextAt = next
+	s.bus.Publish(reminder.UserID, events.ReminderDue, reminder)
+	return true
+}
+
+func (s *reminderService) deliverPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := s.reminderRepo.GetPendingDeliveries(time.Now(), reminderBatch)
+		if err != nil {
+			logging.Error("err getting pending deliveries: %v", err)
+			return
+		}
+
+		handled := 0
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return
+			}
+			if s.deliver(ctx, &delivery) {
+				handled++
+			}
+		}
+		// like fireDue, failures are left for the next tick
+		if len(deliveries) < reminderBatch || handled == 0 {
+			return
+		}
+	}
+}
+
+// deliver reports whether the delivery is no longer pending, false when it
+// failed and was left as it was.
+func (s *reminderService) deliver(ctx context.Context, delivery *models.ReminderDelivery) bool {
+	message, channel, err := s.deliveryMessage(delivery)
+	if err != nil && !errors.Is(err, errUndeliverable) {
+		logging.Error("err preparing delivery %s: %v", delivery.ID, err)
+		return false
+	}
+	if err != nil {
+		logging.Warning("giving up on delivery %s: %v", delivery.ID, err)
+		err = s.reminderRepo.RetryDelivery(delivery.ID, nil, err.Error())
+		if err != nil {
+			logging.Error("err failing delivery %s: %v", delivery.ID, err)
+			return false
+		}
+		return true
+	}
+
+	err = s.send(ctx, *channel, *message)
+	if err == nil {
+		err = s.reminderRepo.CompleteDelivery(delivery.ID)
+		if err != nil {
+			logging.Error("err completing delivery %s: %v", delivery.ID, err)
+			return false
+		}
+		return true
+	}
+	if ctx.Err() != nil {
+		// shutting down, it'll be sent again on the next start
+		return false
+	}
+
+	var retryAt *time.Time
+	if delivery.Attempts < len(deliveryRetryDelays) {
+		at := time.Now().Add(deliveryRetryDelays[delivery.Attempts])
+		retryAt = &at
+	}
+	logging.Warning("err sending delivery %s over %s: %v", delivery.ID, delivery.Channel, err)
+	err = s.reminderRepo.RetryDelivery(delivery.ID, retryAt, err.Error())
+	if err != nil {
+		logging.Error("err recording failed delivery %s: %v", delivery.ID, err)
+		return false
+	}
+	return true
+}
+
+func (s *reminderService) deliveryMessage(delivery *models.ReminderDelivery) (*notify.Message, *models.NotificationChannel, error) {
+	channels, err := s.notificationRepo.GetChannels(delivery.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var channel *models.NotificationChannel
+	for i := range channels {
+		if channels[i].Type == delivery.Channel && channels[i].Enabled {
+			channel = &channels[i]
+		}
+	}
+	if channel == nil {
+		return nil, nil, fmt.Errorf("%w: the channel was removed or disabled", errUndeliverable)
+	}
+
+	reminder, err := s.reminderRepo.GetReminder(delivery.ReminderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("%w: the reminder was removed", errUndeliverable)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	note, err := s.noteService.GetNoteByID(delivery.UserID, delivery.NoteID)
+	var notFound *httperror.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil, fmt.Errorf("%w: the note is gone", errUndeliverable)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := reminder.Message
+	if body == "" {
+		body = note.Title
+	}
+	return &notify.Message{
+		ID:    delivery.ID,
+		Title: "Reminder: " + note.Title,
+		Body:  body,
+		URL:   noteURL(s.conf.URL, note.ID),
+		Time:  delivery.ScheduledAt,
+	}, channel, nil
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/notify"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+// reminderNotes is the one note reminders in these tests are on.
+type reminderNotes struct {
+	NoteService
+}
+
+func (reminderNotes) GetNoteByID(userId string, id string) (*models.Note, error) {
+	return &models.Note{ID: id, Title: "Rent"}, nil
+}
+
+// deliveryTest has a reminder that fired on one channel pointing at a
+// stand-in answering with statuses in turn.
+type deliveryTest struct {
+	service      *reminderService
+	reminderRepo repository.ReminderRepository
+	requests     atomic.Int32
+	bodies       chan string
+}
+
+func newDeliveryTest(t *testing.T, channel models.NotificationChannelType, statuses ...int) *deliveryTest {
+	t.Helper()
+	d := &deliveryTest{bodies: make(chan string, len(statuses))}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(d.requests.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		d.bodies <- string(body)
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+
+	db, err := repository.OpenDB(filepath.Join(t.TempDir(), "v8box.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	d.reminderRepo, err = repository.NewReminderRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notificationRepo, err := repository.NewNotificationRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notificationRepo.SetChannels("user", []models.NotificationChannel{{Type: channel, Enabled: true, Target: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(-time.Minute)
+	reminder, err := d.reminderRepo.CreateReminder(&models.Reminder{
+		ID:       "reminder",
+		NoteID:   "note",
+		UserID:   "user",
+		At:       at,
+		Timezone: "UTC",
+		Message:  "Pay rent",
+		NextAt:   &at,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fired, err := d.reminderRepo.FireReminder(reminder, nil, []models.NotificationChannelType{channel})
+	if err != nil || !fired {
+		t.Fatalf("firing the reminder: %v, %v", fired, err)
+	}
+
+	d.service = &reminderService{
+		reminderRepo:     d.reminderRepo,
+		notificationRepo: notificationRepo,
+		noteService:      reminderNotes{},
+		conf:             config.Config{URL: "https://v8box.example"},
+		// the stand-in is on localhost
+		client: notify.NewClient(true),
+	}
+	return d
+}
+
+// pending lists deliveries that are due by in.
+func (d *deliveryTest) pending(t *testing.T, in time.Duration) []models.ReminderDelivery {
+	t.Helper()
+	deliveries, err := d.reminderRepo.GetPendingDeliveries(time.Now().Add(in), reminderBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func TestDeliveryRetriedAfterServerError(t *testing.T) {
+	for _, channel := range []models.NotificationChannelType{models.NotificationChannelWebhook, models.NotificationChannelNtfy} {
+		t.Run(string(channel), func(t *testing.T) {
+			d := newDeliveryTest(t, channel, http.StatusServiceUnavailable, http.StatusOK)
+
+			d.service.deliverPending(context.Background())
+			if d.requests.Load() != 1 {
+				t.Fatalf("%d requests after the first run", d.requests.Load())
+			}
+			if body := <-d.bodies; !strings.Contains(body, "Pay rent") {
+				t.Errorf("body %q doesn't have the message", body)
+			}
+
+			// not due again until the first retry delay has passed
+			if due := d.pending(t, 0); len(due) != 0 {
+				t.Fatalf("failed delivery is due again right away: %+v", due)
+			}
+			due := d.pending(t, deliveryRetryDelays[0]+time.Second)
+			if len(due) != 1 {
+				t.Fatalf("%d deliveries due after the retry delay", len(due))
+			}
+			if due[0].Attempts != 1 || !strings.Contains(due[0].Error, "503") {
+				t.Errorf("failed attempt recorded as %d attempts, error %q", due[0].Attempts, due[0].Error)
+			}
+
+			if !d.service.deliver(context.Background(), &due[0]) {
+				t.Fatal("retry wasn't handled")
+			}
+			if d.requests.Load() != 2 {
+				t.Fatalf("%d requests after the retry", d.requests.Load())
+			}
+			if due := d.pending(t, 24*time.Hour); len(due) != 0 {
+				t.Errorf("delivery still pending after going through: %+v", due)
+			}
+		})
+	}
+}
+
+func TestDeliveryGivenUpAfterLastRetry(t *testing.T) {
+	d := newDeliveryTest(t, models.NotificationChannelWebhook, http.StatusInternalServerError)
+
+	due := d.pending(t, 0)
+	if len(due) != 1 {
+		t.Fatalf("%d deliveries due", len(due))
+	}
+	due[0].Attempts = len(deliveryRetryDelays)
+	if !d.service.deliver(context.Background(), &due[0]) {
+		t.Fatal("delivery wasn't handled")
+	}
+	if due := d.pending(t, 24*time.Hour); len(due) != 0 {
+		t.Errorf("delivery still pending after its last attempt: %+v", due)
+	}
+}
+
+func TestDeliveryToPrivateAddressRefused(t *testing.T) {
+	d := newDeliveryTest(t, models.NotificationChannelWebhook, http.StatusOK)
+	d.service.client = notify.NewClient(false)
+
+	d.service.deliverPending(context.Background())
+	if d.requests.Load() != 0 {
+		t.Fatal("the stand-in on localhost was reached")
+	}
+	due := d.pending(t, deliveryRetryDelays[0]+time.Second)
+	if len(due) != 1 || !strings.Contains(due[0].Error, "not a public address") {
+		t.Errorf("refused delivery recorded as %+v", due)
+	}
+}
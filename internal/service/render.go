@@ -0,0 +1,43 @@
+package service
+
+import (
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/render"
+)
+
+type RenderService interface {
+	// RenderNoteByID renders the note's Markdown content to HTML that's safe
+	// to show as is. Encrypted notes come back with nothing rendered.
+	RenderNoteByID(userId string, id string) (*models.RenderedNote, error)
+}
+
+type renderService struct {
+	noteService NoteService
+	cache       *render.Cache
+}
+
+func NewRenderService(noteService NoteService, cache *render.Cache) RenderService {
+	return &renderService{
+		noteService: noteService,
+		cache:       cache,
+	}
+}
+
+func (s *renderService) RenderNoteByID(userId string, id string) (*models.RenderedNote, error) {
+	note, err := s.noteService.GetNoteByID(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	// the server can't read encrypted notes, clients render them once
+	// they've decrypted them
+	if note.Encrypted() {
+		return &models.RenderedNote{Note: *note, Document: render.Document{TOC: make([]render.Heading, 0)}}, nil
+	}
+
+	document, err := s.cache.Markdown(note.ID, note.Version, note.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RenderedNote{Note: *note, Document: *document}, nil
+}
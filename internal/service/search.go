@@ -0,0 +1,217 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/search"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	maxSavedSearches   = 100
+	maxSavedSearchName = 255
+
+	// orders search results by how well they match the text searched for
+	sortRelevance = "relevance"
+)
+
+// SearchService runs queries in the search language over every note a user
+// can see, and keeps their saved searches.
+type SearchService interface {
+	Search(userId string, request dto.SearchRequest) ([]models.Note, error)
+
+	CreateSavedSearch(userId string, request dto.SavedSearchRequest) (*models.SavedSearch, error)
+	GetSavedSearches(userId string) ([]models.SavedSearch, error)
+	GetSavedSearch(userId string, id string) (*models.SavedSearch, error)
+	UpdateSavedSearch(userId string, id string, request dto.SavedSearchRequest) (*models.SavedSearch, error)
+	DeleteSavedSearch(userId string, id string) error
+	// RunSavedSearch runs a saved search's query, request.Query is
+	// ignored.
+	RunSavedSearch(userId string, id string, request dto.SearchRequest) ([]models.Note, error)
+}
+
+type searchService struct {
+	searchRepo     repository.SearchRepository
+	stateRepo      repository.NoteStateRepository
+	propertyRepo   repository.PropertyRepository
+	attachmentRepo repository.AttachmentRepository
+	keyRepo        repository.KeyRepository
+	userService    UserService
+	conf           config.Config
+}
+
+func NewSearchService(searchRepo repository.SearchRepository, stateRepo repository.NoteStateRepository, propertyRepo repository.PropertyRepository, attachmentRepo repository.AttachmentRepository, keyRepo repository.KeyRepository, userService UserService, conf config.Config) SearchService {
+	return &searchService{
+		searchRepo:     searchRepo,
+		stateRepo:      stateRepo,
+		propertyRepo:   propertyRepo,
+		attachmentRepo: attachmentRepo,
+		keyRepo:        keyRepo,
+		userService:    userService,
+		conf:           conf,
+	}
+}
+
+// parseSearch reads a query, turning syntax errors into a message that says
+// where the problem is.
+func parseSearch(input string) (*search.Query, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, &httperror.BadClientRequestError{Message: "Search query is empty"}
+	}
+	query, err := search.Parse(input)
+	var syntaxErr *search.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("Search syntax error at column %d: %s", syntaxErr.Column, syntaxErr.Message)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// Search includes archived notes, -archived leaves them out.
+func (s *searchService) Search(userId string, request dto.SearchRequest) ([]models.Note, error) {
+	query, err := parseSearch(request.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if limit < 1 || limit > maxSearchLimit {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)}
+	}
+
+	settings := s.userService.GetSettings(userId)
+	var sort models.NoteSort
+	switch {
+	case request.Sort == sortRelevance:
+		if !query.HasText() {
+			return nil, &httperror.BadClientRequestError{Message: "Only searches for text can be sorted by relevance"}
+		}
+	case request.Sort != "":
+		sort = models.NoteSort(request.Sort)
+		if !sort.Valid() {
+			return nil, &httperror.BadClientRequestError{Message: "sort must be relevance or one of updated_desc, updated_asc, created_desc, created_asc, title_asc or title_desc"}
+		}
+	case !query.HasText():
+		sort = settings.DefaultSort
+	}
+
+	notes, err := s.searchRepo.SearchNotes(userId, query, settings.Location(), sort, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillNoteStates(s.stateRepo, userId, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setThumbnailURLs(s.attachmentRepo, s.conf.URL, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setProperties(s.propertyRepo, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setWrappedKeys(s.keyRepo, userId, notes)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+func checkSavedSearch(request dto.SavedSearchRequest) (dto.SavedSearchRequest, error) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxSavedSearchName {
+		return request, &httperror.BadClientRequestError{Message: fmt.Sprintf("Name must be between 1 and %d characters", maxSavedSearchName)}
+	}
+	request.Query = strings.TrimSpace(request.Query)
+	_, err := parseSearch(request.Query)
+	return request, err
+}
+
+func (s *searchService) CreateSavedSearch(userId string, request dto.SavedSearchRequest) (*models.SavedSearch, error) {
+	request, err := checkSavedSearch(request)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.searchRepo.CountSavedSearches(userId)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxSavedSearches {
+		return nil, &httperror.ConflictError{Message: fmt.Sprintf("You can have at most %d saved searches", maxSavedSearches)}
+	}
+
+	return s.searchRepo.CreateSavedSearch(&models.SavedSearch{
+		ID:     uuid.NewString(),
+		UserID: userId,
+		Name:   request.Name,
+		Query:  request.Query,
+	})
+}
+
+func (s *searchService) GetSavedSearches(userId string) ([]models.SavedSearch, error) {
+	return s.searchRepo.GetSavedSearches(userId)
+}
+
+// GetSavedSearch reports other users' saved searches as missing.
+func (s *searchService) GetSavedSearch(userId string, id string) (*models.SavedSearch, error) {
+	savedSearch, err := s.searchRepo.GetSavedSearch(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && savedSearch.UserID != userId) {
+		return nil, &httperror.NotFoundError{Entity: "Saved search"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return savedSearch, nil
+}
+
+func (s *searchService) UpdateSavedSearch(userId string, id string, request dto.SavedSearchRequest) (*models.SavedSearch, error) {
+	savedSearch, err := s.GetSavedSearch(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	request, err = checkSavedSearch(request)
+	if err != nil {
+		return nil, err
+	}
+
+	savedSearch.Name = request.Name
+	savedSearch.Query = request.Query
+	return s.searchRepo.UpdateSavedSearch(savedSearch)
+}
+
+func (s *searchService) DeleteSavedSearch(userId string, id string) error {
+	_, err := s.GetSavedSearch(userId, id)
+	if err != nil {
+		return err
+	}
+	return s.searchRepo.DeleteSavedSearch(id)
+}
+
+func (s *searchService) RunSavedSearch(userId string, id string, request dto.SearchRequest) ([]models.Note, error) {
+	savedSearch, err := s.GetSavedSearch(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	request.Query = savedSearch.Query
+	return s.Search(userId, request)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+// SessionService answers whether a token has been revoked. Revocations are
+// few and checked on every request, so they're kept in memory.
+type SessionService interface {
+	IsRevoked(userId string, issuedAt time.Time) bool
+	// Revoked records a revocation that has already been stored.
+	Revoked(userId string, at time.Time)
+}
+
+type sessionService struct {
+	mu          sync.RWMutex
+	revocations map[string]time.Time
+}
+
+func NewSessionService(sessionRepo repository.SessionRepository) (SessionService, error) {
+	revocations, err := sessionRepo.GetRevocations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionService{
+		revocations: revocations,
+	}, nil
+}
+
+// IsRevoked reports tokens issued before the user's revocation. Tokens
+// without an issue time count as old.
+func (s *sessionService) IsRevoked(userId string, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revokedAt, ok := s.revocations[userId]
+	return ok && !issuedAt.After(revokedAt)
+}
+
+func (s *sessionService) Revoked(userId string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revocations[userId] = at
+}
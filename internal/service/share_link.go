@@ -0,0 +1,215 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/security"
+)
+
+// a link's password can be got wrong this many times in passwordWindow,
+// after that it can't be tried again until the oldest wrong attempt is
+// passwordWindow old
+const (
+	passwordAttempts = 10
+	passwordWindow   = 15 * time.Minute
+)
+
+type ShareLinkService interface {
+	CreateLink(userId string, noteId string, request dto.CreateShareLinkRequest) (*models.ShareLink, error)
+	GetNoteLinks(userId string, noteId string) ([]models.ShareLink, error)
+	RevokeLink(userId string, noteId string, linkId string) error
+	GetLinkAccesses(userId string, noteId string, linkId string) ([]models.ShareLinkAccess, error)
+	OpenLink(token string, password string, access models.ShareLinkAccess) (*dto.PublicNote, error)
+}
+
+type shareLinkService struct {
+	linkRepo    repository.ShareLinkRepository
+	noteRepo    repository.NoteRepository
+	noteService NoteService
+	conf        config.Config
+}
+
+func NewShareLinkService(linkRepo repository.ShareLinkRepository, noteRepo repository.NoteRepository, noteService NoteService, conf config.Config) ShareLinkService {
+	return &shareLinkService{
+		linkRepo:    linkRepo,
+		noteRepo:    noteRepo,
+		noteService: noteService,
+		conf:        conf,
+	}
+}
+
+func (s *shareLinkService) CreateLink(userId string, noteId string, request dto.CreateShareLinkRequest) (*models.ShareLink, error) {
+	if request.MaxViews < 0 {
+		return nil, &httperror.BadClientRequestError{Message: "max_views can't be negative"}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return nil, &httperror.BadClientRequestError{Message: "expires_at must be in the future"}
+	}
+
+	note, err := s.checkOwner(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+	// whoever opens a link has no key to read it with
+	if note.Encrypted() {
+		return nil, &httperror.BadClientRequestError{Message: "Encrypted notes can't be shared with a link"}
+	}
+
+	var passwordHash string
+	if request.Password != "" {
+		passwordHash, err = security.HashPassword(request.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token := security.GenerateToken()
+	link, err := s.linkRepo.CreateLink(&models.ShareLink{
+		ID:           uuid.NewString(),
+		NoteID:       noteId,
+		UserID:       userId,
+		TokenHash:    security.HashToken(token),
+		PasswordHash: passwordHash,
+		ExpiresAt:    request.ExpiresAt,
+		MaxViews:     request.MaxViews,
+	})
+	if err != nil {
+		return nil, err
+	}
+	link.URL = s.conf.URL + "/api/v1/public/" + token
+
+	return link, nil
+}
+
+func (s *shareLinkService) GetNoteLinks(userId string, noteId string) ([]models.ShareLink, error) {
+	_, err := s.checkOwner(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.linkRepo.GetNoteLinks(noteId)
+}
+
+func (s *shareLinkService) RevokeLink(userId string, noteId string, linkId string) error {
+	_, err := s.getOwnedLink(userId, noteId, linkId)
+	if err != nil {
+		return err
+	}
+
+	return s.linkRepo.RevokeLink(linkId)
+}
+
+func (s *shareLinkService) GetLinkAccesses(userId string, noteId string, linkId string) ([]models.ShareLinkAccess, error) {
+	_, err := s.getOwnedLink(userId, noteId, linkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.linkRepo.GetLinkAccesses(linkId)
+}
+
+// OpenLink resolves a public link to its note. Unknown, revoked, expired and
+// used up links all look the same to the caller.
+func (s *shareLinkService) OpenLink(token string, password string, access models.ShareLinkAccess) (*dto.PublicNote, error) {
+	notFound := &httperror.NotFoundError{Entity: "Share link"}
+
+	link, err := s.linkRepo.GetLinkByTokenHash(security.HashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+
+	if link.Revoked || (link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt)) {
+		return nil, notFound
+	}
+
+	if link.HasPassword {
+		if password == "" {
+			return nil, &httperror.UnauthorizedError{Message: "Password required"}
+		}
+		attempt, allowed, err := s.linkRepo.TakePasswordAttempt(link.ID, access.RemoteAddr, time.Now().Add(-passwordWindow), passwordAttempts)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, &httperror.TooManyRequestsError{Message: "Too many wrong passwords, try again later"}
+		}
+		if !security.CheckPasswordHash(password, link.PasswordHash) {
+			return nil, &httperror.UnauthorizedError{Message: "Wrong password"}
+		}
+		err = s.linkRepo.ForgetPasswordAttempt(attempt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	note, err := s.noteRepo.GetNoteByID(link.NoteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+
+	allowed, err := s.linkRepo.CountView(link.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, notFound
+	}
+
+	access.LinkID = link.ID
+	err = s.linkRepo.RecordAccess(&access)
+	if err != nil {
+		logging.Warning("couldn't record access to share link %s: %v", link.ID, err)
+	}
+
+	return &dto.PublicNote{
+		Title:     note.Title,
+		Content:   note.Content,
+		UpdatedAt: note.UpdatedAt,
+	}, nil
+}
+
+func (s *shareLinkService) checkOwner(userId string, noteId string) (*models.Note, error) {
+	note, err := s.noteService.GetNoteByID(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+	if note.Role != models.NoteRoleOwner {
+		return nil, &httperror.ForbiddenError{Message: "Only the owner can manage share links"}
+	}
+	return note, nil
+}
+
+func (s *shareLinkService) getOwnedLink(userId string, noteId string, linkId string) (*models.ShareLink, error) {
+	_, err := s.checkOwner(userId, noteId)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.linkRepo.GetLinkByID(linkId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Share link"}
+		}
+		return nil, err
+	}
+	if link.NoteID != noteId {
+		return nil, &httperror.NotFoundError{Entity: "Share link"}
+	}
+
+	return link, nil
+}
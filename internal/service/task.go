@@ -0,0 +1,112 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vaporii/v8box/internal/checklist"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+// TaskService works with the task list items found in notes. Tasks are
+// extracted by the note service whenever a note is saved.
+type TaskService interface {
+	GetTasks(userId string, query dto.TaskQuery) ([]models.Task, error)
+	// ToggleTask checks or unchecks a task by editing the line it's on in
+	// its note.
+	ToggleTask(userId string, id string) (*models.Task, error)
+}
+
+type taskService struct {
+	taskRepo    repository.TaskRepository
+	noteService NoteService
+}
+
+func NewTaskService(taskRepo repository.TaskRepository, noteService NoteService) TaskService {
+	return &taskService{
+		taskRepo:    taskRepo,
+		noteService: noteService,
+	}
+}
+
+func (s *taskService) GetTasks(userId string, query dto.TaskQuery) ([]models.Task, error) {
+	filter := models.TaskFilter{
+		Status:    models.TaskStatus(query.Status),
+		DueBefore: query.DueBefore,
+		Priority:  query.Priority,
+		NoteID:    query.NoteID,
+	}
+
+	switch filter.Status {
+	case "":
+		filter.Status = models.TaskStatusOpen
+	case models.TaskStatusOpen, models.TaskStatusDone, models.TaskStatusAll:
+	default:
+		return nil, &httperror.BadClientRequestError{Message: "Unknown status, expected open, done or all"}
+	}
+	if filter.DueBefore != "" {
+		if _, err := time.Parse(checklist.DateLayout, filter.DueBefore); err != nil {
+			return nil, &httperror.BadClientRequestError{Message: "due_before must be a date like 2026-11-01"}
+		}
+	}
+	if !checklist.Priority(filter.Priority).Valid() {
+		return nil, &httperror.BadClientRequestError{Message: "Unknown priority, expected high, medium or low"}
+	}
+	if filter.NoteID != "" {
+		// also rules out notes the user can't see
+		_, err := s.noteService.GetNoteByID(userId, filter.NoteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.taskRepo.GetUserTasks(userId, filter)
+}
+
+func (s *taskService) ToggleTask(userId string, id string) (*models.Task, error) {
+	task, err := s.taskRepo.GetTask(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Task"}
+		}
+		return nil, err
+	}
+
+	note, err := s.noteService.GetNoteByID(userId, task.NoteID)
+	var notFound *httperror.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, &httperror.NotFoundError{Entity: "Task"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content, item, err := checklist.Toggle(note.ID, note.Content, id)
+	if errors.Is(err, checklist.ErrNotFound) {
+		// the note changed without its tasks being saved again, e.g. in a
+		// live editing session that hasn't been written back yet
+		return nil, &httperror.ConflictError{Message: "The task has changed, reload the note and try again"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// fails if the note was edited since it was read, rather than undoing
+	// the edit
+	_, err = s.noteService.EditNoteByID(userId, note.ID, dto.CreateNoteRequest{
+		Title:   note.Title,
+		Content: content,
+		Version: note.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	task.Line = item.Line
+	task.Done = item.Done
+	return task, nil
+}
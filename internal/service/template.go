@@ -0,0 +1,305 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/notetemplate"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+const (
+	maxTemplateName      = 255
+	maxTemplateVariables = 50
+	maxVariableText      = 255
+)
+
+// variables every template can use without declaring them
+var builtinVariables = map[string]bool{
+	"date": true,
+	"time": true,
+	"user": true,
+}
+
+type TemplateService interface {
+	CreateTemplate(userId string, request dto.TemplateRequest) (*models.Template, error)
+	// GetTemplates lists the templates the user can use, only those of
+	// workspaceId if it's set.
+	GetTemplates(userId string, workspaceId string) ([]models.Template, error)
+	GetTemplate(userId string, id string) (*models.Template, error)
+	UpdateTemplate(userId string, id string, request dto.TemplateRequest) (*models.Template, error)
+	DeleteTemplate(userId string, id string) error
+	// ApplyTemplate fills in a note request from the template it names. at
+	// is when the note is for, {{date}} and {{time}} are taken from it in
+	// the user's timezone.
+	ApplyTemplate(userId string, request *dto.CreateNoteRequest, at time.Time) error
+}
+
+type templateService struct {
+	templateRepo  repository.TemplateRepository
+	workspaceRepo repository.WorkspaceRepository
+	userService   UserService
+}
+
+func NewTemplateService(templateRepo repository.TemplateRepository, workspaceRepo repository.WorkspaceRepository, userService UserService) TemplateService {
+	return &templateService{
+		templateRepo:  templateRepo,
+		workspaceRepo: workspaceRepo,
+		userService:   userService,
+	}
+}
+
+func (s *templateService) CreateTemplate(userId string, request dto.TemplateRequest) (*models.Template, error) {
+	if request.WorkspaceID != "" {
+		err := s.checkWorkspace(userId, request.WorkspaceID, models.WorkspaceRoleEditor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	template := &models.Template{
+		ID:          uuid.NewString(),
+		UserID:      userId,
+		WorkspaceID: request.WorkspaceID,
+	}
+	err := fillTemplate(template, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.templateRepo.CreateTemplate(template)
+}
+
+func (s *templateService) GetTemplates(userId string, workspaceId string) ([]models.Template, error) {
+	if workspaceId != "" {
+		err := s.checkWorkspace(userId, workspaceId, models.WorkspaceRoleViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	templates, err := s.templateRepo.GetUserTemplates(userId)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceId == "" {
+		return templates, nil
+	}
+
+	filtered := make([]models.Template, 0)
+	for _, template := range templates {
+		if template.WorkspaceID == workspaceId {
+			filtered = append(filtered, template)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *templateService) GetTemplate(userId string, id string) (*models.Template, error) {
+	return s.authorize(userId, id, models.WorkspaceRoleViewer)
+}
+
+func (s *templateService) UpdateTemplate(userId string, id string, request dto.TemplateRequest) (*models.Template, error) {
+	template, err := s.authorize(userId, id, models.WorkspaceRoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fillTemplate(template, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.templateRepo.UpdateTemplate(template)
+}
+
+func (s *templateService) DeleteTemplate(userId string, id string) error {
+	_, err := s.authorize(userId, id, models.WorkspaceRoleEditor)
+	if err != nil {
+		return err
+	}
+	return s.templateRepo.DeleteTemplate(id)
+}
+
+func (s *templateService) ApplyTemplate(userId string, request *dto.CreateNoteRequest, at time.Time) error {
+	template, err := s.GetTemplate(userId, request.TemplateID)
+	if err != nil {
+		return err
+	}
+	if request.Content != "" {
+		return &httperror.BadClientRequestError{Message: "content can't be given together with template_id"}
+	}
+
+	values, err := s.templateValues(userId, template, request.Variables, at)
+	if err != nil {
+		return err
+	}
+
+	// both were checked against the declared variables when the template
+	// was saved, so nothing can be missing here
+	content, err := notetemplate.Execute(template.Content, values)
+	if err != nil {
+		return err
+	}
+	request.Content = content
+	if request.Title == "" {
+		request.Title, err = notetemplate.Execute(template.Title, values)
+		if err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(request.Title) == "" {
+		request.Title = template.Name
+	}
+	if request.Tags == nil {
+		request.Tags = template.Tags
+	}
+	if request.Folder == nil {
+		request.Folder = &template.Folder
+	}
+
+	return nil
+}
+
+// templateValues puts together the value of every variable template can
+// use, complaining about unknown and missing ones.
+func (s *templateService) templateValues(userId string, template *models.Template, given map[string]string, at time.Time) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, variable := range template.Variables {
+		values[variable.Name] = variable.Default
+	}
+
+	var unknown []string
+	for name, value := range given {
+		if _, ok := values[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if value != "" {
+			values[name] = value
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, &httperror.BadClientRequestError{Message: "Unknown variables: " + strings.Join(unknown, ", ")}
+	}
+
+	var missing []string
+	for _, variable := range template.Variables {
+		if variable.Required && strings.TrimSpace(given[variable.Name]) == "" {
+			missing = append(missing, variable.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &httperror.BadClientRequestError{Message: "Missing required variables: " + strings.Join(missing, ", ")}
+	}
+
+	user, err := s.userService.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	at = at.In(s.userService.GetSettings(userId).Location())
+	values["date"] = at.Format("2006-01-02")
+	values["time"] = at.Format("15:04")
+	values["user"] = user.Username
+	if user.DisplayName != "" {
+		values["user"] = user.DisplayName
+	}
+
+	return values, nil
+}
+
+// fillTemplate validates request and copies it into template.
+func fillTemplate(template *models.Template, request dto.TemplateRequest) error {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxTemplateName {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("name must be between 1 and %d characters", maxTemplateName)}
+	}
+	tags, err := normalizeTags(request.Tags)
+	if err != nil {
+		return err
+	}
+	folder, err := normalizeFolder(request.Folder)
+	if err != nil {
+		return err
+	}
+
+	if len(request.Variables) > maxTemplateVariables {
+		return &httperror.BadClientRequestError{Message: fmt.Sprintf("A template can have at most %d variables", maxTemplateVariables)}
+	}
+	variables := make([]models.TemplateVariable, 0, len(request.Variables))
+	declared := make(map[string]bool)
+	for _, variable := range request.Variables {
+		if !notetemplate.ValidName(variable.Name) {
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("Invalid variable name %q, use letters, digits and underscores", variable.Name)}
+		}
+		if builtinVariables[variable.Name] {
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("{{%s}} is built in and can't be declared", variable.Name)}
+		}
+		if declared[variable.Name] {
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("Variable %q is declared twice", variable.Name)}
+		}
+		if utf8.RuneCountInString(variable.Description) > maxVariableText || utf8.RuneCountInString(variable.Default) > maxVariableText {
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("Variable descriptions and defaults can be at most %d characters", maxVariableText)}
+		}
+		declared[variable.Name] = true
+		variables = append(variables, models.TemplateVariable(variable))
+	}
+
+	for _, name := range notetemplate.Variables(request.Title + "\n" + request.Content) {
+		if !declared[name] && !builtinVariables[name] {
+			return &httperror.BadClientRequestError{Message: fmt.Sprintf("{{%s}} is used but not declared as a variable", name)}
+		}
+	}
+
+	template.Name = name
+	template.Title = request.Title
+	template.Content = request.Content
+	template.Tags = tags
+	template.Folder = folder
+	template.Variables = variables
+	return nil
+}
+
+// authorize loads a template the user can see. Changing a workspace
+// template takes the given workspace role, personal ones are their owner's
+// alone.
+func (s *templateService) authorize(userId string, id string, required models.WorkspaceRole) (*models.Template, error) {
+	template, err := s.templateRepo.GetTemplate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Template"}
+		}
+		return nil, err
+	}
+
+	if template.WorkspaceID == "" {
+		if template.UserID != userId {
+			return nil, &httperror.NotFoundError{Entity: "Template"}
+		}
+		return template, nil
+	}
+
+	err = s.checkWorkspace(userId, template.WorkspaceID, required)
+	var notFound *httperror.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, &httperror.NotFoundError{Entity: "Template"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (s *templateService) checkWorkspace(userId string, workspaceId string, required models.WorkspaceRole) error {
+	return checkWorkspaceRole(s.workspaceRepo, userId, workspaceId, required)
+}
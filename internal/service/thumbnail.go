@@ -0,0 +1,52 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/thumbnail"
+)
+
+// setThumbnailURLs points each note at a thumbnail of the image attachment
+// its content references first. Notes that don't mention any of their
+// images are left without one.
+func setThumbnailURLs(attachmentRepo repository.AttachmentRepository, baseURL string, notes []models.Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	noteIDs := make([]string, len(notes))
+	for i, note := range notes {
+		noteIDs[i] = note.ID
+	}
+
+	images, err := attachmentRepo.GetAttachmentsOfType(noteIDs, thumbnail.MimeTypes)
+	if err != nil {
+		return err
+	}
+
+	byNote := make(map[string][]models.Attachment)
+	for _, image := range images {
+		byNote[image.NoteID] = append(byNote[image.NoteID], image)
+	}
+
+	for i := range notes {
+		first, firstIndex := "", -1
+		for _, image := range byNote[notes[i].ID] {
+			index := strings.Index(plainContent(&notes[i]), image.ID)
+			if index >= 0 && (firstIndex < 0 || index < firstIndex) {
+				first, firstIndex = image.ID, index
+			}
+		}
+		if first != "" {
+			notes[i].ThumbnailURL = thumbnailURL(baseURL, notes[i].ID, first)
+		}
+	}
+
+	return nil
+}
+
+func thumbnailURL(baseURL string, noteId string, attachmentId string) string {
+	return attachmentURL(baseURL, noteId, attachmentId) + "/thumbnail"
+}
@@ -1,28 +1,53 @@
 package service
 
 import (
+	"bytes"
 	"database/sql"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/avatar"
 
 	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
 	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/logging"
 	"github.com/vaporii/v8box/internal/models"
 	"github.com/vaporii/v8box/internal/repository"
+	"github.com/vaporii/v8box/internal/thumbnail"
 )
 
 type UserService interface {
 	GetUser(userId string) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
 	CheckUserExists(userId string) bool
+	// GetAvatar returns the user's avatar at the standard size closest to
+	// size, or their identicon if they don't have one.
+	GetAvatar(userId string, size int) (*dto.Avatar, error)
+	SetAvatar(userId string, r io.ReadSeeker) error
+	DeleteAvatar(userId string) error
+	UpdateProfile(userId string, request dto.UpdateProfileRequest) (*models.User, error)
+	// GetSettings returns the user's settings, or the defaults for users
+	// that no longer exist.
+	GetSettings(userId string) models.UserSettings
 }
 
 type userService struct {
 	userRepo repository.UserRepository
+	avatars  *avatar.Store
 	conf     config.Config
 }
 
-func NewUserService(userRepo repository.UserRepository, conf config.Config) UserService {
+func NewUserService(userRepo repository.UserRepository, avatars *avatar.Store, conf config.Config) UserService {
 	return &userService{
 		userRepo: userRepo,
+		avatars:  avatars,
 		conf:     conf,
 	}
 }
@@ -32,10 +57,227 @@ func (s *userService) GetUser(userId string) (*models.User, error) {
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, &httperror.NotFoundError{Entity: "User"}
 	}
-	return user, err
+	if err != nil {
+		return nil, err
+	}
+	user.AvatarURL = avatarURL(s.conf, user.ID)
+	return user, nil
+}
+
+func (s *userService) GetUserByUsername(username string) (*models.User, error) {
+	user, err := s.userRepo.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &httperror.NotFoundError{Entity: "User"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.AvatarURL = avatarURL(s.conf, user.ID)
+	return user, nil
 }
 
 func (s *userService) CheckUserExists(userId string) bool {
 	_, err := s.userRepo.GetUserById(userId)
 	return err == nil
 }
+
+func (s *userService) GetAvatar(userId string, size int) (*dto.Avatar, error) {
+	user, err := s.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	size = s.avatars.Size(size)
+
+	if user.AvatarSource != models.AvatarSourceNone {
+		file, err := s.avatars.Open(user.ID, size)
+		if err == nil {
+			stat, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return &dto.Avatar{
+				Content: file,
+				ModTime: stat.ModTime(),
+				ETag:    fmt.Sprintf(`"%d-%d"`, size, stat.ModTime().UnixNano()),
+			}, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		logging.Warning("avatar of user %s is missing, falling back to identicon", user.ID)
+	}
+
+	identicon, err := avatar.Identicon(user.ID, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.Avatar{
+		Content: nopCloser{bytes.NewReader(identicon)},
+		ETag:    fmt.Sprintf(`"identicon-%d"`, size),
+	}, nil
+}
+
+func (s *userService) SetAvatar(userId string, r io.ReadSeeker) error {
+	err := s.avatars.Save(userId, r)
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrUnsupported) {
+			return &httperror.BadClientRequestError{Message: "Avatar must be a JPEG, PNG, GIF or WebP image"}
+		}
+		if errors.Is(err, thumbnail.ErrTooLarge) {
+			return &httperror.BadClientRequestError{Message: "Avatar image is too large"}
+		}
+		return err
+	}
+
+	return s.userRepo.UpdateAvatar(userId, models.AvatarSourceUpload, "")
+}
+
+// DeleteAvatar goes back to the identicon. GitHub users get their GitHub
+// avatar again the next time they log in.
+func (s *userService) DeleteAvatar(userId string) error {
+	err := s.avatars.Delete(userId)
+	if err != nil {
+		return err
+	}
+
+	return s.userRepo.UpdateAvatar(userId, models.AvatarSourceNone, "")
+}
+
+func (s *userService) UpdateProfile(userId string, request dto.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Username != nil && *request.Username != user.Username {
+		username := *request.Username
+		if !validUsername(username) {
+			return nil, &httperror.BadClientRequestError{Message: "Username must be 3 to 30 letters, digits, dashes, dots or underscores"}
+		}
+
+		_, err := s.userRepo.GetUserByUsername(username)
+		if err == nil {
+			return nil, &httperror.BadClientRequestError{Message: "Username is already taken"}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		user.Username = username
+	}
+
+	if request.DisplayName != nil {
+		displayName := strings.TrimSpace(*request.DisplayName)
+		if utf8.RuneCountInString(displayName) > 100 {
+			return nil, &httperror.BadClientRequestError{Message: "Display name can be at most 100 characters"}
+		}
+		user.DisplayName = displayName
+	}
+
+	if request.Settings != nil {
+		user.Settings, err = applySettings(user.Settings, *request.Settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.userRepo.UpdateProfile(user)
+	if err != nil {
+		// lost a race for the username against another rename
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, &httperror.BadClientRequestError{Message: "Username is already taken"}
+		}
+		return nil, err
+	}
+
+	return s.GetUser(userId)
+}
+
+func (s *userService) GetSettings(userId string) models.UserSettings {
+	user, err := s.userRepo.GetUserById(userId)
+	if err != nil {
+		return models.DefaultUserSettings()
+	}
+	return user.Settings
+}
+
+var errInvalidSort = &httperror.BadClientRequestError{Message: "sort must be one of updated_desc, updated_asc, created_desc, created_asc, title_asc or title_desc"}
+
+func applySettings(settings models.UserSettings, request dto.UpdateSettingsRequest) (models.UserSettings, error) {
+	if request.Theme != nil {
+		theme := models.Theme(*request.Theme)
+		if !theme.Valid() {
+			return settings, &httperror.BadClientRequestError{Message: "theme must be system, light or dark"}
+		}
+		settings.Theme = theme
+	}
+
+	if request.DefaultSort != nil {
+		sort := models.NoteSort(*request.DefaultSort)
+		if !sort.Valid() {
+			return settings, errInvalidSort
+		}
+		settings.DefaultSort = sort
+	}
+
+	if request.Timezone != nil {
+		// Local would mean whatever zone the server runs in
+		if *request.Timezone == "" || *request.Timezone == "Local" {
+			return settings, &httperror.BadClientRequestError{Message: "timezone must be an IANA time zone name like Europe/Berlin"}
+		}
+		if _, err := time.LoadLocation(*request.Timezone); err != nil {
+			return settings, &httperror.BadClientRequestError{Message: "timezone must be an IANA time zone name like Europe/Berlin"}
+		}
+		settings.Timezone = *request.Timezone
+	}
+
+	if request.EditorMode != nil {
+		mode := models.EditorMode(*request.EditorMode)
+		if !mode.Valid() {
+			return settings, &httperror.BadClientRequestError{Message: "editor_mode must be markdown, rich or plain"}
+		}
+		settings.EditorMode = mode
+	}
+
+	if request.DailyFolder != nil {
+		folder, err := normalizeFolder(*request.DailyFolder)
+		if err != nil {
+			return settings, err
+		}
+		settings.DailyFolder = folder
+	}
+
+	if request.DailyTemplateID != nil {
+		if *request.DailyTemplateID != "" && uuid.Validate(*request.DailyTemplateID) != nil {
+			return settings, &httperror.BadClientRequestError{Message: "daily_template_id must be a template id"}
+		}
+		settings.DailyTemplateID = *request.DailyTemplateID
+	}
+
+	return settings, nil
+}
+
+func validUsername(username string) bool {
+	if len(username) < 3 || len(username) > 30 {
+		return false
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func avatarURL(conf config.Config, userId string) string {
+	return conf.URL + "/api/v1/avatars/" + userId
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
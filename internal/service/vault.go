@@ -0,0 +1,181 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/vault"
+)
+
+const (
+	maxImportFiles  = 10000
+	maxNoteFileSize = 10 << 20
+)
+
+// VaultService moves notes in and out as ZIPs of Markdown files, see the
+// vault package for the format.
+type VaultService interface {
+	// Import creates a note for every Markdown file in the archive, in the
+	// workspace if one is given. Files that can't be imported are reported
+	// and don't stop the rest.
+	Import(userId string, workspaceId string, archive *zip.Reader) (*dto.ImportReport, error)
+	// GetNotes returns the notes an export of the user's personal notes, or
+	// the workspace's, contains.
+	GetNotes(userId string, workspaceId string) ([]models.Note, error)
+	WriteArchive(w io.Writer, notes []models.Note) error
+}
+
+type vaultService struct {
+	noteService      NoteService
+	workspaceService WorkspaceService
+}
+
+func NewVaultService(noteService NoteService, workspaceService WorkspaceService) VaultService {
+	return &vaultService{
+		noteService:      noteService,
+		workspaceService: workspaceService,
+	}
+}
+
+func (s *vaultService) Import(userId string, workspaceId string, archive *zip.Reader) (*dto.ImportReport, error) {
+	if len(archive.File) > maxImportFiles {
+		return nil, &httperror.BadClientRequestError{Message: fmt.Sprintf("Archive has more than %d files", maxImportFiles)}
+	}
+
+	files := make([]*zip.File, 0, len(archive.File))
+	for _, file := range archive.File {
+		if !file.FileInfo().IsDir() {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	report := &dto.ImportReport{Results: make([]dto.ImportResult, 0, len(files))}
+	for _, file := range files {
+		result, err := s.importFile(userId, workspaceId, file)
+		if err != nil {
+			return nil, err
+		}
+		report.Add(result)
+	}
+
+	return report, nil
+}
+
+// importFile only returns an error if the whole import has to stop, problems
+// with the file itself go in the result.
+func (s *vaultService) importFile(userId string, workspaceId string, file *zip.File) (dto.ImportResult, error) {
+	name := strings.ReplaceAll(file.Name, "\\", "/")
+	result := dto.ImportResult{Path: name, Status: dto.ImportStatusFailed}
+
+	cleaned := path.Clean(name)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		result.Message = "Unsafe path"
+		return result, nil
+	}
+	for _, segment := range strings.Split(cleaned, "/") {
+		// .obsidian, .trash, __MACOSX and the like
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			result.Status = dto.ImportStatusSkipped
+			result.Message = "Hidden file"
+			return result, nil
+		}
+	}
+	if !strings.EqualFold(path.Ext(cleaned), ".md") {
+		result.Status = dto.ImportStatusSkipped
+		result.Message = "Not a Markdown file"
+		return result, nil
+	}
+
+	data, err := vault.ReadFile(file, maxNoteFileSize)
+	if errors.Is(err, vault.ErrTooLarge) {
+		result.Message = fmt.Sprintf("File is larger than %d MB", maxNoteFileSize>>20)
+		return result, nil
+	}
+	if err != nil {
+		result.Message = "Couldn't read file: " + err.Error()
+		return result, nil
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		result.Message = "File isn't UTF-8 text"
+		return result, nil
+	}
+
+	filename := path.Base(cleaned)
+	doc, err := vault.Parse(data, strings.TrimSuffix(filename, path.Ext(filename)))
+	if err != nil {
+		result.Message = err.Error()
+		return result, nil
+	}
+	if len(doc.Ignored) > 0 {
+		result.Warnings = append(result.Warnings, "Ignored front matter: "+strings.Join(doc.Ignored, ", "))
+	}
+
+	folder := path.Dir(cleaned)
+	if folder == "." {
+		folder = ""
+	}
+	if doc.Folder != nil {
+		folder = *doc.Folder
+	}
+
+	created, updated := doc.Created, doc.Updated
+	// plain Markdown files only have the time they were last written
+	if created.IsZero() && updated.IsZero() && file.Modified.Year() > 1980 {
+		created, updated = file.Modified.UTC(), file.Modified.UTC()
+	}
+	if updated.IsZero() {
+		updated = created
+	}
+
+	note, err := s.noteService.Create(dto.CreateNoteRequest{
+		Title:       doc.Title,
+		UserID:      userId,
+		WorkspaceID: workspaceId,
+		Content:     doc.Content,
+		Tags:        doc.Tags,
+		Folder:      &folder,
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+	})
+	if err != nil {
+		return noteResult(result, err)
+	}
+
+	result.Status = dto.ImportStatusCreated
+	result.NoteID = note.ID
+	return result, nil
+}
+
+func (s *vaultService) GetNotes(userId string, workspaceId string) ([]models.Note, error) {
+	query := dto.NoteListQuery{Sort: string(models.NoteSortCreatedAsc)}
+	if workspaceId != "" {
+		return s.workspaceService.GetWorkspaceNotes(userId, workspaceId, query)
+	}
+	return s.noteService.GetUserNotes(userId, query)
+}
+
+func (s *vaultService) WriteArchive(w io.Writer, notes []models.Note) error {
+	archive := zip.NewWriter(w)
+	writer := vault.NewWriter(archive, "")
+	for _, note := range notes {
+		err := writer.Add(note)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vaporii/v8box/internal/config"
+	"github.com/vaporii/v8box/internal/dto"
+	"github.com/vaporii/v8box/internal/httperror"
+	"github.com/vaporii/v8box/internal/models"
+	"github.com/vaporii/v8box/internal/repository"
+)
+
+type WorkspaceService interface {
+	CreateWorkspace(userId string, request dto.WorkspaceRequest) (*models.Workspace, error)
+	GetUserWorkspaces(userId string) ([]models.Workspace, error)
+	GetWorkspace(userId string, id string) (*models.Workspace, error)
+	UpdateWorkspace(userId string, id string, request dto.WorkspaceRequest) (*models.Workspace, error)
+	DeleteWorkspace(userId string, id string) error
+	AddMember(userId string, id string, request dto.AddWorkspaceMemberRequest) (*models.WorkspaceMember, error)
+	UpdateMember(userId string, id string, targetUserId string, request dto.UpdateWorkspaceMemberRequest) (*models.WorkspaceMember, error)
+	RemoveMember(userId string, id string, targetUserId string) error
+	GetWorkspaceNotes(userId string, id string, query dto.NoteListQuery) ([]models.Note, error)
+}
+
+type workspaceService struct {
+	workspaceRepo  repository.WorkspaceRepository
+	noteRepo       repository.NoteRepository
+	attachmentRepo repository.AttachmentRepository
+	stateRepo      repository.NoteStateRepository
+	propertyRepo   repository.PropertyRepository
+	userService    UserService
+	conf           config.Config
+}
+
+func NewWorkspaceService(workspaceRepo repository.WorkspaceRepository, noteRepo repository.NoteRepository, attachmentRepo repository.AttachmentRepository, stateRepo repository.NoteStateRepository, propertyRepo repository.PropertyRepository, userService UserService, conf config.Config) WorkspaceService {
+	return &workspaceService{
+		workspaceRepo:  workspaceRepo,
+		noteRepo:       noteRepo,
+		attachmentRepo: attachmentRepo,
+		stateRepo:      stateRepo,
+		propertyRepo:   propertyRepo,
+		userService:    userService,
+		conf:           conf,
+	}
+}
+
+func (s *workspaceService) CreateWorkspace(userId string, request dto.WorkspaceRequest) (*models.Workspace, error) {
+	name, err := validateWorkspaceName(request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.workspaceRepo.CreateWorkspace(&models.Workspace{
+		ID:        uuid.NewString(),
+		Name:      name,
+		CreatedBy: userId,
+	})
+}
+
+func (s *workspaceService) GetUserWorkspaces(userId string) ([]models.Workspace, error) {
+	return s.workspaceRepo.GetUserWorkspaces(userId)
+}
+
+func (s *workspaceService) GetWorkspace(userId string, id string) (*models.Workspace, error) {
+	member, err := s.authorize(userId, id, models.WorkspaceRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := s.workspaceRepo.GetWorkspaceByID(id)
+	if err != nil {
+		return nil, err
+	}
+	workspace.Role = member.Role
+
+	workspace.Members, err = s.workspaceRepo.GetMembers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace, nil
+}
+
+func (s *workspaceService) UpdateWorkspace(userId string, id string, request dto.WorkspaceRequest) (*models.Workspace, error) {
+	name, err := validateWorkspaceName(request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.authorize(userId, id, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := s.workspaceRepo.UpdateWorkspace(id, name)
+	if err != nil {
+		return nil, err
+	}
+	workspace.Role = member.Role
+
+	return workspace, nil
+}
+
+// DeleteWorkspace removes the workspace. Its notes become personal notes of
+// the owner who deleted it.
+func (s *workspaceService) DeleteWorkspace(userId string, id string) error {
+	_, err := s.authorize(userId, id, models.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return s.workspaceRepo.DeleteWorkspace(id, userId)
+}
+
+func (s *workspaceService) AddMember(userId string, id string, request dto.AddWorkspaceMemberRequest) (*models.WorkspaceMember, error) {
+	role := models.WorkspaceRole(request.Role)
+	if !role.Valid() {
+		return nil, &httperror.BadClientRequestError{Message: "Role must be viewer, editor, admin or owner"}
+	}
+
+	actor, err := s.authorize(userId, id, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.userService.GetUserByUsername(request.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.workspaceRepo.GetMember(id, target.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &httperror.BadClientRequestError{Message: "User is already a member of this workspace"}
+	}
+
+	if role == models.WorkspaceRoleOwner && actor.Role != models.WorkspaceRoleOwner {
+		return nil, &httperror.ForbiddenError{Message: "Only owners can add other owners"}
+	}
+
+	return s.workspaceRepo.UpsertMember(&models.WorkspaceMember{
+		WorkspaceID: id,
+		UserID:      target.ID,
+		Role:        role,
+	})
+}
+
+func (s *workspaceService) UpdateMember(userId string, id string, targetUserId string, request dto.UpdateWorkspaceMemberRequest) (*models.WorkspaceMember, error) {
+	role := models.WorkspaceRole(request.Role)
+	if !role.Valid() {
+		return nil, &httperror.BadClientRequestError{Message: "Role must be viewer, editor, admin or owner"}
+	}
+
+	actor, err := s.authorize(userId, id, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.getMember(id, targetUserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if (role == models.WorkspaceRoleOwner || target.Role == models.WorkspaceRoleOwner) && actor.Role != models.WorkspaceRoleOwner {
+		return nil, &httperror.ForbiddenError{Message: "Only owners can change who owns a workspace"}
+	}
+
+	target.Role = role
+	member, err := s.workspaceRepo.UpsertMember(target)
+	if errors.Is(err, repository.ErrLastOwner) {
+		return nil, lastOwner
+	}
+	return member, err
+}
+
+// RemoveMember takes targetUserId out of the workspace. Admins can remove
+// others and anyone can leave. The notes they wrote stay in the workspace.
+func (s *workspaceService) RemoveMember(userId string, id string, targetUserId string) error {
+	required := models.WorkspaceRoleAdmin
+	if userId == targetUserId {
+		required = models.WorkspaceRoleViewer
+	}
+
+	actor, err := s.authorize(userId, id, required)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.getMember(id, targetUserId)
+	if err != nil {
+		return err
+	}
+
+	if target.Role == models.WorkspaceRoleOwner && actor.Role != models.WorkspaceRoleOwner {
+		return &httperror.ForbiddenError{Message: "Only owners can remove owners"}
+	}
+
+	err = s.workspaceRepo.DeleteMember(id, targetUserId)
+	if errors.Is(err, repository.ErrLastOwner) {
+		return lastOwner
+	}
+	return err
+}
+
+func (s *workspaceService) GetWorkspaceNotes(userId string, id string, query dto.NoteListQuery) ([]models.Note, error) {
+	sort, err := listSort(s.userService, userId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.authorize(userId, id, models.WorkspaceRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPropertyFilters(query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.noteRepo.GetWorkspaceNotes(id, sort, query.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range notes {
+		notes[i].Role = member.Role.NoteRole()
+	}
+
+	notes, err = applyNoteStates(s.stateRepo, userId, notes, query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setThumbnailURLs(s.attachmentRepo, s.conf.URL, notes)
+	if err != nil {
+		return nil, err
+	}
+	err = setProperties(s.propertyRepo, notes)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// authorize checks userId is a member of the workspace with at least the
+// required role. Workspaces the user isn't in are reported as missing.
+func (s *workspaceService) authorize(userId string, id string, required models.WorkspaceRole) (*models.WorkspaceMember, error) {
+	member, err := s.workspaceRepo.GetMember(id, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Workspace"}
+		}
+		return nil, err
+	}
+
+	if !member.Role.Allows(required) {
+		return nil, &httperror.ForbiddenError{Message: "You need " + string(required) + " access to this workspace to do that"}
+	}
+
+	return member, nil
+}
+
+// checkWorkspaceRole is authorize for services that only hold the
+// repository.
+func checkWorkspaceRole(workspaceRepo repository.WorkspaceRepository, userId string, workspaceId string, required models.WorkspaceRole) error {
+	member, err := workspaceRepo.GetMember(workspaceId, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &httperror.NotFoundError{Entity: "Workspace"}
+		}
+		return err
+	}
+	if !member.Role.Allows(required) {
+		return &httperror.ForbiddenError{Message: "You need " + string(required) + " access to the workspace to do that"}
+	}
+	return nil
+}
+
+func (s *workspaceService) getMember(id string, userId string) (*models.WorkspaceMember, error) {
+	member, err := s.workspaceRepo.GetMember(id, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &httperror.NotFoundError{Entity: "Member"}
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// lastOwner keeps at least one owner around so the workspace and its notes
+// are never left unmanaged.
+var lastOwner = &httperror.BadClientRequestError{Message: "A workspace needs at least one owner, add another owner first"}
+
+func validateWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return "", &httperror.BadClientRequestError{Message: "Workspace name must be between 1 and 255 characters"}
+	}
+	return name, nil
+}
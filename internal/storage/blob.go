@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps attachment contents. Keys are SHA-256 hex digests, so the
+// same content is only ever stored once.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns ErrNotFound if there is no blob under key.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete succeeds if the blob is already gone.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// s3StandIn keeps objects in memory and refuses requests that aren't
+// signed with testSecretKey.
+type s3StandIn struct {
+	t      *testing.T
+	server *httptest.Server
+	// bucket in the path rather than the host
+	pathStyle bool
+	// answer ranged GETs with the whole object, like some servers do
+	ignoreRange bool
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	// the Range header of every GET
+	ranges []string
+	// status to answer the next request with instead of handling it
+	fail int
+}
+
+func newS3StandIn(t *testing.T, pathStyle bool) *s3StandIn {
+	s := &s3StandIn{t: t, pathStyle: pathStyle, objects: make(map[string][]byte)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// store returns an S3Store pointed at the stand-in. With virtual-hosted
+// buckets every host name is dialed to the stand-in.
+func (s *s3StandIn) store(secretKey string) *S3Store {
+	s.t.Helper()
+	endpoint := s.server.URL
+	if !s.pathStyle {
+		endpoint = "http://s3.test:" + s.server.URL[strings.LastIndex(s.server.URL, ":")+1:]
+	}
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "notes",
+		Region:    "eu-central-1",
+		AccessKey: testAccessKey,
+		SecretKey: secretKey,
+		PathStyle: s.pathStyle,
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	address := s.server.Listener.Addr().String()
+	store.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	}
+	return store
+}
+
+// failNext makes the stand-in answer the next request with status.
+func (s *s3StandIn) failNext(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = status
+}
+
+func (s *s3StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != 0 {
+		w.WriteHeader(s.fail)
+		fmt.Fprintf(w, "<Error><Code>InternalError</Code></Error>")
+		s.fail = 0
+		return
+	}
+	if err := checkSignature(r, "eu-central-1", testSecretKey); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	var key string
+	if s.pathStyle {
+		bucket, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if bucket != "notes" {
+			s.t.Errorf("request for bucket %q", bucket)
+		}
+		key = rest
+	} else {
+		if !strings.HasPrefix(r.Host, "notes.s3.test:") {
+			s.t.Errorf("request for host %q", r.Host)
+		}
+		key = strings.TrimPrefix(r.URL.Path, "/")
+	}
+
+	object, ok := s.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if int64(len(body)) != r.ContentLength {
+			s.t.Errorf("PUT %s with Content-Length %d and %d bytes", key, r.ContentLength, len(body))
+		}
+		s.objects[key] = body
+	case http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+	case http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rng := r.Header.Get("Range")
+		s.ranges = append(s.ranges, rng)
+		if rng == "" || s.ignoreRange {
+			w.Write(object)
+			return
+		}
+		from, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if err != nil || from >= len(object) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, len(object)-1, len(object)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(object[from:])
+	case http.MethodDelete:
+		// S3 doesn't mind deleting what isn't there
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature verifies an AWS signature version 4 from what arrived
+// over the wire.
+func checkSignature(r *http.Request, region string, secretKey string) error {
+	authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("not signed with AWS4-HMAC-SHA256")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(authorization, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("bad X-Amz-Date %q", amzDate)
+	}
+	if time.Since(date).Abs() > 15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return fmt.Errorf("bad credential %q", fields["Credential"])
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return errors.New("signed headers aren't sorted")
+	}
+	required := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if r.Header.Get("Range") != "" {
+		required = append(required, "range")
+	}
+	for _, name := range required {
+		if !contains(signed, name) {
+			return fmt.Errorf("%s isn't signed", name)
+		}
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	if fields["Signature"] != signature(canonical, amzDate, region, secretKey) {
+		return errors.New("signature doesn't match")
+	}
+	return nil
+}
+
+func signature(canonicalRequest string, amzDate string, region string, secretKey string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + amzDate[:8] + "/" + region + "/s3/aws4_request\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestS3Sign(t *testing.T) {
+	store, err := NewS3Store(S3Config{
+		Endpoint:  "https://s3.eu-central-1.amazonaws.com",
+		Bucket:    "notes",
+		Region:    "eu-central-1",
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := store.newRequest(context.Background(), http.MethodGet, "ab/cd/abcdef", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=100-")
+	store.sign(req, time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC))
+
+	canonical := "GET\n" +
+		"/ab/cd/abcdef\n" +
+		"\n" +
+		"host:notes.s3.eu-central-1.amazonaws.com\n" +
+		"range:bytes=100-\n" +
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:20260301T123000Z\n" +
+		"\n" +
+		"host;range;x-amz-content-sha256;x-amz-date\n" +
+		"UNSIGNED-PAYLOAD"
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20260301/eu-central-1/s3/aws4_request, " +
+		"SignedHeaders=host;range;x-amz-content-sha256;x-amz-date, " +
+		"Signature=" + signature(canonical, "20260301T123000Z", "eu-central-1", testSecretKey)
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization is\n%s\nwant\n%s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20260301T123000Z" {
+		t.Errorf("X-Amz-Date is %q", got)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	for _, pathStyle := range []bool{true, false} {
+		t.Run(fmt.Sprintf("pathStyle=%v", pathStyle), func(t *testing.T) {
+			standIn := newS3StandIn(t, pathStyle)
+			store := standIn.store(testSecretKey)
+			ctx := context.Background()
+			content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+			err := store.Put(ctx, "ab/cd/blob", bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			object, err := store.Open(ctx, "ab/cd/blob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(object)
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("read %q, %v", got, err)
+			}
+
+			// seeking reads from there on, not from the start
+			_, err = object.Seek(-10, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = io.ReadAll(object)
+			if err != nil || string(got) != "qrstuvwxyz" {
+				t.Errorf("read %q after seeking, %v", got, err)
+			}
+			_, err = object.Seek(5, io.SeekStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(object, buf)
+			if err != nil || string(buf) != "567" {
+				t.Errorf("read %q at 5, %v", buf, err)
+			}
+			object.Close()
+			standIn.mu.Lock()
+			if want := []string{"bytes=0-", "bytes=26-", "bytes=5-"}; strings.Join(standIn.ranges, " ") != strings.Join(want, " ") {
+				t.Errorf("got ranges %q, want %q", standIn.ranges, want)
+			}
+
+			standIn.mu.Unlock()
+
+			err = store.Delete(ctx, "ab/cd/blob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.Open(ctx, "ab/cd/blob")
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("opening a deleted blob: %v", err)
+			}
+			// already gone
+			err = store.Delete(ctx, "ab/cd/blob")
+			if err != nil {
+				t.Errorf("deleting twice: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3RangeIgnored(t *testing.T) {
+	standIn := newS3StandIn(t, true)
+	standIn.ignoreRange = true
+	store := standIn.store(testSecretKey)
+	ctx := context.Background()
+
+	err := store.Put(ctx, "blob", strings.NewReader("hello world"), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := store.Open(ctx, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	object.Seek(6, io.SeekStart)
+	got, err := io.ReadAll(object)
+	if err != nil || string(got) != "world" {
+		t.Errorf("read %q, %v", got, err)
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	standIn := newS3StandIn(t, true)
+	ctx := context.Background()
+
+	// a wrong secret is refused, and the error says why
+	err := standIn.store("wrong").Put(ctx, "blob", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("put with a wrong secret: %v", err)
+	}
+	standIn.mu.Lock()
+	if len(standIn.objects) != 0 {
+		t.Error("unsigned put was stored")
+	}
+	standIn.mu.Unlock()
+
+	store := standIn.store(testSecretKey)
+	err = store.Put(ctx, "blob", strings.NewReader("x"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only a 404 means the blob isn't there
+	standIn.failNext(http.StatusInternalServerError)
+	_, err = store.Open(ctx, "blob")
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "500") {
+		t.Errorf("open on a server error: %v", err)
+	}
+
+	standIn.failNext(http.StatusServiceUnavailable)
+	err = store.Delete(ctx, "blob")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("delete on a server error: %v", err)
+	}
+
+	object, err := store.Open(ctx, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	standIn.failNext(http.StatusInternalServerError)
+	_, err = io.ReadAll(object)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("read on a server error: %v", err)
+	}
+}
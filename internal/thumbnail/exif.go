@@ -0,0 +1,142 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// orientation reads the EXIF orientation tag from a JPEG or WebP file. It
+// returns 1, meaning no transformation, if there isn't one.
+func orientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(12)
+	if err != nil {
+		return 1
+	}
+
+	var exif []byte
+	switch {
+	case magic[0] == 0xff && magic[1] == 0xd8:
+		exif = jpegExif(br)
+	case string(magic[:4]) == "RIFF" && string(magic[8:12]) == "WEBP":
+		exif = webpExif(br)
+	}
+	if exif == nil {
+		return 1
+	}
+
+	return tiffOrientation(bytes.TrimPrefix(exif, []byte("Exif\x00\x00")))
+}
+
+// jpegExif walks the JPEG segments up to the image data looking for the
+// APP1 segment holding EXIF.
+func jpegExif(r *bufio.Reader) []byte {
+	if _, err := r.Discard(2); err != nil {
+		return nil
+	}
+
+	for {
+		marker := make([]byte, 4)
+		if _, err := io.ReadFull(r, marker); err != nil || marker[0] != 0xff {
+			return nil
+		}
+		// start of scan, no more metadata after this
+		if marker[1] == 0xda {
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil
+		}
+
+		if marker[1] == 0xe1 {
+			segment := make([]byte, length)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return nil
+			}
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment
+			}
+			continue
+		}
+
+		if _, err := r.Discard(length); err != nil {
+			return nil
+		}
+	}
+}
+
+func webpExif(r *bufio.Reader) []byte {
+	if _, err := r.Discard(12); err != nil {
+		return nil
+	}
+
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+		size := int(binary.LittleEndian.Uint32(header[4:]))
+		// chunks are padded to an even size
+		padded := size + size&1
+
+		if string(header[:4]) == "EXIF" {
+			if size > 1<<20 {
+				return nil
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil
+			}
+			return chunk
+		}
+
+		if _, err := r.Discard(padded); err != nil {
+			return nil
+		}
+	}
+}
+
+// tiffOrientation finds tag 0x0112 in the first IFD of TIFF formatted EXIF
+// data.
+func tiffOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(data[4:]))
+	if offset < 8 || offset+2 > len(data) {
+		return 1
+	}
+	count := int(order.Uint16(data[offset:]))
+
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(data) {
+			return 1
+		}
+		if order.Uint16(data[entry:]) != 0x0112 {
+			continue
+		}
+		value := int(order.Uint16(data[entry+8:]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+
+	return 1
+}
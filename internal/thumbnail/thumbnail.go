@@ -0,0 +1,314 @@
+// Package thumbnail makes small previews of image attachments and keeps them
+// cached on disk.
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	// registered with image.Decode
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image is too large to make a thumbnail of")
+)
+
+// images bigger than this would need too much memory to decode
+const maxPixels = 50_000_000
+
+// MimeTypes are the attachment types thumbnails can be made for.
+var MimeTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+func Supports(mimeType string) bool {
+	return slices.Contains(MimeTypes, mimeType)
+}
+
+// Generate scales the image down to fit in a size by size square. The image
+// is re-encoded from pixels only so none of the original metadata is carried
+// over. Opaque images become JPEGs and the rest PNGs; the returned string is
+// the MIME type written.
+func Generate(w io.Writer, r io.ReadSeeker, size int) (string, error) {
+	thumb, err := Load(r, size, false)
+	if err != nil {
+		return "", err
+	}
+
+	if opaque(thumb) {
+		return "image/jpeg", jpeg.Encode(w, thumb, &jpeg.Options{Quality: 85})
+	}
+	return "image/png", png.Encode(w, thumb)
+}
+
+// Load reads a JPEG, PNG, GIF or WebP image, scales it down to fit in a size
+// by size square and turns it the right way up. With square set the middle
+// of the image is cropped out first so the result fills the square. Images
+// too big to decode safely are refused with ErrTooLarge.
+func Load(r io.ReadSeeker, size int, square bool) (*image.NRGBA, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	orient := orientation(r)
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	if square {
+		src = cropSquare(src)
+	}
+
+	// orienting after scaling touches far fewer pixels
+	return orientImage(Scale(src, size), orient), nil
+}
+
+// Scale fits the image in a size by size square. It never enlarges, small
+// images are only converted.
+func Scale(src image.Image, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func cropSquare(src image.Image) image.Image {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Copy(dst, image.Point{}, src, image.Rect(x, y, x+side, y+side), draw.Src, nil)
+	return dst
+}
+
+// orientImage undoes the rotation and mirroring described by an EXIF
+// orientation value.
+func orientImage(src *image.NRGBA, orient int) *image.NRGBA {
+	if orient <= 1 || orient > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orient >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orient {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetNRGBA(x, y, src.NRGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+func opaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// Cache generates thumbnails the first time they are asked for and keeps
+// them on disk under the key of the blob they were made from.
+type Cache struct {
+	dir   string
+	sizes []int
+	group singleflight.Group
+}
+
+func NewCache(dir string, sizes []int) (*Cache, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("at least one thumbnail size is required")
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail size %d", size)
+		}
+	}
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		dir:   dir,
+		sizes: slices.Sorted(slices.Values(sizes)),
+	}, nil
+}
+
+// Sizes returns the configured sizes, smallest first.
+func (c *Cache) Sizes() []int {
+	return c.sizes
+}
+
+func (c *Cache) ValidSize(size int) bool {
+	return slices.Contains(c.sizes, size)
+}
+
+// Get returns the path and MIME type of the thumbnail, generating it from
+// the image open returns if it isn't cached yet.
+func (c *Cache) Get(key string, size int, open func() (io.ReadSeekCloser, error)) (string, string, error) {
+	if !c.ValidSize(size) {
+		return "", "", fmt.Errorf("invalid thumbnail size %d", size)
+	}
+	if len(key) < 2 || filepath.Base(key) != key {
+		return "", "", fmt.Errorf("invalid thumbnail key %q", key)
+	}
+
+	if path, mimeType, ok := c.lookup(key, size); ok {
+		return path, mimeType, nil
+	}
+
+	// concurrent requests for the same thumbnail only generate it once
+	type result struct{ path, mimeType string }
+	res, err, _ := c.group.Do(fmt.Sprintf("%s-%d", key, size), func() (any, error) {
+		if path, mimeType, ok := c.lookup(key, size); ok {
+			return result{path, mimeType}, nil
+		}
+
+		path, mimeType, err := c.generate(key, size, open)
+		return result{path, mimeType}, err
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return res.(result).path, res.(result).mimeType, nil
+}
+
+// Delete removes every cached size of key.
+func (c *Cache) Delete(key string) error {
+	if len(key) < 2 || filepath.Base(key) != key {
+		return fmt.Errorf("invalid thumbnail key %q", key)
+	}
+
+	for _, size := range c.sizes {
+		for _, ext := range []string{".jpg", ".png"} {
+			err := os.Remove(c.path(key, size, ext))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cache) path(key string, size int, ext string) string {
+	return filepath.Join(c.dir, key[:2], fmt.Sprintf("%s-%d%s", key, size, ext))
+}
+
+func (c *Cache) lookup(key string, size int) (string, string, bool) {
+	if path := c.path(key, size, ".jpg"); fileExists(path) {
+		return path, "image/jpeg", true
+	}
+	if path := c.path(key, size, ".png"); fileExists(path) {
+		return path, "image/png", true
+	}
+	return "", "", false
+}
+
+func (c *Cache) generate(key string, size int, open func() (io.ReadSeekCloser, error)) (string, string, error) {
+	src, err := open()
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	dir := filepath.Join(c.dir, key[:2])
+	err = os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return "", "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	mimeType, err := Generate(tmp, src, size)
+	if err != nil {
+		return "", "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", "", err
+	}
+
+	ext := ".png"
+	if mimeType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	path := c.path(key, size, ext)
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", "", err
+	}
+
+	return path, mimeType, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
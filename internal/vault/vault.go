@@ -0,0 +1,346 @@
+// Package vault reads and writes notes as Markdown files with YAML front
+// matter, laid out in folders the way Obsidian-style vaults are.
+package vault
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaporii/v8box/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a parsed Markdown file. Zero times mean the file didn't say.
+type Document struct {
+	Title   string
+	Tags    []string
+	Created time.Time
+	Updated time.Time
+	// only set when the front matter has one, otherwise the file's
+	// location decides
+	Folder  *string
+	Content string
+	// front matter keys that don't map to anything on a note
+	Ignored []string
+}
+
+// frontMatter is what Marshal puts at the top of every file. Fields are in
+// the order they're written.
+type frontMatter struct {
+	Title   string    `yaml:"title"`
+	Tags    []string  `yaml:"tags,omitempty"`
+	Folder  string    `yaml:"folder,omitempty"`
+	Created time.Time `yaml:"created"`
+	Updated time.Time `yaml:"updated"`
+}
+
+// aliases other tools use for the keys we read
+var (
+	titleKeys   = []string{"title"}
+	tagKeys     = []string{"tags", "tag"}
+	folderKeys  = []string{"folder"}
+	createdKeys = []string{"created", "created_at", "date"}
+	updatedKeys = []string{"updated", "updated_at", "modified", "lastmod"}
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Parse splits a Markdown file into its front matter and content. Files
+// without front matter are all content. The title falls back to
+// defaultTitle, usually the file name.
+func Parse(data []byte, defaultTitle string) (*Document, error) {
+	doc := &Document{Title: defaultTitle, Tags: make([]string, 0)}
+
+	header, content, ok := splitFrontMatter(data)
+	if !ok {
+		doc.Content = string(data)
+		return doc, nil
+	}
+	doc.Content = string(content)
+
+	fields := make(map[string]yaml.Node)
+	err := yaml.Unmarshal(header, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	known := make(map[string]bool)
+	lookup := func(keys []string) (yaml.Node, bool) {
+		for _, key := range keys {
+			known[key] = true
+		}
+		for _, key := range keys {
+			if node, ok := fields[key]; ok && node.Tag != "!!null" {
+				return node, true
+			}
+		}
+		return yaml.Node{}, false
+	}
+
+	if node, ok := lookup(titleKeys); ok {
+		if node.Kind != yaml.ScalarNode {
+			return nil, errors.New("title must be text")
+		}
+		if strings.TrimSpace(node.Value) != "" {
+			doc.Title = node.Value
+		}
+	}
+	if node, ok := lookup(tagKeys); ok {
+		doc.Tags, err = parseTags(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if node, ok := lookup(folderKeys); ok {
+		if node.Kind != yaml.ScalarNode {
+			return nil, errors.New("folder must be text")
+		}
+		doc.Folder = &node.Value
+	}
+	if node, ok := lookup(createdKeys); ok {
+		doc.Created, err = parseTime(node)
+		if err != nil {
+			return nil, fmt.Errorf("created: %w", err)
+		}
+	}
+	if node, ok := lookup(updatedKeys); ok {
+		doc.Updated, err = parseTime(node)
+		if err != nil {
+			return nil, fmt.Errorf("updated: %w", err)
+		}
+	}
+
+	for key := range fields {
+		if !known[key] {
+			doc.Ignored = append(doc.Ignored, key)
+		}
+	}
+	sort.Strings(doc.Ignored)
+
+	return doc, nil
+}
+
+// splitFrontMatter finds a front matter block opened by "---" on the first
+// line and closed by "---" or "...".
+func splitFrontMatter(data []byte) ([]byte, []byte, bool) {
+	rest, ok := cutLine(data, "---")
+	if !ok {
+		return nil, nil, false
+	}
+
+	header := rest
+	offset := 0
+	for offset <= len(rest) {
+		line := rest[offset:]
+		if content, ok := cutLine(line, "---"); ok {
+			return header[:offset], content, true
+		}
+		if content, ok := cutLine(line, "..."); ok {
+			return header[:offset], content, true
+		}
+		next := bytes.IndexByte(line, '\n')
+		if next < 0 {
+			break
+		}
+		offset += next + 1
+	}
+	return nil, nil, false
+}
+
+// cutLine reports whether data starts with a line holding only marker, and
+// returns what follows that line.
+func cutLine(data []byte, marker string) ([]byte, bool) {
+	rest, ok := bytes.CutPrefix(data, []byte(marker))
+	if !ok {
+		return nil, false
+	}
+	rest = bytes.TrimLeft(rest, " \t")
+	switch {
+	case len(rest) == 0:
+		return rest, true
+	case rest[0] == '\n':
+		return rest[1:], true
+	case rest[0] == '\r' && len(rest) > 1 && rest[1] == '\n':
+		return rest[2:], true
+	}
+	return nil, false
+}
+
+// parseTags takes a list or a string of tags separated by commas or spaces.
+func parseTags(node yaml.Node) ([]string, error) {
+	tags := make([]string, 0)
+	switch node.Kind {
+	case yaml.ScalarNode:
+		tags = append(tags, strings.FieldsFunc(node.Value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, errors.New("tags must be a list of text")
+			}
+			tags = append(tags, item.Value)
+		}
+	default:
+		return nil, errors.New("tags must be a list or text")
+	}
+	return tags, nil
+}
+
+func parseTime(node yaml.Node) (time.Time, error) {
+	if node.Kind != yaml.ScalarNode {
+		return time.Time{}, errors.New("expected a date")
+	}
+	value := strings.TrimSpace(node.Value)
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("couldn't read %q as a date", value)
+}
+
+// Marshal renders a note as Markdown with front matter that Parse turns
+// back into the same note.
+func Marshal(note models.Note, folder string) ([]byte, error) {
+	fm := frontMatter{
+		Title:   note.Title,
+		Tags:    note.Tags,
+		Created: note.CreatedAt.UTC(),
+		Updated: note.UpdatedAt.UTC(),
+	}
+	// the file's location only stands in for the folder when it's exact
+	if folder != note.Folder {
+		fm.Folder = note.Folder
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(fm)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("---\n")
+	buf.WriteString(note.Content)
+
+	return buf.Bytes(), nil
+}
+
+// Writer adds notes to a ZIP archive below a directory, one file per note
+// at <dir>/<folder>/<title>.md. Encrypted notes aren't Markdown to anyone
+// but their readers, so they're left out.
+type Writer struct {
+	archive *zip.Writer
+	dir     string
+	used    map[string]bool
+}
+
+func NewWriter(archive *zip.Writer, dir string) *Writer {
+	return &Writer{
+		archive: archive,
+		dir:     dir,
+		used:    make(map[string]bool),
+	}
+}
+
+func (w *Writer) Add(note models.Note) error {
+	if note.Encrypted() {
+		return nil
+	}
+
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(note.Folder, "/") {
+		if segment != "" {
+			segments = append(segments, Filename(segment))
+		}
+	}
+	folder := strings.Join(segments, "/")
+
+	data, err := Marshal(note, folder)
+	if err != nil {
+		return err
+	}
+
+	name := UniqueName(w.used, path.Join(w.dir, folder, Filename(note.Title)), ".md")
+	file, err := w.archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: note.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// Filename makes a title or folder name safe to use as a file name on any
+// system.
+func Filename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	name = strings.Trim(name, ". ")
+	if len(name) > 100 {
+		name = strings.ToValidUTF8(name[:100], "")
+	}
+	if name == "" {
+		name = "Untitled"
+	}
+	return name
+}
+
+// UniqueName numbers names that are already taken, case-insensitively
+// since archives get unpacked on case-insensitive file systems too.
+func UniqueName(used map[string]bool, base string, ext string) string {
+	name := base + ext
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// ReadFile reads at most limit bytes of a file in an archive.
+func ReadFile(file *zip.File, limit int64) ([]byte, error) {
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, ErrTooLarge
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// the size in the header can't be trusted
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+var ErrTooLarge = errors.New("file is too large")
@@ -0,0 +1,67 @@
+// Package wikilink finds links between notes written as [[Note Title]] or
+// [[note id|text to show]].
+package wikilink
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var pattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// Link is one [[...]] in a note.
+type Link struct {
+	// note title or id, as written
+	Target string
+	// text shown instead of the target, if any
+	Alias string
+}
+
+// IsID says the link points at a note id rather than a title.
+func (l Link) IsID() bool {
+	return uuid.Validate(l.Target) == nil
+}
+
+// Parse lists the links in content, each target once. Titles are matched
+// without regard to case, so [[Ideas]] and [[ideas]] are the same link.
+func Parse(content string) []Link {
+	links := make([]Link, 0)
+	seen := make(map[string]bool)
+	for _, match := range pattern.FindAllStringSubmatch(content, -1) {
+		link := Link{Target: strings.TrimSpace(match[1]), Alias: strings.TrimSpace(match[2])}
+		key := strings.ToLower(link.Target)
+		if link.Target == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+// CanLinkTitle says whether title can be written as a link. Titles with
+// brackets, pipes or line breaks have to be linked by id instead.
+func CanLinkTitle(title string) bool {
+	return strings.TrimSpace(title) != "" && !strings.ContainsAny(title, "[]|\n")
+}
+
+// Rewrite points links to the title from at the title to instead, keeping
+// any alias. It reports whether anything changed.
+func Rewrite(content string, from string, to string) (string, bool) {
+	from = strings.TrimSpace(from)
+	changed := false
+	content = pattern.ReplaceAllStringFunc(content, func(match string) string {
+		parts := pattern.FindStringSubmatch(match)
+		if !strings.EqualFold(strings.TrimSpace(parts[1]), from) {
+			return match
+		}
+		changed = true
+		if strings.Contains(match, "|") {
+			return "[[" + to + "|" + parts[2] + "]]"
+		}
+		return "[[" + to + "]]"
+	})
+	return content, changed
+}